@@ -0,0 +1,100 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"dr-mario-backend/config"
+)
+
+var (
+	ErrNotExist   = errors.New("blob does not exist")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	ETag        string
+}
+
+// BlobStore stores image files and derived artifacts by key. Keys are
+// slash-separated relative paths such as "blobs/ab/abcdef.jpg".
+type BlobStore interface {
+	// Put streams r into the object at key. size may be -1 if unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object for streaming reads. The caller must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Store is the blob store used by the application
+var Store BlobStore
+
+// Initialize creates the configured blob store backend
+func Initialize() error {
+	cfg := config.AppConfig.Blob
+	switch cfg.Backend {
+	case "", "local":
+		Store = NewLocalStore(config.AppConfig.Upload.UploadDir)
+		return nil
+	case "s3":
+		s3, err := NewS3Store(cfg)
+		if err != nil {
+			return err
+		}
+		if err := s3.EnsureBucket(context.Background()); err != nil {
+			return err
+		}
+		Store = s3
+		return nil
+	default:
+		return fmt.Errorf("unknown blob store backend: %s", cfg.Backend)
+	}
+}
+
+// Default returns the configured store, falling back to local disk when
+// Initialize has not been called
+func Default() BlobStore {
+	if Store == nil {
+		Store = NewLocalStore(config.AppConfig.Upload.UploadDir)
+	}
+	return Store
+}
+
+// ReadAll reads a whole object into memory
+func ReadAll(ctx context.Context, store BlobStore, key string) ([]byte, error) {
+	reader, _, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// ContentTypeFor guesses the content type of a key from its extension
+func ContentTypeFor(key string) string {
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// cleanKey validates a key and normalizes it to a relative slash path
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean(strings.TrimPrefix(key, "/"))
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"dr-mario-backend/config"
+)
+
+// runCreateAdmin creates the first admin account on a running server with
+// the bootstrap token printed at startup or set in ADMIN_BOOTSTRAP_TOKEN
+func runCreateAdmin(args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	server := flags.String("server", "http://localhost:"+config.AppConfig.Server.Port, "base URL of the running server")
+	token := flags.String("bootstrap-token", config.AppConfig.Account.AdminBootstrapToken, "bootstrap token from the server log (default $ADMIN_BOOTSTRAP_TOKEN)")
+	email := flags.String("email", "", "email of the admin account")
+	password := flags.String("password", os.Getenv("DRMARIO_PASSWORD"), "password of the admin account (default $DRMARIO_PASSWORD)")
+	firstName := flags.String("first-name", "Admin", "first name of the admin")
+	lastName := flags.String("last-name", "User", "last name of the admin")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dr-mario-backend create-admin [flags]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *token == "" {
+		return errors.New("a bootstrap token is required")
+	}
+	if *email == "" || *password == "" {
+		flags.Usage()
+		return errors.New("an email and password are required")
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"bootstrap_token": *token,
+		"email":           *email,
+		"password":        *password,
+		"first_name":      *firstName,
+		"last_name":       *lastName,
+	})
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(*server+"/api/v1/admin/bootstrap", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create-admin request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("create-admin failed: %s", result.Error)
+	}
+
+	fmt.Printf("Admin account %s created. Sign in to set up multi-factor authentication.\n", *email)
+	return nil
+}
@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+)
+
+// Run executes a command line subcommand instead of starting the server
+func Run(args []string) error {
+	switch args[0] {
+	case "import":
+		return runImport(args[1:])
+	case "create-admin":
+		return runCreateAdmin(args[1:])
+	case "help", "-h", "--help":
+		printUsage()
+		return nil
+	default:
+		printUsage()
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: dr-mario-backend [command] [flags]
+
+Without a command the API server is started.
+
+Commands:
+  import        Import a ZIP archive of screening images into a running server
+  create-admin  Create the first admin account on a running server`)
+}
@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/storage"
+)
+
+// runImport uploads an archive to the import endpoint of a running server.
+// Storage is held in the server's memory, so the CLI acts as an API client.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	server := flags.String("server", "http://localhost:"+config.AppConfig.Server.Port, "base URL of the running server")
+	token := flags.String("token", os.Getenv("DRMARIO_TOKEN"), "JWT of a doctor or admin (default $DRMARIO_TOKEN)")
+	email := flags.String("email", "", "log in with this email instead of -token")
+	password := flags.String("password", os.Getenv("DRMARIO_PASSWORD"), "password for -email (default $DRMARIO_PASSWORD)")
+	createPatients := flags.Bool("create-patients", true, "create patients missing from the system")
+	detect := flags.Bool("detect", false, "queue DR detection for imported images")
+	dryRun := flags.Bool("dry-run", false, "validate the archive without importing")
+	reportPath := flags.String("report", "", "write the per-row report to this CSV file")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dr-mario-backend import [flags] archive.zip")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("exactly one archive is required")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Minute}
+
+	if *email != "" {
+		loggedIn, err := login(client, *server, *email, *password)
+		if err != nil {
+			return err
+		}
+		*token = loggedIn
+	}
+	if *token == "" {
+		return errors.New("a token or email is required")
+	}
+
+	report, err := uploadArchive(client, *server, *token, flags.Arg(0), map[string]bool{
+		"create_patients": *createPatients,
+		"detect":          *detect,
+		"dry_run":         *dryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	printReport(report)
+	if *reportPath != "" {
+		if err := writeReportCSV(*reportPath, report); err != nil {
+			return err
+		}
+		fmt.Printf("Report written to %s\n", *reportPath)
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d rows failed", report.Failed, report.TotalRows)
+	}
+	return nil
+}
+
+func login(client *http.Client, server, email, password string) (string, error) {
+	body, _ := json.Marshal(map[string]string{"email": email, "password": password})
+	resp, err := client.Post(server+"/api/v1/auth/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("login failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Token string `json:"token"`
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("login failed: %s", result.Error)
+	}
+	return result.Token, nil
+}
+
+func uploadArchive(client *http.Client, server, token, archivePath string, options map[string]bool) (*storage.ImportReport, error) {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	// Stream the multipart body so large archives are not held in memory
+	body, pipeWriter := io.Pipe()
+	writer := multipart.NewWriter(pipeWriter)
+	go func() {
+		for name, value := range options {
+			writer.WriteField(name, strconv.FormatBool(value))
+		}
+		part, err := writer.CreateFormFile("archive", filepath.Base(archivePath))
+		if err != nil {
+			pipeWriter.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(part, archive); err != nil {
+			pipeWriter.CloseWithError(err)
+			return
+		}
+		pipeWriter.CloseWithError(writer.Close())
+	}()
+
+	req, err := http.NewRequest("POST", server+"/api/v1/imports/", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("import request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Report *storage.ImportReport `json:"report"`
+		Error  string                `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid response from server: %s", resp.Status)
+	}
+	if result.Report == nil {
+		return nil, fmt.Errorf("import failed: %s", result.Error)
+	}
+	return result.Report, nil
+}
+
+func printReport(report *storage.ImportReport) {
+	if report.DryRun {
+		fmt.Println("Dry run, nothing was imported")
+	}
+	fmt.Printf("Rows: %d  imported: %d  duplicates: %d  failed: %d  patients created: %d\n",
+		report.TotalRows, report.Imported, report.Duplicates, report.Failed, report.PatientsCreated)
+	for _, row := range report.Rows {
+		if row.Status == storage.ImportRowFailed {
+			fmt.Printf("  row %d (%s): %s\n", row.Row, row.File, row.Error)
+		}
+	}
+	for _, name := range report.UnreferencedFiles {
+		fmt.Printf("  not in manifest: %s\n", name)
+	}
+}
+
+func writeReportCSV(reportPath string, report *storage.ImportReport) error {
+	file, err := os.Create(reportPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"row", "file", "patient_id", "status", "patient", "patient_created", "image", "detection_queued", "error"})
+	for _, row := range report.Rows {
+		writer.Write([]string{
+			strconv.Itoa(row.Row),
+			row.File,
+			row.PatientIdentifier,
+			row.Status,
+			row.PatientID.String(),
+			strconv.FormatBool(row.PatientCreated),
+			row.ImageID.String(),
+			strconv.FormatBool(row.DetectionQueued),
+			row.Error,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
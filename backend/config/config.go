@@ -1,34 +1,109 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Server ServerConfig
-	JWT    JWTConfig
-	Upload UploadConfig
-	AI     AIConfig
-	CORS   CORSConfig
+	Server     ServerConfig
+	JWT        JWTConfig
+	MFA        MFAConfig
+	RBAC       RBACConfig
+	Account    AccountConfig
+	Lockout    LockoutConfig
+	BreakGlass BreakGlassConfig
+	Mail       MailConfig
+	Upload     UploadConfig
+	AI         AIConfig
+	Quality    QualityConfig
+	Preprocess PreprocessConfig
+	Blob       BlobConfig
+	Download   DownloadConfig
+	Retention  RetentionConfig
+	CORS       CORSConfig
 }
 
 type ServerConfig struct {
-	Port string
-	Env  string
+	Port           string
+	Env            string
+	TrustedProxies []string // proxies allowed to set X-Forwarded-For
 }
 
 type JWTConfig struct {
-	Secret string
-	Expiry string
+	Secret           string
+	Expiry           string
+	RefreshExpiry    string
+	Algorithm        string
+	RotationInterval string
+	KeyOverlap       string
+	// Key pairs for RS256/EdDSA, each PEM or the path of a PEM file
+	ActiveKey   string
+	NextKey     string
+	RetiredKeys []string
+}
+
+type MFAConfig struct {
+	Issuer          string
+	RequiredRoles   []string
+	ChallengeExpiry string
+	BackupCodeCount int
+}
+
+// RBACConfig maps each role to the permissions it grants
+type RBACConfig struct {
+	RolePermissions map[string][]string
+}
+
+type AccountConfig struct {
+	AppURL                   string
+	AdminBootstrapToken      string
+	RequireEmailVerification bool
+	VerificationExpiry       string
+	PasswordResetExpiry      string
+	MaxEmailsPerAddress      int // verification and reset emails per EmailWindow
+	MaxEmailsPerIP           int
+	EmailWindow              string
+}
+
+type LockoutConfig struct {
+	MaxAccountFailures int
+	MaxIPFailures      int
+	FailureWindow      string
+	LockoutDuration    string
+	DelayBase          string
+	DelayMax           string
+}
+
+// BreakGlassConfig controls emergency access to patients outside a doctor's
+// care
+type BreakGlassConfig struct {
+	Duration        string
+	MinReasonLength int
+}
+
+type MailConfig struct {
+	Driver       string
+	From         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	OutboxDir    string
 }
 
 type UploadConfig struct {
 	MaxFileSize       int64
 	UploadDir         string
 	AllowedExtensions []string
+	MaxChunkSize      int64
+	SessionExpiry     string
+	MaxArchiveSize    int64
+	MaxImportRows     int
 }
 
 type AIConfig struct {
@@ -36,12 +111,81 @@ type AIConfig struct {
 	ConfidenceThreshold float64
 }
 
+type QualityConfig struct {
+	MinScore                  float64
+	MinBlurVariance           float64
+	MinIlluminationUniformity float64
+	MinContrast               float64
+	MinFieldOfView            float64
+}
+
+type PreprocessConfig struct {
+	Steps          []string
+	InputSize      int
+	CLAHEClipLimit float64
+	CLAHETiles     int
+	BenGrahamSigma float64
+}
+
+type BlobConfig struct {
+	Backend      string
+	S3Endpoint   string
+	S3Region     string
+	S3Bucket     string
+	S3AccessKey  string
+	S3SecretKey  string
+	S3PathStyle  bool
+	S3DisableTLS bool
+}
+
+type DownloadConfig struct {
+	SigningSecret string
+	URLExpiry     string
+}
+
+type RetentionConfig struct {
+	DeletedGracePeriod string
+	ImageRetention     string
+	Action             string
+	JobInterval        string
+}
+
 type CORSConfig struct {
 	AllowedOrigins []string
 }
 
 var AppConfig Config
 
+// Roles and the permissions they are granted unless overridden with
+// RBAC_<ROLE>_PERMISSIONS. Scoped permissions end in :own (the patient's own
+// records), :care (patients in the doctor's care) or :any.
+var defaultRolePermissions = map[string][]string{
+	"patient": {
+		"patients:profile", "patients:read:own", "images:read:own", "images:upload:own",
+		"images:delete:own", "images:analyze:own", "appointments:manage:own", "care_team:read:own",
+	},
+	"doctor": {
+		"doctors:profile", "patients:list", "patients:read:care", "patients:refer", "patients:break_glass",
+		"images:read:care", "images:upload:care", "images:delete:care", "images:analyze:care",
+		"images:import", "annotations:write:care", "results:review:care", "analytics:read:care",
+		"appointments:manage:care", "care_team:read:own", "care_team:end:own",
+	},
+	"admin": {
+		"patients:list", "patients:read:any", "images:read:any", "images:upload:any",
+		"images:delete:any", "images:analyze:any", "images:import", "imports:read:any",
+		"annotations:write:any", "annotations:delete:any", "results:review:any", "analytics:read:any",
+		"appointments:manage:any", "care_team:read:any", "care_team:assign", "care_team:end:any",
+		"doctors:verify", "users:manage", "security:manage", "retention:manage",
+	},
+}
+
+// DefaultJWTSecret is used when JWT_SECRET is not set. It is only acceptable
+// during development.
+const DefaultJWTSecret = "default-secret-key"
+
+// exampleJWTSecret is the placeholder shipped in env.example
+const exampleJWTSecret = "your-super-secret-jwt-key-here"
+
 func LoadEnv() error {
 	if err := godotenv.Load(); err != nil {
 		// If .env file doesn't exist, continue with system environment variables
@@ -49,22 +193,108 @@ func LoadEnv() error {
 
 	AppConfig = Config{
 		Server: ServerConfig{
-			Port: getEnv("PORT", "8080"),
-			Env:  getEnv("ENV", "development"),
+			Port:           getEnv("PORT", "8080"),
+			Env:            getEnv("ENV", "development"),
+			TrustedProxies: getEnvAsSlice("TRUSTED_PROXIES", nil),
 		},
 		JWT: JWTConfig{
-			Secret: getEnv("JWT_SECRET", "default-secret-key"),
-			Expiry: getEnv("JWT_EXPIRY", "24h"),
+			Secret:           getEnv("JWT_SECRET", DefaultJWTSecret),
+			Expiry:           getEnv("JWT_EXPIRY", "15m"),
+			RefreshExpiry:    getEnv("JWT_REFRESH_EXPIRY", "720h"), // 30 days
+			Algorithm:        getEnv("JWT_ALGORITHM", "HS256"),
+			RotationInterval: getEnv("JWT_KEY_ROTATION_INTERVAL", "720h"),
+			KeyOverlap:       getEnv("JWT_KEY_OVERLAP", "24h"),
+			ActiveKey:        getEnv("JWT_ACTIVE_KEY", ""),
+			NextKey:          getEnv("JWT_NEXT_KEY", ""),
+			RetiredKeys:      getEnvAsSlice("JWT_RETIRED_KEYS", nil),
+		},
+		MFA: MFAConfig{
+			Issuer:          getEnv("MFA_ISSUER", "Dr. Mario"),
+			RequiredRoles:   getEnvAsSlice("MFA_REQUIRED_ROLES", []string{"doctor", "admin"}),
+			ChallengeExpiry: getEnv("MFA_CHALLENGE_EXPIRY", "5m"),
+			BackupCodeCount: int(getEnvAsInt64("MFA_BACKUP_CODE_COUNT", 10)),
+		},
+		RBAC: RBACConfig{
+			RolePermissions: loadRolePermissions(),
+		},
+		Account: AccountConfig{
+			AppURL:                   getEnv("APP_URL", "http://localhost:5173"),
+			AdminBootstrapToken:      getEnv("ADMIN_BOOTSTRAP_TOKEN", ""),
+			RequireEmailVerification: getEnvAsBool("REQUIRE_EMAIL_VERIFICATION", false),
+			VerificationExpiry:       getEnv("EMAIL_VERIFICATION_EXPIRY", "48h"),
+			PasswordResetExpiry:      getEnv("PASSWORD_RESET_EXPIRY", "1h"),
+			MaxEmailsPerAddress:      int(getEnvAsInt64("ACCOUNT_EMAIL_MAX_PER_ADDRESS", 3)),
+			MaxEmailsPerIP:           int(getEnvAsInt64("ACCOUNT_EMAIL_MAX_PER_IP", 10)),
+			EmailWindow:              getEnv("ACCOUNT_EMAIL_WINDOW", "1h"),
+		},
+		Lockout: LockoutConfig{
+			MaxAccountFailures: int(getEnvAsInt64("LOGIN_MAX_ACCOUNT_FAILURES", 5)),
+			MaxIPFailures:      int(getEnvAsInt64("LOGIN_MAX_IP_FAILURES", 20)),
+			FailureWindow:      getEnv("LOGIN_FAILURE_WINDOW", "15m"),
+			LockoutDuration:    getEnv("LOGIN_LOCKOUT_DURATION", "15m"),
+			DelayBase:          getEnv("LOGIN_DELAY_BASE", "1s"),
+			DelayMax:           getEnv("LOGIN_DELAY_MAX", "30s"),
+		},
+		BreakGlass: BreakGlassConfig{
+			Duration:        getEnv("BREAK_GLASS_DURATION", "4h"),
+			MinReasonLength: int(getEnvAsInt64("BREAK_GLASS_MIN_REASON_LENGTH", 20)),
+		},
+		Mail: MailConfig{
+			Driver:       getEnv("MAIL_DRIVER", "log"),
+			From:         getEnv("MAIL_FROM", "Dr. Mario <no-reply@localhost>"),
+			SMTPHost:     getEnv("SMTP_HOST", ""),
+			SMTPPort:     int(getEnvAsInt64("SMTP_PORT", 587)),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			OutboxDir:    getEnv("MAIL_OUTBOX_DIR", "./mail"),
 		},
 		Upload: UploadConfig{
 			MaxFileSize:       getEnvAsInt64("MAX_FILE_SIZE", 10485760), // 10MB
 			UploadDir:         getEnv("UPLOAD_DIR", "./uploads"),
-			AllowedExtensions: getEnvAsSlice("ALLOWED_EXTENSIONS", []string{"jpg", "jpeg", "png", "tiff", "bmp"}),
+			AllowedExtensions: getEnvAsSlice("ALLOWED_EXTENSIONS", []string{"jpg", "jpeg", "png", "tif", "tiff", "bmp", "dcm", "dicom"}),
+			MaxChunkSize:      getEnvAsInt64("UPLOAD_MAX_CHUNK_SIZE", 5242880), // 5MB
+			SessionExpiry:     getEnv("UPLOAD_SESSION_EXPIRY", "24h"),
+			MaxArchiveSize:    getEnvAsInt64("IMPORT_MAX_ARCHIVE_SIZE", 524288000), // 500MB
+			MaxImportRows:     int(getEnvAsInt64("IMPORT_MAX_ROWS", 5000)),
 		},
 		AI: AIConfig{
 			ModelPath:           getEnv("MODEL_PATH", "./models/dr_detection_model"),
 			ConfidenceThreshold: getEnvAsFloat("CONFIDENCE_THRESHOLD", 0.7),
 		},
+		Quality: QualityConfig{
+			MinScore:                  getEnvAsFloat("QUALITY_MIN_SCORE", 0.5),
+			MinBlurVariance:           getEnvAsFloat("QUALITY_MIN_BLUR_VARIANCE", 10),
+			MinIlluminationUniformity: getEnvAsFloat("QUALITY_MIN_ILLUMINATION_UNIFORMITY", 0.5),
+			MinContrast:               getEnvAsFloat("QUALITY_MIN_CONTRAST", 0.04),
+			MinFieldOfView:            getEnvAsFloat("QUALITY_MIN_FIELD_OF_VIEW", 0.6),
+		},
+		Preprocess: PreprocessConfig{
+			Steps:          getEnvAsSlice("PREPROCESS_STEPS", []string{"circular_crop", "resize", "clahe"}),
+			InputSize:      int(getEnvAsInt64("PREPROCESS_INPUT_SIZE", 512)),
+			CLAHEClipLimit: getEnvAsFloat("PREPROCESS_CLAHE_CLIP_LIMIT", 2.0),
+			CLAHETiles:     int(getEnvAsInt64("PREPROCESS_CLAHE_TILES", 8)),
+			BenGrahamSigma: getEnvAsFloat("PREPROCESS_BEN_GRAHAM_SIGMA", 10),
+		},
+		Blob: BlobConfig{
+			Backend:      getEnv("BLOB_BACKEND", "local"),
+			S3Endpoint:   getEnv("S3_ENDPOINT", ""),
+			S3Region:     getEnv("S3_REGION", "us-east-1"),
+			S3Bucket:     getEnv("S3_BUCKET", "dr-mario-images"),
+			S3AccessKey:  getEnv("S3_ACCESS_KEY", ""),
+			S3SecretKey:  getEnv("S3_SECRET_KEY", ""),
+			S3PathStyle:  getEnvAsBool("S3_USE_PATH_STYLE", true),
+			S3DisableTLS: getEnvAsBool("S3_DISABLE_TLS", false),
+		},
+		Download: DownloadConfig{
+			SigningSecret: getEnv("DOWNLOAD_SIGNING_SECRET", ""),
+			URLExpiry:     getEnv("DOWNLOAD_URL_EXPIRY", "5m"),
+		},
+		Retention: RetentionConfig{
+			DeletedGracePeriod: getEnv("DELETED_IMAGE_GRACE_PERIOD", "720h"), // 30 days
+			ImageRetention:     getEnv("IMAGE_RETENTION_PERIOD", ""),
+			Action:             getEnv("IMAGE_RETENTION_ACTION", "archive"),
+			JobInterval:        getEnv("RETENTION_JOB_INTERVAL", "24h"),
+		},
 		CORS: CORSConfig{
 			AllowedOrigins: getEnvAsSlice("ALLOWED_ORIGINS", []string{"http://localhost:3000", "http://localhost:5173"}),
 		},
@@ -73,6 +303,36 @@ func LoadEnv() error {
 	return nil
 }
 
+// Validate rejects configurations that are unsafe to run with. In production
+// the default JWT secret is refused wherever it would still be used to sign:
+// HS256 access tokens and signed download URLs without their own secret.
+// Key pairs must be configured, since generated ones differ per process.
+func (c *Config) Validate() error {
+	if c.Server.Env != "production" {
+		return nil
+	}
+	if c.JWT.Algorithm != "HS256" && c.JWT.ActiveKey == "" {
+		return errors.New("JWT_ACTIVE_KEY must be set in production for " + c.JWT.Algorithm)
+	}
+	if c.JWT.Secret == DefaultJWTSecret || c.JWT.Secret == exampleJWTSecret || c.JWT.Secret == "" {
+		if c.JWT.Algorithm == "HS256" {
+			return errors.New("JWT_SECRET must be set in production")
+		}
+		if c.Download.SigningSecret == "" {
+			return errors.New("JWT_SECRET or DOWNLOAD_SIGNING_SECRET must be set in production")
+		}
+	}
+	return nil
+}
+
+func loadRolePermissions() map[string][]string {
+	permissions := make(map[string][]string, len(defaultRolePermissions))
+	for role, defaults := range defaultRolePermissions {
+		permissions[role] = getEnvAsSlice("RBAC_"+strings.ToUpper(role)+"_PERMISSIONS", defaults)
+	}
+	return permissions
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -98,12 +358,27 @@ func getEnvAsFloat(key string, defaultValue float64) float64 {
 	return defaultValue
 }
 
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvAsSlice(key string, defaultValue []string) []string {
 	if value := os.Getenv(key); value != "" {
 		// Simple comma-separated values
-		// In production, you might want more sophisticated parsing
-		// For now, return the default value if the env var exists
-		return defaultValue
+		var values []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		if len(values) > 0 {
+			return values
+		}
 	}
 	return defaultValue
 }
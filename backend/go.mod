@@ -9,6 +9,7 @@ require (
 	github.com/google/uuid v1.3.1
 	github.com/joho/godotenv v1.4.0
 	golang.org/x/crypto v0.14.0
+	golang.org/x/image v0.13.0
 )
 
 require (
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"dr-mario-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type EmailRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// VerifyEmail confirms an email address with the token from the verification email
+func VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := services.VerifyEmail(req.Token)
+	if errors.Is(err, services.ErrAccountTokenInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email address"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email address verified",
+		"user":    user,
+	})
+}
+
+// ResendVerificationEmail sends a new verification link. The response is the
+// same whether or not the address belongs to an unverified account.
+func ResendVerificationEmail(c *gin.Context) {
+	var req EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !ensureAccountEmailAllowed(c, req.Email) {
+		return
+	}
+
+	if err := services.ResendEmailVerification(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address belongs to an unverified account, a new verification link is on its way"})
+}
+
+// ForgotPassword emails a password reset link. The response is the same
+// whether or not the address is registered.
+func ForgotPassword(c *gin.Context) {
+	var req EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !ensureAccountEmailAllowed(c, req.Email) {
+		return
+	}
+
+	if err := services.RequestPasswordReset(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send password reset email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address is registered, a password reset link is on its way"})
+}
+
+// ResetPassword sets a new password with the token from the reset email.
+// All existing sessions of the user are signed out.
+func ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := services.ResetPassword(req.Token, req.Password)
+	if errors.Is(err, services.ErrAccountTokenInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated. Sign in with your new password."})
+}
+
+// ensureAccountEmailAllowed writes a 429 response with Retry-After and
+// returns false once the address or client IP has asked for too many emails
+func ensureAccountEmailAllowed(c *gin.Context, email string) bool {
+	err := services.AllowAccountEmail(email, c.ClientIP())
+	var throttled *services.AccountEmailThrottledError
+	if !errors.As(err, &throttled) {
+		return true
+	}
+
+	retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       throttled.Error(),
+		"retry_after": retryAfter,
+	})
+	return false
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dr-mario-backend/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAccountEmailsAreThrottled(t *testing.T) {
+	perAddress, perIP := config.AppConfig.Account.MaxEmailsPerAddress, config.AppConfig.Account.MaxEmailsPerIP
+
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		ip      string
+		email   func(i int) string
+		allowed int
+	}{
+		{
+			name:    "resend verification to one address",
+			handler: ResendVerificationEmail,
+			email:   func(i int) string { return "resend@example.com" },
+			allowed: perAddress,
+		},
+		{
+			name:    "forgot password for one address",
+			handler: ForgotPassword,
+			email:   func(i int) string { return "forgot@example.com" },
+			allowed: perAddress,
+		},
+		{
+			name:    "forgot password from one IP",
+			handler: ForgotPassword,
+			ip:      "198.51.100.20",
+			email:   func(i int) string { return fmt.Sprintf("forgot-%d@example.com", i) },
+			allowed: perIP,
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.POST("/", tt.handler)
+
+			for i := 0; i <= tt.allowed; i++ {
+				ip := tt.ip
+				if ip == "" {
+					ip = fmt.Sprintf("198.51.100.%d", 100+n*20+i)
+				}
+				req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"email":%q}`, tt.email(i))))
+				req.RemoteAddr = ip + ":1234"
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+
+				want := http.StatusAccepted
+				if i == tt.allowed {
+					want = http.StatusTooManyRequests
+				}
+				if w.Code != want {
+					t.Fatalf("request %d: status = %d, want %d: %s", i+1, w.Code, want, w.Body.String())
+				}
+				if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+					t.Error("throttled response has no Retry-After header")
+				}
+			}
+		})
+	}
+}
+
+func TestThrottledAddressDoesNotUseUpIPAllowance(t *testing.T) {
+	perAddress, perIP := config.AppConfig.Account.MaxEmailsPerAddress, config.AppConfig.Account.MaxEmailsPerIP
+	router := gin.New()
+	router.POST("/", ForgotPassword)
+
+	request := func(email string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"email":%q}`, email)))
+		req.RemoteAddr = "198.51.100.90:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for i := 0; i < perAddress; i++ {
+		if code := request("throttled@example.com"); code != http.StatusAccepted {
+			t.Fatalf("request %d: status = %d, want %d", i+1, code, http.StatusAccepted)
+		}
+	}
+	// Refused requests for the address must not count against the IP
+	for i := 0; i < perIP; i++ {
+		if code := request("throttled@example.com"); code != http.StatusTooManyRequests {
+			t.Fatalf("refused request %d: status = %d, want %d", i+1, code, http.StatusTooManyRequests)
+		}
+	}
+	for i := perAddress; i < perIP; i++ {
+		if code := request(fmt.Sprintf("other-%d@example.com", i)); code != http.StatusAccepted {
+			t.Fatalf("request %d from the IP: status = %d, want %d", i+1, code, http.StatusAccepted)
+		}
+	}
+	if code := request("last@example.com"); code != http.StatusTooManyRequests {
+		t.Fatalf("request past the IP allowance: status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type BootstrapAdminRequest struct {
+	BootstrapToken string `json:"bootstrap_token" binding:"required"`
+	Email          string `json:"email" binding:"required,email"`
+	Password       string `json:"password" binding:"required,min=6"`
+	FirstName      string `json:"first_name" binding:"required"`
+	LastName       string `json:"last_name" binding:"required"`
+}
+
+type ChangeRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=patient doctor admin"`
+}
+
+type DeactivateUserRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// BootstrapAdmin creates the first admin account. It only works while no
+// admin exists and needs the bootstrap token from the server configuration
+// or startup log.
+func BootstrapAdmin(c *gin.Context) {
+	var req BootstrapAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := &storage.User{
+		Email:     req.Email,
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+	}
+	err := services.BootstrapAdmin(req.BootstrapToken, user, req.Password)
+	switch {
+	case errors.Is(err, services.ErrBootstrapTokenInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrBootstrapUnavailable):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, storage.ErrConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create admin"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Admin account created",
+		"user":    user,
+	})
+}
+
+// GetUsers lists user accounts, oldest first. Filter with the q (email or
+// name), role and status (active or deactivated) query parameters and page
+// with limit and offset.
+func GetUsers(c *gin.Context) {
+	filter := storage.UserFilter{
+		Query: c.Query("q"),
+		Role:  c.Query("role"),
+	}
+	switch c.Query("status") {
+	case "":
+	case "active":
+		deactivated := false
+		filter.Deactivated = &deactivated
+	case "deactivated":
+		deactivated := true
+		filter.Deactivated = &deactivated
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active or deactivated"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return
+	}
+
+	users := storage.GlobalStorage.SearchUsers(filter)
+	total := len(users)
+	// Clamp before adding so a huge offset cannot overflow
+	start := min(offset, total)
+	users = users[start : start+min(limit, total-start)]
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":  users,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// GetUser returns a user account with its security events
+func GetUser(c *gin.Context) {
+	user, ok := loadUser(c)
+	if !ok {
+		return
+	}
+
+	events := storage.GlobalStorage.GetSecurityEvents(storage.SecurityEventFilter{UserID: user.ID})
+	if events == nil {
+		events = []*storage.SecurityEvent{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user":   user,
+		"events": events,
+	})
+}
+
+// ChangeUserRole moves a user to another role
+func ChangeUserRole(c *gin.Context) {
+	admin, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	user, ok := loadUser(c)
+	if !ok {
+		return
+	}
+
+	var req ChangeRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !respondUserAdminError(c, services.ChangeUserRole(user, req.Role, admin.ID)) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Role updated",
+		"user":    user,
+	})
+}
+
+// DeactivateUser blocks a user from signing in and ends their sessions
+func DeactivateUser(c *gin.Context) {
+	admin, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	user, ok := loadUser(c)
+	if !ok {
+		return
+	}
+
+	var req DeactivateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !respondUserAdminError(c, services.DeactivateUser(user, req.Reason, admin.ID)) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User deactivated",
+		"user":    user,
+	})
+}
+
+// ReactivateUser lets a deactivated user sign in again
+func ReactivateUser(c *gin.Context) {
+	admin, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	user, ok := loadUser(c)
+	if !ok {
+		return
+	}
+
+	if !respondUserAdminError(c, services.ReactivateUser(user, admin.ID)) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User reactivated",
+		"user":    user,
+	})
+}
+
+// ResetUserMFA removes the authenticator of a user who lost access to it
+func ResetUserMFA(c *gin.Context) {
+	admin, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	user, ok := loadUser(c)
+	if !ok {
+		return
+	}
+
+	if !respondUserAdminError(c, services.ResetUserMFA(user, admin.ID)) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Multi-factor authentication reset; the user must enroll again if their role requires it",
+		"user":    user,
+	})
+}
+
+// loadUser fetches the user named by the id path parameter, writing a 400 or
+// 404 response and returning false when it cannot
+func loadUser(c *gin.Context) (*storage.User, bool) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, false
+	}
+
+	user, err := storage.GlobalStorage.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return user, true
+}
+
+// respondUserAdminError writes the response for a user management service
+// error and returns false, or returns true when there is no error
+func respondUserAdminError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrCannotModifySelf), errors.Is(err, services.ErrLastAdmin):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFANotEnrolled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetUsersPaging(t *testing.T) {
+	role := "pager"
+	for i := 0; i < 3; i++ {
+		if err := storage.GlobalStorage.CreateUser(&storage.User{Email: "pager" + strconv.Itoa(i) + "@example.com", Role: role}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"limit=2", 2},
+		{"limit=2&offset=2", 1},
+		{"offset=3", 0},
+		{"offset=9223372036854775807", 0},
+		{"limit=500&offset=9223372036854775806", 0},
+	}
+
+	router := gin.New()
+	router.GET("/users", GetUsers)
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users?role="+role+"&"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+			var body struct {
+				Users []json.RawMessage `json:"users"`
+				Total int               `json:"total"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Users) != tt.want || body.Total != 3 {
+				t.Errorf("got %d of %d users, want %d of 3", len(body.Users), body.Total, tt.want)
+			}
+		})
+	}
+}
@@ -3,14 +3,31 @@ package handlers
 import (
 	"net/http"
 
+	"dr-mario-backend/middleware"
 	"dr-mario-backend/services"
 	"dr-mario-backend/storage"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
-// GetAnalytics returns system analytics and statistics
+// GetAnalytics returns system analytics and statistics. Users who may not
+// read analytics for every patient, such as doctors, get the statistics of
+// the patients they may read.
 func GetAnalytics(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	if patientIDs, all := services.AuthorizedPatientIDs(user, services.PermAnalyticsRead); !all {
+		c.JSON(http.StatusOK, gin.H{
+			"care_team_stats": patientStats(patientIDs),
+		})
+		return
+	}
+
 	// Get detection statistics
 	detectionStats := services.GetDetectionStats()
 
@@ -27,18 +44,95 @@ func GetAnalytics(c *gin.Context) {
 
 // GetPatientAnalytics returns analytics for a specific patient
 func GetPatientAnalytics(c *gin.Context) {
-	// This would be implemented to show patient-specific analytics
-	// For now, returning a placeholder
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	patientID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+		return
+	}
+
+	if !services.Authorize(user, services.PermAnalyticsRead, patientID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	if _, err := storage.GlobalStorage.GetPatientByID(patientID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+		return
+	}
+
+	defer recordServedAccess(c, services.PermAnalyticsRead, patientID)
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Patient analytics endpoint - to be implemented",
+		"patient_id":    patientID,
+		"patient_stats": patientStats([]uuid.UUID{patientID}),
 	})
 }
 
-// GetDoctorAnalytics returns analytics for a specific doctor
+// GetDoctorAnalytics returns analytics for a specific doctor. Doctors can only
+// see their own.
 func GetDoctorAnalytics(c *gin.Context) {
-	// This would be implemented to show doctor-specific analytics
-	// For now, returning a placeholder
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	doctor, ok := loadDoctor(c)
+	if !ok {
+		return
+	}
+	if !services.HasScope(user, services.PermAnalyticsRead, services.ScopeAny) && doctor.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	appointments, err := storage.GlobalStorage.GetAppointmentsByDoctorID(doctor.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch appointments"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Doctor analytics endpoint - to be implemented",
+		"doctor_id":          doctor.ID,
+		"care_team_stats":    patientStats(services.CarePatientIDs(doctor.ID)),
+		"total_appointments": len(appointments),
 	})
 }
+
+// patientStats counts the images and detection results of the given patients
+func patientStats(patientIDs []uuid.UUID) gin.H {
+	totalImages, totalDetections, drDetected := 0, 0, 0
+	for _, patientID := range patientIDs {
+		images, err := storage.GlobalStorage.GetImagesByPatientID(patientID)
+		if err != nil {
+			continue
+		}
+		totalImages += len(images)
+		for _, image := range images {
+			results, err := storage.GlobalStorage.GetDetectionResultsByImageID(image.ID)
+			if err != nil {
+				continue
+			}
+			for _, result := range results {
+				if result.Retraction != nil {
+					continue
+				}
+				totalDetections++
+				if result.HasDR {
+					drDetected++
+				}
+			}
+		}
+	}
+
+	return gin.H{
+		"total_patients":   len(patientIDs),
+		"total_images":     totalImages,
+		"total_detections": totalDetections,
+		"dr_detected":      drDetected,
+	}
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"net/http"
+
+	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type AnnotationRequest struct {
+	Lesions []storage.Lesion `json:"lesions" binding:"required"`
+	Notes   string           `json:"notes"`
+}
+
+// CreateAnnotation stores clinician lesion annotations for an image
+func CreateAnnotation(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	var req AnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	image, err := storage.GlobalStorage.GetImageByID(imageID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	if !hasImageAccess(user, image, services.PermAnnotationsWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	if !ensureNotArchived(c, image) {
+		return
+	}
+
+	if err := services.ValidateLesions(req.Lesions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	annotation := &storage.Annotation{
+		ImageID:  imageID,
+		Source:   storage.AnnotationSourceClinician,
+		AuthorID: user.ID,
+		Lesions:  req.Lesions,
+		Notes:    req.Notes,
+	}
+
+	if err := storage.GlobalStorage.CreateAnnotation(annotation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save annotation"})
+		return
+	}
+
+	defer recordServedAccess(c, services.PermAnnotationsWrite, image.PatientID)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Annotation created successfully",
+		"annotation": annotation,
+	})
+}
+
+// GetImageAnnotations returns the annotations of an image
+func GetImageAnnotations(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	image, err := storage.GlobalStorage.GetImageByID(imageID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	if !hasImageAccess(user, image, services.PermImagesRead) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	includeHistory := c.Query("history") == "true"
+	annotations, err := storage.GlobalStorage.GetAnnotationsByImageID(imageID, includeHistory)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch annotations"})
+		return
+	}
+
+	defer recordServedAccess(c, services.PermImagesRead, image.PatientID)
+	c.JSON(http.StatusOK, gin.H{"annotations": annotations})
+}
+
+// ExportImageAnnotationsCOCO exports the current annotations of an image in COCO JSON
+func ExportImageAnnotationsCOCO(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	image, err := storage.GlobalStorage.GetImageByID(imageID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	if !hasImageAccess(user, image, services.PermImagesRead) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	annotations, err := storage.GlobalStorage.GetAnnotationsByImageID(imageID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch annotations"})
+		return
+	}
+
+	export, err := services.BuildCOCOExport(image, annotations)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export annotations: " + err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=annotations_"+imageID.String()+".json")
+	defer recordServedAccess(c, services.PermImagesRead, image.PatientID)
+	c.JSON(http.StatusOK, export)
+}
+
+// GetAnnotation returns a specific annotation with its version history
+func GetAnnotation(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	annotationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid annotation ID"})
+		return
+	}
+
+	annotation, err := storage.GlobalStorage.GetAnnotationByID(annotationID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Annotation not found"})
+		return
+	}
+
+	image, err := storage.GlobalStorage.GetImageByID(annotation.ImageID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	if !hasImageAccess(user, image, services.PermImagesRead) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	history, err := storage.GlobalStorage.GetAnnotationHistory(annotationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch annotation history"})
+		return
+	}
+
+	defer recordServedAccess(c, services.PermImagesRead, image.PatientID)
+	c.JSON(http.StatusOK, gin.H{
+		"annotation": annotation,
+		"history":    history,
+	})
+}
+
+// UpdateAnnotation records a new version of the caller's annotation
+func UpdateAnnotation(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	annotationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid annotation ID"})
+		return
+	}
+
+	var req AnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := storage.GlobalStorage.GetAnnotationByID(annotationID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Annotation not found"})
+		return
+	}
+
+	// Clinicians may only revise their own annotations; CNN output is immutable
+	if existing.Source != storage.AnnotationSourceClinician || existing.AuthorID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Can only update your own annotations"})
+		return
+	}
+	image, err := storage.GlobalStorage.GetImageByID(existing.ImageID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	if !hasImageAccess(user, image, services.PermAnnotationsWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if err := services.ValidateLesions(req.Lesions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	annotation := &storage.Annotation{
+		ImageID:  existing.ImageID,
+		Source:   existing.Source,
+		AuthorID: existing.AuthorID,
+		Lesions:  req.Lesions,
+		Notes:    req.Notes,
+	}
+
+	if err := storage.GlobalStorage.CreateAnnotation(annotation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update annotation"})
+		return
+	}
+
+	defer recordServedAccess(c, services.PermAnnotationsWrite, image.PatientID)
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Annotation updated successfully",
+		"annotation": annotation,
+	})
+}
+
+// DeleteAnnotation removes an annotation version
+func DeleteAnnotation(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	annotationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid annotation ID"})
+		return
+	}
+
+	annotation, err := storage.GlobalStorage.GetAnnotationByID(annotationID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Annotation not found"})
+		return
+	}
+
+	if !services.HasScope(user, services.PermAnnotationsDelete, services.ScopeAny) && annotation.AuthorID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Can only delete your own annotations"})
+		return
+	}
+
+	if err := storage.GlobalStorage.DeleteAnnotation(annotationID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete annotation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Annotation deleted successfully",
+	})
+}
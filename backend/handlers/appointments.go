@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
 	"dr-mario-backend/storage"
 
 	"github.com/gin-gonic/gin"
@@ -60,34 +61,23 @@ func CreateAppointment(c *gin.Context) {
 		return
 	}
 
-	// Verify doctor exists
-	_, err = storage.GlobalStorage.GetDoctorByID(req.DoctorID)
-	if err != nil {
+	// Verify doctor exists and may see patients
+	doctor, err := storage.GlobalStorage.GetDoctorByID(req.DoctorID)
+	if err != nil || doctor.VerificationStatus != storage.DoctorVerificationVerified {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor not found"})
 		return
 	}
 
-	// Check permissions
-	if user.Role == "patient" {
-		currentPatient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-		if currentPatient.ID != req.PatientID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only create appointments for yourself"})
-			return
-		}
-	} else if user.Role == "doctor" {
-		currentDoctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-		if currentDoctor.ID != req.DoctorID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only create appointments for yourself"})
-			return
-		}
+	// Check permissions: patients book for themselves, and doctors for
+	// themselves with patients in their care
+	scope := appointmentScope(user, req.PatientID, req.DoctorID)
+	if scope == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Can only create appointments for yourself"})
+		return
+	}
+	if scope == services.ScopeCare && !services.Authorize(user, services.PermPatientsRead, req.PatientID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrPatientNotInCare.Error()})
+		return
 	}
 
 	// Check for scheduling conflicts
@@ -116,6 +106,12 @@ func CreateAppointment(c *gin.Context) {
 		return
 	}
 
+	// Booking an appointment puts the patient in the doctor's care
+	if _, err := services.EnsureCareRelationship(req.DoctorID, req.PatientID, storage.CareSourceAppointment, appointment.ID, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update care team"})
+		return
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message":     "Appointment created successfully",
 		"appointment": appointment,
@@ -131,28 +127,31 @@ func GetAppointments(c *gin.Context) {
 	}
 
 	var appointments []*storage.Appointment
-	if user.Role == "patient" {
+	if services.HasScope(user, services.PermAppointmentsManage, services.ScopeOwn) {
 		patient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Patient profile not found"})
 			return
 		}
-		appointments, err = storage.GlobalStorage.GetAppointmentsByPatientID(patient.ID)
+		patientAppointments, err := storage.GlobalStorage.GetAppointmentsByPatientID(patient.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch appointments"})
 			return
 		}
-	} else if user.Role == "doctor" {
+		appointments = append(appointments, patientAppointments...)
+	}
+	if services.HasScope(user, services.PermAppointmentsManage, services.ScopeCare) {
 		doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Doctor profile not found"})
 			return
 		}
-		appointments, err = storage.GlobalStorage.GetAppointmentsByDoctorID(doctor.ID)
+		doctorAppointments, err := storage.GlobalStorage.GetAppointmentsByDoctorID(doctor.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch appointments"})
 			return
 		}
+		appointments = append(appointments, doctorAppointments...)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"appointments": appointments})
@@ -179,26 +178,9 @@ func GetAppointment(c *gin.Context) {
 	}
 
 	// Check permissions
-	if user.Role == "patient" {
-		currentPatient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-		if appointment.PatientID != currentPatient.ID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-	} else if user.Role == "doctor" {
-		currentDoctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-		if appointment.DoctorID != currentDoctor.ID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
+	if appointmentScope(user, appointment.PatientID, appointment.DoctorID) == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"appointment": appointment})
@@ -231,26 +213,9 @@ func UpdateAppointment(c *gin.Context) {
 	}
 
 	// Check permissions
-	if user.Role == "patient" {
-		currentPatient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-		if appointment.PatientID != currentPatient.ID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-	} else if user.Role == "doctor" {
-		currentDoctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-		if appointment.DoctorID != currentDoctor.ID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
+	if appointmentScope(user, appointment.PatientID, appointment.DoctorID) == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
 	}
 
 	// Update fields
@@ -311,26 +276,9 @@ func CancelAppointment(c *gin.Context) {
 	}
 
 	// Check permissions
-	if user.Role == "patient" {
-		currentPatient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-		if appointment.PatientID != currentPatient.ID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-	} else if user.Role == "doctor" {
-		currentDoctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-		if appointment.DoctorID != currentDoctor.ID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
+	if appointmentScope(user, appointment.PatientID, appointment.DoctorID) == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
 	}
 
 	// Check if appointment can be cancelled
@@ -354,3 +302,23 @@ func CancelAppointment(c *gin.Context) {
 		"message": "Appointment cancelled successfully",
 	})
 }
+
+// appointmentScope returns the scope at which the user manages an appointment
+// between the patient and doctor: any, own when the user is the patient, care
+// when the user is the doctor, or "" when they may not manage it
+func appointmentScope(user *storage.User, patientID, doctorID uuid.UUID) string {
+	if services.HasScope(user, services.PermAppointmentsManage, services.ScopeAny) {
+		return services.ScopeAny
+	}
+	if services.HasScope(user, services.PermAppointmentsManage, services.ScopeOwn) {
+		if patient, err := storage.GlobalStorage.GetPatientByUserID(user.ID); err == nil && patient.ID == patientID {
+			return services.ScopeOwn
+		}
+	}
+	if services.HasScope(user, services.PermAppointmentsManage, services.ScopeCare) {
+		if doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID); err == nil && doctor.ID == doctorID {
+			return services.ScopeCare
+		}
+	}
+	return ""
+}
@@ -1,14 +1,18 @@
 package handlers
 
 import (
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
-	"dr-mario-backend/config"
+	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
 	"dr-mario-backend/storage"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -27,9 +31,31 @@ type RegisterRequest struct {
 	Phone     string `json:"phone"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+	All          bool   `json:"all"`
+}
+
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  storage.User `json:"user"`
+	Token                   string       `json:"token"`
+	ExpiresAt               time.Time    `json:"expires_at"`
+	RefreshToken            string       `json:"refresh_token"`
+	RefreshExpiresAt        time.Time    `json:"refresh_expires_at"`
+	MFAEnrollmentRequired   bool         `json:"mfa_enrollment_required,omitempty"`
+	VerificationEmailFailed bool         `json:"verification_email_failed,omitempty"`
+	User                    storage.User `json:"user"`
+}
+
+// MFAChallengeResponse is returned by Login instead of tokens when the user
+// has MFA enabled. The token is exchanged at /auth/mfa/verify with a code.
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
 }
 
 // Register handles user registration
@@ -70,7 +96,7 @@ func Register(c *gin.Context) {
 	}
 
 	// Create role-specific profile
-	if req.Role == "patient" {
+	if req.Role == services.RolePatient {
 		patient := &storage.Patient{
 			UserID: user.ID,
 		}
@@ -78,7 +104,7 @@ func Register(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create patient profile"})
 			return
 		}
-	} else if req.Role == "doctor" {
+	} else if req.Role == services.RoleDoctor {
 		doctor := &storage.Doctor{
 			UserID: user.ID,
 		}
@@ -88,17 +114,39 @@ func Register(c *gin.Context) {
 		}
 	}
 
-	// Generate token
-	token, err := generateToken(user)
+	// Ask the user to confirm their email address. The account already
+	// exists, so a failure only means the user has to ask for a new link.
+	emailFailed := false
+	if err := services.SendEmailVerification(user); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+		emailFailed = true
+	}
+
+	// Unverified accounts cannot sign in when verification is required
+	if services.EmailVerificationRequired() {
+		message := "Account created. Follow the link we emailed you to verify your address, then sign in."
+		if emailFailed {
+			message = "Account created, but we could not send the verification email. Request a new link to verify your address, then sign in."
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"message":                     message,
+			"email_verification_required": true,
+			"verification_email_failed":   emailFailed,
+			"user":                        user,
+		})
+		return
+	}
+
+	// Generate tokens
+	tokens, err := services.IssueTokens(user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, AuthResponse{
-		Token: token,
-		User:  *user,
-	})
+	response := newAuthResponse(tokens, user)
+	response.VerificationEmailFailed = emailFailed
+	c.JSON(http.StatusCreated, response)
 }
 
 // Login handles user authentication
@@ -109,30 +157,107 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	// Refuse locked accounts and IPs, and slow down repeated failures
+	attempt, ok := beginLoginAttempt(c, req.Email, c.ClientIP())
+	if !ok {
+		return
+	}
+	defer attempt.Release()
+
 	// Find user
 	user, err := storage.GlobalStorage.GetUserByEmail(req.Email)
 	if err != nil {
+		attempt.Fail()
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		attempt.Fail()
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	// Generate token
-	token, err := generateToken(user)
+	if user.DeactivatedAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrAccountDeactivated.Error()})
+		return
+	}
+
+	if services.EmailVerificationRequired() && user.EmailVerifiedAt == nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":                       "Please verify your email address before signing in",
+			"email_verification_required": true,
+		})
+		return
+	}
+
+	// Users with MFA enabled get a challenge instead of tokens
+	if user.MFAEnabled {
+		mfaToken, expiresAt, err := services.StartMFAChallenge(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start MFA challenge"})
+			return
+		}
+		c.JSON(http.StatusOK, MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresAt:   expiresAt,
+		})
+		return
+	}
+	attempt.Succeed()
+
+	// Generate tokens
+	tokens, err := services.IssueTokens(user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, AuthResponse{
-		Token: token,
-		User:  *user,
-	})
+	c.JSON(http.StatusOK, newAuthResponse(tokens, user))
+}
+
+// RefreshToken rotates a refresh token and issues a new access token
+func RefreshToken(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, user, err := services.RefreshTokens(req.RefreshToken)
+	if errors.Is(err, services.ErrRefreshTokenInvalid) || errors.Is(err, services.ErrRefreshTokenReused) || errors.Is(err, services.ErrAccountDeactivated) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newAuthResponse(tokens, user))
+}
+
+// Logout revokes the current access token and the refresh token's session
+func Logout(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req LogoutRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	services.Logout(user.ID, c.GetString("token_id"), c.GetTime("token_expires_at"), req.RefreshToken, req.All)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
 // GetProfile returns the current user's profile
@@ -149,7 +274,10 @@ func GetProfile(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"user": user})
+	c.JSON(http.StatusOK, gin.H{
+		"user":        user,
+		"permissions": services.Permissions(user),
+	})
 }
 
 // UpdateProfile updates the current user's profile
@@ -196,20 +324,39 @@ func UpdateProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"user": user})
 }
 
-// generateToken creates a JWT token for the user
-func generateToken(user *storage.User) (string, error) {
-	expiry, err := time.ParseDuration(config.AppConfig.JWT.Expiry)
-	if err != nil {
-		expiry = 24 * time.Hour // Default to 24 hours
-	}
+// GetJWKS publishes the public keys that verify access tokens so other
+// services can validate them without sharing a secret
+func GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": services.PublicJWKS()})
+}
 
-	claims := jwt.RegisteredClaims{
-		Subject:   user.ID.String(),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		NotBefore: jwt.NewNumericDate(time.Now()),
+// beginLoginAttempt starts a sign-in attempt, or writes a 429 response with
+// Retry-After and returns false if the account or IP is throttled
+func beginLoginAttempt(c *gin.Context, email, ip string) (*services.LoginAttempt, bool) {
+	attempt, err := services.BeginLoginAttempt(email, ip)
+	var throttled *services.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return attempt, true
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(config.AppConfig.JWT.Secret))
+	retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       throttled.Error(),
+		"locked":      throttled.Locked,
+		"retry_after": retryAfter,
+	})
+	return nil, false
+}
+
+func newAuthResponse(tokens *services.TokenPair, user *storage.User) AuthResponse {
+	return AuthResponse{
+		Token:                 tokens.AccessToken,
+		ExpiresAt:             tokens.AccessExpiresAt,
+		RefreshToken:          tokens.RefreshToken,
+		RefreshExpiresAt:      tokens.RefreshExpiresAt,
+		MFAEnrollmentRequired: services.MFARequired(user) && !user.MFAEnabled,
+		User:                  *user,
+	}
 }
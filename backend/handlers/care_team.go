@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type AssignCareRequest struct {
+	DoctorID  uuid.UUID  `json:"doctor_id" binding:"required"`
+	PatientID uuid.UUID  `json:"patient_id" binding:"required"`
+	StartsAt  *time.Time `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at"`
+	Notes     string     `json:"notes"`
+}
+
+type ReferralRequest struct {
+	DoctorID uuid.UUID  `json:"doctor_id" binding:"required"`
+	Reason   string     `json:"reason" binding:"required"`
+	EndsAt   *time.Time `json:"ends_at"`
+}
+
+// GetCareRelationships lists care relationships: a doctor's patients, a
+// patient's doctors, or with care_team:read:any any relationship filtered by
+// the doctor_id and patient_id query parameters. Ended relationships are only included with
+// include_ended=true.
+func GetCareRelationships(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var filter storage.CareRelationshipFilter
+	switch {
+	case services.HasScope(user, services.PermCareTeamRead, services.ScopeAny):
+		for param, id := range map[string]*uuid.UUID{"doctor_id": &filter.DoctorID, "patient_id": &filter.PatientID} {
+			if value := c.Query(param); value != "" {
+				parsed, err := uuid.Parse(value)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+					return
+				}
+				*id = parsed
+			}
+		}
+	case services.HasPermission(user, services.PermDoctorProfile):
+		doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Doctor profile not found"})
+			return
+		}
+		filter.DoctorID = doctor.ID
+	case services.HasPermission(user, services.PermPatientProfile):
+		patient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Patient profile not found"})
+			return
+		}
+		filter.PatientID = patient.ID
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	if c.Query("include_ended") != "true" {
+		filter.ActiveAt = time.Now()
+	}
+
+	relationships := storage.GlobalStorage.GetCareRelationships(filter)
+	c.JSON(http.StatusOK, gin.H{
+		"relationships": relationships,
+		"total":         len(relationships),
+	})
+}
+
+// AssignCare lets an admin put a patient in a doctor's care, optionally for a
+// limited period
+func AssignCare(c *gin.Context) {
+	admin, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req AssignCareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	startsAt := time.Now()
+	if req.StartsAt != nil {
+		startsAt = *req.StartsAt
+	}
+
+	relationship, err := services.AssignCare(req.DoctorID, req.PatientID, startsAt, req.EndsAt, req.Notes, admin.ID)
+	if !respondCareError(c, err) {
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Patient assigned to doctor",
+		"relationship": relationship,
+	})
+}
+
+// ReferPatient lets a doctor refer a patient in their care to another doctor
+func ReferPatient(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	patientID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+		return
+	}
+
+	var req ReferralRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	relationship, err := services.ReferPatient(user, patientID, req.DoctorID, req.Reason, req.EndsAt)
+	if !respondCareError(c, err) {
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Patient referred",
+		"relationship": relationship,
+	})
+}
+
+// EndCare ends a care relationship. Doctors can end their own relationships
+// and admins any.
+func EndCare(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	relationshipID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid care relationship ID"})
+		return
+	}
+
+	relationship, err := storage.GlobalStorage.GetCareRelationship(relationshipID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Care relationship not found"})
+		return
+	}
+	if !services.HasScope(user, services.PermCareTeamEnd, services.ScopeAny) && (relationship.Doctor == nil || relationship.Doctor.UserID != user.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Care relationship not found"})
+		return
+	}
+
+	if !respondCareError(c, services.EndCare(relationship, user.ID)) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Care relationship ended",
+		"relationship": relationship,
+	})
+}
+
+// respondCareError writes the response for a care team error and returns
+// false, or returns true when there is no error
+func respondCareError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor or patient not found"})
+	case errors.Is(err, services.ErrPatientNotInCare):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCarePeriodInvalid), errors.Is(err, services.ErrReferralInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCareRelationshipEnded):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update care team"})
+	}
+	return false
+}
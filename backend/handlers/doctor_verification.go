@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type RejectDoctorRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// GetCredentialDocuments lists the current doctor's credential documents
+func GetCredentialDocuments(c *gin.Context) {
+	doctor, ok := loadCurrentDoctor(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"verification_status": doctor.VerificationStatus,
+		"documents":           storage.GlobalStorage.GetCredentialDocuments(doctor.ID),
+	})
+}
+
+// UploadCredentialDocument adds a document, such as a scan of the medical
+// license, for an admin to review
+func UploadCredentialDocument(c *gin.Context) {
+	doctor, ok := loadCurrentDoctor(c)
+	if !ok {
+		return
+	}
+
+	file, header, err := c.Request.FormFile("document")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No document file provided"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, config.AppConfig.Upload.MaxFileSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	if int64(len(data)) > config.AppConfig.Upload.MaxFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File too large"})
+		return
+	}
+
+	document, err := services.SaveCredentialDocument(doctor, header.Filename, data)
+	if errors.Is(err, services.ErrCredentialTypeInvalid) || errors.Is(err, services.ErrCredentialDocumentEmpty) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save document"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":             "Document uploaded for review",
+		"document":            document,
+		"verification_status": doctor.VerificationStatus,
+	})
+}
+
+// ServeOwnCredentialDocument serves one of the current doctor's documents
+func ServeOwnCredentialDocument(c *gin.Context) {
+	doctor, ok := loadCurrentDoctor(c)
+	if !ok {
+		return
+	}
+	document, ok := loadCredentialDocument(c, doctor)
+	if !ok {
+		return
+	}
+
+	serveBlob(c, document.Key, "Document file not found", credentialHeaders(document))
+}
+
+// DeleteCredentialDocument removes one of the current doctor's documents
+// while the credentials are not yet verified
+func DeleteCredentialDocument(c *gin.Context) {
+	doctor, ok := loadCurrentDoctor(c)
+	if !ok {
+		return
+	}
+	document, ok := loadCredentialDocument(c, doctor)
+	if !ok {
+		return
+	}
+
+	err := services.DeleteCredentialDocument(doctor, document)
+	if errors.Is(err, services.ErrCredentialsLocked) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Document deleted"})
+}
+
+// GetDoctorVerifications lists doctors for credential review, filtered by
+// the status query parameter (pending by default, or all)
+func GetDoctorVerifications(c *gin.Context) {
+	status := c.DefaultQuery("status", storage.DoctorVerificationPending)
+	switch status {
+	case "all", storage.DoctorVerificationPending, storage.DoctorVerificationVerified, storage.DoctorVerificationRejected:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, verified, rejected or all"})
+		return
+	}
+
+	doctors, err := storage.GlobalStorage.GetAllDoctors()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch doctors"})
+		return
+	}
+
+	reviews := []gin.H{}
+	for _, doctor := range doctors {
+		if status != "all" && doctor.VerificationStatus != status {
+			continue
+		}
+		reviews = append(reviews, gin.H{
+			"doctor":    doctor,
+			"documents": storage.GlobalStorage.GetCredentialDocuments(doctor.ID),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"doctors": reviews,
+		"total":   len(reviews),
+	})
+}
+
+// GetDoctorVerification returns a doctor with their credential documents
+func GetDoctorVerification(c *gin.Context) {
+	doctor, ok := loadDoctor(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"doctor":    doctor,
+		"documents": storage.GlobalStorage.GetCredentialDocuments(doctor.ID),
+	})
+}
+
+// ServeCredentialDocument serves a doctor's credential document for review
+func ServeCredentialDocument(c *gin.Context) {
+	doctor, ok := loadDoctor(c)
+	if !ok {
+		return
+	}
+	document, ok := loadCredentialDocument(c, doctor)
+	if !ok {
+		return
+	}
+
+	serveBlob(c, document.Key, "Document file not found", credentialHeaders(document))
+}
+
+// VerifyDoctor approves a doctor's credentials
+func VerifyDoctor(c *gin.Context) {
+	admin, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	doctor, ok := loadDoctor(c)
+	if !ok {
+		return
+	}
+
+	if !respondDoctorReviewError(c, services.VerifyDoctor(doctor, admin.ID)) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Doctor verified",
+		"doctor":  doctor,
+	})
+}
+
+// RejectDoctor declines a doctor's credentials, or revokes a verification
+func RejectDoctor(c *gin.Context) {
+	admin, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	doctor, ok := loadDoctor(c)
+	if !ok {
+		return
+	}
+
+	var req RejectDoctorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !respondDoctorReviewError(c, services.RejectDoctor(doctor, req.Reason, admin.ID)) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Doctor credentials rejected",
+		"doctor":  doctor,
+	})
+}
+
+// loadCurrentDoctor fetches the doctor profile of the signed-in user, writing
+// an error response and returning false if there is none
+func loadCurrentDoctor(c *gin.Context) (*storage.Doctor, bool) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return nil, false
+	}
+
+	doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor profile not found"})
+		return nil, false
+	}
+	return doctor, true
+}
+
+// loadDoctor fetches the doctor named by the id path parameter
+func loadDoctor(c *gin.Context) (*storage.Doctor, bool) {
+	doctorID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid doctor ID"})
+		return nil, false
+	}
+
+	doctor, err := storage.GlobalStorage.GetDoctorByID(doctorID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor not found"})
+		return nil, false
+	}
+	return doctor, true
+}
+
+// loadCredentialDocument fetches the document named by the documentId path
+// parameter if it belongs to the doctor
+func loadCredentialDocument(c *gin.Context, doctor *storage.Doctor) (*storage.CredentialDocument, bool) {
+	documentID, err := uuid.Parse(c.Param("documentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return nil, false
+	}
+
+	document, err := storage.GlobalStorage.GetCredentialDocument(documentID)
+	if err != nil || document.DoctorID != doctor.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return nil, false
+	}
+	return document, true
+}
+
+// credentialHeaders keeps credential documents out of shared caches and
+// offers them as downloads under their original name
+func credentialHeaders(document *storage.CredentialDocument) map[string]string {
+	return map[string]string{
+		"Cache-Control":          "private, no-store",
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": document.FileName}),
+		"X-Content-Type-Options": "nosniff",
+	}
+}
+
+// respondDoctorReviewError writes the response for a doctor review error and
+// returns false, or returns true when there is no error
+func respondDoctorReviewError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrCredentialsIncomplete), errors.Is(err, services.ErrDoctorAlreadyReviewed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update doctor verification"})
+	}
+	return false
+}
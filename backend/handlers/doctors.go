@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
 	"dr-mario-backend/storage"
 
 	"github.com/gin-gonic/gin"
@@ -17,9 +18,10 @@ type DoctorProfileRequest struct {
 	Hospital       string `json:"hospital"`
 }
 
-// GetDoctors returns all doctors
+// GetDoctors returns all doctors. Only users who verify doctors, such as
+// admins, see doctors whose credentials are not verified.
 func GetDoctors(c *gin.Context) {
-	_, err := middleware.GetUserFromContext(c)
+	user, err := middleware.GetUserFromContext(c)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
@@ -31,11 +33,27 @@ func GetDoctors(c *gin.Context) {
 		return
 	}
 
+	if !services.HasPermission(user, services.PermDoctorsVerify) {
+		verified := []*storage.Doctor{}
+		for _, doctor := range doctors {
+			if doctor.VerificationStatus == storage.DoctorVerificationVerified {
+				verified = append(verified, doctor)
+			}
+		}
+		doctors = verified
+	}
+
 	c.JSON(http.StatusOK, gin.H{"doctors": doctors})
 }
 
 // GetDoctor returns a specific doctor by ID
 func GetDoctor(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
 	doctorID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid doctor ID"})
@@ -43,7 +61,7 @@ func GetDoctor(c *gin.Context) {
 	}
 
 	doctor, err := storage.GlobalStorage.GetDoctorByID(doctorID)
-	if err != nil {
+	if err != nil || (!services.HasPermission(user, services.PermDoctorsVerify) && doctor.VerificationStatus != storage.DoctorVerificationVerified) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor not found"})
 		return
 	}
@@ -59,11 +77,6 @@ func GetDoctorProfile(c *gin.Context) {
 		return
 	}
 
-	if user.Role != "doctor" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-		return
-	}
-
 	doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor profile not found"})
@@ -81,11 +94,6 @@ func UpdateDoctorProfile(c *gin.Context) {
 		return
 	}
 
-	if user.Role != "doctor" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-		return
-	}
-
 	var req DoctorProfileRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -98,9 +106,9 @@ func UpdateDoctorProfile(c *gin.Context) {
 		return
 	}
 
-	// Update fields
+	// Update fields; a new license number has to be verified again
 	if req.License != "" {
-		doctor.License = req.License
+		services.ChangeDoctorLicense(doctor, req.License)
 	}
 	if req.Specialization != "" {
 		doctor.Specialization = req.Specialization
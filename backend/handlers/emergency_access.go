@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type BreakGlassRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+type ReviewEmergencyAccessRequest struct {
+	Outcome string `json:"outcome" binding:"required"`
+	Notes   string `json:"notes"`
+}
+
+// BreakGlass gives a doctor time-limited emergency access to a patient outside
+// their care
+func BreakGlass(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	patientID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+		return
+	}
+
+	var req BreakGlassRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	access, err := services.BreakGlass(user, patientID, req.Reason, c.ClientIP())
+	if !respondEmergencyAccessError(c, err) {
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Emergency access granted. This access is logged and will be reviewed.",
+		"access":  access,
+	})
+}
+
+// GetEmergencyAccessReport lists emergency accesses for compliance review,
+// newest first. Filter with the status (reviewed or unreviewed), doctor_id,
+// patient_id and since (RFC 3339) query parameters.
+func GetEmergencyAccessReport(c *gin.Context) {
+	var filter storage.EmergencyAccessFilter
+	switch status := c.Query("status"); status {
+	case "", "reviewed", "unreviewed":
+		filter.Reviewed = status
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be reviewed or unreviewed"})
+		return
+	}
+	for param, id := range map[string]*uuid.UUID{"doctor_id": &filter.DoctorID, "patient_id": &filter.PatientID} {
+		if value := c.Query(param); value != "" {
+			parsed, err := uuid.Parse(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return
+			}
+			*id = parsed
+		}
+	}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC 3339 timestamp"})
+			return
+		}
+		filter.Since = t
+	}
+
+	accesses := storage.GlobalStorage.GetEmergencyAccesses(filter)
+	unreviewed, unjustified := 0, 0
+	for _, access := range accesses {
+		switch {
+		case access.ReviewedAt == nil:
+			unreviewed++
+		case access.ReviewOutcome == storage.EmergencyAccessUnjustified:
+			unjustified++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"accesses":    accesses,
+		"total":       len(accesses),
+		"unreviewed":  unreviewed,
+		"unjustified": unjustified,
+	})
+}
+
+// ReviewEmergencyAccess records the outcome of a compliance review of an
+// emergency access
+func ReviewEmergencyAccess(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	accessID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid emergency access ID"})
+		return
+	}
+
+	var req ReviewEmergencyAccessRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	access, err := storage.GlobalStorage.GetEmergencyAccess(accessID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Emergency access not found"})
+		return
+	}
+	if !respondEmergencyAccessError(c, services.ReviewEmergencyAccess(access, req.Outcome, req.Notes, user.ID)) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Emergency access reviewed",
+		"access":  access,
+	})
+}
+
+// respondEmergencyAccessError writes the response for an emergency access
+// error and returns false, or returns true when there is no error
+func respondEmergencyAccessError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor or patient not found"})
+	case errors.Is(err, services.ErrBreakGlassReasonRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s (at least %d characters)",
+			err.Error(), config.AppConfig.BreakGlass.MinReasonLength)})
+	case errors.Is(err, services.ErrEmergencyReviewOutcomeInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrBreakGlassNotNeeded), errors.Is(err, services.ErrEmergencyAccessReviewed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update emergency access"})
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestEmergencyAccessCountsServedRecordsOnly(t *testing.T) {
+	patientUser := &storage.User{Email: t.Name() + "-patient@example.com", Role: "patient"}
+	doctorUser := &storage.User{Email: t.Name() + "-doctor@example.com", Role: "doctor"}
+	for _, user := range []*storage.User{patientUser, doctorUser} {
+		if err := storage.GlobalStorage.CreateUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	patient := &storage.Patient{UserID: patientUser.ID}
+	if err := storage.GlobalStorage.CreatePatient(patient); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.GlobalStorage.CreateDoctor(&storage.Doctor{UserID: doctorUser.ID, VerificationStatus: storage.DoctorVerificationVerified}); err != nil {
+		t.Fatal(err)
+	}
+
+	access, err := services.BreakGlass(doctorUser, patient.ID, "Patient unconscious in the emergency department", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Checking access is not a use of it
+	for i := 0; i < 3; i++ {
+		if !services.Authorize(doctorUser, services.PermPatientsRead, patient.ID) {
+			t.Fatal("emergency access does not authorize reading the patient")
+		}
+	}
+
+	router := gin.New()
+	router.GET("/patients/:id", func(c *gin.Context) { c.Set("user_id", doctorUser.ID) }, GetPatient)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/patients/"+patient.ID.String(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+
+	access, err = storage.GlobalStorage.GetEmergencyAccess(access.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := access.AccessCounts[services.PermPatientsRead]; got != 1 {
+		t.Errorf("counted %d uses of %s, want 1: %v", got, services.PermPatientsRead, access.AccessCounts)
+	}
+}
+
+// breakGlassDoctor creates a verified doctor outside the patient's care who
+// breaks the glass for them
+func breakGlassDoctor(t *testing.T, patientID uuid.UUID) (*storage.User, *storage.EmergencyAccess) {
+	t.Helper()
+	user := &storage.User{Email: t.Name() + "-emergency@example.com", Role: "doctor"}
+	if err := storage.GlobalStorage.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.GlobalStorage.CreateDoctor(&storage.Doctor{UserID: user.ID, VerificationStatus: storage.DoctorVerificationVerified}); err != nil {
+		t.Fatal(err)
+	}
+	access, err := services.BreakGlass(user, patientID, "Patient unconscious in the emergency department", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, access
+}
+
+func TestEmergencyAccessIsReadOnly(t *testing.T) {
+	fixture := newSignedImageFixture(t)
+	patientID := fixture.image.PatientID
+	otherUser, _ := breakGlassDoctor(t, patientID)
+
+	for _, permission := range []string{services.PermPatientsRead, services.PermImagesRead, services.PermAnalyticsRead} {
+		if !services.Authorize(otherUser, permission, patientID) {
+			t.Errorf("emergency access does not grant %s", permission)
+		}
+	}
+	for _, permission := range []string{services.PermImagesUpload, services.PermImagesDelete, services.PermImagesAnalyze, services.PermAnnotationsWrite, services.PermResultsReview} {
+		if services.Authorize(otherUser, permission, patientID) {
+			t.Errorf("emergency access grants %s", permission)
+		}
+		if ids, all := services.AuthorizedPatientIDs(otherUser, permission); all || len(ids) > 0 {
+			t.Errorf("emergency access lists patients for %s: %v", permission, ids)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{
+			name:   "delete image",
+			method: http.MethodDelete,
+			path:   "/images/" + fixture.image.ID.String(),
+			body:   `{"reason":"duplicate"}`,
+		},
+		{
+			name:   "sign off results",
+			method: http.MethodPut,
+			path:   "/images/" + fixture.image.ID.String() + "/status",
+			body:   `{"status":"reviewed"}`,
+		},
+	}
+
+	router := gin.New()
+	setUser := func(c *gin.Context) { c.Set("user_id", otherUser.ID) }
+	router.DELETE("/images/:id", setUser, DeleteImage)
+	router.PUT("/images/:id/status", setUser, UpdateImageStatus)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	image, err := storage.GlobalStorage.GetImageByID(fixture.image.ID)
+	if err != nil || image.Deletion != nil {
+		t.Errorf("image was deleted: %v", err)
+	}
+}
+
+func TestEmergencyAccessIsNotCountedForRejectedRequests(t *testing.T) {
+	fixture := newSignedImageFixture(t)
+	user, access := breakGlassDoctor(t, fixture.image.PatientID)
+
+	router := gin.New()
+	setUser := func(c *gin.Context) { c.Set("user_id", user.ID) }
+	router.GET("/images/:id", setUser, GetImage)
+	router.GET("/images/:id/file", setUser, ServeImage)
+	router.POST("/images/:id/file/url", setUser, CreateImageURL)
+	base := "/images/" + fixture.image.ID.String()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		counts int
+	}{
+		{name: "invalid preview size", method: http.MethodGet, path: base + "/file?size=huge", status: http.StatusBadRequest},
+		{name: "invalid signed URL size", method: http.MethodPost, path: base + "/file/url?size=huge", status: http.StatusBadRequest},
+		{name: "issuing a signed URL", method: http.MethodPost, path: base + "/file/url", status: http.StatusOK},
+		{name: "image details", method: http.MethodGet, path: base, status: http.StatusOK, counts: 1},
+		{name: "image file", method: http.MethodGet, path: base + "/file", status: http.StatusOK, counts: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			access, err := storage.GlobalStorage.GetEmergencyAccess(access.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := access.AccessCounts[services.PermImagesRead]; got != tt.counts {
+				t.Errorf("counted %d uses, want %d", got, tt.counts)
+			}
+		})
+	}
+}
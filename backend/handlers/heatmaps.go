@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// loadAccessibleResult fetches a detection result and its image for the
+// handler to serve, writing an error response and returning false if the
+// caller does not hold the permission for the image
+func loadAccessibleResult(c *gin.Context, permission string) (*storage.DetectionResult, *storage.RetinalImage, bool) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return nil, nil, false
+	}
+
+	resultID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid result ID"})
+		return nil, nil, false
+	}
+
+	result, err := storage.GlobalStorage.GetDetectionResultByID(resultID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Detection result not found"})
+		return nil, nil, false
+	}
+
+	image, err := storage.GlobalStorage.GetImageByID(result.ImageID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return nil, nil, false
+	}
+
+	if !hasImageAccess(user, image, permission) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, nil, false
+	}
+
+	return result, image, true
+}
+
+// UploadHeatmap attaches a saliency/attention map to a detection result
+func UploadHeatmap(c *gin.Context) {
+	result, image, ok := loadAccessibleResult(c, services.PermResultsReview)
+	if !ok {
+		return
+	}
+	defer recordServedAccess(c, services.PermResultsReview, image.PatientID)
+	if !ensureNotArchived(c, image) {
+		return
+	}
+
+	file, _, err := c.Request.FormFile("heatmap")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No heatmap file provided"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, config.AppConfig.Upload.MaxFileSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read heatmap file"})
+		return
+	}
+	if int64(len(data)) > config.AppConfig.Upload.MaxFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large"})
+		return
+	}
+
+	if err := services.SaveHeatmap(result, image, data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := storage.GlobalStorage.UpdateDetectionResult(result); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update detection result"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Heatmap uploaded successfully",
+		"result":  result,
+	})
+}
+
+// ServeHeatmap serves the raw heatmap of a detection result
+func ServeHeatmap(c *gin.Context) {
+	result, image, ok := loadAccessibleResult(c, services.PermImagesRead)
+	if !ok {
+		return
+	}
+	defer recordServedAccess(c, services.PermImagesRead, image.PatientID)
+
+	if !result.HasHeatmap {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Heatmap not found"})
+		return
+	}
+
+	serveBlob(c, result.HeatmapPath, "Heatmap file not found", nil)
+}
+
+// RenderHeatmapOverlay serves the heatmap blended over the original fundus image as PNG
+func RenderHeatmapOverlay(c *gin.Context) {
+	result, image, ok := loadAccessibleResult(c, services.PermImagesRead)
+	if !ok {
+		return
+	}
+	defer recordServedAccess(c, services.PermImagesRead, image.PatientID)
+
+	if !result.HasHeatmap {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Heatmap not found"})
+		return
+	}
+
+	opacity, err := strconv.ParseFloat(c.DefaultQuery("opacity", "0.5"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid opacity"})
+		return
+	}
+	colormap := c.DefaultQuery("colormap", "jet")
+
+	overlay, err := services.RenderHeatmapOverlay(image.FilePath, result.HeatmapPath, opacity, colormap)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to render overlay: " + err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "image/png")
+	c.Status(http.StatusOK)
+	if err := png.Encode(c.Writer, overlay); err != nil {
+		c.Error(err)
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUploadHeatmap(t *testing.T) {
+	fixture := newSignedImageFixture(t)
+	fixture.image.Metadata = &storage.ImageMetadata{Width: 800, Height: 600}
+	if err := storage.GlobalStorage.UpdateImage(fixture.image); err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(width, height int) []byte {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	matching := encode(80, 60)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		maxSize int64
+		want    int
+	}{
+		{
+			name: "matching heatmap",
+			data: matching,
+			want: http.StatusOK,
+		},
+		{
+			name: "different aspect ratio",
+			data: encode(60, 60),
+			want: http.StatusBadRequest,
+		},
+		{
+			name:    "larger than the upload limit",
+			data:    matching,
+			maxSize: int64(len(matching) - 1),
+			want:    http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	router := gin.New()
+	router.POST("/results/:id/heatmap", func(c *gin.Context) { c.Set("user_id", fixture.doctor.ID) }, UploadHeatmap)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.maxSize > 0 {
+				maxSize := config.AppConfig.Upload.MaxFileSize
+				config.AppConfig.Upload.MaxFileSize = tt.maxSize
+				defer func() { config.AppConfig.Upload.MaxFileSize = maxSize }()
+			}
+
+			result := &storage.DetectionResult{ImageID: fixture.image.ID}
+			if err := storage.GlobalStorage.CreateDetectionResult(result); err != nil {
+				t.Fatal(err)
+			}
+
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			part, err := form.CreateFormFile("heatmap", "heatmap.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write(tt.data)
+			form.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/results/"+result.ID.String()+"/heatmap", &body)
+			req.Header.Set("Content-Type", form.FormDataContentType())
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ImageStatusRequest struct {
+	Status string `json:"status" binding:"required,oneof=reviewed rejected"`
+	Reason string `json:"reason"`
+}
+
+// UpdateImageStatus lets clinicians mark an analyzed image as reviewed or
+// reject an image. All other states are reached through upload and analysis.
+func UpdateImageStatus(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	image, ok := loadAccessibleImage(c, services.PermResultsReview)
+	if !ok {
+		return
+	}
+	defer recordServedAccess(c, services.PermResultsReview, image.PatientID)
+	if !ensureNotArchived(c, image) {
+		return
+	}
+
+	var req ImageStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Status == storage.ImageStatusRejected && req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required to reject an image"})
+		return
+	}
+
+	if !transitionImage(c, image, req.Status, user.ID, req.Reason) {
+		return
+	}
+
+	// Reviewing an image signs off its current detection results
+	if req.Status == storage.ImageStatusReviewed {
+		results, _ := storage.GlobalStorage.GetDetectionResultsByImageID(image.ID)
+		for _, result := range results {
+			if result.Retraction != nil {
+				continue
+			}
+			result.ReviewedBy = user.ID
+			result.ReviewDate = time.Now()
+			result.ReviewNotes = req.Reason
+			storage.GlobalStorage.UpdateDetectionResult(result)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Image status updated",
+		"image":   image,
+	})
+}
+
+// transitionImage moves an image to a new status. It writes a conflict
+// response and returns false when the lifecycle does not allow the change.
+func transitionImage(c *gin.Context, image *storage.RetinalImage, status string, changedBy uuid.UUID, reason string) bool {
+	err := storage.GlobalStorage.TransitionImageStatus(image, status, changedBy, reason)
+	if errors.Is(err, storage.ErrInvalidTransition) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  "Image cannot move from " + image.Status + " to " + status,
+			"status": image.Status,
+		})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image status: " + err.Error()})
+		return false
+	}
+	return true
+}
@@ -1,13 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"io"
+	"log"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
+	"strconv"
 	"time"
 
+	"dr-mario-backend/blobstore"
 	"dr-mario-backend/config"
 	"dr-mario-backend/middleware"
 	"dr-mario-backend/services"
@@ -60,100 +61,118 @@ func UploadImage(c *gin.Context) {
 	defer file.Close()
 
 	// Validate file extension
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	allowed := false
-	for _, allowedExt := range config.AppConfig.Upload.AllowedExtensions {
-		if "."+allowedExt == ext {
-			allowed = true
-			break
-		}
-	}
-	if !allowed {
+	if !services.IsAllowedExtension(header.Filename) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file type"})
 		return
 	}
 
-	// Create upload directory if it doesn't exist
-	if err := os.MkdirAll(config.AppConfig.Upload.UploadDir, 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload directory"})
+	// Read the upload into memory for content inspection
+	data, err := io.ReadAll(io.LimitReader(file, config.AppConfig.Upload.MaxFileSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	if int64(len(data)) > config.AppConfig.Upload.MaxFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File too large"})
 		return
 	}
 
-	// Generate unique filename
-	filename := uuid.New().String() + ext
-	filepath := filepath.Join(config.AppConfig.Upload.UploadDir, filename)
-
-	// Create file
-	dst, err := os.Create(filepath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file"})
+	patient, ok := resolveUploadPatient(c, user, c.PostForm("patient_id"))
+	if !ok {
+		return
+	}
+	doctorID, ok := uploaderDoctorID(c, user)
+	if !ok {
 		return
 	}
-	defer dst.Close()
 
-	// Copy file content
-	if _, err := io.Copy(dst, file); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+	image, duplicate, ok := ingestImage(c, patient.ID, doctorID, user.ID, data, header.Filename, req.ImageType, req.Notes)
+	if !ok {
+		return
+	}
+	defer recordServedAccess(c, services.PermImagesUpload, patient.ID)
+	if duplicate {
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Image already uploaded",
+			"duplicate": true,
+			"image":     image,
+		})
 		return
 	}
 
-	// Get patient ID
-	var patient *storage.Patient
-	if user.Role == "patient" {
-		patient, err = storage.GlobalStorage.GetPatientByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Patient profile not found"})
-			return
-		}
-	} else {
-		// If doctor is uploading, get patient ID from request
-		patientIDStr := c.PostForm("patient_id")
-		if patientIDStr == "" {
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Image uploaded successfully",
+		"image":   image,
+	})
+}
+
+// resolveUploadPatient returns the patient an upload belongs to: the patient
+// given by ID, or the caller's own patient profile when none is given
+func resolveUploadPatient(c *gin.Context, user *storage.User, patientIDStr string) (*storage.Patient, bool) {
+	if patientIDStr == "" {
+		patient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
+		if err != nil || !services.HasScope(user, services.PermImagesUpload, services.ScopeOwn) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Patient ID required for doctor uploads"})
-			return
-		}
-		patientID, err := uuid.Parse(patientIDStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
-			return
-		}
-		patient, err = storage.GlobalStorage.GetPatientByID(patientID)
-		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
-			return
+			return nil, false
 		}
+		return patient, true
 	}
 
-	// Create image record
-	image := &storage.RetinalImage{
-		PatientID:  patient.ID,
-		FileName:   header.Filename,
-		FilePath:   filepath,
-		FileSize:   header.Size,
-		ImageType:  req.ImageType,
-		UploadDate: time.Now(),
-		Notes:      req.Notes,
-		Status:     "pending",
+	patientID, err := uuid.Parse(patientIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+		return nil, false
 	}
-
-	if user.Role == "doctor" {
-		doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Doctor profile not found"})
-			return
-		}
-		image.DoctorID = doctor.ID
+	patient, err := storage.GlobalStorage.GetPatientByID(patientID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+		return nil, false
 	}
+	if !services.Authorize(user, services.PermImagesUpload, patient.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false
+	}
+	return patient, true
+}
 
-	if err := storage.GlobalStorage.CreateImage(image); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image record"})
-		return
+// uploaderDoctorID returns the doctor profile ID of the uploader, or uuid.Nil for non-doctors
+func uploaderDoctorID(c *gin.Context, user *storage.User) (uuid.UUID, bool) {
+	if !services.HasPermission(user, services.PermDoctorProfile) {
+		return uuid.Nil, true
+	}
+	doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Doctor profile not found"})
+		return uuid.Nil, false
 	}
+	return doctor.ID, true
+}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Image uploaded successfully",
-		"image":   image,
+// ingestImage adds uploaded bytes to the patient's images. On failure an
+// error response is written and ok is false.
+func ingestImage(c *gin.Context, patientID, doctorID, uploadedBy uuid.UUID, data []byte, filename, imageType, notes string) (image *storage.RetinalImage, duplicate bool, ok bool) {
+	image, duplicate, err := services.IngestImage(services.ImageIngest{
+		PatientID:  patientID,
+		DoctorID:   doctorID,
+		UploadedBy: uploadedBy,
+		Data:       data,
+		FileName:   filename,
+		ImageType:  imageType,
+		Notes:      notes,
 	})
+	if err != nil {
+		var rejection *services.ImageRejection
+		if errors.As(err, &rejection) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":     "Image rejected: " + rejection.Reason,
+				"rejection": rejection,
+			})
+			return nil, false, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store image: " + err.Error()})
+		return nil, false, false
+	}
+	return image, duplicate, true
 }
 
 // DetectDR performs AI detection on uploaded images using CNN
@@ -178,21 +197,31 @@ func DetectDR(c *gin.Context) {
 	}
 
 	// Check permissions
-	if user.Role == "patient" {
-		patient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-		if image.PatientID != patient.ID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
+	if !hasImageAccess(user, image, services.PermImagesAnalyze) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
 	}
 
 	// Check if image exists
-	if _, err := os.Stat(image.FilePath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Image file not found"})
+	if !ensureBlobExists(c, image.FilePath, "Image file not found") {
+		return
+	}
+
+	if !ensureNotArchived(c, image) {
+		return
+	}
+
+	// Ungradable images are marked for retake instead of being sent to the CNN
+	if !ensureGradable(c, user, image) {
+		return
+	}
+
+	// Identical content already analyzed by the same model is not re-sent to the CNN
+	if reuseCachedResult(c, user, image, services.ConfiguredPreprocessingSteps()) {
+		return
+	}
+
+	if !transitionImage(c, image, storage.ImageStatusAnalyzing, user.ID, "") {
 		return
 	}
 
@@ -202,9 +231,8 @@ func DetectDR(c *gin.Context) {
 	processingTime := time.Since(startTime).Seconds()
 
 	if err != nil {
-		// Update image status to error
-		image.Status = "error"
-		storage.GlobalStorage.UpdateImage(image)
+		// Return the image to quality_checked so detection can be retried
+		storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusQualityChecked, user.ID, "detection failed: "+err.Error())
 
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Detection failed: " + err.Error()})
 		return
@@ -212,34 +240,20 @@ func DetectDR(c *gin.Context) {
 
 	// Check if detection was successful
 	if result.Error != "" {
-		// Update image status to error
-		image.Status = "error"
-		storage.GlobalStorage.UpdateImage(image)
+		// Return the image to quality_checked so detection can be retried
+		storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusQualityChecked, user.ID, "detection failed: "+result.Error)
 
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Detection failed: " + result.Error})
 		return
 	}
 
 	// Update image status
-	image.Status = "processed"
-	storage.GlobalStorage.UpdateImage(image)
+	storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusAnalyzed, user.ID, "")
 
 	// Create detection result
-	detectionResult := &storage.DetectionResult{
-		ImageID:           image.ID,
-		HasDR:             result.HasDR,
-		DRStage:           result.DRStage,
-		Confidence:        result.Confidence,
-		HasMacularEdema:   result.HasMacularEdema,
-		HasHemorrhages:    result.HasHemorrhages,
-		HasExudates:       result.HasExudates,
-		HasMicroaneurysms: result.HasMicroaneurysms,
-		AnalysisDate:      time.Now(),
-		ProcessingTime:    processingTime,
-		ModelVersion:      result.ModelVersion,
-	}
-
-	if user.Role == "doctor" {
+	detectionResult := services.NewDetectionResult(image.ID, result, processingTime)
+
+	if services.HasPermission(user, services.PermDoctorProfile) {
 		doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
 		if err == nil {
 			detectionResult.DoctorID = doctor.ID
@@ -251,6 +265,7 @@ func DetectDR(c *gin.Context) {
 		return
 	}
 
+	defer recordServedAccess(c, services.PermImagesAnalyze, image.PatientID)
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Detection completed successfully",
 		"result":  detectionResult,
@@ -279,21 +294,27 @@ func ScanWithCNN(c *gin.Context) {
 	}
 
 	// Check permissions
-	if user.Role == "patient" {
-		patient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-		if image.PatientID != patient.ID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
+	if !hasImageAccess(user, image, services.PermImagesAnalyze) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
 	}
 
 	// Check if image exists
-	if _, err := os.Stat(image.FilePath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Image file not found"})
+	if !ensureBlobExists(c, image.FilePath, "Image file not found") {
+		return
+	}
+
+	if !ensureNotArchived(c, image) {
+		return
+	}
+
+	// Ungradable images are marked for retake instead of being sent to the CNN
+	if !ensureGradable(c, user, image) {
+		return
+	}
+
+	// Identical content already analyzed by the same model is not re-sent to the CNN
+	if reuseCachedResult(c, user, image, services.ConfiguredPreprocessingSteps()) {
 		return
 	}
 
@@ -306,42 +327,55 @@ func ScanWithCNN(c *gin.Context) {
 		return
 	}
 
-	// Perform comprehensive CNN analysis
+	if !transitionImage(c, image, storage.ImageStatusAnalyzing, user.ID, "") {
+		return
+	}
+
+	// Perform comprehensive CNN analysis on the preprocessed image
 	startTime := time.Now()
-	cnnResult, err := cnnService.ScanImageWithCNN(image.FilePath)
+	preprocessed, steps, err := cnnService.PreprocessImage(image.FilePath)
+	if err != nil {
+		storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusQualityChecked, user.ID, "preprocessing failed: "+err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Image preprocessing failed: " + err.Error()})
+		return
+	}
+	cnnResult, err := cnnService.ScanImageBytes(preprocessed, services.PreprocessedName(image.FilePath))
 	processingTime := time.Since(startTime).Seconds()
 
 	if err != nil {
+		storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusQualityChecked, user.ID, "CNN analysis failed: "+err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "CNN analysis failed: " + err.Error()})
 		return
 	}
 
 	// Check if CNN analysis was successful
 	if !cnnResult.Success {
+		storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusQualityChecked, user.ID, "CNN analysis failed: "+cnnResult.Error)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "CNN analysis failed: " + cnnResult.Error})
 		return
 	}
 
 	// Update image status
-	image.Status = "cnn_processed"
-	storage.GlobalStorage.UpdateImage(image)
+	storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusAnalyzed, user.ID, "")
 
 	// Create comprehensive detection result
 	detectionResult := &storage.DetectionResult{
-		ImageID:           image.ID,
-		HasDR:             cnnResult.HasDR,
-		DRStage:           cnnResult.DRStage,
-		Confidence:        cnnResult.Confidence,
-		HasMacularEdema:   cnnResult.MacularEdema,
-		HasHemorrhages:    cnnResult.Hemorrhages,
-		HasExudates:       cnnResult.Exudates,
-		HasMicroaneurysms: cnnResult.Microaneurysms,
-		AnalysisDate:      time.Now(),
-		ProcessingTime:    processingTime,
-		ModelVersion:      cnnResult.ModelVersion,
-	}
-
-	if user.Role == "doctor" {
+		ImageID:            image.ID,
+		HasDR:              cnnResult.HasDR,
+		DRStage:            cnnResult.DRStage,
+		Confidence:         cnnResult.Confidence,
+		HasMacularEdema:    cnnResult.MacularEdema,
+		HasHemorrhages:     cnnResult.Hemorrhages,
+		HasExudates:        cnnResult.Exudates,
+		HasMicroaneurysms:  cnnResult.Microaneurysms,
+		AnalysisDate:       time.Now(),
+		ProcessingTime:     processingTime,
+		ModelVersion:       cnnResult.ModelVersion,
+		RequestedModel:     services.CNNModelVersion,
+		PreprocessingSteps: steps,
+	}
+
+	if services.HasPermission(user, services.PermDoctorProfile) {
 		doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
 		if err == nil {
 			detectionResult.DoctorID = doctor.ID
@@ -353,6 +387,39 @@ func ScanWithCNN(c *gin.Context) {
 		return
 	}
 
+	// Store the saliency map reported by the CNN. The grading is saved
+	// already, so a heatmap that cannot be stored is logged and left out.
+	if cnnResult.Heatmap != "" {
+		if err := services.SaveHeatmapBase64(detectionResult, image, cnnResult.Heatmap); err != nil {
+			log.Printf("Failed to store CNN heatmap for result %s: %v", detectionResult.ID, err)
+		} else if err := storage.GlobalStorage.UpdateDetectionResult(detectionResult); err != nil {
+			log.Printf("Failed to attach CNN heatmap to result %s: %v", detectionResult.ID, err)
+		}
+		cnnResult.Heatmap = ""
+	}
+
+	// Store lesion geometries reported by the CNN, skipping invalid ones
+	var lesions []storage.Lesion
+	for i, lesion := range cnnResult.Lesions {
+		if err := services.ValidateLesion(lesion); err != nil {
+			log.Printf("Skipping CNN lesion %d for image %s: %v", i, image.ID, err)
+			continue
+		}
+		lesions = append(lesions, lesion)
+	}
+	if len(lesions) > 0 {
+		annotation := &storage.Annotation{
+			ImageID:      image.ID,
+			Source:       storage.AnnotationSourceCNN,
+			ModelVersion: cnnResult.ModelVersion,
+			Lesions:      lesions,
+		}
+		if err := storage.GlobalStorage.CreateAnnotation(annotation); err != nil {
+			log.Printf("Failed to store CNN lesions for image %s: %v", image.ID, err)
+		}
+	}
+
+	defer recordServedAccess(c, services.PermImagesAnalyze, image.PatientID)
 	c.JSON(http.StatusOK, gin.H{
 		"message":          "CNN analysis completed successfully",
 		"result":           cnnResult,
@@ -368,36 +435,24 @@ func GetImages(c *gin.Context) {
 		return
 	}
 
-	var images []*storage.RetinalImage
-	if user.Role == "patient" {
-		patient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Patient profile not found"})
-			return
-		}
-		images, err = storage.GlobalStorage.GetImagesByPatientID(patient.ID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
-			return
-		}
-	} else if user.Role == "doctor" {
-		_, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Doctor profile not found"})
-			return
-		}
-		// For doctors, we'll return all images they have access to
-		// In a real system, you might want to implement proper access control
-		allPatients, err := storage.GlobalStorage.GetAllPatients()
+	// Patients see their own images and doctors those of patients in their care
+	patientIDs, all := services.AuthorizedPatientIDs(user, services.PermImagesRead)
+	if all {
+		patients, err := storage.GlobalStorage.GetAllPatients()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch patients"})
 			return
 		}
-		for _, patient := range allPatients {
-			patientImages, err := storage.GlobalStorage.GetImagesByPatientID(patient.ID)
-			if err == nil {
-				images = append(images, patientImages...)
-			}
+		for _, patient := range patients {
+			patientIDs = append(patientIDs, patient.ID)
+		}
+	}
+
+	var images []*storage.RetinalImage
+	for _, patientID := range patientIDs {
+		patientImages, err := storage.GlobalStorage.GetImagesByPatientID(patientID)
+		if err == nil {
+			images = append(images, patientImages...)
 		}
 	}
 
@@ -425,16 +480,9 @@ func GetImage(c *gin.Context) {
 	}
 
 	// Check permissions
-	if user.Role == "patient" {
-		patient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-		if image.PatientID != patient.ID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
+	if !hasImageAccess(user, image, services.PermImagesRead) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
 	}
 
 	// Get detection results
@@ -444,14 +492,181 @@ func GetImage(c *gin.Context) {
 		return
 	}
 
+	defer recordServedAccess(c, services.PermImagesRead, image.PatientID)
 	c.JSON(http.StatusOK, gin.H{
 		"image":   image,
 		"results": results,
 	})
 }
 
-// ServeImage serves the image file
+// ServeImage serves the image file, or a preview when size is thumbnail or medium
 func ServeImage(c *gin.Context) {
+	image, ok := loadAccessibleImage(c, services.PermImagesRead)
+	if !ok {
+		return
+	}
+	defer recordServedAccess(c, services.PermImagesRead, image.PatientID)
+
+	// Access can be revoked at any time, so browsers revalidate on every use
+	serveImageFile(c, image, c.Query("size"), "private, no-cache")
+}
+
+// CreateImageURL issues a short-lived signed URL for the image file, so it can
+// be loaded by an <img> tag that cannot send the Authorization header
+func CreateImageURL(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	image, ok := loadAccessibleImage(c, services.PermImagesRead)
+	if !ok {
+		return
+	}
+
+	size := c.Query("size")
+	if size != "" && size != services.SizeOriginal {
+		if _, ok := services.DerivativeSizes[size]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid size. Use original, thumbnail or medium"})
+			return
+		}
+	}
+
+	url, expiresAt := services.SignImageURL(image.ID, user.ID, size)
+	c.JSON(http.StatusOK, gin.H{
+		"url":        url,
+		"expires_at": expiresAt,
+	})
+}
+
+// ServeSignedImage serves an image file from a signed URL without a bearer
+// token. Access is checked again for the user the link was issued to, so
+// links stop working as soon as that user loses access, is deactivated or,
+// for doctors, loses their verification.
+func ServeSignedImage(c *gin.Context) {
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	size := c.Query("size")
+	userID, err := services.VerifyImageSignature(imageID, size, c.Query("user"), c.Query("expires"), c.Query("signature"))
+	if errors.Is(err, services.ErrSignatureExpired) {
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := storage.GlobalStorage.GetUserByID(userID)
+	if err != nil || user.DeactivatedAt != nil || !services.DoctorVerified(user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	image, err := storage.GlobalStorage.GetImageByID(imageID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	if !hasImageAccess(user, image, services.PermImagesRead) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	// Browsers may cache the file, but not beyond the lifetime of the link
+	maxAge := int64(0)
+	if expires, err := strconv.ParseInt(c.Query("expires"), 10, 64); err == nil {
+		maxAge = max(expires-time.Now().Unix(), 0)
+	}
+	defer recordServedAccess(c, services.PermImagesRead, image.PatientID)
+	serveImageFile(c, image, size, "private, max-age="+strconv.FormatInt(maxAge, 10))
+}
+
+// serveImageFile writes the original image or one of its previews with cache headers
+func serveImageFile(c *gin.Context, image *storage.RetinalImage, size, cacheControl string) {
+	if size == "" {
+		size = services.SizeOriginal
+	}
+	key := image.FilePath
+	etag := `"` + image.ContentHash + `"`
+	if size != services.SizeOriginal {
+		if _, ok := services.DerivativeSizes[size]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid size. Use original, thumbnail or medium"})
+			return
+		}
+		etag = `"` + image.ContentHash + "-" + size + `"`
+	}
+
+	// Content is addressed by hash, so a matching ETag never needs the store
+	cacheHeaders := map[string]string{
+		"Cache-Control": cacheControl,
+		"ETag":          etag,
+	}
+	if c.GetHeader("If-None-Match") == etag {
+		for name, value := range cacheHeaders {
+			c.Header(name, value)
+		}
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	if size != services.SizeOriginal {
+		// Previews are generated on upload; create them now if that has not finished
+		var err error
+		key, err = services.EnsureDerivative(image, size)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate preview: " + err.Error()})
+			return
+		}
+	}
+
+	serveBlob(c, key, "Image file not found", cacheHeaders)
+}
+
+// ensureBlobExists writes an error response and returns false if the stored
+// object is missing or the blob store cannot be reached
+func ensureBlobExists(c *gin.Context, key, notFound string) bool {
+	_, err := blobstore.Default().Stat(c.Request.Context(), key)
+	if errors.Is(err, blobstore.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to access file: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// serveBlob streams a stored object to the client. Extra headers are only
+// sent with a successful response.
+func serveBlob(c *gin.Context, key, notFound string, headers map[string]string) {
+	reader, info, err := blobstore.Default().Get(c.Request.Context(), key)
+	if errors.Is(err, blobstore.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file: " + err.Error()})
+		return
+	}
+	defer reader.Close()
+
+	c.DataFromReader(http.StatusOK, info.Size, info.ContentType, reader, headers)
+}
+
+// AssessImageQuality re-runs the quality assessment on an image
+func AssessImageQuality(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
 	imageID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
@@ -464,13 +679,87 @@ func ServeImage(c *gin.Context) {
 		return
 	}
 
-	// Check if file exists
-	if _, err := os.Stat(image.FilePath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Image file not found"})
+	if !hasImageAccess(user, image, services.PermImagesAnalyze) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 		return
 	}
 
-	c.File(image.FilePath)
+	quality, err := services.AssessImageQuality(image.FilePath)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quality assessment failed: " + err.Error()})
+		return
+	}
+
+	services.ApplyQualityAssessment(image, quality, user.ID)
+	defer recordServedAccess(c, services.PermImagesAnalyze, image.PatientID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"quality": quality,
+		"image":   image,
+	})
+}
+
+// reuseCachedResult copies an earlier detection result computed on identical
+// content, requested from the current model version with the given
+// preprocessing steps. It writes the response and returns true when a cached
+// result was used.
+func reuseCachedResult(c *gin.Context, user *storage.User, image *storage.RetinalImage, steps []string) bool {
+	if !storage.CanTransitionImage(image.Status, storage.ImageStatusAnalyzing) {
+		return false
+	}
+	cached, err := storage.GlobalStorage.FindCachedDetectionResult(image.ContentHash, services.CNNModelVersion, steps)
+	if err != nil {
+		return false
+	}
+
+	detectionResult := services.NewCachedDetectionResult(image.ID, cached)
+
+	if services.HasPermission(user, services.PermDoctorProfile) {
+		doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
+		if err == nil {
+			detectionResult.DoctorID = doctor.ID
+		}
+	}
+
+	if err := storage.GlobalStorage.CreateDetectionResult(detectionResult); err != nil {
+		return false
+	}
+
+	reason := "reused result " + cached.ID.String()
+	storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusAnalyzing, user.ID, reason)
+	storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusAnalyzed, user.ID, reason)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Detection reused from cached result",
+		"cached":           true,
+		"result":           detectionResult,
+		"detection_result": detectionResult,
+	})
+	services.RecordPatientAccess(user, services.PermImagesAnalyze, image.PatientID)
+	return true
+}
+
+// ensureGradable assesses image quality if needed and rejects ungradable images.
+// It writes the error response and returns false when grading must not proceed.
+func ensureGradable(c *gin.Context, user *storage.User, image *storage.RetinalImage) bool {
+	if image.Quality == nil {
+		quality, err := services.AssessImageQuality(image.FilePath)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Quality assessment failed: " + err.Error()})
+			return false
+		}
+		services.ApplyQualityAssessment(image, quality, user.ID)
+	}
+
+	if !image.Quality.Gradable {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":   "Image quality insufficient for grading, please retake",
+			"quality": image.Quality,
+		})
+		return false
+	}
+
+	return true
 }
 
 // GetCNNHealth checks the health of the CNN service
@@ -490,3 +779,51 @@ func GetCNNHealth(c *gin.Context) {
 		"message": "CNN service is available",
 	})
 }
+
+// loadAccessibleImage fetches the image named by the id parameter for the
+// handler to serve, writing an error response and returning false if the
+// caller does not hold the permission for it
+func loadAccessibleImage(c *gin.Context, permission string) (*storage.RetinalImage, bool) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return nil, false
+	}
+
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return nil, false
+	}
+
+	image, err := storage.GlobalStorage.GetImageByID(imageID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return nil, false
+	}
+
+	if !hasImageAccess(user, image, permission) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false
+	}
+
+	return image, true
+}
+
+// recordServedAccess counts a use of emergency access to the patient's
+// records once the handler has responded. Handlers defer it after loading the
+// records, so that requests rejected by their own checks are not counted.
+func recordServedAccess(c *gin.Context, permission string, patientID uuid.UUID) {
+	if c.Writer.Status() >= http.StatusBadRequest {
+		return
+	}
+	if user, err := middleware.GetUserFromContext(c); err == nil {
+		services.RecordPatientAccess(user, permission, patientID)
+	}
+}
+
+// hasImageAccess reports whether the user holds the permission for the
+// patient the image belongs to
+func hasImageAccess(user *storage.User, image *storage.RetinalImage, permission string) bool {
+	return services.Authorize(user, permission, image.PatientID)
+}
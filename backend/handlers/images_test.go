@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/config"
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	config.LoadEnv()
+	os.Exit(m.Run())
+}
+
+// signedImageFixture stores an image of a patient who is in the care of a
+// verified doctor
+type signedImageFixture struct {
+	patient *storage.User
+	doctor  *storage.User
+	image   *storage.RetinalImage
+}
+
+func newSignedImageFixture(t *testing.T) *signedImageFixture {
+	t.Helper()
+	blobstore.Store = blobstore.NewLocalStore(t.TempDir())
+
+	patientUser := &storage.User{Email: t.Name() + "-patient@example.com", Role: "patient"}
+	doctorUser := &storage.User{Email: t.Name() + "-doctor@example.com", Role: "doctor"}
+	for _, user := range []*storage.User{patientUser, doctorUser} {
+		if err := storage.GlobalStorage.CreateUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	patient := &storage.Patient{UserID: patientUser.ID}
+	if err := storage.GlobalStorage.CreatePatient(patient); err != nil {
+		t.Fatal(err)
+	}
+	doctor := &storage.Doctor{UserID: doctorUser.ID, VerificationStatus: storage.DoctorVerificationVerified}
+	if err := storage.GlobalStorage.CreateDoctor(doctor); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.GlobalStorage.CreateCareRelationship(&storage.CareRelationship{
+		DoctorID:  doctor.ID,
+		PatientID: patient.ID,
+		Source:    storage.CareSourceAdmin,
+		StartsAt:  time.Now().Add(-time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("image bytes")
+	key := "images/" + t.Name() + ".png"
+	if err := blobstore.Default().Put(context.Background(), key, bytes.NewReader(data), int64(len(data)), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	image := &storage.RetinalImage{
+		PatientID:   patient.ID,
+		FileName:    "fundus.png",
+		FilePath:    key,
+		FileSize:    int64(len(data)),
+		ContentHash: services.ContentHash(data),
+	}
+	if err := storage.GlobalStorage.CreateImage(image); err != nil {
+		t.Fatal(err)
+	}
+
+	return &signedImageFixture{patient: patientUser, doctor: doctorUser, image: image}
+}
+
+func serveSigned(url string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.GET(services.SignedImagePath+":id", ServeSignedImage)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	return w
+}
+
+func TestServeSignedImage(t *testing.T) {
+	tests := []struct {
+		name   string
+		signer func(f *signedImageFixture) *storage.User
+		revoke func(t *testing.T, f *signedImageFixture)
+		modify func(url string) string
+		expiry string
+		want   int
+	}{
+		{
+			name:   "patient",
+			signer: func(f *signedImageFixture) *storage.User { return f.patient },
+			want:   http.StatusOK,
+		},
+		{
+			name:   "doctor in care",
+			signer: func(f *signedImageFixture) *storage.User { return f.doctor },
+			want:   http.StatusOK,
+		},
+		{
+			name:   "deactivated patient",
+			signer: func(f *signedImageFixture) *storage.User { return f.patient },
+			revoke: func(t *testing.T, f *signedImageFixture) {
+				now := time.Now()
+				f.patient.DeactivatedAt = &now
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name:   "deactivated doctor",
+			signer: func(f *signedImageFixture) *storage.User { return f.doctor },
+			revoke: func(t *testing.T, f *signedImageFixture) {
+				now := time.Now()
+				f.doctor.DeactivatedAt = &now
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name:   "tampered signature",
+			signer: func(f *signedImageFixture) *storage.User { return f.patient },
+			modify: func(url string) string { return strings.Replace(url, "signature=", "signature=A", 1) },
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "other user",
+			signer: func(f *signedImageFixture) *storage.User { return f.patient },
+			modify: func(url string) string {
+				return regexp.MustCompile(`user=[^&]+`).ReplaceAllString(url, "user="+uuid.NewString())
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name:   "expired",
+			signer: func(f *signedImageFixture) *storage.User { return f.patient },
+			expiry: "1ns",
+			want:   http.StatusGone,
+		},
+		{
+			name:   "doctor verification revoked",
+			signer: func(f *signedImageFixture) *storage.User { return f.doctor },
+			revoke: func(t *testing.T, f *signedImageFixture) {
+				doctor, err := storage.GlobalStorage.GetDoctorByUserID(f.doctor.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				doctor.VerificationStatus = storage.DoctorVerificationRejected
+			},
+			want: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newSignedImageFixture(t)
+			if tt.expiry != "" {
+				saved := config.AppConfig.Download.URLExpiry
+				config.AppConfig.Download.URLExpiry = tt.expiry
+				t.Cleanup(func() { config.AppConfig.Download.URLExpiry = saved })
+			}
+			url, expiresAt := services.SignImageURL(f.image.ID, tt.signer(f).ID, "")
+			if tt.revoke != nil {
+				tt.revoke(t, f)
+			}
+			if tt.modify != nil {
+				url = tt.modify(url)
+			}
+			if tt.expiry != "" {
+				// Expiry has a resolution of one second
+				time.Sleep(time.Until(expiresAt.Add(time.Second)))
+			}
+
+			w := serveSigned(url)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want == http.StatusOK && w.Body.String() != "image bytes" {
+				t.Errorf("body = %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServeImageRevalidatesAccess(t *testing.T) {
+	fixture := newSignedImageFixture(t)
+	router := gin.New()
+	router.GET("/images/:id/file", func(c *gin.Context) { c.Set("user_id", fixture.doctor.ID) }, ServeImage)
+	path := "/images/" + fixture.image.ID.String() + "/file"
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Cache-Control"); got != "private, no-cache" {
+		t.Errorf("Cache-Control = %q, want private, no-cache", got)
+	}
+	etag := w.Header().Get("ETag")
+
+	revalidate := func() int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("If-None-Match", etag)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if got := revalidate(); got != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want 304", got)
+	}
+
+	// Once the doctor's care ends, the cached copy is no longer confirmed
+	doctor, err := storage.GlobalStorage.GetDoctorByUserID(fixture.doctor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, relationship := range storage.GlobalStorage.GetCareRelationships(storage.CareRelationshipFilter{DoctorID: doctor.ID}) {
+		if err := storage.GlobalStorage.EndCareRelationship(relationship.ID, time.Now(), fixture.patient.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := revalidate(); got != http.StatusForbidden {
+		t.Errorf("revalidation after care ended = %d, want 403", got)
+	}
+}
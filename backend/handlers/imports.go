@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ImportArchive imports a ZIP archive of screening images with a CSV manifest
+func ImportArchive(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.AppConfig.Upload.MaxArchiveSize)
+	file, header, err := c.Request.FormFile("archive")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No archive provided or archive too large"})
+		return
+	}
+	defer file.Close()
+
+	createPatients, err := strconv.ParseBool(c.DefaultPostForm("create_patients", "true"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid create_patients value"})
+		return
+	}
+	detect, err := strconv.ParseBool(c.DefaultPostForm("detect", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid detect value"})
+		return
+	}
+	dryRun, err := strconv.ParseBool(c.DefaultPostForm("dry_run", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dry_run value"})
+		return
+	}
+
+	doctorID, ok := uploaderDoctorID(c, user)
+	if !ok {
+		return
+	}
+
+	report, err := services.ImportArchive(file, header.Size, services.ImportOptions{
+		ArchiveName:      header.Filename,
+		CreatedBy:        user.ID,
+		DoctorID:         doctorID,
+		AnyPatient:       services.HasScope(user, services.PermPatientsRead, services.ScopeAny),
+		CreatePatients:   createPatients,
+		EnqueueDetection: detect,
+		DryRun:           dryRun,
+	})
+	if errors.Is(err, services.ErrInvalidArchive) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Import failed: " + err.Error()})
+		return
+	}
+
+	status := http.StatusCreated
+	if dryRun {
+		status = http.StatusOK
+	}
+	c.JSON(status, gin.H{
+		"message": "Import completed",
+		"report":  report,
+	})
+}
+
+// GetImportReports lists import reports; admins see all, doctors their own
+func GetImportReports(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	createdBy := user.ID
+	if services.HasScope(user, services.PermImportsRead, services.ScopeAny) {
+		createdBy = uuid.Nil
+	}
+
+	reports := storage.GlobalStorage.GetImportReports(createdBy)
+	c.JSON(http.StatusOK, gin.H{
+		"reports": reports,
+		"total":   len(reports),
+	})
+}
+
+// GetImportReport returns the per-row report of an import
+func GetImportReport(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	reportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid report ID"})
+		return
+	}
+
+	report, err := storage.GlobalStorage.GetImportReportByID(reportID)
+	if err != nil || (!services.HasScope(user, services.PermImportsRead, services.ScopeAny) && report.CreatedBy != user.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import report not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
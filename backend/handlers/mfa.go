@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// VerifyMFAChallenge completes a login with a TOTP or backup code
+func VerifyMFAChallenge(c *gin.Context) {
+	var req MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	challengeUser, err := services.MFAChallengeUser(req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Wrong codes count towards the same lockout as wrong passwords
+	attempt, ok := beginLoginAttempt(c, challengeUser.Email, c.ClientIP())
+	if !ok {
+		return
+	}
+	defer attempt.Release()
+
+	user, err := services.CompleteMFAChallenge(req.MFAToken, req.Code)
+	if errors.Is(err, services.ErrMFACodeInvalid) || errors.Is(err, services.ErrMFAChallengeInvalid) {
+		attempt.Fail()
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	attempt.Succeed()
+
+	tokens, err := services.IssueTokens(user)
+	if errors.Is(err, services.ErrAccountDeactivated) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newAuthResponse(tokens, user))
+}
+
+// GetMFAStatus reports whether MFA is enabled and required for the current user
+func GetMFAStatus(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                user.MFAEnabled,
+		"required":               services.MFARequired(user),
+		"backup_codes_remaining": services.RemainingBackupCodes(user),
+	})
+}
+
+// BeginMFAEnrollment creates a TOTP secret and returns its provisioning URI
+// for the authenticator app's QR code
+func BeginMFAEnrollment(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	setup, err := services.BeginMFAEnrollment(user)
+	if errors.Is(err, services.ErrMFAAlreadyEnabled) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start MFA enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, setup)
+}
+
+// ConfirmMFAEnrollment enables MFA with the first code from the authenticator
+// app and returns the backup codes
+func ConfirmMFAEnrollment(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	backupCodes, err := services.ConfirmMFAEnrollment(user, req.Code)
+	if !respondMFAError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Multi-factor authentication enabled. Store the backup codes somewhere safe; they are shown only once.",
+		"backup_codes": backupCodes,
+	})
+}
+
+// DisableMFA turns off MFA for users whose role does not require it
+func DisableMFA(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Wrong codes count towards the same lockout as at sign-in, so a stolen
+	// session cannot be used to guess codes
+	attempt, ok := beginLoginAttempt(c, user.Email, c.ClientIP())
+	if !ok {
+		return
+	}
+	defer attempt.Release()
+
+	err = services.DisableMFA(user, req.Code)
+	if errors.Is(err, services.ErrMFACodeInvalid) {
+		attempt.Fail()
+	}
+	if !respondMFAError(c, err) {
+		return
+	}
+	attempt.Succeed()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Multi-factor authentication disabled"})
+}
+
+// RegenerateBackupCodes replaces the user's backup codes
+func RegenerateBackupCodes(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	attempt, ok := beginLoginAttempt(c, user.Email, c.ClientIP())
+	if !ok {
+		return
+	}
+	defer attempt.Release()
+
+	backupCodes, err := services.RegenerateBackupCodes(user, req.Code)
+	if errors.Is(err, services.ErrMFACodeInvalid) {
+		attempt.Fail()
+	}
+	if !respondMFAError(c, err) {
+		return
+	}
+	attempt.Succeed()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Backup codes regenerated; earlier codes no longer work",
+		"backup_codes": backupCodes,
+	})
+}
+
+// respondMFAError writes the response for an MFA service error and returns
+// false, or returns true when there is no error. A wrong code is a bad
+// request here rather than 401, since the session itself is still valid.
+func respondMFAError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrMFACodeInvalid), errors.Is(err, services.ErrMFANotEnrolled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFARequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update multi-factor authentication"})
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newMFAUser stores a patient with MFA enabled
+func newMFAUser(t *testing.T) *storage.User {
+	t.Helper()
+	user := &storage.User{Email: t.Name() + "@example.com", Role: "patient"}
+	if err := storage.GlobalStorage.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.GlobalStorage.SaveMFAEnrollment(&storage.MFAEnrollment{UserID: user.ID, Secret: "JBSWY3DPEHPK3PXP"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.GlobalStorage.EnableMFA(user.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestMFACodeChecksAreThrottled(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		ip      string
+	}{
+		{"disable", DisableMFA, "198.51.100.7"},
+		{"regenerate backup codes", RegenerateBackupCodes, "198.51.100.8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := newMFAUser(t)
+			router := gin.New()
+			router.POST("/", func(c *gin.Context) { c.Set("user_id", user.ID) }, tt.handler)
+
+			// Two wrong codes are refused; the third attempt has to wait
+			want := []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusTooManyRequests}
+			for i, status := range want {
+				req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"code":"wrong-code"}`))
+				req.RemoteAddr = tt.ip + ":1234"
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				if w.Code != status {
+					t.Fatalf("attempt %d: status = %d, want %d: %s", i+1, w.Code, status, w.Body.String())
+				}
+				if status == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+					t.Error("throttled response has no Retry-After header")
+				}
+			}
+		})
+	}
+}
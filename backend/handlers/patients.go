@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
 	"dr-mario-backend/storage"
 
 	"github.com/gin-gonic/gin"
@@ -27,11 +28,6 @@ func GetPatientProfile(c *gin.Context) {
 		return
 	}
 
-	if user.Role != "patient" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-		return
-	}
-
 	patient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Patient profile not found"})
@@ -49,11 +45,6 @@ func UpdatePatientProfile(c *gin.Context) {
 		return
 	}
 
-	if user.Role != "patient" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-		return
-	}
-
 	var req PatientProfileRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -97,7 +88,8 @@ func UpdatePatientProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"patient": patient})
 }
 
-// GetPatients returns all patients (for doctors and admins)
+// GetPatients returns the patients whose records the user may read: those in
+// a doctor's care, or all patients for admins
 func GetPatients(c *gin.Context) {
 	user, err := middleware.GetUserFromContext(c)
 	if err != nil {
@@ -105,15 +97,22 @@ func GetPatients(c *gin.Context) {
 		return
 	}
 
-	if user.Role != "doctor" && user.Role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+	patientIDs, all := services.AuthorizedPatientIDs(user, services.PermPatientsRead)
+	if all {
+		patients, err := storage.GlobalStorage.GetAllPatients()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch patients"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"patients": patients})
 		return
 	}
 
-	patients, err := storage.GlobalStorage.GetAllPatients()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch patients"})
-		return
+	patients := []*storage.Patient{}
+	for _, patientID := range patientIDs {
+		if patient, err := storage.GlobalStorage.GetPatientByID(patientID); err == nil {
+			patients = append(patients, patient)
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{"patients": patients})
@@ -134,16 +133,9 @@ func GetPatient(c *gin.Context) {
 	}
 
 	// Check permissions
-	if user.Role == "patient" {
-		currentPatient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-		if currentPatient.ID != patientID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
+	if !services.Authorize(user, services.PermPatientsRead, patientID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
 	}
 
 	patient, err := storage.GlobalStorage.GetPatientByID(patientID)
@@ -152,6 +144,7 @@ func GetPatient(c *gin.Context) {
 		return
 	}
 
+	defer recordServedAccess(c, services.PermPatientsRead, patientID)
 	c.JSON(http.StatusOK, gin.H{"patient": patient})
 }
 
@@ -170,16 +163,9 @@ func GetPatientImages(c *gin.Context) {
 	}
 
 	// Check permissions
-	if user.Role == "patient" {
-		currentPatient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-		if currentPatient.ID != patientID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
+	if !services.Authorize(user, services.PermImagesRead, patientID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
 	}
 
 	images, err := storage.GlobalStorage.GetImagesByPatientID(patientID)
@@ -188,5 +174,6 @@ func GetPatientImages(c *gin.Context) {
 		return
 	}
 
+	defer recordServedAccess(c, services.PermImagesRead, patientID)
 	c.JSON(http.StatusOK, gin.H{"images": images})
 }
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type DeletionRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+type RetractionRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// DeleteImage soft-deletes an image and retracts its detection results.
+// Patients, who may only delete their own images, cannot delete images taken
+// by a doctor.
+func DeleteImage(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	image, ok := loadAccessibleImage(c, services.PermImagesDelete)
+	if !ok {
+		return
+	}
+	defer recordServedAccess(c, services.PermImagesDelete, image.PatientID)
+
+	if image.DoctorID != uuid.Nil && services.AuthorizedScope(user, services.PermImagesDelete, image.PatientID) == services.ScopeOwn {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the clinic can delete images taken by a doctor"})
+		return
+	}
+
+	var req DeletionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.DeleteImage(image, user.ID, req.Reason); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Image deleted",
+		"deletion": image.Deletion,
+	})
+}
+
+// GetDeletedImages lists soft-deleted images and when they will be purged
+func GetDeletedImages(c *gin.Context) {
+	images, err := storage.GlobalStorage.GetDeletedImages()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deleted images"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"images": images})
+}
+
+// RestoreImage undoes a soft delete while the image content is still stored
+func RestoreImage(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	image, err := storage.GlobalStorage.GetDeletedImageByID(imageID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deleted image not found"})
+		return
+	}
+	if !hasImageAccess(user, image, services.PermImagesDelete) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if err := services.RestoreImage(image); err != nil {
+		if errors.Is(err, services.ErrImageAlreadyPurged) {
+			c.JSON(http.StatusGone, gin.H{"error": "Image content has already been purged"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore image: " + err.Error()})
+		return
+	}
+
+	defer recordServedAccess(c, services.PermImagesDelete, image.PatientID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Image restored",
+		"image":   image,
+	})
+}
+
+// RetractDetectionResult withdraws a detection result, e.g. after a grading error
+func RetractDetectionResult(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	result, image, ok := loadAccessibleResult(c, services.PermResultsReview)
+	if !ok {
+		return
+	}
+	defer recordServedAccess(c, services.PermResultsReview, image.PatientID)
+
+	var req RetractionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.RetractDetectionResult(result, user.ID, req.Reason); err != nil {
+		if errors.Is(err, services.ErrResultRetracted) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retract result: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Detection result retracted",
+		"result":  result,
+	})
+}
+
+// RunRetentionPolicy purges expired deletions and applies the retention
+// policy immediately instead of waiting for the background job
+func RunRetentionPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"report": services.ApplyRetentionPolicy()})
+}
+
+// ensureNotArchived rejects changes to archived images. It writes the error
+// response and returns false when the image is read-only.
+func ensureNotArchived(c *gin.Context, image *storage.RetinalImage) bool {
+	if image.ArchivedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Image is archived and read-only"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type UnlockRequest struct {
+	Email string `json:"email" binding:"omitempty,email"`
+	IP    string `json:"ip" binding:"omitempty,ip"`
+}
+
+// GetSecurityEvents lists security events, newest first. Filter with the
+// type, user_id and since (RFC 3339) query parameters.
+func GetSecurityEvents(c *gin.Context) {
+	filter := storage.SecurityEventFilter{Type: c.Query("type")}
+	if userID := c.Query("user_id"); userID != "" {
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		filter.UserID = id
+	}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC 3339 timestamp"})
+			return
+		}
+		filter.Since = t
+	}
+
+	events := storage.GlobalStorage.GetSecurityEvents(filter)
+	if events == nil {
+		events = []*storage.SecurityEvent{}
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// GetLoginLockouts lists the accounts and client IPs currently locked out
+func GetLoginLockouts(c *gin.Context) {
+	lockouts := services.GetLoginLockouts()
+	if lockouts == nil {
+		lockouts = []storage.LoginThrottle{}
+	}
+	c.JSON(http.StatusOK, gin.H{"lockouts": lockouts})
+}
+
+// UnlockLogin lifts the sign-in lockout of an account, a client IP or both
+func UnlockLogin(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req UnlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Email == "" && req.IP == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email or ip is required"})
+		return
+	}
+
+	unlocked := gin.H{}
+	if req.Email != "" {
+		unlocked["account"] = services.UnlockAccount(req.Email, user.ID)
+	}
+	if req.IP != "" {
+		unlocked["ip"] = services.UnlockIP(req.IP, user.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Sign-in lockout cleared",
+		"unlocked": unlocked,
+	})
+}
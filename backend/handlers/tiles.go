@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// GetImageTileInfo describes the deep-zoom tile pyramid of an image and its overlays
+func GetImageTileInfo(c *gin.Context) {
+	image, ok := loadAccessibleImage(c, services.PermImagesRead)
+	if !ok {
+		return
+	}
+	defer recordServedAccess(c, services.PermImagesRead, image.PatientID)
+
+	pyramid, err := services.ImagePyramid(image)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read image: " + err.Error()})
+		return
+	}
+
+	base := "/api/v1/images/" + image.ID.String()
+	heatmaps := []gin.H{}
+	results, _ := storage.GlobalStorage.GetDetectionResultsByImageID(image.ID)
+	for _, result := range results {
+		if result.HasHeatmap {
+			heatmaps = append(heatmaps, gin.H{
+				"result_id": result.ID,
+				"tile_url":  "/api/v1/results/" + result.ID.String() + "/heatmap/tiles/{level}/{x}/{y}",
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pyramid":  pyramid,
+		"tile_url": base + "/tiles/{level}/{x}/{y}",
+		"overlays": gin.H{
+			"annotations": base + "/overlays/annotations/{level}/{x}/{y}",
+			"heatmaps":    heatmaps,
+		},
+	})
+}
+
+// ServeImageTile serves one JPEG tile of the image pyramid
+func ServeImageTile(c *gin.Context) {
+	image, ok := loadAccessibleImage(c, services.PermImagesRead)
+	if !ok {
+		return
+	}
+	defer recordServedAccess(c, services.PermImagesRead, image.PatientID)
+	level, x, y, ok := parseTileCoordinates(c)
+	if !ok {
+		return
+	}
+
+	// Tiles never change, but access to them can be revoked, so browsers
+	// revalidate on every use
+	etag := fmt.Sprintf(`"%s-%d-%d-%d"`, image.ContentHash, level, x, y)
+	cacheHeaders := map[string]string{
+		"Cache-Control": "private, no-cache",
+		"ETag":          etag,
+	}
+	if c.GetHeader("If-None-Match") == etag {
+		for name, value := range cacheHeaders {
+			c.Header(name, value)
+		}
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	key, err := services.EnsureImageTile(image, level, x, y)
+	if errors.Is(err, services.ErrTileOutOfRange) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tile not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tiles: " + err.Error()})
+		return
+	}
+
+	serveBlob(c, key, "Tile not found", cacheHeaders)
+}
+
+// ServeAnnotationTile renders the current lesion annotations of an image as a
+// transparent PNG tile. Optional filters: source (cnn or clinician) and annotation_id.
+func ServeAnnotationTile(c *gin.Context) {
+	image, ok := loadAccessibleImage(c, services.PermImagesRead)
+	if !ok {
+		return
+	}
+	defer recordServedAccess(c, services.PermImagesRead, image.PatientID)
+	level, x, y, ok := parseTileCoordinates(c)
+	if !ok {
+		return
+	}
+
+	annotations, err := storage.GlobalStorage.GetAnnotationsByImageID(image.ID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch annotations"})
+		return
+	}
+
+	source := c.Query("source")
+	annotationID := uuid.Nil
+	if value := c.Query("annotation_id"); value != "" {
+		if annotationID, err = uuid.Parse(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid annotation ID"})
+			return
+		}
+	}
+	var selected []*storage.Annotation
+	for _, annotation := range annotations {
+		if source != "" && annotation.Source != source {
+			continue
+		}
+		if annotationID != uuid.Nil && annotation.ID != annotationID {
+			continue
+		}
+		selected = append(selected, annotation)
+	}
+
+	pyramid, err := services.ImagePyramid(image)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read image: " + err.Error()})
+		return
+	}
+	tile, err := services.RenderAnnotationTile(pyramid, level, x, y, selected)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tile not found"})
+		return
+	}
+
+	// Annotations change, so overlay tiles are always revalidated
+	c.Header("Cache-Control", "private, no-cache")
+	c.Header("Content-Type", "image/png")
+	c.Status(http.StatusOK)
+	if err := png.Encode(c.Writer, tile); err != nil {
+		c.Error(err)
+	}
+}
+
+// ServeHeatmapTile renders the heatmap of a detection result as a transparent
+// PNG tile aligned with the image pyramid (opacity 0-1, colormap)
+func ServeHeatmapTile(c *gin.Context) {
+	result, image, ok := loadAccessibleResult(c, services.PermImagesRead)
+	if !ok {
+		return
+	}
+	defer recordServedAccess(c, services.PermImagesRead, image.PatientID)
+	if !result.HasHeatmap {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Heatmap not found"})
+		return
+	}
+	level, x, y, ok := parseTileCoordinates(c)
+	if !ok {
+		return
+	}
+
+	opacity, err := strconv.ParseFloat(c.DefaultQuery("opacity", "0.5"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid opacity"})
+		return
+	}
+	colormap := c.DefaultQuery("colormap", "jet")
+
+	pyramid, err := services.ImagePyramid(image)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read image: " + err.Error()})
+		return
+	}
+	tile, err := services.RenderHeatmapTile(pyramid, level, x, y, result.HeatmapPath, opacity, colormap)
+	if errors.Is(err, services.ErrTileOutOfRange) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tile not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to render heatmap tile: " + err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-cache")
+	c.Header("Content-Type", "image/png")
+	c.Status(http.StatusOK)
+	if err := png.Encode(c.Writer, tile); err != nil {
+		c.Error(err)
+	}
+}
+
+// parseTileCoordinates reads the level, x and y path parameters. A file
+// extension on y is accepted for Deep Zoom viewers that append one.
+func parseTileCoordinates(c *gin.Context) (int, int, int, bool) {
+	y := c.Param("y")
+	if dot := strings.IndexByte(y, '.'); dot >= 0 {
+		y = y[:dot]
+	}
+
+	var coords [3]int
+	for i, value := range []string{c.Param("level"), c.Param("x"), y} {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tile coordinates"})
+			return 0, 0, 0, false
+		}
+		coords[i] = n
+	}
+	return coords[0], coords[1], coords[2], true
+}
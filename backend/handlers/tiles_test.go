@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServeImageTileRevalidatesAccess(t *testing.T) {
+	fixture := newSignedImageFixture(t)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 300, 200))); err != nil {
+		t.Fatal(err)
+	}
+	if err := blobstore.Default().Put(context.Background(), fixture.image.FilePath, bytes.NewReader(buf.Bytes()), int64(buf.Len()), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.GET("/images/:id/tiles/:level/:x/:y", func(c *gin.Context) { c.Set("user_id", fixture.doctor.ID) }, ServeImageTile)
+	path := "/images/" + fixture.image.ID.String() + "/tiles/0/0/0.jpg"
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Cache-Control"); got != "private, no-cache" {
+		t.Errorf("Cache-Control = %q, want private, no-cache", got)
+	}
+	etag := w.Header().Get("ETag")
+
+	revalidate := func() int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("If-None-Match", etag)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if got := revalidate(); got != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want 304", got)
+	}
+
+	doctor, err := storage.GlobalStorage.GetDoctorByUserID(fixture.doctor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, relationship := range storage.GlobalStorage.GetCareRelationships(storage.CareRelationshipFilter{DoctorID: doctor.ID}) {
+		if err := storage.GlobalStorage.EndCareRelationship(relationship.ID, time.Now(), fixture.patient.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := revalidate(); got != http.StatusForbidden {
+		t.Errorf("revalidation after care ended = %d, want 403", got)
+	}
+}
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
+	"dr-mario-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// tusVersion is the resumable upload protocol version the endpoints follow
+const tusVersion = "1.0.0"
+
+type UploadSessionRequest struct {
+	FileName  string `json:"file_name" binding:"required"`
+	Length    int64  `json:"length" binding:"required,min=1"`
+	ImageType string `json:"image_type" binding:"required,oneof=left_eye right_eye"`
+	Notes     string `json:"notes"`
+	PatientID string `json:"patient_id"`
+}
+
+// CreateUploadSession starts a resumable chunked upload
+func CreateUploadSession(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req UploadSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !services.IsAllowedExtension(req.FileName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file type"})
+		return
+	}
+	if req.Length > config.AppConfig.Upload.MaxFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large"})
+		return
+	}
+
+	patient, ok := resolveUploadPatient(c, user, req.PatientID)
+	if !ok {
+		return
+	}
+	doctorID, ok := uploaderDoctorID(c, user)
+	if !ok {
+		return
+	}
+
+	session := &storage.UploadSession{
+		UserID:    user.ID,
+		PatientID: patient.ID,
+		DoctorID:  doctorID,
+		FileName:  req.FileName,
+		ImageType: req.ImageType,
+		Notes:     req.Notes,
+		Length:    req.Length,
+		ExpiresAt: time.Now().Add(services.UploadSessionExpiry()),
+	}
+	if err := storage.GlobalStorage.CreateUploadSession(session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload session"})
+		return
+	}
+
+	setUploadHeaders(c, session)
+	c.Header("Location", "/api/v1/uploads/"+session.ID.String())
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Upload session created",
+		"upload":  session,
+	})
+}
+
+// GetUploadSession reports the progress of an upload. HEAD requests return the
+// offset in the Upload-Offset header only.
+func GetUploadSession(c *gin.Context) {
+	session, ok := loadUploadSession(c)
+	if !ok {
+		return
+	}
+
+	setUploadHeaders(c, session)
+	c.Header("Cache-Control", "no-store")
+	if c.Request.Method == http.MethodHead {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"upload":   session,
+		"progress": session.Progress(),
+	})
+}
+
+// PatchUploadSession appends a chunk at the offset given by the Upload-Offset header
+func PatchUploadSession(c *gin.Context) {
+	session, ok := loadUploadSession(c)
+	if !ok {
+		return
+	}
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+	if session.Status != storage.UploadStatusActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload already finalized"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Offset header"})
+		return
+	}
+	if offset != session.Offset {
+		setUploadHeaders(c, session)
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the current offset", "offset": session.Offset})
+		return
+	}
+
+	remaining := session.Length - session.Offset
+	limit := config.AppConfig.Upload.MaxChunkSize
+	if remaining < limit {
+		limit = remaining
+	}
+
+	// Keep whatever arrived before an interrupted connection so the client can resume from there
+	data, readErr := io.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+	if int64(len(data)) > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Chunk exceeds the maximum chunk size or upload length"})
+		return
+	}
+	if len(data) == 0 {
+		if readErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read chunk"})
+			return
+		}
+		setUploadHeaders(c, session)
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	session, err = services.WriteUploadChunk(session, offset, data)
+	if errors.Is(err, storage.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the current offset"})
+		return
+	}
+	if errors.Is(err, storage.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chunk exceeds the upload length"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store chunk"})
+		return
+	}
+	if readErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chunk transfer interrupted", "offset": session.Offset})
+		return
+	}
+
+	setUploadHeaders(c, session)
+	c.Status(http.StatusNoContent)
+}
+
+// FinalizeUploadSession turns a completed upload into a retinal image
+func FinalizeUploadSession(c *gin.Context) {
+	session, ok := loadUploadSession(c)
+	if !ok {
+		return
+	}
+
+	if session.Status == storage.UploadStatusCompleted {
+		image, err := storage.GlobalStorage.GetImageByID(session.ImageID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Upload already finalized", "image": image})
+		return
+	}
+	if session.Offset != session.Length {
+		setUploadHeaders(c, session)
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  "Upload is incomplete",
+			"offset": session.Offset,
+			"length": session.Length,
+		})
+		return
+	}
+
+	// The patient may have left the uploader's care since the upload started
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil || !services.Authorize(user, services.PermImagesUpload, session.PatientID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	data, err := services.AssembleUpload(session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assemble upload: " + err.Error()})
+		return
+	}
+
+	image, duplicate, ok := ingestImage(c, session.PatientID, session.DoctorID, session.UserID, data, session.FileName, session.ImageType, session.Notes)
+	if !ok {
+		return
+	}
+
+	services.DiscardUploadChunks(session)
+	session.Status = storage.UploadStatusCompleted
+	session.ImageID = image.ID
+	storage.GlobalStorage.UpdateUploadSession(session)
+	defer recordServedAccess(c, services.PermImagesUpload, session.PatientID)
+
+	if duplicate {
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Image already uploaded",
+			"duplicate": true,
+			"image":     image,
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Image uploaded successfully",
+		"image":   image,
+	})
+}
+
+// DeleteUploadSession aborts an upload and discards the received chunks
+func DeleteUploadSession(c *gin.Context) {
+	session, ok := loadUploadSession(c)
+	if !ok {
+		return
+	}
+
+	services.DiscardUploadChunks(session)
+	if err := storage.GlobalStorage.DeleteUploadSession(session.ID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload session not found"})
+		return
+	}
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Status(http.StatusNoContent)
+}
+
+// loadUploadSession fetches the caller's upload session, writing an error
+// response and returning false if it is missing, foreign or expired
+func loadUploadSession(c *gin.Context) (*storage.UploadSession, bool) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return nil, false
+	}
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload ID"})
+		return nil, false
+	}
+
+	session, err := storage.GlobalStorage.GetUploadSessionByID(sessionID)
+	if err != nil || session.UserID != user.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload session not found"})
+		return nil, false
+	}
+	if time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "Upload session expired"})
+		return nil, false
+	}
+
+	return session, true
+}
+
+func setUploadHeaders(c *gin.Context, session *storage.UploadSession) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.Length, 10))
+	c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+}
@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dr-mario-backend/config"
+)
+
+// FileMailer writes each email to an .eml file in an outbox directory instead
+// of sending it. Intended for development and testing.
+type FileMailer struct {
+	dir string
+}
+
+// NewFileMailer creates a mailer that writes to dir
+func NewFileMailer(dir string) *FileMailer {
+	return &FileMailer{dir: dir}
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg *Message) error {
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create outbox directory: %v", err)
+	}
+
+	file, err := os.CreateTemp(m.dir, time.Now().Format("20060102-150405")+"-*.eml")
+	if err != nil {
+		return fmt.Errorf("failed to create email file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(format(config.AppConfig.Mail.From, msg)); err != nil {
+		return fmt.Errorf("failed to write email file: %v", err)
+	}
+	log.Printf("📧 Wrote email %q for %s to %s", msg.Subject, msg.To, filepath.Base(file.Name()))
+	return nil
+}
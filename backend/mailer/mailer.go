@@ -0,0 +1,83 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"dr-mario-backend/config"
+
+	"github.com/google/uuid"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers account emails such as verification and password reset links
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Mail is the mailer used by the application
+var Mail Mailer
+
+// Initialize creates the configured mailer
+func Initialize() error {
+	cfg := config.AppConfig.Mail
+	switch cfg.Driver {
+	case "", "log":
+		Mail = NewLogMailer()
+		return nil
+	case "file":
+		Mail = NewFileMailer(cfg.OutboxDir)
+		return nil
+	case "smtp":
+		smtp, err := NewSMTPMailer(cfg)
+		if err != nil {
+			return err
+		}
+		Mail = smtp
+		return nil
+	default:
+		return fmt.Errorf("unknown mail driver: %s", cfg.Driver)
+	}
+}
+
+// Default returns the configured mailer, falling back to the log when
+// Initialize has not been called
+func Default() Mailer {
+	if Mail == nil {
+		Mail = NewLogMailer()
+	}
+	return Mail
+}
+
+// format renders a message as an RFC 5322 email
+func format(from string, msg *Message) []byte {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", uuid.New(), domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// headerValue drops line breaks so values cannot inject extra headers
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+
+	"dr-mario-backend/config"
+)
+
+// SMTPMailer sends email through an SMTP relay. The connection is upgraded
+// with STARTTLS when the server offers it.
+type SMTPMailer struct {
+	addr string
+	host string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer creates a mailer for the configured SMTP server
+func NewSMTPMailer(cfg config.MailConfig) (*SMTPMailer, error) {
+	if cfg.SMTPHost == "" {
+		return nil, errors.New("SMTP_HOST is required for the smtp mail driver")
+	}
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("invalid MAIL_FROM address: %v", err)
+	}
+
+	m := &SMTPMailer{
+		addr: net.JoinHostPort(cfg.SMTPHost, fmt.Sprint(cfg.SMTPPort)),
+		host: cfg.SMTPHost,
+		from: cfg.From,
+	}
+	if cfg.SMTPUsername != "" {
+		m.auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %v", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := smtp.SendMail(m.addr, m.auth, from.Address, []string{to.Address}, format(m.from, msg)); err != nil {
+		return fmt.Errorf("failed to send email via %s: %v", m.host, err)
+	}
+	return nil
+}
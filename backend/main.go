@@ -3,8 +3,12 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/cli"
 	"dr-mario-backend/config"
+	"dr-mario-backend/mailer"
 	"dr-mario-backend/routes"
 	"dr-mario-backend/services"
 )
@@ -15,6 +19,57 @@ func main() {
 		log.Fatal("Error loading .env file:", err)
 	}
 
+	// Run a command line subcommand instead of the server if one is given
+	if len(os.Args) > 1 {
+		if err := cli.Run(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Refuse to start with unsafe settings such as the default JWT secret in production
+	if err := config.AppConfig.Validate(); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	// Refuse to start with role permissions that do not exist
+	if err := services.ValidatePermissions(); err != nil {
+		log.Fatal("Invalid role permissions: ", err)
+	}
+
+	// Initialize token signing keys
+	if err := services.InitializeSigningKeys(); err != nil {
+		log.Fatal("Error initializing signing keys:", err)
+	}
+	services.StartKeyRotation(services.KeyRotationInterval())
+	log.Printf("🔑 Token signing initialized (%s)", config.AppConfig.JWT.Algorithm)
+
+	// Initialize blob storage
+	if err := blobstore.Initialize(); err != nil {
+		log.Fatal("Error initializing blob storage:", err)
+	}
+	log.Printf("🗄️  Blob storage initialized (%s)", config.AppConfig.Blob.Backend)
+
+	// Initialize the mailer for account emails
+	if err := mailer.Initialize(); err != nil {
+		log.Fatal("Error initializing mailer:", err)
+	}
+	log.Printf("📧 Mailer initialized (%s)", config.AppConfig.Mail.Driver)
+
+	// Allow creating the first admin account with a bootstrap token
+	if err := services.InitializeAdminBootstrap(); err != nil {
+		log.Fatal("Error initializing admin bootstrap:", err)
+	}
+
+	// Expire abandoned resumable uploads
+	services.StartUploadSessionJanitor(10 * time.Minute)
+
+	// Drop expired refresh tokens, account tokens and revocation entries
+	services.StartTokenJanitor(time.Hour)
+
+	// Purge deleted images and apply the image retention policy
+	services.StartRetentionJob(services.RetentionJobInterval())
+
 	// Initialize CNN service
 	services.InitializeCNNService()
 	log.Println("🔬 CNN Service initialized")
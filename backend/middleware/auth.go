@@ -4,11 +4,10 @@ import (
 	"net/http"
 	"strings"
 
-	"dr-mario-backend/config"
+	"dr-mario-backend/services"
 	"dr-mario-backend/storage"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -28,12 +27,8 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		claims := &jwt.RegisteredClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(config.AppConfig.JWT.Secret), nil
-		})
-
-		if err != nil || !token.Valid {
+		claims, err := services.ParseAccessToken(tokenString)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
@@ -54,35 +49,90 @@ func AuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		if user.DeactivatedAt != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": services.ErrAccountDeactivated.Error()})
+			c.Abort()
+			return
+		}
 
 		// Set user info in context
 		c.Set("user_id", user.ID)
 		c.Set("email", user.Email)
 		c.Set("role", user.Role)
+		c.Set("token_id", claims.ID)
+		c.Set("token_expires_at", claims.ExpiresAt.Time)
+
+		c.Next()
+	}
+}
+
+// RequirePermission lets the request through only if the user's role grants
+// the permission at some scope. Handlers check the scope against the records
+// involved. It runs after AuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := GetUserFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+
+		if !services.HasPermission(user, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
 }
 
-func RoleMiddleware(allowedRoles ...string) gin.HandlerFunc {
+// MFAEnrollmentMiddleware blocks users whose role requires multi-factor
+// authentication until they have enrolled. It runs after AuthMiddleware.
+func MFAEnrollmentMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userRole, exists := c.Get("role")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User role not found"})
+		user, err := GetUserFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+
+		if services.MFARequired(user) && !user.MFAEnabled {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":                   "Multi-factor authentication must be set up before using this endpoint",
+				"mfa_enrollment_required": true,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// DoctorVerificationMiddleware keeps doctors away from clinical data until an
+// admin has verified their credentials. It runs after AuthMiddleware.
+func DoctorVerificationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := GetUserFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 			c.Abort()
 			return
 		}
 
-		role := userRole.(string)
-		for _, allowedRole := range allowedRoles {
-			if role == allowedRole {
-				c.Next()
-				return
-			}
+		if !services.DoctorVerified(user) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":                        services.ErrDoctorNotVerified.Error(),
+				"doctor_verification_required": true,
+			})
+			c.Abort()
+			return
 		}
 
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
-		c.Abort()
+		c.Next()
 	}
 }
 
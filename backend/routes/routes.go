@@ -1,8 +1,12 @@
 package routes
 
 import (
+	"log"
+
+	"dr-mario-backend/config"
 	"dr-mario-backend/handlers"
 	"dr-mario-backend/middleware"
+	"dr-mario-backend/services"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -11,11 +15,18 @@ import (
 func SetupRouter() *gin.Engine {
 	router := gin.Default()
 
+	// Only trust X-Forwarded-For from configured proxies when resolving client IPs
+	if err := router.SetTrustedProxies(config.AppConfig.Server.TrustedProxies); err != nil {
+		log.Printf("Invalid TRUSTED_PROXIES, trusting no proxies: %v", err)
+		router.SetTrustedProxies(nil)
+	}
+
 	// CORS configuration
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowOrigins = []string{"http://localhost:3000", "http://localhost:5173"}
-	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
+	corsConfig.AllowMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "Upload-Offset", "Tus-Resumable"}
+	corsConfig.ExposeHeaders = []string{"Location", "Upload-Offset", "Upload-Length", "Upload-Expires", "Tus-Resumable"}
 	corsConfig.AllowCredentials = true
 	router.Use(cors.New(corsConfig))
 
@@ -28,6 +39,9 @@ func SetupRouter() *gin.Engine {
 		})
 	})
 
+	// Public keys for verifying access tokens
+	router.GET("/.well-known/jwks.json", handlers.GetJWKS)
+
 	// API v1 routes
 	v1 := router.Group("/api/v1")
 	{
@@ -36,24 +50,77 @@ func SetupRouter() *gin.Engine {
 		{
 			auth.POST("/register", handlers.Register)
 			auth.POST("/login", handlers.Login)
+			auth.POST("/mfa/verify", handlers.VerifyMFAChallenge)
+			auth.POST("/refresh", handlers.RefreshToken)
+			auth.POST("/verify-email", handlers.VerifyEmail)
+			auth.POST("/verify-email/resend", handlers.ResendVerificationEmail)
+			auth.POST("/forgot-password", handlers.ForgotPassword)
+			auth.POST("/reset-password", handlers.ResetPassword)
+			auth.POST("/logout", middleware.AuthMiddleware(), handlers.Logout)
 		}
 
-		// Protected routes
-		protected := v1.Group("/")
-		protected.Use(middleware.AuthMiddleware())
+		// First admin account creation (public, authorized by the bootstrap token)
+		v1.POST("/admin/bootstrap", handlers.BootstrapAdmin)
+
+		// Signed file downloads (public, authorized by the URL signature)
+		files := v1.Group("/files")
+		{
+			files.GET("/images/:id", handlers.ServeSignedImage)
+		}
+
+		// Account routes (reachable before a required MFA enrollment is done)
+		account := v1.Group("/")
+		account.Use(middleware.AuthMiddleware())
 		{
 			// User profile
-			protected.GET("/profile", handlers.GetProfile)
-			protected.PUT("/profile", handlers.UpdateProfile)
+			account.GET("/profile", handlers.GetProfile)
+			account.PUT("/profile", handlers.UpdateProfile)
 
+			// Multi-factor authentication
+			mfa := account.Group("/mfa")
+			{
+				mfa.GET("/", handlers.GetMFAStatus)
+				mfa.POST("/enroll", handlers.BeginMFAEnrollment)
+				mfa.POST("/confirm", handlers.ConfirmMFAEnrollment)
+				mfa.POST("/disable", handlers.DisableMFA)
+				mfa.POST("/backup-codes", handlers.RegenerateBackupCodes)
+			}
+		}
+
+		// Doctor profile and credential routes (reachable before verification)
+		doctorAccount := v1.Group("/doctors")
+		doctorAccount.Use(middleware.AuthMiddleware(), middleware.MFAEnrollmentMiddleware(), middleware.RequirePermission(services.PermDoctorProfile))
+		{
+			doctorAccount.GET("/profile", handlers.GetDoctorProfile)
+			doctorAccount.PUT("/profile", handlers.UpdateDoctorProfile)
+			doctorAccount.GET("/profile/credentials", handlers.GetCredentialDocuments)
+			doctorAccount.POST("/profile/credentials", handlers.UploadCredentialDocument)
+			doctorAccount.GET("/profile/credentials/:documentId/file", handlers.ServeOwnCredentialDocument)
+			doctorAccount.DELETE("/profile/credentials/:documentId", handlers.DeleteCredentialDocument)
+		}
+
+		// Protected routes (doctors need verified credentials)
+		protected := v1.Group("/")
+		protected.Use(middleware.AuthMiddleware(), middleware.MFAEnrollmentMiddleware(), middleware.DoctorVerificationMiddleware())
+		{
 			// Patient routes
 			patients := protected.Group("/patients")
 			{
-				patients.GET("/profile", handlers.GetPatientProfile)
-				patients.PUT("/profile", handlers.UpdatePatientProfile)
-				patients.GET("/", middleware.RoleMiddleware("doctor", "admin"), handlers.GetPatients)
+				patients.GET("/profile", middleware.RequirePermission(services.PermPatientProfile), handlers.GetPatientProfile)
+				patients.PUT("/profile", middleware.RequirePermission(services.PermPatientProfile), handlers.UpdatePatientProfile)
+				patients.GET("/", middleware.RequirePermission(services.PermPatientsList), handlers.GetPatients)
 				patients.GET("/:id", handlers.GetPatient)
 				patients.GET("/:id/images", handlers.GetPatientImages)
+				patients.POST("/:id/referrals", middleware.RequirePermission(services.PermPatientsRefer), handlers.ReferPatient)
+				patients.POST("/:id/emergency-access", middleware.RequirePermission(services.PermPatientsBreakGlass), handlers.BreakGlass)
+			}
+
+			// Care team routes
+			careTeam := protected.Group("/care-team")
+			{
+				careTeam.GET("/", middleware.RequirePermission(services.PermCareTeamRead), handlers.GetCareRelationships)
+				careTeam.POST("/", middleware.RequirePermission(services.PermCareTeamAssign), handlers.AssignCare)
+				careTeam.POST("/:id/end", middleware.RequirePermission(services.PermCareTeamEnd), handlers.EndCare)
 			}
 
 			// Doctor routes
@@ -61,8 +128,6 @@ func SetupRouter() *gin.Engine {
 			{
 				doctors.GET("/", handlers.GetDoctors)
 				doctors.GET("/:id", handlers.GetDoctor)
-				doctors.GET("/profile", middleware.RoleMiddleware("doctor"), handlers.GetDoctorProfile)
-				doctors.PUT("/profile", middleware.RoleMiddleware("doctor"), handlers.UpdateDoctorProfile)
 			}
 
 			// Image routes
@@ -72,8 +137,58 @@ func SetupRouter() *gin.Engine {
 				images.POST("/detect", handlers.DetectDR)
 				images.POST("/scan-cnn", handlers.ScanWithCNN) // New CNN scanning endpoint
 				images.GET("/", handlers.GetImages)
+				images.GET("/deleted", middleware.RequirePermission(services.PermRetentionManage), handlers.GetDeletedImages)
 				images.GET("/:id", handlers.GetImage)
+				images.DELETE("/:id", handlers.DeleteImage)
+				images.POST("/:id/restore", middleware.RequirePermission(services.PermImagesDelete), handlers.RestoreImage)
 				images.GET("/:id/file", handlers.ServeImage)
+				images.POST("/:id/file/url", handlers.CreateImageURL)
+				images.POST("/:id/quality", handlers.AssessImageQuality)
+				images.PUT("/:id/status", middleware.RequirePermission(services.PermResultsReview), handlers.UpdateImageStatus)
+				images.GET("/:id/annotations", handlers.GetImageAnnotations)
+				images.POST("/:id/annotations", middleware.RequirePermission(services.PermAnnotationsWrite), handlers.CreateAnnotation)
+				images.GET("/:id/annotations/coco", handlers.ExportImageAnnotationsCOCO)
+				images.GET("/:id/tiles", handlers.GetImageTileInfo)
+				images.GET("/:id/tiles/:level/:x/:y", handlers.ServeImageTile)
+				images.GET("/:id/overlays/annotations/:level/:x/:y", handlers.ServeAnnotationTile)
+			}
+
+			// Resumable upload routes
+			uploads := protected.Group("/uploads")
+			{
+				uploads.POST("/", handlers.CreateUploadSession)
+				uploads.HEAD("/:id", handlers.GetUploadSession)
+				uploads.GET("/:id", handlers.GetUploadSession)
+				uploads.PATCH("/:id", handlers.PatchUploadSession)
+				uploads.POST("/:id/finalize", handlers.FinalizeUploadSession)
+				uploads.DELETE("/:id", handlers.DeleteUploadSession)
+			}
+
+			// Bulk import routes (doctors and admins only)
+			imports := protected.Group("/imports")
+			imports.Use(middleware.RequirePermission(services.PermImagesImport))
+			{
+				imports.POST("/", handlers.ImportArchive)
+				imports.GET("/", handlers.GetImportReports)
+				imports.GET("/:id", handlers.GetImportReport)
+			}
+
+			// Detection result routes
+			results := protected.Group("/results")
+			{
+				results.POST("/:id/retract", middleware.RequirePermission(services.PermResultsReview), handlers.RetractDetectionResult)
+				results.POST("/:id/heatmap", middleware.RequirePermission(services.PermResultsReview), handlers.UploadHeatmap)
+				results.GET("/:id/heatmap", handlers.ServeHeatmap)
+				results.GET("/:id/heatmap/overlay", handlers.RenderHeatmapOverlay)
+				results.GET("/:id/heatmap/tiles/:level/:x/:y", handlers.ServeHeatmapTile)
+			}
+
+			// Lesion annotation routes
+			annotations := protected.Group("/annotations")
+			{
+				annotations.GET("/:id", handlers.GetAnnotation)
+				annotations.PUT("/:id", middleware.RequirePermission(services.PermAnnotationsWrite), handlers.UpdateAnnotation)
+				annotations.DELETE("/:id", middleware.RequirePermission(services.PermAnnotationsWrite), handlers.DeleteAnnotation)
 			}
 
 			// Appointment routes
@@ -88,13 +203,54 @@ func SetupRouter() *gin.Engine {
 
 			// Analytics routes (doctors and admins only)
 			analytics := protected.Group("/analytics")
-			analytics.Use(middleware.RoleMiddleware("doctor", "admin"))
+			analytics.Use(middleware.RequirePermission(services.PermAnalyticsRead))
 			{
 				analytics.GET("/stats", handlers.GetAnalytics)
 				analytics.GET("/patient/:id", handlers.GetPatientAnalytics)
 				analytics.GET("/doctor/:id", handlers.GetDoctorAnalytics)
 			}
 
+			// Retention routes (admins only)
+			retention := protected.Group("/retention")
+			retention.Use(middleware.RequirePermission(services.PermRetentionManage))
+			{
+				retention.POST("/run", handlers.RunRetentionPolicy)
+			}
+
+			// Security routes (admins only)
+			security := protected.Group("/security")
+			security.Use(middleware.RequirePermission(services.PermSecurityManage))
+			{
+				security.GET("/events", handlers.GetSecurityEvents)
+				security.GET("/lockouts", handlers.GetLoginLockouts)
+				security.POST("/unlock", handlers.UnlockLogin)
+				security.GET("/emergency-access", handlers.GetEmergencyAccessReport)
+				security.POST("/emergency-access/:id/review", handlers.ReviewEmergencyAccess)
+			}
+
+			// User management routes (admins only)
+			adminUsers := protected.Group("/admin/users")
+			adminUsers.Use(middleware.RequirePermission(services.PermUsersManage))
+			{
+				adminUsers.GET("/", handlers.GetUsers)
+				adminUsers.GET("/:id", handlers.GetUser)
+				adminUsers.PUT("/:id/role", handlers.ChangeUserRole)
+				adminUsers.POST("/:id/deactivate", handlers.DeactivateUser)
+				adminUsers.POST("/:id/reactivate", handlers.ReactivateUser)
+				adminUsers.POST("/:id/mfa/reset", handlers.ResetUserMFA)
+			}
+
+			// Doctor credential review routes (admins only)
+			adminDoctors := protected.Group("/admin/doctors")
+			adminDoctors.Use(middleware.RequirePermission(services.PermDoctorsVerify))
+			{
+				adminDoctors.GET("/", handlers.GetDoctorVerifications)
+				adminDoctors.GET("/:id", handlers.GetDoctorVerification)
+				adminDoctors.GET("/:id/credentials/:documentId/file", handlers.ServeCredentialDocument)
+				adminDoctors.POST("/:id/verify", handlers.VerifyDoctor)
+				adminDoctors.POST("/:id/reject", handlers.RejectDoctor)
+			}
+
 			// CNN service routes
 			cnn := protected.Group("/cnn")
 			{
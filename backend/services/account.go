@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/mailer"
+	"dr-mario-backend/storage"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrAccountTokenInvalid  = errors.New("this link is invalid or has expired")
+	ErrEmailAlreadyVerified = errors.New("email address is already verified")
+)
+
+// EmailVerificationRequired reports whether users must verify their email
+// address before they can sign in
+func EmailVerificationRequired() bool {
+	return config.AppConfig.Account.RequireEmailVerification
+}
+
+// EmailVerificationExpiry returns how long an email verification link is valid
+func EmailVerificationExpiry() time.Duration {
+	expiry, err := time.ParseDuration(config.AppConfig.Account.VerificationExpiry)
+	if err != nil || expiry <= 0 {
+		return 48 * time.Hour
+	}
+	return expiry
+}
+
+// PasswordResetExpiry returns how long a password reset link is valid
+func PasswordResetExpiry() time.Duration {
+	expiry, err := time.ParseDuration(config.AppConfig.Account.PasswordResetExpiry)
+	if err != nil || expiry <= 0 {
+		return time.Hour
+	}
+	return expiry
+}
+
+// SendEmailVerification emails the user a link to verify their address.
+// Sending a new link invalidates earlier ones.
+func SendEmailVerification(user *storage.User) error {
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+
+	token, err := createAccountToken(user, storage.AccountTokenEmailVerification, EmailVerificationExpiry())
+	if err != nil {
+		return err
+	}
+
+	sendAccountEmail(&mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Dr. Mario email address",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Please confirm your email address by opening the link below:\n\n%s\n\n"+
+			"The link expires in %s. If you did not create an account, you can ignore this email.\n",
+			user.FirstName, accountLink("/verify-email", token), describeDuration(EmailVerificationExpiry())),
+	})
+	return nil
+}
+
+// ResendEmailVerification sends a new verification link to an unverified
+// account. It does nothing for unknown or verified addresses so the response
+// does not reveal which emails are registered.
+func ResendEmailVerification(email string) error {
+	user, err := storage.GlobalStorage.GetUserByEmail(email)
+	if err != nil || user.EmailVerifiedAt != nil {
+		return nil
+	}
+	return SendEmailVerification(user)
+}
+
+// VerifyEmail marks the address a verification token was sent to as verified
+func VerifyEmail(token string) (*storage.User, error) {
+	accountToken, user, err := consumeAccountToken(token, storage.AccountTokenEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+	if accountToken.Email != user.Email {
+		return nil, ErrAccountTokenInvalid
+	}
+	if err := storage.GlobalStorage.MarkEmailVerified(user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// RequestPasswordReset emails a password reset link. Like
+// ResendEmailVerification it silently ignores unknown addresses.
+func RequestPasswordReset(email string) error {
+	user, err := storage.GlobalStorage.GetUserByEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	token, err := createAccountToken(user, storage.AccountTokenPasswordReset, PasswordResetExpiry())
+	if err != nil {
+		return err
+	}
+
+	sendAccountEmail(&mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Dr. Mario password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"We received a request to reset your password. Choose a new one here:\n\n%s\n\n"+
+			"The link expires in %s and can be used once. If you did not ask for a reset, "+
+			"you can ignore this email; your password has not changed.\n",
+			user.FirstName, accountLink("/reset-password", token), describeDuration(PasswordResetExpiry())),
+	})
+	return nil
+}
+
+// ResetPassword sets a new password with a reset token and signs the user
+// out of every session. Receiving the link also proves the user controls the
+// address, so it counts as email verification.
+func ResetPassword(token, password string) (*storage.User, error) {
+	accountToken, user, err := consumeAccountToken(token, storage.AccountTokenPasswordReset)
+	if err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = string(hashedPassword)
+	if err := storage.GlobalStorage.UpdateUser(user); err != nil {
+		return nil, err
+	}
+
+	// Sign out every session and lift any lockout from failed sign-ins
+	storage.GlobalStorage.RevokeUserRefreshTokens(user.ID)
+	RecordLoginSuccess(user.Email)
+	if accountToken.Email == user.Email {
+		storage.GlobalStorage.MarkEmailVerified(user.ID)
+	}
+	return user, nil
+}
+
+func createAccountToken(user *storage.User, purpose string, expiry time.Duration) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	if err := storage.GlobalStorage.CreateAccountToken(&storage.AccountToken{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(expiry),
+	}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func consumeAccountToken(token, purpose string) (*storage.AccountToken, *storage.User, error) {
+	accountToken, err := storage.GlobalStorage.ConsumeAccountToken(hashToken(token), purpose)
+	if err != nil || time.Now().After(accountToken.ExpiresAt) {
+		return nil, nil, ErrAccountTokenInvalid
+	}
+	user, err := storage.GlobalStorage.GetUserByID(accountToken.UserID)
+	if err != nil {
+		return nil, nil, ErrAccountTokenInvalid
+	}
+	return accountToken, user, nil
+}
+
+// accountLink builds a link to a frontend page that takes the token
+func accountLink(path, token string) string {
+	return strings.TrimRight(config.AppConfig.Account.AppURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// describeDuration writes a link lifetime the way a person would, e.g. "2 days"
+func describeDuration(d time.Duration) string {
+	count, unit := int(d.Minutes()), "minute"
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		count, unit = int(d/(24*time.Hour)), "day"
+	case d >= time.Hour && d%time.Hour == 0:
+		count, unit = int(d/time.Hour), "hour"
+	}
+	if count != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", count, unit)
+}
+
+// sendAccountEmail delivers in the background so that response times do not
+// depend on the mail server, or reveal whether an address is registered
+func sendAccountEmail(msg *mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := mailer.Default().Send(ctx, msg); err != nil {
+			log.Printf("Failed to send email %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
@@ -8,16 +8,20 @@ import (
 
 // DetectionResult represents the result of diabetic retinopathy detection
 type DetectionResult struct {
-	HasDR             bool    `json:"has_dr"`
-	DRStage           string  `json:"dr_stage"`
-	Confidence        float64 `json:"confidence"`
-	HasMacularEdema   bool    `json:"has_macular_edema"`
-	HasHemorrhages    bool    `json:"has_hemorrhages"`
-	HasExudates       bool    `json:"has_exudates"`
-	HasMicroaneurysms bool    `json:"has_microaneurysms"`
-	ProcessingTime    float64 `json:"processing_time"`
-	ModelVersion      string  `json:"model_version"`
-	Error             string  `json:"error,omitempty"`
+	HasDR              bool     `json:"has_dr"`
+	DRStage            string   `json:"dr_stage"`
+	Confidence         float64  `json:"confidence"`
+	HasMacularEdema    bool     `json:"has_macular_edema"`
+	HasHemorrhages     bool     `json:"has_hemorrhages"`
+	HasExudates        bool     `json:"has_exudates"`
+	HasMicroaneurysms  bool     `json:"has_microaneurysms"`
+	ProcessingTime     float64  `json:"processing_time"`
+	ModelVersion       string   `json:"model_version"`
+	PreprocessingSteps []string `json:"preprocessing_steps"`
+	Error              string   `json:"error,omitempty"`
+
+	// requestedModel is set for results from the CNN, which are cached
+	requestedModel string
 }
 
 // DetectionStats represents statistics about detections
@@ -56,7 +60,7 @@ func DetectDiabeticRetinopathy(imagePath string) (*DetectionResult, error) {
 	}
 
 	// Preprocess image for better CNN analysis
-	preprocessedPath, err := cnnService.PreprocessImage(imagePath)
+	preprocessed, preprocessingSteps, err := cnnService.PreprocessImage(imagePath)
 	if err != nil {
 		return &DetectionResult{
 			Error: fmt.Sprintf("Image preprocessing failed: %v", err),
@@ -64,10 +68,14 @@ func DetectDiabeticRetinopathy(imagePath string) (*DetectionResult, error) {
 	}
 
 	// Send to CNN for complex analysis
-	cnnResult, err := cnnService.ScanImageWithCNN(preprocessedPath)
+	cnnResult, err := cnnService.ScanImageBytes(preprocessed, PreprocessedName(imagePath))
 	if err != nil {
 		// Fallback to simulated detection if CNN is unavailable
-		return simulateDetection(imagePath, startTime)
+		result, err := simulateDetection(imagePath, startTime)
+		if result != nil {
+			result.PreprocessingSteps = preprocessingSteps
+		}
+		return result, err
 	}
 
 	// Check if CNN analysis was successful
@@ -79,15 +87,17 @@ func DetectDiabeticRetinopathy(imagePath string) (*DetectionResult, error) {
 
 	// Convert CNN result to DetectionResult format
 	result := &DetectionResult{
-		HasDR:             cnnResult.HasDR,
-		DRStage:           cnnResult.DRStage,
-		Confidence:        cnnResult.Confidence,
-		HasMacularEdema:   cnnResult.MacularEdema,
-		HasHemorrhages:    cnnResult.Hemorrhages,
-		HasExudates:       cnnResult.Exudates,
-		HasMicroaneurysms: cnnResult.Microaneurysms,
-		ProcessingTime:    cnnResult.ProcessingTime,
-		ModelVersion:      cnnResult.ModelVersion,
+		HasDR:              cnnResult.HasDR,
+		DRStage:            cnnResult.DRStage,
+		Confidence:         cnnResult.Confidence,
+		HasMacularEdema:    cnnResult.MacularEdema,
+		HasHemorrhages:     cnnResult.Hemorrhages,
+		HasExudates:        cnnResult.Exudates,
+		HasMicroaneurysms:  cnnResult.Microaneurysms,
+		ProcessingTime:     cnnResult.ProcessingTime,
+		ModelVersion:       cnnResult.ModelVersion,
+		PreprocessingSteps: preprocessingSteps,
+		requestedModel:     CNNModelVersion,
 	}
 
 	return result, nil
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"path"
+	"time"
+
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/storage"
+)
+
+// LesionTypes lists the supported lesion types in COCO category order
+var LesionTypes = []string{
+	"microaneurysm",
+	"hemorrhage",
+	"hard_exudate",
+	"soft_exudate",
+	"neovascularization",
+}
+
+// COCOExport represents an annotation export in COCO JSON format
+type COCOExport struct {
+	Info        COCOInfo         `json:"info"`
+	Images      []COCOImage      `json:"images"`
+	Annotations []COCOAnnotation `json:"annotations"`
+	Categories  []COCOCategory   `json:"categories"`
+}
+
+type COCOInfo struct {
+	Description string `json:"description"`
+	Version     string `json:"version"`
+	DateCreated string `json:"date_created"`
+}
+
+type COCOImage struct {
+	ID       int    `json:"id"`
+	FileName string `json:"file_name"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+type COCOAnnotation struct {
+	ID           int         `json:"id"`
+	ImageID      int         `json:"image_id"`
+	CategoryID   int         `json:"category_id"`
+	BBox         []float64   `json:"bbox"`
+	Segmentation [][]float64 `json:"segmentation,omitempty"`
+	Keypoints    []float64   `json:"keypoints,omitempty"`
+	NumKeypoints int         `json:"num_keypoints,omitempty"`
+	Area         float64     `json:"area"`
+	IsCrowd      int         `json:"iscrowd"`
+	Score        float64     `json:"score"`
+	Attributes   COCOAttrs   `json:"attributes"`
+}
+
+type COCOAttrs struct {
+	AnnotationID string `json:"annotation_id"`
+	Source       string `json:"source"`
+	AuthorID     string `json:"author_id"`
+	Version      int    `json:"version"`
+	Shape        string `json:"shape"`
+}
+
+type COCOCategory struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Supercategory string `json:"supercategory"`
+}
+
+// ValidateLesions checks lesion types, shapes and geometry consistency
+func ValidateLesions(lesions []storage.Lesion) error {
+	for i, lesion := range lesions {
+		if err := ValidateLesion(lesion); err != nil {
+			return fmt.Errorf("lesion %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// ValidateLesion checks the type, shape and geometry of a single lesion
+func ValidateLesion(lesion storage.Lesion) error {
+	if lesionCategoryID(lesion.Type) == 0 {
+		return fmt.Errorf("unsupported lesion type: %s", lesion.Type)
+	}
+	if lesion.Confidence < 0 || lesion.Confidence > 1 {
+		return fmt.Errorf("confidence must be between 0 and 1")
+	}
+
+	switch lesion.Shape {
+	case storage.ShapeBox:
+		if len(lesion.BBox) != 4 {
+			return fmt.Errorf("box requires bbox [x, y, width, height]")
+		}
+		if lesion.BBox[0] < 0 || lesion.BBox[1] < 0 || lesion.BBox[2] <= 0 || lesion.BBox[3] <= 0 {
+			return fmt.Errorf("box must have non-negative origin and positive size")
+		}
+	case storage.ShapePolygon:
+		if len(lesion.Points) < 3 {
+			return fmt.Errorf("polygon requires at least 3 points")
+		}
+	case storage.ShapePoint:
+		if len(lesion.Points) != 1 {
+			return fmt.Errorf("point requires exactly 1 point")
+		}
+	default:
+		return fmt.Errorf("unsupported shape: %s", lesion.Shape)
+	}
+
+	for _, p := range lesion.Points {
+		if p[0] < 0 || p[1] < 0 {
+			return fmt.Errorf("coordinates must be non-negative")
+		}
+	}
+	return nil
+}
+
+// BuildCOCOExport converts the annotations of an image into COCO JSON
+func BuildCOCOExport(img *storage.RetinalImage, annotations []*storage.Annotation) (*COCOExport, error) {
+	width, height, err := imageDimensions(img.FilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &COCOExport{
+		Info: COCOInfo{
+			Description: "Dr. Mario retinal lesion annotations",
+			Version:     "1.0",
+			DateCreated: time.Now().Format(time.RFC3339),
+		},
+		Images: []COCOImage{{
+			ID:       1,
+			FileName: img.FileName,
+			Width:    width,
+			Height:   height,
+		}},
+		Annotations: []COCOAnnotation{},
+	}
+
+	for i, name := range LesionTypes {
+		export.Categories = append(export.Categories, COCOCategory{
+			ID:            i + 1,
+			Name:          name,
+			Supercategory: "lesion",
+		})
+	}
+
+	nextID := 1
+	for _, annotation := range annotations {
+		for _, lesion := range annotation.Lesions {
+			coco := COCOAnnotation{
+				ID:         nextID,
+				ImageID:    1,
+				CategoryID: lesionCategoryID(lesion.Type),
+				Score:      lesion.Confidence,
+				Attributes: COCOAttrs{
+					AnnotationID: annotation.ID.String(),
+					Source:       annotation.Source,
+					AuthorID:     annotation.AuthorID.String(),
+					Version:      annotation.Version,
+					Shape:        lesion.Shape,
+				},
+			}
+
+			switch lesion.Shape {
+			case storage.ShapeBox:
+				x, y, w, h := lesion.BBox[0], lesion.BBox[1], lesion.BBox[2], lesion.BBox[3]
+				coco.BBox = []float64{x, y, w, h}
+				coco.Segmentation = [][]float64{{x, y, x + w, y, x + w, y + h, x, y + h}}
+				coco.Area = w * h
+			case storage.ShapePolygon:
+				segment := make([]float64, 0, len(lesion.Points)*2)
+				for _, p := range lesion.Points {
+					segment = append(segment, p[0], p[1])
+				}
+				coco.BBox = pointsBoundingBox(lesion.Points)
+				coco.Segmentation = [][]float64{segment}
+				coco.Area = polygonArea(lesion.Points)
+			case storage.ShapePoint:
+				p := lesion.Points[0]
+				coco.BBox = []float64{p[0], p[1], 0, 0}
+				coco.Keypoints = []float64{p[0], p[1], 2}
+				coco.NumKeypoints = 1
+			}
+
+			export.Annotations = append(export.Annotations, coco)
+			nextID++
+		}
+	}
+
+	return export, nil
+}
+
+// lesionCategoryID returns the 1-based COCO category ID, or 0 if unknown
+func lesionCategoryID(lesionType string) int {
+	for i, name := range LesionTypes {
+		if name == lesionType {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func imageDimensions(imageKey string) (int, int, error) {
+	reader, _, err := blobstore.Default().Get(context.Background(), imageKey)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open image %s: %v", path.Base(imageKey), err)
+	}
+	defer reader.Close()
+
+	cfg, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode image: %v", err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+func pointsBoundingBox(points [][2]float64) []float64 {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		minX = math.Min(minX, p[0])
+		minY = math.Min(minY, p[1])
+		maxX = math.Max(maxX, p[0])
+		maxY = math.Max(maxY, p[1])
+	}
+	return []float64{minX, minY, maxX - minX, maxY - minY}
+}
+
+// polygonArea computes the polygon area using the shoelace formula
+func polygonArea(points [][2]float64) float64 {
+	area := 0.0
+	for i := range points {
+		j := (i + 1) % len(points)
+		area += points[i][0]*points[j][1] - points[j][0]*points[i][1]
+	}
+	return math.Abs(area) / 2
+}
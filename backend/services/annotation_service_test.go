@@ -0,0 +1,71 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"dr-mario-backend/storage"
+)
+
+func TestValidateLesion(t *testing.T) {
+	tests := []struct {
+		name   string
+		lesion storage.Lesion
+		err    string
+	}{
+		{
+			name:   "box",
+			lesion: storage.Lesion{Type: LesionTypes[0], Shape: storage.ShapeBox, BBox: []float64{1, 2, 3, 4}, Confidence: 0.9},
+		},
+		{
+			name:   "point",
+			lesion: storage.Lesion{Type: LesionTypes[0], Shape: storage.ShapePoint, Points: [][2]float64{{5, 5}}},
+		},
+		{
+			name:   "unknown type",
+			lesion: storage.Lesion{Type: "scar", Shape: storage.ShapePoint, Points: [][2]float64{{5, 5}}},
+			err:    "unsupported lesion type",
+		},
+		{
+			name:   "confidence above one",
+			lesion: storage.Lesion{Type: LesionTypes[0], Shape: storage.ShapePoint, Points: [][2]float64{{5, 5}}, Confidence: 1.5},
+			err:    "confidence must be between 0 and 1",
+		},
+		{
+			name:   "box without size",
+			lesion: storage.Lesion{Type: LesionTypes[0], Shape: storage.ShapeBox, BBox: []float64{1, 2, 0, 4}},
+			err:    "positive size",
+		},
+		{
+			name:   "polygon with two points",
+			lesion: storage.Lesion{Type: LesionTypes[0], Shape: storage.ShapePolygon, Points: [][2]float64{{0, 0}, {1, 1}}},
+			err:    "at least 3 points",
+		},
+		{
+			name:   "negative coordinates",
+			lesion: storage.Lesion{Type: LesionTypes[0], Shape: storage.ShapePoint, Points: [][2]float64{{-1, 5}}},
+			err:    "non-negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLesion(tt.lesion)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	// ValidateLesions names the first invalid lesion
+	lesions := []storage.Lesion{tests[0].lesion, tests[2].lesion}
+	if err := ValidateLesions(lesions); err == nil || !strings.HasPrefix(err.Error(), "lesion 1: unsupported lesion type") {
+		t.Errorf("ValidateLesions err = %v", err)
+	}
+}
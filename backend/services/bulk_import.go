@@ -0,0 +1,499 @@
+package services
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidArchive is returned when an import archive or its manifest cannot be used
+var ErrInvalidArchive = errors.New("invalid import archive")
+
+// requiredManifestColumns must be present in the manifest header. Optional
+// columns are email, first_name, last_name, date_of_birth, gender and notes.
+var requiredManifestColumns = []string{"file", "patient_id", "eye", "date"}
+
+// ImportOptions controls a bulk archive import
+type ImportOptions struct {
+	ArchiveName      string
+	CreatedBy        uuid.UUID
+	DoctorID         uuid.UUID
+	AnyPatient       bool // the importer may read every patient's records
+	CreatePatients   bool // create patients that do not exist yet
+	EnqueueDetection bool // queue DR detection for gradable imported images
+	DryRun           bool // validate only, without creating patients or images
+}
+
+// manifestRow is one parsed manifest line
+type manifestRow struct {
+	line   int
+	fields map[string]string
+}
+
+func (r manifestRow) get(column string) string {
+	return strings.TrimSpace(r.fields[column])
+}
+
+// ImportArchive imports the images of a ZIP archive described by its CSV
+// manifest (manifest.csv, or the only CSV file in the archive). Every manifest
+// row is reported individually; a row failing never aborts the import.
+// Errors are returned only when the archive or manifest as a whole is unusable.
+func ImportArchive(r io.ReaderAt, size int64, opts ImportOptions) (*storage.ImportReport, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not a ZIP file", ErrInvalidArchive)
+	}
+
+	files, manifest, err := indexArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := readManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &storage.ImportReport{
+		CreatedBy:   opts.CreatedBy,
+		ArchiveName: opts.ArchiveName,
+		DryRun:      opts.DryRun,
+		TotalRows:   len(rows),
+		StartedAt:   time.Now(),
+	}
+
+	importer := &archiveImporter{
+		opts:     opts,
+		files:    files,
+		baseDir:  path.Dir(manifest.Name),
+		patients: make(map[string]*storage.Patient),
+		used:     make(map[string]bool),
+	}
+
+	for _, row := range rows {
+		result := importer.importRow(row)
+		switch result.Status {
+		case storage.ImportRowImported, storage.ImportRowValid:
+			report.Imported++
+		case storage.ImportRowDuplicate:
+			report.Duplicates++
+		case storage.ImportRowFailed:
+			report.Failed++
+		}
+		if result.PatientCreated {
+			report.PatientsCreated++
+		}
+		report.Rows = append(report.Rows, result)
+	}
+
+	for name := range files {
+		if !importer.used[name] {
+			report.UnreferencedFiles = append(report.UnreferencedFiles, name)
+		}
+	}
+	sort.Strings(report.UnreferencedFiles)
+
+	report.CompletedAt = time.Now()
+	if err := storage.GlobalStorage.CreateImportReport(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// indexArchive maps archive entries by cleaned name and locates the manifest
+func indexArchive(archive *zip.Reader) (map[string]*zip.File, *zip.File, error) {
+	files := make(map[string]*zip.File)
+	var manifest *zip.File
+	var csvFiles []*zip.File
+
+	for _, f := range archive.File {
+		name := path.Clean(strings.TrimPrefix(f.Name, "./"))
+		base := path.Base(name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+			continue
+		}
+		if strings.EqualFold(path.Ext(name), ".csv") {
+			csvFiles = append(csvFiles, f)
+			if strings.EqualFold(base, "manifest.csv") {
+				manifest = f
+			}
+			continue
+		}
+		files[name] = f
+	}
+
+	if manifest == nil {
+		if len(csvFiles) != 1 {
+			return nil, nil, fmt.Errorf("%w: archive must contain manifest.csv", ErrInvalidArchive)
+		}
+		manifest = csvFiles[0]
+	}
+	return files, manifest, nil
+}
+
+// readManifest parses and validates the manifest header and rows
+func readManifest(manifest *zip.File) ([]manifestRow, error) {
+	rc, err := manifest.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open manifest: %v", ErrInvalidArchive, err)
+	}
+	defer rc.Close()
+
+	reader := csv.NewReader(rc)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: manifest has no header row", ErrInvalidArchive)
+	}
+	columns := make([]string, len(header))
+	present := make(map[string]bool)
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		columns[i] = column
+		present[column] = true
+	}
+	var missing []string
+	for _, column := range requiredManifestColumns {
+		if !present[column] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: manifest is missing columns: %s", ErrInvalidArchive, strings.Join(missing, ", "))
+	}
+
+	maxRows := config.AppConfig.Upload.MaxImportRows
+	var rows []manifestRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed manifest: %v", ErrInvalidArchive, err)
+		}
+		if len(rows) >= maxRows {
+			return nil, fmt.Errorf("%w: manifest exceeds %d rows", ErrInvalidArchive, maxRows)
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := manifestRow{line: line, fields: make(map[string]string)}
+		blank := true
+		for i, value := range record {
+			if i < len(columns) {
+				row.fields[columns[i]] = value
+			}
+			if strings.TrimSpace(value) != "" {
+				blank = false
+			}
+		}
+		if !blank {
+			rows = append(rows, row)
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: manifest has no rows", ErrInvalidArchive)
+	}
+	return rows, nil
+}
+
+// archiveImporter holds the state shared between the rows of one import
+type archiveImporter struct {
+	opts     ImportOptions
+	files    map[string]*zip.File
+	baseDir  string
+	patients map[string]*storage.Patient // resolved patients by identifier; nil for dry-run creations
+	used     map[string]bool
+}
+
+func (im *archiveImporter) importRow(row manifestRow) storage.ImportRowResult {
+	result := storage.ImportRowResult{
+		Row:               row.line,
+		File:              row.get("file"),
+		PatientIdentifier: row.get("patient_id"),
+	}
+	fail := func(format string, args ...interface{}) storage.ImportRowResult {
+		result.Status = storage.ImportRowFailed
+		result.Error = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	if result.File == "" {
+		return fail("file is required")
+	}
+	if result.PatientIdentifier == "" {
+		return fail("patient_id is required")
+	}
+	imageType, err := ParseEye(row.get("eye"))
+	if err != nil {
+		return fail("%v", err)
+	}
+	acquired, err := parseImportDate(row.get("date"))
+	if err != nil {
+		return fail("invalid date %q, use YYYY-MM-DD", row.get("date"))
+	}
+	if !IsAllowedExtension(result.File) {
+		return fail("file type is not allowed")
+	}
+
+	name, entry := im.lookupFile(result.File)
+	if entry == nil {
+		return fail("file not found in archive")
+	}
+	im.used[name] = true
+
+	data, err := readArchiveFile(entry)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	// Validate the image before creating a patient for it
+	sanitized, err := SanitizeImage(data, result.File)
+	if err == nil {
+		err = CheckLaterality(sanitized, imageType)
+	}
+	if err != nil {
+		return fail("image rejected: %v", err)
+	}
+
+	patient, created, err := im.resolvePatient(row)
+	if err != nil {
+		return fail("%v", err)
+	}
+	result.PatientCreated = created
+	if patient != nil {
+		result.PatientID = patient.ID
+	}
+
+	// Doctors can only import for patients in their care; patients they
+	// create are put in their care
+	if im.opts.DoctorID != uuid.Nil && patient != nil {
+		if created {
+			if _, err := EnsureCareRelationship(im.opts.DoctorID, patient.ID, storage.CareSourceImport, uuid.Nil, im.opts.CreatedBy); err != nil {
+				return fail("failed to add patient to care team: %v", err)
+			}
+		} else if !storage.GlobalStorage.HasActiveCareRelationship(im.opts.DoctorID, patient.ID, time.Now()) {
+			return fail("%v", im.patientNotFound(result.PatientIdentifier, ErrPatientNotInCare))
+		}
+	}
+
+	// A patient matched by email gets the identifier once the importer is
+	// known to be allowed to change their records
+	if !im.opts.DryRun && patient != nil && patient.MRN == "" {
+		patient.MRN = result.PatientIdentifier
+		storage.GlobalStorage.UpdatePatient(patient)
+	}
+
+	if im.opts.DryRun {
+		result.Status = storage.ImportRowValid
+		return result
+	}
+
+	image, duplicate, err := IngestImage(ImageIngest{
+		PatientID:       patient.ID,
+		DoctorID:        im.opts.DoctorID,
+		UploadedBy:      im.opts.CreatedBy,
+		Data:            data,
+		FileName:        path.Base(result.File),
+		ImageType:       imageType,
+		Notes:           row.get("notes"),
+		AcquisitionDate: &acquired,
+		Sanitized:       sanitized,
+	})
+	if err != nil {
+		return fail("%v", err)
+	}
+	result.ImageID = image.ID
+
+	if duplicate {
+		result.Status = storage.ImportRowDuplicate
+		return result
+	}
+	result.Status = storage.ImportRowImported
+
+	if im.opts.EnqueueDetection && image.Status != storage.ImageStatusRejected {
+		if err := EnqueueDetection(image.ID, im.opts.DoctorID, im.opts.CreatedBy); err != nil {
+			result.Error = "detection not queued: " + err.Error()
+		} else {
+			result.DetectionQueued = true
+		}
+	}
+	return result
+}
+
+// lookupFile resolves a manifest file reference relative to the archive root
+// or to the directory containing the manifest
+func (im *archiveImporter) lookupFile(ref string) (string, *zip.File) {
+	ref = path.Clean(strings.TrimPrefix(strings.ReplaceAll(ref, "\\", "/"), "./"))
+	for _, name := range []string{ref, path.Join(im.baseDir, ref)} {
+		if entry, exists := im.files[name]; exists {
+			return name, entry
+		}
+	}
+	return "", nil
+}
+
+func readArchiveFile(entry *zip.File) ([]byte, error) {
+	maxSize := config.AppConfig.Upload.MaxFileSize
+	if entry.UncompressedSize64 > uint64(maxSize) {
+		return nil, errors.New("file too large")
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer rc.Close()
+
+	// Do not trust the declared size of compressed entries
+	data, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, errors.New("file too large")
+	}
+	return data, nil
+}
+
+// resolvePatient matches the row to a patient by medical record number, then by
+// email, creating the patient if allowed. created is true only for the first row
+// of a patient created by this import.
+func (im *archiveImporter) resolvePatient(row manifestRow) (*storage.Patient, bool, error) {
+	mrn := row.get("patient_id")
+	if patient, seen := im.patients[mrn]; seen {
+		return patient, false, nil
+	}
+
+	if patient, err := storage.GlobalStorage.GetPatientByMRN(mrn); err == nil {
+		im.patients[mrn] = patient
+		return patient, false, nil
+	}
+
+	email := strings.ToLower(row.get("email"))
+	if email != "" {
+		if user, err := storage.GlobalStorage.GetUserByEmail(email); err == nil {
+			patient, err := storage.GlobalStorage.GetPatientByUserID(user.ID)
+			if err != nil {
+				return nil, false, im.patientNotFound(mrn, fmt.Errorf("email %s does not belong to a patient", email))
+			}
+			if patient.MRN != "" && patient.MRN != mrn {
+				return nil, false, im.patientNotFound(mrn, fmt.Errorf("email %s belongs to patient with a different identifier", email))
+			}
+			im.patients[mrn] = patient
+			return patient, false, nil
+		}
+	}
+
+	if !im.opts.CreatePatients {
+		return nil, false, fmt.Errorf("patient %s not found", mrn)
+	}
+
+	if im.opts.DryRun {
+		im.patients[mrn] = nil
+		return nil, true, nil
+	}
+
+	patient, err := createImportedPatient(row, mrn, email)
+	if err != nil {
+		return nil, false, err
+	}
+	im.patients[mrn] = patient
+	return patient, true, nil
+}
+
+// patientNotFound returns err to importers who may read every patient's
+// records. Others only learn that the patient was not found, so that an import
+// cannot be used to probe which identifiers and emails belong to whom.
+func (im *archiveImporter) patientNotFound(mrn string, err error) error {
+	if im.opts.AnyPatient {
+		return err
+	}
+	return fmt.Errorf("patient %s not found", mrn)
+}
+
+// createImportedPatient creates a patient account for a screening participant.
+// The account gets a random password; the patient cannot log in until it is reset.
+func createImportedPatient(row manifestRow, mrn, email string) (*storage.Patient, error) {
+	var dob time.Time
+	if value := row.get("date_of_birth"); value != "" {
+		parsed, err := parseImportDate(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date_of_birth %q, use YYYY-MM-DD", value)
+		}
+		dob = parsed
+	}
+
+	if email == "" {
+		email = "imported-" + uuid.New().String() + "@patients.invalid"
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(secret)), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	user := &storage.User{
+		Email:     email,
+		Password:  string(hashedPassword),
+		FirstName: row.get("first_name"),
+		LastName:  row.get("last_name"),
+		Role:      RolePatient,
+	}
+	if err := storage.GlobalStorage.CreateUser(user); err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	patient := &storage.Patient{
+		UserID:      user.ID,
+		User:        user,
+		MRN:         mrn,
+		DateOfBirth: dob,
+		Gender:      row.get("gender"),
+	}
+	if err := storage.GlobalStorage.CreatePatient(patient); err != nil {
+		return nil, fmt.Errorf("failed to create patient profile: %v", err)
+	}
+	return patient, nil
+}
+
+// ParseEye normalizes the eye notations used by screening devices to an image type
+func ParseEye(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "left_eye", "left", "l", "os":
+		return "left_eye", nil
+	case "right_eye", "right", "r", "od":
+		return "right_eye", nil
+	}
+	return "", fmt.Errorf("invalid eye %q, use left_eye or right_eye", value)
+}
+
+func parseImportDate(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", time.RFC3339, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
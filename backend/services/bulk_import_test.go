@@ -0,0 +1,105 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+)
+
+// testArchive zips a manifest and a fundus image it references
+func testArchive(t *testing.T, manifest string) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, data := range map[string][]byte{"manifest.csv": []byte(manifest), "fundus.jpg": testJPEG(t)} {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestImportDoesNotRevealOrChangePatientsOutsideCare(t *testing.T) {
+	patientUser := &storage.User{Email: strings.ToLower(t.Name()) + "-patient@example.com", Role: "patient"}
+	doctorUser := &storage.User{Email: strings.ToLower(t.Name()) + "-doctor@example.com", Role: "doctor"}
+	for _, user := range []*storage.User{patientUser, doctorUser} {
+		if err := storage.GlobalStorage.CreateUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	patient := &storage.Patient{UserID: patientUser.ID}
+	if err := storage.GlobalStorage.CreatePatient(patient); err != nil {
+		t.Fatal(err)
+	}
+	doctor := &storage.Doctor{UserID: doctorUser.ID}
+	if err := storage.GlobalStorage.CreateDoctor(doctor); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		email      string
+		anyPatient bool
+		err        string
+	}{
+		{
+			name:  "patient outside care",
+			email: patientUser.Email,
+			err:   "patient MRN-1 not found",
+		},
+		{
+			name:  "staff account",
+			email: doctorUser.Email,
+			err:   "patient MRN-1 not found",
+		},
+		{
+			name:  "unknown email",
+			email: "nobody@example.com",
+			err:   "patient MRN-1 not found",
+		},
+		{
+			name:       "staff account for an importer who reads every patient",
+			email:      doctorUser.Email,
+			anyPatient: true,
+			err:        "email " + doctorUser.Email + " does not belong to a patient",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := testArchive(t, "file,patient_id,eye,date,email\nfundus.jpg,MRN-1,right,2024-05-01,"+tt.email+"\n")
+			report, err := ImportArchive(archive, archive.Size(), ImportOptions{
+				CreatedBy:  doctorUser.ID,
+				DoctorID:   doctor.ID,
+				AnyPatient: tt.anyPatient,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			row := report.Rows[0]
+			if row.Status != storage.ImportRowFailed || row.Error != tt.err || row.ImageID != uuid.Nil {
+				t.Errorf("row = %s %q, want failed %q", row.Status, row.Error, tt.err)
+			}
+
+			stored, err := storage.GlobalStorage.GetPatientByID(patient.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.MRN != "" {
+				t.Errorf("import outside care set the patient's MRN to %q", stored.MRN)
+			}
+		})
+	}
+}
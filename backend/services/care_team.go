@@ -0,0 +1,145 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrPatientNotInCare      = errors.New("this patient is not in your care")
+	ErrCareRelationshipEnded = errors.New("care relationship has already ended")
+	ErrCarePeriodInvalid     = errors.New("a care relationship must end after it starts")
+	ErrReferralInvalid       = errors.New("patients can only be referred to another verified doctor")
+)
+
+// CarePatientIDs returns the patients currently in the doctor's care
+func CarePatientIDs(doctorID uuid.UUID) []uuid.UUID {
+	relationships := storage.GlobalStorage.GetCareRelationships(storage.CareRelationshipFilter{
+		DoctorID: doctorID,
+		ActiveAt: time.Now(),
+	})
+
+	seen := make(map[uuid.UUID]bool)
+	var patientIDs []uuid.UUID
+	for _, relationship := range relationships {
+		if !seen[relationship.PatientID] {
+			seen[relationship.PatientID] = true
+			patientIDs = append(patientIDs, relationship.PatientID)
+		}
+	}
+	return patientIDs
+}
+
+// EnsureCareRelationship puts the patient in the doctor's care from now on,
+// unless an active relationship already exists
+func EnsureCareRelationship(doctorID, patientID uuid.UUID, source string, sourceID, createdBy uuid.UUID) (*storage.CareRelationship, error) {
+	now := time.Now()
+	active := storage.GlobalStorage.GetCareRelationships(storage.CareRelationshipFilter{
+		DoctorID:  doctorID,
+		PatientID: patientID,
+		ActiveAt:  now,
+	})
+	if len(active) > 0 {
+		return active[0], nil
+	}
+
+	return createCareRelationship(&storage.CareRelationship{
+		DoctorID:  doctorID,
+		PatientID: patientID,
+		Source:    source,
+		SourceID:  sourceID,
+		StartsAt:  now,
+		CreatedBy: createdBy,
+	})
+}
+
+// AssignCare lets an admin put a patient in a doctor's care for a period
+func AssignCare(doctorID, patientID uuid.UUID, startsAt time.Time, endsAt *time.Time, notes string, assignedBy uuid.UUID) (*storage.CareRelationship, error) {
+	if endsAt != nil && !endsAt.After(startsAt) {
+		return nil, ErrCarePeriodInvalid
+	}
+
+	return createCareRelationship(&storage.CareRelationship{
+		DoctorID:  doctorID,
+		PatientID: patientID,
+		Source:    storage.CareSourceAdmin,
+		Notes:     notes,
+		StartsAt:  startsAt,
+		EndsAt:    endsAt,
+		CreatedBy: assignedBy,
+	})
+}
+
+// ReferPatient lets a doctor bring another doctor into a patient's care. The
+// referring doctor must be caring for the patient, and keeps their own access.
+func ReferPatient(referrer *storage.User, patientID, doctorID uuid.UUID, reason string, endsAt *time.Time) (*storage.CareRelationship, error) {
+	from, err := storage.GlobalStorage.GetDoctorByUserID(referrer.ID)
+	if err != nil {
+		return nil, ErrPatientNotInCare
+	}
+	// Emergency access covers the emergency, not bringing in other doctors
+	if relationship := storage.GlobalStorage.FindActiveCareRelationship(from.ID, patientID, time.Now()); relationship != nil &&
+		relationship.Source == storage.CareSourceEmergency && !HasScope(referrer, PermPatientsRead, ScopeAny) {
+		return nil, ErrPatientNotInCare
+	}
+	if !Authorize(referrer, PermPatientsRead, patientID) {
+		return nil, ErrPatientNotInCare
+	}
+	to, err := storage.GlobalStorage.GetDoctorByID(doctorID)
+	if err != nil || to.ID == from.ID || to.VerificationStatus != storage.DoctorVerificationVerified {
+		return nil, ErrReferralInvalid
+	}
+	now := time.Now()
+	if endsAt != nil && !endsAt.After(now) {
+		return nil, ErrCarePeriodInvalid
+	}
+
+	return createCareRelationship(&storage.CareRelationship{
+		DoctorID:  to.ID,
+		PatientID: patientID,
+		Source:    storage.CareSourceReferral,
+		SourceID:  from.ID,
+		Notes:     reason,
+		StartsAt:  now,
+		EndsAt:    endsAt,
+		CreatedBy: referrer.ID,
+	})
+}
+
+// EndCare ends a care relationship now. Admins can end any relationship and
+// doctors their own.
+func EndCare(relationship *storage.CareRelationship, endedBy uuid.UUID) error {
+	now := time.Now()
+	if relationship.EndsAt != nil && !relationship.EndsAt.After(now) {
+		return ErrCareRelationshipEnded
+	}
+	// A relationship that has not started yet is cancelled outright
+	endsAt := now
+	if relationship.StartsAt.After(now) {
+		endsAt = relationship.StartsAt
+	}
+	if err := storage.GlobalStorage.EndCareRelationship(relationship.ID, endsAt, endedBy); err != nil {
+		return err
+	}
+	log.Printf("🩺 Care relationship %s between doctor %s and patient %s ended by %s", relationship.ID, relationship.DoctorID, relationship.PatientID, endedBy)
+	return nil
+}
+
+func createCareRelationship(relationship *storage.CareRelationship) (*storage.CareRelationship, error) {
+	if _, err := storage.GlobalStorage.GetDoctorByID(relationship.DoctorID); err != nil {
+		return nil, err
+	}
+	if _, err := storage.GlobalStorage.GetPatientByID(relationship.PatientID); err != nil {
+		return nil, err
+	}
+	if err := storage.GlobalStorage.CreateCareRelationship(relationship); err != nil {
+		return nil, err
+	}
+	log.Printf("🩺 Patient %s in care of doctor %s (%s)", relationship.PatientID, relationship.DoctorID, relationship.Source)
+	return storage.GlobalStorage.GetCareRelationship(relationship.ID)
+}
@@ -2,21 +2,30 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"image"
-	"image/jpeg"
+	_ "image/jpeg"
 	"image/png"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
-	"path/filepath"
+	"path"
+	"strings"
 	"time"
 
-	"dr-mario-backend/config"
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/storage"
 )
 
+// CNNModelVersion is the model version requested from the CNN service
+const CNNModelVersion = "v2.1.0"
+
+// MaxImageDimension is the largest width or height accepted for an image
+const MaxImageDimension = 4096
+
 // CNNScanResult represents the result from CNN analysis
 type CNNScanResult struct {
 	Success        bool    `json:"success"`
@@ -39,6 +48,12 @@ type CNNScanResult struct {
 	LesionArea       float64 `json:"lesion_area_percentage"`
 	VesselTortuosity float64 `json:"vessel_tortuosity"`
 
+	// Lesion Localization
+	Lesions []storage.Lesion `json:"lesions,omitempty"`
+
+	// Explainability: base64-encoded saliency/attention map (PNG or JPEG)
+	Heatmap string `json:"heatmap,omitempty"`
+
 	// Processing Information
 	ProcessingTime float64 `json:"processing_time"`
 	ModelVersion   string  `json:"model_version"`
@@ -67,42 +82,42 @@ func NewCNNService() *CNNService {
 }
 
 // ScanImageWithCNN sends an image to the CNN for complex analysis
-func (c *CNNService) ScanImageWithCNN(imagePath string) (*CNNScanResult, error) {
+func (c *CNNService) ScanImageWithCNN(imageKey string) (*CNNScanResult, error) {
 	startTime := time.Now()
 
-	// Validate image file exists
-	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("image file not found: %s", imagePath)
+	// Open the image from the blob store
+	file, _, err := blobstore.Default().Get(context.Background(), imageKey)
+	if err == blobstore.ErrNotExist {
+		return nil, fmt.Errorf("image file not found: %s", imageKey)
 	}
-
-	// Prepare multipart form data
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-
-	// Add image file
-	file, err := os.Open(imagePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open image file: %v", err)
 	}
 	defer file.Close()
 
-	part, err := writer.CreateFormFile("image", filepath.Base(imagePath))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create form file: %v", err)
-	}
-
-	_, err = io.Copy(part, file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to copy image data: %v", err)
-	}
-
-	// Add additional parameters
-	writer.WriteField("api_key", c.apiKey)
-	writer.WriteField("model_version", "v2.1.0")
-	writer.WriteField("analysis_type", "comprehensive")
-	writer.WriteField("confidence_threshold", "0.7")
-
-	writer.Close()
+	// Stream multipart form data so the image is never held in memory
+	body, pipeWriter := io.Pipe()
+	writer := multipart.NewWriter(pipeWriter)
+
+	go func() {
+		part, err := writer.CreateFormFile("image", path.Base(imageKey))
+		if err != nil {
+			pipeWriter.CloseWithError(fmt.Errorf("failed to create form file: %v", err))
+			return
+		}
+		if _, err := io.Copy(part, file); err != nil {
+			pipeWriter.CloseWithError(fmt.Errorf("failed to copy image data: %v", err))
+			return
+		}
+
+		// Add additional parameters
+		writer.WriteField("api_key", c.apiKey)
+		writer.WriteField("model_version", CNNModelVersion)
+		writer.WriteField("analysis_type", "comprehensive")
+		writer.WriteField("confidence_threshold", "0.7")
+
+		pipeWriter.CloseWithError(writer.Close())
+	}()
 
 	// Create HTTP request
 	req, err := http.NewRequest("POST", c.baseURL+"/scan", body)
@@ -169,7 +184,7 @@ func (c *CNNService) ScanImageBytes(imageData []byte, filename string) (*CNNScan
 
 	// Add additional parameters
 	writer.WriteField("api_key", c.apiKey)
-	writer.WriteField("model_version", "v2.1.0")
+	writer.WriteField("model_version", CNNModelVersion)
 	writer.WriteField("analysis_type", "comprehensive")
 	writer.WriteField("confidence_threshold", "0.7")
 
@@ -220,8 +235,8 @@ func (c *CNNService) ScanImageBytes(imageData []byte, filename string) (*CNNScan
 }
 
 // ValidateImage validates if the image is suitable for CNN analysis
-func (c *CNNService) ValidateImage(imagePath string) error {
-	file, err := os.Open(imagePath)
+func (c *CNNService) ValidateImage(imageKey string) error {
+	file, _, err := blobstore.Default().Get(context.Background(), imageKey)
 	if err != nil {
 		return fmt.Errorf("failed to open image: %v", err)
 	}
@@ -234,8 +249,15 @@ func (c *CNNService) ValidateImage(imagePath string) error {
 	}
 
 	// Check supported formats
-	if format != "jpeg" && format != "png" {
-		return fmt.Errorf("unsupported image format: %s. Only JPEG and PNG are supported", format)
+	supported := false
+	for _, f := range SupportedFormats {
+		if format == f {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return fmt.Errorf("unsupported image format: %s. Supported formats: %s", format, strings.Join(SupportedFormats, ", "))
 	}
 
 	// Check minimum resolution
@@ -244,70 +266,52 @@ func (c *CNNService) ValidateImage(imagePath string) error {
 	}
 
 	// Check maximum resolution
-	if img.Width > 4096 || img.Height > 4096 {
-		return fmt.Errorf("image resolution too high: %dx%d. Maximum allowed: 4096x4096", img.Width, img.Height)
+	if img.Width > MaxImageDimension || img.Height > MaxImageDimension {
+		return fmt.Errorf("image resolution too high: %dx%d. Maximum allowed: %dx%d", img.Width, img.Height, MaxImageDimension, MaxImageDimension)
 	}
 
 	return nil
 }
 
-// PreprocessImage prepares the image for CNN analysis
-func (c *CNNService) PreprocessImage(imagePath string) (string, error) {
-	// Create preprocessing directory
-	preprocessDir := filepath.Join(config.AppConfig.Upload.UploadDir, "preprocessed")
-	if err := os.MkdirAll(preprocessDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create preprocess directory: %v", err)
-	}
-
-	// Generate output filename
-	ext := filepath.Ext(imagePath)
-	baseName := filepath.Base(imagePath)
-	outputPath := filepath.Join(preprocessDir, "preprocessed_"+baseName)
-
-	// Open source image
-	srcFile, err := os.Open(imagePath)
+// PreprocessImage prepares the image for CNN analysis by running the configured
+// preprocessing pipeline. It returns the PNG-encoded output, held in memory so
+// concurrent scans of the same image never share a file, and the applied steps.
+func (c *CNNService) PreprocessImage(imageKey string) ([]byte, []string, error) {
+	pipeline, err := NewDefaultPreprocessPipeline()
 	if err != nil {
-		return "", fmt.Errorf("failed to open source image: %v", err)
-	}
-	defer srcFile.Close()
-
-	// Decode image
-	var img image.Image
-	ext = filepath.Ext(imagePath)
-	switch ext {
-	case ".jpg", ".jpeg":
-		img, err = jpeg.Decode(srcFile)
-	case ".png":
-		img, err = png.Decode(srcFile)
-	default:
-		return "", fmt.Errorf("unsupported image format: %s", ext)
+		return nil, nil, fmt.Errorf("invalid preprocessing configuration: %v", err)
 	}
 
+	// Open and decode source image
+	img, err := decodeStoredImage(imageKey)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode image: %v", err)
+		return nil, nil, err
 	}
 
-	// Create output file
-	outFile, err := os.Create(outputPath)
+	processed, err := pipeline.Run(img)
 	if err != nil {
-		return "", fmt.Errorf("failed to create output file: %v", err)
-	}
-	defer outFile.Close()
-
-	// Encode with high quality
-	var encodeErr error
-	switch ext {
-	case ".jpg", ".jpeg":
-		encodeErr = jpeg.Encode(outFile, img, &jpeg.Options{Quality: 95})
-	case ".png":
-		encodeErr = png.Encode(outFile, img)
+		return nil, nil, fmt.Errorf("preprocessing failed: %v", err)
 	}
 
-	if encodeErr != nil {
-		return "", fmt.Errorf("failed to encode preprocessed image: %v", encodeErr)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, processed); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode preprocessed image: %v", err)
 	}
 
-	return outputPath, nil
+	return buf.Bytes(), pipeline.StepNames(), nil
+}
+
+// PreprocessedName returns the file name the preprocessed copy of an image is
+// sent to the CNN under; output is always lossless PNG
+func PreprocessedName(imageKey string) string {
+	baseName := strings.TrimSuffix(path.Base(imageKey), path.Ext(imageKey))
+	return "preprocessed_" + baseName + ".png"
+}
+
+// PreprocessedKey returns the blob key earlier versions stored the
+// preprocessed copy of an image under
+func PreprocessedKey(imageKey string) string {
+	return "preprocessed/" + PreprocessedName(imageKey)
 }
 
 // GetCNNHealth checks if the CNN service is available
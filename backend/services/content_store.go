@@ -0,0 +1,87 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/storage"
+)
+
+// ContentHash returns the hex SHA-256 digest used to address stored content
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// contentLocks serializes storing and releasing content per hash, so that an
+// object is never deleted while another image takes a reference to it
+var contentLocks sync.Map
+
+func lockContent(hash string) func() {
+	lock, _ := contentLocks.LoadOrStore(hash, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+// StoreContent writes data to the content-addressed store, keyed by its SHA-256,
+// and retains a reference to the blob. Identical content is written only once.
+func StoreContent(data []byte, ext string) (*storage.Blob, error) {
+	hash := ContentHash(data)
+	unlock := lockContent(hash)
+	defer unlock()
+
+	// Retain the reference before writing, so that the blob counts as used
+	// from the moment its object is known to exist. Shard by hash prefix to
+	// keep directories small.
+	blob, err := storage.GlobalStorage.RetainBlob(&storage.Blob{
+		Hash: hash,
+		Key:  "blobs/" + hash[:2] + "/" + hash + ext,
+		Size: int64(len(data)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	store := blobstore.Default()
+	if _, err := store.Stat(context.Background(), blob.Key); err == blobstore.ErrNotExist {
+		err = store.Put(context.Background(), blob.Key, bytes.NewReader(data), int64(len(data)), blobstore.ContentTypeFor(blob.Key))
+		if err != nil {
+			storage.GlobalStorage.ReleaseBlob(hash)
+			return nil, err
+		}
+	} else if err != nil {
+		storage.GlobalStorage.ReleaseBlob(hash)
+		return nil, fmt.Errorf("failed to check blob: %v", err)
+	}
+	return blob, nil
+}
+
+// ReleaseContent drops a reference to a blob and deletes its object,
+// derivatives and tiles once no image refers to it any more
+func ReleaseContent(hash string) error {
+	unlock := lockContent(hash)
+	defer unlock()
+
+	blob, unreferenced, err := storage.GlobalStorage.ReleaseBlob(hash)
+	if err != nil {
+		return err
+	}
+	if !unreferenced {
+		return nil
+	}
+	if err := blobstore.Default().Delete(context.Background(), blob.Key); err != nil {
+		return fmt.Errorf("failed to delete blob: %v", err)
+	}
+	if err := blobstore.Default().Delete(context.Background(), PreprocessedKey(blob.Key)); err != nil {
+		log.Printf("Failed to delete preprocessed copy of %s: %v", hash, err)
+	}
+	DeleteDerivatives(hash)
+	DeleteTiles(hash)
+	return nil
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/storage"
+)
+
+// slowStatStore widens the window between checking for an object and acting on
+// the answer
+type slowStatStore struct {
+	blobstore.BlobStore
+}
+
+func (s slowStatStore) Stat(ctx context.Context, key string) (*blobstore.ObjectInfo, error) {
+	info, err := s.BlobStore.Stat(ctx, key)
+	time.Sleep(time.Millisecond)
+	return info, err
+}
+
+func TestStoreContentWhileReleasingKeepsReferencedObjects(t *testing.T) {
+	blobstore.Store = slowStatStore{blobstore.NewLocalStore(t.TempDir())}
+
+	for i := 0; i < 20; i++ {
+		data := []byte(fmt.Sprintf("%s content %d", t.Name(), i))
+		first, err := StoreContent(data, ".png")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The last image with the content is purged while another is uploaded
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := ReleaseContent(first.Hash); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := StoreContent(data, ".png"); err != nil {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+
+		blob, err := storage.GlobalStorage.GetBlob(first.Hash)
+		if err != nil {
+			t.Fatalf("blob record of the new image is gone: %v", err)
+		}
+		if blob.RefCount != 1 {
+			t.Fatalf("ref count = %d, want 1", blob.RefCount)
+		}
+		if _, err := blobstore.Default().Stat(context.Background(), blob.Key); err != nil {
+			t.Fatalf("object of a referenced blob is missing: %v", err)
+		}
+		if err := ReleaseContent(first.Hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+)
+
+// Image derivative sizes
+const (
+	SizeOriginal  = "original"
+	SizeThumbnail = "thumbnail"
+	SizeMedium    = "medium"
+)
+
+// DerivativeSizes maps each derivative to the maximum length of its longer side
+var DerivativeSizes = map[string]int{
+	SizeThumbnail: 256,
+	SizeMedium:    1024,
+}
+
+// derivativeWorkers bounds concurrent background derivative generation
+const derivativeWorkers = 4
+
+var derivativeSlots = make(chan struct{}, derivativeWorkers)
+
+// DerivativeKey returns the blob key of a derivative. Derivatives are addressed
+// by content hash so images sharing content share their previews.
+func DerivativeKey(contentHash, size string) string {
+	return "derivatives/" + contentHash + "/" + size + ".jpg"
+}
+
+// GenerateDerivativesAsync creates the previews and deep-zoom tiles of an image in the background
+func GenerateDerivativesAsync(imageID uuid.UUID) {
+	go func() {
+		derivativeSlots <- struct{}{}
+		defer func() { <-derivativeSlots }()
+
+		image, err := storage.GlobalStorage.GetImageByID(imageID)
+		if err != nil {
+			return
+		}
+		if err := GenerateDerivatives(image); err != nil {
+			log.Printf("Failed to generate derivatives for image %s: %v", imageID, err)
+		}
+		if err := GenerateTiles(image); err != nil {
+			log.Printf("Failed to generate tiles for image %s: %v", imageID, err)
+		}
+	}()
+}
+
+// GenerateDerivatives creates the thumbnail and medium previews of an image
+func GenerateDerivatives(image *storage.RetinalImage) error {
+	src, err := decodeStoredImage(image.FilePath)
+	if err != nil {
+		return err
+	}
+	for size := range DerivativeSizes {
+		if err := storeDerivative(image, src, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureDerivative returns the key of a derivative, generating it if it does not exist yet
+func EnsureDerivative(image *storage.RetinalImage, size string) (string, error) {
+	if _, ok := DerivativeSizes[size]; !ok {
+		return "", fmt.Errorf("unsupported size: %s", size)
+	}
+
+	key := DerivativeKey(image.ContentHash, size)
+	_, err := blobstore.Default().Stat(context.Background(), key)
+	if err == nil {
+		return key, nil
+	}
+	if err != blobstore.ErrNotExist {
+		return "", err
+	}
+
+	src, err := decodeStoredImage(image.FilePath)
+	if err != nil {
+		return "", err
+	}
+	if err := storeDerivative(image, src, size); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// DeleteDerivatives removes the derivatives of a content hash
+func DeleteDerivatives(contentHash string) {
+	for size := range DerivativeSizes {
+		if err := blobstore.Default().Delete(context.Background(), DerivativeKey(contentHash, size)); err != nil {
+			log.Printf("Failed to delete %s derivative of %s: %v", size, contentHash, err)
+		}
+	}
+}
+
+func storeDerivative(image *storage.RetinalImage, src image.Image, size string) error {
+	scaled := scaleToFit(src, DerivativeSizes[size])
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85}); err != nil {
+		return fmt.Errorf("failed to encode %s: %v", size, err)
+	}
+
+	key := DerivativeKey(image.ContentHash, size)
+	if err := blobstore.Default().Put(context.Background(), key, &buf, int64(buf.Len()), "image/jpeg"); err != nil {
+		return fmt.Errorf("failed to store %s: %v", size, err)
+	}
+	return nil
+}
+
+// scaleToFit downsamples an image so its longer side is at most maxSide pixels
+func scaleToFit(src image.Image, maxSide int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSide && height <= maxSide {
+		return src
+	}
+
+	if width >= height {
+		height = max(1, height*maxSide/width)
+		width = maxSide
+	} else {
+		width = max(1, width*maxSide/height)
+		height = maxSide
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+)
+
+const (
+	detectionQueueSize = 1024
+	detectionWorkers   = 2
+)
+
+var (
+	ErrDetectionQueueFull = errors.New("detection queue is full")
+	ErrImageUngradable    = errors.New("image quality is insufficient for grading")
+)
+
+type detectionJob struct {
+	imageID  uuid.UUID
+	doctorID uuid.UUID
+}
+
+var (
+	detectionQueue     chan detectionJob
+	detectionQueueOnce sync.Once
+)
+
+// EnqueueDetection schedules background DR detection for an image on behalf
+// of the requesting user
+func EnqueueDetection(imageID, doctorID, requestedBy uuid.UUID) error {
+	detectionQueueOnce.Do(func() {
+		detectionQueue = make(chan detectionJob, detectionQueueSize)
+		for i := 0; i < detectionWorkers; i++ {
+			go detectionWorker()
+		}
+	})
+
+	image, err := storage.GlobalStorage.GetImageByID(imageID)
+	if err != nil {
+		return err
+	}
+	if image.ArchivedAt != nil {
+		return ErrImageArchived
+	}
+
+	// Images must pass the quality check before they can be queued
+	if image.Quality == nil {
+		quality, err := AssessImageQuality(image.FilePath)
+		if err != nil {
+			return fmt.Errorf("quality assessment failed: %v", err)
+		}
+		ApplyQualityAssessment(image, quality, uuid.Nil)
+	}
+	if !storage.CanTransitionImage(image.Status, storage.ImageStatusQueued) {
+		return fmt.Errorf("%w: %s to %s", storage.ErrInvalidTransition, image.Status, storage.ImageStatusQueued)
+	}
+
+	select {
+	case detectionQueue <- detectionJob{imageID: imageID, doctorID: doctorID}:
+		return storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusQueued, requestedBy, "")
+	default:
+		return ErrDetectionQueueFull
+	}
+}
+
+func detectionWorker() {
+	for job := range detectionQueue {
+		image, err := storage.GlobalStorage.GetImageByID(job.imageID)
+		if err != nil {
+			continue
+		}
+		if _, err := RunDetection(image, job.doctorID); err != nil {
+			log.Printf("Queued detection for image %s failed: %v", job.imageID, err)
+		}
+	}
+}
+
+// RunDetection grades an image and records the detection result. Results for
+// identical content from the same model are reused instead of calling the CNN.
+// Status changes are attributed to background processing.
+func RunDetection(image *storage.RetinalImage, doctorID uuid.UUID) (*storage.DetectionResult, error) {
+	if image.ArchivedAt != nil {
+		return nil, ErrImageArchived
+	}
+	if image.Quality == nil {
+		quality, err := AssessImageQuality(image.FilePath)
+		if err != nil {
+			return nil, fmt.Errorf("quality assessment failed: %v", err)
+		}
+		ApplyQualityAssessment(image, quality, uuid.Nil)
+	}
+	if !image.Quality.Gradable {
+		return nil, ErrImageUngradable
+	}
+	if err := storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusAnalyzing, uuid.Nil, ""); err != nil {
+		return nil, err
+	}
+
+	var detectionResult *storage.DetectionResult
+	if cached, err := storage.GlobalStorage.FindCachedDetectionResult(image.ContentHash, CNNModelVersion, ConfiguredPreprocessingSteps()); err == nil {
+		detectionResult = NewCachedDetectionResult(image.ID, cached)
+	} else {
+		startTime := time.Now()
+		result, err := DetectDiabeticRetinopathy(image.FilePath)
+		if err == nil && result.Error != "" {
+			err = errors.New(result.Error)
+		}
+		if err != nil {
+			storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusQualityChecked, uuid.Nil, "detection failed: "+err.Error())
+			return nil, fmt.Errorf("detection failed: %v", err)
+		}
+		detectionResult = NewDetectionResult(image.ID, result, time.Since(startTime).Seconds())
+	}
+
+	detectionResult.DoctorID = doctorID
+	if err := storage.GlobalStorage.CreateDetectionResult(detectionResult); err != nil {
+		storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusQualityChecked, uuid.Nil, "failed to save detection result")
+		return nil, fmt.Errorf("failed to save detection result: %v", err)
+	}
+
+	storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusAnalyzed, uuid.Nil, "")
+	return detectionResult, nil
+}
+
+// NewDetectionResult builds the stored record for a detection run on an image
+func NewDetectionResult(imageID uuid.UUID, result *DetectionResult, processingTime float64) *storage.DetectionResult {
+	return &storage.DetectionResult{
+		ImageID:            imageID,
+		HasDR:              result.HasDR,
+		DRStage:            result.DRStage,
+		Confidence:         result.Confidence,
+		HasMacularEdema:    result.HasMacularEdema,
+		HasHemorrhages:     result.HasHemorrhages,
+		HasExudates:        result.HasExudates,
+		HasMicroaneurysms:  result.HasMicroaneurysms,
+		AnalysisDate:       time.Now(),
+		ProcessingTime:     processingTime,
+		ModelVersion:       result.ModelVersion,
+		RequestedModel:     result.requestedModel,
+		PreprocessingSteps: result.PreprocessingSteps,
+	}
+}
+
+// NewCachedDetectionResult copies an earlier result computed on identical content
+func NewCachedDetectionResult(imageID uuid.UUID, cached *storage.DetectionResult) *storage.DetectionResult {
+	return &storage.DetectionResult{
+		ImageID:            imageID,
+		HasDR:              cached.HasDR,
+		DRStage:            cached.DRStage,
+		Confidence:         cached.Confidence,
+		HasMacularEdema:    cached.HasMacularEdema,
+		HasHemorrhages:     cached.HasHemorrhages,
+		HasExudates:        cached.HasExudates,
+		HasMicroaneurysms:  cached.HasMicroaneurysms,
+		AnalysisDate:       time.Now(),
+		ModelVersion:       cached.ModelVersion,
+		RequestedModel:     cached.RequestedModel,
+		PreprocessingSteps: cached.PreprocessingSteps,
+		HeatmapPath:        cached.HeatmapPath,
+		HasHeatmap:         cached.HasHeatmap,
+		CachedFromID:       cached.ID,
+	}
+}
@@ -0,0 +1,520 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DICOM transfer syntaxes supported for ophthalmic photography
+const (
+	dicomImplicitVRLittleEndian = "1.2.840.10008.1.2"
+	dicomExplicitVRLittleEndian = "1.2.840.10008.1.2.1"
+	dicomJPEGBaseline           = "1.2.840.10008.1.2.4.50"
+	dicomJPEGExtended           = "1.2.840.10008.1.2.4.51"
+)
+
+const dicomPreambleSize = 128
+
+// dicomTag identifies a data element by group and element number
+type dicomTag uint32
+
+func newDICOMTag(group, element uint16) dicomTag {
+	return dicomTag(uint32(group)<<16 | uint32(element))
+}
+
+func (t dicomTag) group() uint16 { return uint16(t >> 16) }
+
+var (
+	tagTransferSyntax       = newDICOMTag(0x0002, 0x0010)
+	tagStudyDate            = newDICOMTag(0x0008, 0x0020)
+	tagAcquisitionDate      = newDICOMTag(0x0008, 0x0022)
+	tagContentDate          = newDICOMTag(0x0008, 0x0023)
+	tagAcquisitionDateTime  = newDICOMTag(0x0008, 0x002A)
+	tagModality             = newDICOMTag(0x0008, 0x0060)
+	tagManufacturer         = newDICOMTag(0x0008, 0x0070)
+	tagManufacturerModel    = newDICOMTag(0x0008, 0x1090)
+	tagLaterality           = newDICOMTag(0x0020, 0x0060)
+	tagImageLaterality      = newDICOMTag(0x0020, 0x0062)
+	tagSamplesPerPixel      = newDICOMTag(0x0028, 0x0002)
+	tagPhotometric          = newDICOMTag(0x0028, 0x0004)
+	tagPlanarConfiguration  = newDICOMTag(0x0028, 0x0006)
+	tagNumberOfFrames       = newDICOMTag(0x0028, 0x0008)
+	tagRows                 = newDICOMTag(0x0028, 0x0010)
+	tagColumns              = newDICOMTag(0x0028, 0x0011)
+	tagBitsAllocated        = newDICOMTag(0x0028, 0x0100)
+	tagPixelRepresentation  = newDICOMTag(0x0028, 0x0103)
+	tagWindowCenter         = newDICOMTag(0x0028, 0x1050)
+	tagWindowWidth          = newDICOMTag(0x0028, 0x1051)
+	tagPixelData            = newDICOMTag(0x7FE0, 0x0010)
+	tagItem                 = newDICOMTag(0xFFFE, 0xE000)
+	tagItemDelimitation     = newDICOMTag(0xFFFE, 0xE00D)
+	tagSequenceDelimitation = newDICOMTag(0xFFFE, 0xE0DD)
+)
+
+const dicomUndefinedLength = 0xFFFFFFFF
+
+// DICOMMetadata holds the non-identifying acquisition metadata of a DICOM image
+type DICOMMetadata struct {
+	Laterality        string
+	AcquisitionDate   *time.Time
+	Modality          string
+	Manufacturer      string
+	ManufacturerModel string
+	TransferSyntax    string
+}
+
+// DICOMFile is a parsed DICOM object with its decoded first frame
+type DICOMFile struct {
+	Image    image.Image
+	Metadata DICOMMetadata
+}
+
+// dicomDataset holds the raw values of parsed top-level elements
+type dicomDataset struct {
+	elements map[dicomTag][]byte
+	// fragments holds encapsulated pixel data fragments, excluding the offset table
+	fragments [][]byte
+}
+
+func (d *dicomDataset) str(tag dicomTag) string {
+	return strings.TrimRight(strings.TrimSpace(string(d.elements[tag])), "\x00 ")
+}
+
+func (d *dicomDataset) uint16(tag dicomTag) (int, bool) {
+	value, ok := d.elements[tag]
+	if !ok || len(value) < 2 {
+		return 0, false
+	}
+	return int(binary.LittleEndian.Uint16(value)), true
+}
+
+// decimal parses the first value of a DS/IS element
+func (d *dicomDataset) decimal(tag dicomTag) (float64, bool) {
+	value := d.str(tag)
+	if value == "" {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(strings.Split(value, "\\")[0]), 64)
+	return f, err == nil
+}
+
+func init() {
+	magic := strings.Repeat("?", dicomPreambleSize) + "DICM"
+	image.RegisterFormat("dicom", magic, DecodeDICOM, DecodeDICOMConfig)
+}
+
+// DecodeDICOM decodes the first frame of a DICOM file
+func DecodeDICOM(r io.Reader) (image.Image, error) {
+	file, err := ParseDICOM(r)
+	if err != nil {
+		return nil, err
+	}
+	return file.Image, nil
+}
+
+// DecodeDICOMConfig returns the dimensions and colour model of a DICOM file
+func DecodeDICOMConfig(r io.Reader) (image.Config, error) {
+	file, err := ParseDICOM(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	bounds := file.Image.Bounds()
+	return image.Config{
+		ColorModel: file.Image.ColorModel(),
+		Width:      bounds.Dx(),
+		Height:     bounds.Dy(),
+	}, nil
+}
+
+// ParseDICOM parses a DICOM Part 10 file, extracting pixel data and metadata
+func ParseDICOM(r io.Reader) (*DICOMFile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DICOM data: %v", err)
+	}
+	if len(data) < dicomPreambleSize+4 || string(data[dicomPreambleSize:dicomPreambleSize+4]) != "DICM" {
+		return nil, fmt.Errorf("not a DICOM file")
+	}
+
+	dataset, err := parseDICOMDataset(data[dicomPreambleSize+4:])
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := dataset.decodePixelData()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DICOMFile{
+		Image:    img,
+		Metadata: dataset.metadata(),
+	}, nil
+}
+
+// parseDICOMDataset reads all top-level elements. The file meta group is always
+// explicit VR little endian; the rest follows the declared transfer syntax.
+func parseDICOMDataset(data []byte) (*dicomDataset, error) {
+	dataset := &dicomDataset{elements: make(map[dicomTag][]byte)}
+	reader := &dicomReader{data: data, explicit: true}
+
+	for reader.remaining() > 0 {
+		// Switch encoding once the meta group has been read
+		if !reader.metaDone {
+			tag, _ := reader.peekTag()
+			if tag.group() != 0x0002 {
+				syntax := dataset.str(tagTransferSyntax)
+				switch syntax {
+				case dicomImplicitVRLittleEndian:
+					reader.explicit = false
+				case dicomExplicitVRLittleEndian, dicomJPEGBaseline, dicomJPEGExtended, "":
+					reader.explicit = true
+				default:
+					return nil, fmt.Errorf("unsupported DICOM transfer syntax: %s", syntax)
+				}
+				reader.metaDone = true
+			}
+		}
+
+		tag, vr, length, err := reader.readHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		if tag == tagPixelData && length == dicomUndefinedLength {
+			fragments, err := reader.readFragments()
+			if err != nil {
+				return nil, err
+			}
+			dataset.fragments = fragments
+			continue
+		}
+
+		if length == dicomUndefinedLength {
+			if vr != "SQ" && vr != "UN" && vr != "" {
+				return nil, fmt.Errorf("undefined length for non-sequence element %08x", uint32(tag))
+			}
+			if err := reader.skipUndefinedSequence(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		value, err := reader.read(int(length))
+		if err != nil {
+			return nil, err
+		}
+		if vr != "SQ" {
+			dataset.elements[tag] = value
+		}
+	}
+
+	return dataset, nil
+}
+
+// dicomReader walks DICOM elements in a byte slice
+type dicomReader struct {
+	data     []byte
+	pos      int
+	explicit bool
+	metaDone bool
+}
+
+func (r *dicomReader) remaining() int { return len(r.data) - r.pos }
+
+func (r *dicomReader) read(n int) ([]byte, error) {
+	if n < 0 || r.remaining() < n {
+		return nil, fmt.Errorf("truncated DICOM data")
+	}
+	value := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return value, nil
+}
+
+func (r *dicomReader) peekTag() (dicomTag, error) {
+	if r.remaining() < 4 {
+		return 0, fmt.Errorf("truncated DICOM data")
+	}
+	group := binary.LittleEndian.Uint16(r.data[r.pos:])
+	element := binary.LittleEndian.Uint16(r.data[r.pos+2:])
+	return newDICOMTag(group, element), nil
+}
+
+// readHeader reads an element tag, VR (explicit syntaxes only) and value length
+func (r *dicomReader) readHeader() (dicomTag, string, uint32, error) {
+	tag, err := r.peekTag()
+	if err != nil {
+		return 0, "", 0, err
+	}
+	r.pos += 4
+
+	// Item and delimitation tags never carry a VR
+	if tag.group() == 0xFFFE || !r.explicit {
+		raw, err := r.read(4)
+		if err != nil {
+			return 0, "", 0, err
+		}
+		return tag, "", binary.LittleEndian.Uint32(raw), nil
+	}
+
+	raw, err := r.read(2)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	vr := string(raw)
+
+	switch vr {
+	case "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UC", "UN", "UR", "UT", "UV":
+		raw, err = r.read(6)
+		if err != nil {
+			return 0, "", 0, err
+		}
+		return tag, vr, binary.LittleEndian.Uint32(raw[2:]), nil
+	default:
+		raw, err = r.read(2)
+		if err != nil {
+			return 0, "", 0, err
+		}
+		return tag, vr, uint32(binary.LittleEndian.Uint16(raw)), nil
+	}
+}
+
+// skipUndefinedSequence skips items until the sequence delimitation item
+func (r *dicomReader) skipUndefinedSequence() error {
+	for {
+		tag, _, length, err := r.readHeader()
+		if err != nil {
+			return err
+		}
+		switch tag {
+		case tagSequenceDelimitation:
+			return nil
+		case tagItem:
+			if length == dicomUndefinedLength {
+				if err := r.skipUndefinedItem(); err != nil {
+					return err
+				}
+			} else if _, err := r.read(int(length)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected element %08x in sequence", uint32(tag))
+		}
+	}
+}
+
+// skipUndefinedItem skips nested elements until the item delimitation item
+func (r *dicomReader) skipUndefinedItem() error {
+	for {
+		tag, _, length, err := r.readHeader()
+		if err != nil {
+			return err
+		}
+		if tag == tagItemDelimitation {
+			return nil
+		}
+		if length == dicomUndefinedLength {
+			if err := r.skipUndefinedSequence(); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := r.read(int(length)); err != nil {
+			return err
+		}
+	}
+}
+
+// readFragments reads encapsulated pixel data items, dropping the offset table
+func (r *dicomReader) readFragments() ([][]byte, error) {
+	var fragments [][]byte
+	first := true
+	for {
+		tag, _, length, err := r.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		if tag == tagSequenceDelimitation {
+			return fragments, nil
+		}
+		if tag != tagItem || length == dicomUndefinedLength {
+			return nil, fmt.Errorf("malformed encapsulated pixel data")
+		}
+		value, err := r.read(int(length))
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			first = false
+			continue
+		}
+		fragments = append(fragments, value)
+	}
+}
+
+func (d *dicomDataset) metadata() DICOMMetadata {
+	meta := DICOMMetadata{
+		Modality:          d.str(tagModality),
+		Manufacturer:      d.str(tagManufacturer),
+		ManufacturerModel: d.str(tagManufacturerModel),
+		TransferSyntax:    d.str(tagTransferSyntax),
+	}
+
+	laterality := d.str(tagImageLaterality)
+	if laterality == "" {
+		laterality = d.str(tagLaterality)
+	}
+	switch laterality {
+	case "L":
+		meta.Laterality = "left_eye"
+	case "R":
+		meta.Laterality = "right_eye"
+	}
+
+	if value := d.str(tagAcquisitionDateTime); len(value) >= 8 {
+		if t, err := time.Parse("20060102150405", firstN(value, 14)); err == nil {
+			meta.AcquisitionDate = &t
+		} else if t, err := time.Parse("20060102", value[:8]); err == nil {
+			meta.AcquisitionDate = &t
+		}
+	}
+	if meta.AcquisitionDate == nil {
+		for _, tag := range []dicomTag{tagAcquisitionDate, tagContentDate, tagStudyDate} {
+			if t, err := time.Parse("20060102", d.str(tag)); err == nil {
+				meta.AcquisitionDate = &t
+				break
+			}
+		}
+	}
+
+	return meta
+}
+
+func firstN(s string, n int) string {
+	if len(s) < n {
+		return s
+	}
+	return s[:n]
+}
+
+// decodePixelData converts the first frame into a standard image
+func (d *dicomDataset) decodePixelData() (image.Image, error) {
+	if len(d.fragments) > 0 {
+		// Single-frame objects may split the frame over several fragments
+		frame := d.fragments[0]
+		if frames, ok := d.decimal(tagNumberOfFrames); !ok || frames <= 1 {
+			frame = bytes.Join(d.fragments, nil)
+		}
+		img, _, err := image.Decode(bytes.NewReader(frame))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode encapsulated DICOM pixel data: %v", err)
+		}
+		return img, nil
+	}
+
+	pixels, ok := d.elements[tagPixelData]
+	if !ok {
+		return nil, fmt.Errorf("DICOM file has no pixel data")
+	}
+
+	rows, _ := d.uint16(tagRows)
+	columns, _ := d.uint16(tagColumns)
+	bits, _ := d.uint16(tagBitsAllocated)
+	samples, ok := d.uint16(tagSamplesPerPixel)
+	if !ok {
+		samples = 1
+	}
+	if rows == 0 || columns == 0 {
+		return nil, fmt.Errorf("DICOM file has invalid dimensions")
+	}
+
+	photometric := d.str(tagPhotometric)
+	switch {
+	case samples == 1 && (bits == 8 || bits == 16):
+		return d.decodeMonochrome(pixels, columns, rows, bits, photometric == "MONOCHROME1")
+	case samples == 3 && bits == 8:
+		planar, _ := d.uint16(tagPlanarConfiguration)
+		return decodeDICOMColor(pixels, columns, rows, planar == 1, strings.HasPrefix(photometric, "YBR"))
+	default:
+		return nil, fmt.Errorf("unsupported DICOM pixel format: %d samples, %d bits", samples, bits)
+	}
+}
+
+func (d *dicomDataset) decodeMonochrome(pixels []byte, width, height, bits int, invert bool) (image.Image, error) {
+	bytesPerPixel := bits / 8
+	if len(pixels) < width*height*bytesPerPixel {
+		return nil, fmt.Errorf("DICOM pixel data is truncated")
+	}
+
+	signed, _ := d.uint16(tagPixelRepresentation)
+	values := make([]float64, width*height)
+	minValue, maxValue := math.Inf(1), math.Inf(-1)
+	for i := range values {
+		var v float64
+		if bits == 8 {
+			v = float64(pixels[i])
+		} else if signed == 1 {
+			v = float64(int16(binary.LittleEndian.Uint16(pixels[i*2:])))
+		} else {
+			v = float64(binary.LittleEndian.Uint16(pixels[i*2:]))
+		}
+		values[i] = v
+		minValue = math.Min(minValue, v)
+		maxValue = math.Max(maxValue, v)
+	}
+
+	// Use the stored VOI window if present, otherwise the full value range
+	low, high := minValue, maxValue
+	if center, ok := d.decimal(tagWindowCenter); ok {
+		if width, ok := d.decimal(tagWindowWidth); ok && width > 1 {
+			low, high = center-width/2, center+width/2
+		}
+	}
+	if bits == 8 && low == minValue && high == maxValue {
+		low, high = 0, 255
+	}
+	span := math.Max(high-low, 1)
+
+	gray := image.NewGray(image.Rect(0, 0, width, height))
+	for i, v := range values {
+		level := clampByte((v - low) / span * 255)
+		if invert {
+			level = 255 - level
+		}
+		gray.Pix[i] = level
+	}
+	return gray, nil
+}
+
+func decodeDICOMColor(pixels []byte, width, height int, planar, ybr bool) (image.Image, error) {
+	n := width * height
+	if len(pixels) < n*3 {
+		return nil, fmt.Errorf("DICOM pixel data is truncated")
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < n; i++ {
+		var a, b, c uint8
+		if planar {
+			a, b, c = pixels[i], pixels[n+i], pixels[2*n+i]
+		} else {
+			a, b, c = pixels[i*3], pixels[i*3+1], pixels[i*3+2]
+		}
+		if ybr {
+			a, b, c = color.YCbCrToRGB(a, b, c)
+		}
+		out.Pix[i*4] = a
+		out.Pix[i*4+1] = b
+		out.Pix[i*4+2] = c
+		out.Pix[i*4+3] = 255
+	}
+	return out, nil
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// dicomHeader encodes an explicit VR little endian element header
+func dicomHeader(group, element uint16, vr string, length uint32) []byte {
+	out := binary.LittleEndian.AppendUint16(nil, group)
+	out = binary.LittleEndian.AppendUint16(out, element)
+	if group == 0xFFFE {
+		return binary.LittleEndian.AppendUint32(out, length)
+	}
+	out = append(out, vr...)
+	switch vr {
+	case "OB", "OW", "SQ", "UN", "UT":
+		out = append(out, 0, 0)
+		return binary.LittleEndian.AppendUint32(out, length)
+	default:
+		return binary.LittleEndian.AppendUint16(out, uint16(length))
+	}
+}
+
+// dicomElement encodes an explicit VR little endian element with its value
+func dicomElement(group, element uint16, vr string, value []byte) []byte {
+	return append(dicomHeader(group, element, vr, uint32(len(value))), value...)
+}
+
+func dicomUS(group, element uint16, value uint16) []byte {
+	return dicomElement(group, element, "US", binary.LittleEndian.AppendUint16(nil, value))
+}
+
+// testDICOM builds a Part 10 file with the given transfer syntax and dataset
+func testDICOM(syntax string, elements ...[]byte) []byte {
+	uid := []byte(syntax)
+	if len(uid)%2 == 1 {
+		uid = append(uid, 0)
+	}
+	out := append(make([]byte, dicomPreambleSize), "DICM"...)
+	out = append(out, dicomElement(0x0002, 0x0010, "UI", uid)...)
+	for _, element := range elements {
+		out = append(out, element...)
+	}
+	return out
+}
+
+// monochromeDICOM builds a 2x2 8-bit greyscale image with the given pixel data
+func monochromeDICOM(pixels []byte, extra ...[]byte) []byte {
+	elements := [][]byte{
+		dicomUS(0x0028, 0x0002, 1),
+		dicomElement(0x0028, 0x0004, "CS", []byte("MONOCHROME2 ")),
+		dicomUS(0x0028, 0x0010, 2),
+		dicomUS(0x0028, 0x0011, 2),
+		dicomUS(0x0028, 0x0100, 8),
+	}
+	elements = append(elements, extra...)
+	elements = append(elements, dicomElement(0x7FE0, 0x0010, "OW", pixels))
+	return testDICOM(dicomExplicitVRLittleEndian, elements...)
+}
+
+// encapsulatedDICOM builds a JPEG-compressed DICOM from raw pixel data items
+func encapsulatedDICOM(items ...[]byte) []byte {
+	pixelData := dicomHeader(0x7FE0, 0x0010, "OB", dicomUndefinedLength)
+	for _, item := range items {
+		pixelData = append(pixelData, item...)
+	}
+	return testDICOM(dicomJPEGBaseline, dicomUS(0x0028, 0x0002, 3), pixelData)
+}
+
+func dicomItem(value []byte) []byte {
+	return append(dicomHeader(0xFFFE, 0xE000, "", uint32(len(value))), value...)
+}
+
+func TestParseDICOM(t *testing.T) {
+	valid := monochromeDICOM([]byte{0, 64, 128, 255})
+	jpegData := testJPEG(t)
+	sequenceEnd := dicomHeader(0xFFFE, 0xE0DD, "", 0)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		err   string
+		code  string
+		width int
+	}{
+		{
+			name:  "monochrome",
+			data:  valid,
+			width: 2,
+		},
+		{
+			name:  "encapsulated JPEG",
+			data:  encapsulatedDICOM(dicomItem(nil), dicomItem(jpegData), sequenceEnd),
+			width: 64,
+		},
+		{
+			name: "empty",
+			data: nil,
+			err:  "not a DICOM file",
+			code: RejectUnsupportedFormat,
+		},
+		{
+			name: "shorter than the preamble",
+			data: valid[:dicomPreambleSize],
+			err:  "not a DICOM file",
+			code: RejectUnsupportedFormat,
+		},
+		{
+			name: "missing DICM magic",
+			data: append(append(append([]byte{}, valid[:dicomPreambleSize]...), "DICX"...), valid[dicomPreambleSize+4:]...),
+			err:  "not a DICOM file",
+			code: RejectUnsupportedFormat,
+		},
+		{
+			name: "truncated element tag",
+			data: valid[:dicomPreambleSize+4+2],
+			err:  "truncated DICOM data",
+			code: RejectCorrupted,
+		},
+		{
+			name: "truncated element header",
+			data: valid[:dicomPreambleSize+4+6],
+			err:  "truncated DICOM data",
+			code: RejectCorrupted,
+		},
+		{
+			name: "truncated pixel data",
+			data: valid[:len(valid)-1],
+			err:  "truncated DICOM data",
+			code: RejectCorrupted,
+		},
+		{
+			name: "value length past the end",
+			data: append(monochromeDICOM([]byte{0, 64, 128, 255}), dicomHeader(0x0028, 0x1050, "DS", 100)...),
+			err:  "truncated DICOM data",
+			code: RejectCorrupted,
+		},
+		{
+			name: "huge value length",
+			data: append(monochromeDICOM([]byte{0, 64, 128, 255}), dicomHeader(0x0009, 0x1010, "OB", 0xFFFFFFFE)...),
+			err:  "truncated DICOM data",
+			code: RejectCorrupted,
+		},
+		{
+			name: "unsupported transfer syntax",
+			data: testDICOM("1.2.840.10008.1.2.2", dicomUS(0x0028, 0x0010, 2)),
+			err:  "unsupported DICOM transfer syntax",
+			code: RejectCorrupted,
+		},
+		{
+			name: "undefined length outside a sequence",
+			data: monochromeDICOM([]byte{0, 64, 128, 255}, dicomHeader(0x0029, 0x1010, "OB", dicomUndefinedLength)),
+			err:  "undefined length for non-sequence element",
+			code: RejectCorrupted,
+		},
+		{
+			name: "unterminated sequence",
+			data: monochromeDICOM([]byte{0, 64, 128, 255}, dicomHeader(0x0008, 0x1140, "SQ", dicomUndefinedLength), dicomHeader(0xFFFE, 0xE000, "", dicomUndefinedLength)),
+			err:  "truncated DICOM data",
+			code: RejectCorrupted,
+		},
+		{
+			name: "element inside a sequence without an item",
+			data: monochromeDICOM([]byte{0, 64, 128, 255}, dicomHeader(0x0008, 0x1140, "SQ", dicomUndefinedLength)),
+			err:  "unexpected element",
+			code: RejectCorrupted,
+		},
+		{
+			name: "element among pixel data fragments",
+			data: encapsulatedDICOM(dicomItem(nil), dicomUS(0x0028, 0x0010, 2), sequenceEnd),
+			err:  "malformed encapsulated pixel data",
+			code: RejectCorrupted,
+		},
+		{
+			name: "fragment of undefined length",
+			data: encapsulatedDICOM(dicomItem(nil), dicomHeader(0xFFFE, 0xE000, "", dicomUndefinedLength), sequenceEnd),
+			err:  "malformed encapsulated pixel data",
+			code: RejectCorrupted,
+		},
+		{
+			name: "fragments without a delimiter",
+			data: encapsulatedDICOM(dicomItem(nil), dicomItem(jpegData)),
+			err:  "truncated DICOM data",
+			code: RejectCorrupted,
+		},
+		{
+			name: "truncated JPEG fragment",
+			data: encapsulatedDICOM(dicomItem(nil), dicomItem(jpegData[:len(jpegData)/2]), sequenceEnd),
+			err:  "failed to decode encapsulated DICOM pixel data",
+			code: RejectCorrupted,
+		},
+		{
+			name: "no pixel data",
+			data: testDICOM(dicomExplicitVRLittleEndian, dicomUS(0x0028, 0x0010, 2), dicomUS(0x0028, 0x0011, 2)),
+			err:  "DICOM file has no pixel data",
+			code: RejectCorrupted,
+		},
+		{
+			name: "zero rows",
+			data: monochromeDICOM([]byte{0, 64, 128, 255}, dicomUS(0x0028, 0x0010, 0)),
+			err:  "DICOM file has invalid dimensions",
+			code: RejectCorrupted,
+		},
+		{
+			name: "fewer pixels than rows by columns",
+			data: monochromeDICOM([]byte{0, 64}),
+			err:  "DICOM pixel data is truncated",
+			code: RejectCorrupted,
+		},
+		{
+			name: "fewer 16-bit pixels than rows by columns",
+			data: monochromeDICOM([]byte{0, 64, 128, 255}, dicomUS(0x0028, 0x0100, 16)),
+			err:  "DICOM pixel data is truncated",
+			code: RejectCorrupted,
+		},
+		{
+			name: "fewer colour pixels than rows by columns",
+			data: monochromeDICOM([]byte{0, 64, 128, 255}, dicomUS(0x0028, 0x0002, 3)),
+			err:  "DICOM pixel data is truncated",
+			code: RejectCorrupted,
+		},
+		{
+			name: "huge dimensions with little pixel data",
+			data: monochromeDICOM([]byte{0, 64, 128, 255}, dicomUS(0x0028, 0x0010, 0xFFFF), dicomUS(0x0028, 0x0011, 0xFFFF), dicomUS(0x0028, 0x0100, 16)),
+			err:  "DICOM pixel data is truncated",
+			code: RejectCorrupted,
+		},
+		{
+			name: "unsupported bit depth",
+			data: monochromeDICOM([]byte{0, 64, 128, 255}, dicomUS(0x0028, 0x0100, 12)),
+			err:  "unsupported DICOM pixel format",
+			code: RejectCorrupted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := ParseDICOM(bytes.NewReader(tt.data))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got := file.Image.Bounds().Dx(); got != tt.width {
+				t.Errorf("width = %d, want %d", got, tt.width)
+			}
+
+			sanitized, err := SanitizeImage(tt.data, "fundus.dcm")
+			if tt.code != "" {
+				var rejection *ImageRejection
+				if !errors.As(err, &rejection) || rejection.Code != tt.code {
+					t.Fatalf("sanitize err = %v, want rejection %q", err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sanitized.Format != "dicom" || sanitized.Extension != ".png" {
+				t.Errorf("format = %s %s", sanitized.Format, sanitized.Extension)
+			}
+		})
+	}
+}
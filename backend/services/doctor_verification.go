@@ -0,0 +1,189 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/config"
+	"dr-mario-backend/mailer"
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrDoctorNotVerified       = errors.New("your doctor credentials have not been verified yet")
+	ErrCredentialsIncomplete   = errors.New("a license number and at least one credential document are required")
+	ErrCredentialTypeInvalid   = errors.New("credential documents must be PDF, JPEG or PNG files")
+	ErrCredentialsLocked       = errors.New("documents of verified credentials cannot be removed")
+	ErrDoctorAlreadyReviewed   = errors.New("doctor credentials have already been reviewed with this result")
+	ErrCredentialDocumentEmpty = errors.New("credential document is empty")
+)
+
+// Content types accepted for credential documents, by file extension
+var credentialContentTypes = map[string]string{
+	"application/pdf": ".pdf",
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+}
+
+// DoctorVerified reports whether a user may access clinical data as a
+// doctor. Users whose role has no doctor profile are not affected by doctor
+// verification.
+func DoctorVerified(user *storage.User) bool {
+	if !HasPermission(user, PermDoctorProfile) {
+		return true
+	}
+	doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
+	return err == nil && doctor.VerificationStatus == storage.DoctorVerificationVerified
+}
+
+// SaveCredentialDocument stores a document a doctor submitted for review. A
+// rejected doctor who submits new documents goes back to pending review.
+func SaveCredentialDocument(doctor *storage.Doctor, fileName string, data []byte) (*storage.CredentialDocument, error) {
+	if len(data) == 0 {
+		return nil, ErrCredentialDocumentEmpty
+	}
+	// Trust the content, not the client's file name or header
+	contentType := http.DetectContentType(data)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	ext, ok := credentialContentTypes[contentType]
+	if !ok {
+		return nil, ErrCredentialTypeInvalid
+	}
+
+	document := &storage.CredentialDocument{
+		ID:          uuid.New(),
+		DoctorID:    doctor.ID,
+		FileName:    filepath.Base(fileName),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+	document.Key = "credentials/" + doctor.ID.String() + "/" + document.ID.String() + ext
+	if err := blobstore.Default().Put(context.Background(), document.Key, bytes.NewReader(data), document.Size, contentType); err != nil {
+		return nil, fmt.Errorf("failed to store credential document: %v", err)
+	}
+	if err := storage.GlobalStorage.CreateCredentialDocument(document); err != nil {
+		return nil, err
+	}
+
+	if doctor.VerificationStatus == storage.DoctorVerificationRejected {
+		doctor.VerificationStatus = storage.DoctorVerificationPending
+		if err := storage.GlobalStorage.UpdateDoctor(doctor); err != nil {
+			return nil, err
+		}
+	}
+	return document, nil
+}
+
+// DeleteCredentialDocument removes a document that has not been accepted yet
+func DeleteCredentialDocument(doctor *storage.Doctor, document *storage.CredentialDocument) error {
+	if doctor.VerificationStatus == storage.DoctorVerificationVerified {
+		return ErrCredentialsLocked
+	}
+	if err := storage.GlobalStorage.DeleteCredentialDocument(document.ID); err != nil {
+		return err
+	}
+	if err := blobstore.Default().Delete(context.Background(), document.Key); err != nil && !errors.Is(err, blobstore.ErrNotExist) {
+		log.Printf("Failed to delete credential document %s: %v", document.Key, err)
+	}
+	return nil
+}
+
+// ChangeDoctorLicense updates the license number. A verified doctor goes back
+// to pending review, since the verification was for the previous license.
+func ChangeDoctorLicense(doctor *storage.Doctor, license string) {
+	if license == doctor.License {
+		return
+	}
+	doctor.License = license
+	if doctor.VerificationStatus == storage.DoctorVerificationVerified {
+		doctor.VerificationStatus = storage.DoctorVerificationPending
+		doctor.VerificationNote = ""
+		log.Printf("🩺 Doctor %s changed their license number; verification is pending again", doctor.ID)
+	}
+}
+
+// VerifyDoctor approves a doctor's credentials after an admin has checked the
+// license against the uploaded documents, granting access to clinical data
+func VerifyDoctor(doctor *storage.Doctor, verifiedBy uuid.UUID) error {
+	if doctor.VerificationStatus == storage.DoctorVerificationVerified {
+		return ErrDoctorAlreadyReviewed
+	}
+	if strings.TrimSpace(doctor.License) == "" || len(storage.GlobalStorage.GetCredentialDocuments(doctor.ID)) == 0 {
+		return ErrCredentialsIncomplete
+	}
+
+	if err := reviewDoctor(doctor, storage.DoctorVerificationVerified, "", verifiedBy); err != nil {
+		return err
+	}
+	notifyDoctorReview(doctor, "Your Dr. Mario doctor account has been verified",
+		"Your credentials have been verified. You now have access to patient records and screening results.\n")
+	return nil
+}
+
+// RejectDoctor declines a doctor's credentials, or revokes an earlier
+// verification. The doctor can upload new documents to be reviewed again.
+func RejectDoctor(doctor *storage.Doctor, reason string, rejectedBy uuid.UUID) error {
+	if doctor.VerificationStatus == storage.DoctorVerificationRejected {
+		return ErrDoctorAlreadyReviewed
+	}
+
+	if err := reviewDoctor(doctor, storage.DoctorVerificationRejected, reason, rejectedBy); err != nil {
+		return err
+	}
+	notifyDoctorReview(doctor, "Your Dr. Mario doctor account could not be verified",
+		fmt.Sprintf("We could not verify your credentials:\n\n%s\n\n"+
+			"You can upload new documents from your profile to have them reviewed again.\n", reason))
+	return nil
+}
+
+func reviewDoctor(doctor *storage.Doctor, status, note string, reviewedBy uuid.UUID) error {
+	now := time.Now()
+	doctor.VerificationStatus = status
+	doctor.VerificationNote = note
+	doctor.ReviewedBy = reviewedBy
+	doctor.ReviewedAt = &now
+	if err := storage.GlobalStorage.UpdateDoctor(doctor); err != nil {
+		return err
+	}
+
+	eventType, details := storage.SecurityEventDoctorVerified, "Doctor credentials verified for license "+doctor.License
+	if status == storage.DoctorVerificationRejected {
+		eventType, details = storage.SecurityEventDoctorRejected, "Doctor credentials rejected: "+note
+	}
+	event := &storage.SecurityEvent{
+		Type:    eventType,
+		UserID:  doctor.UserID,
+		ActorID: reviewedBy,
+		Details: details,
+	}
+	if user, err := storage.GlobalStorage.GetUserByID(doctor.UserID); err == nil {
+		event.Email = user.Email
+	}
+	recordSecurityEvent(event)
+	return nil
+}
+
+func notifyDoctorReview(doctor *storage.Doctor, subject, body string) {
+	user, err := storage.GlobalStorage.GetUserByID(doctor.UserID)
+	if err != nil {
+		return
+	}
+	sendAccountEmail(&mailer.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body: fmt.Sprintf("Hello %s,\n\n%s\nSign in at %s\n",
+			user.FirstName, body, strings.TrimRight(config.AppConfig.Account.AppURL, "/")+"/login"),
+	})
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/mailer"
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrBreakGlassReasonRequired      = errors.New("a reason describing the emergency is required")
+	ErrBreakGlassNotNeeded           = errors.New("you already have access to this patient's records")
+	ErrEmergencyAccessReviewed       = errors.New("emergency access has already been reviewed")
+	ErrEmergencyReviewOutcomeInvalid = errors.New("review outcome must be justified or unjustified")
+)
+
+// BreakGlassDuration returns how long emergency access lasts
+func BreakGlassDuration() time.Duration {
+	duration, err := time.ParseDuration(config.AppConfig.BreakGlass.Duration)
+	if err != nil || duration <= 0 {
+		return 4 * time.Hour
+	}
+	return duration
+}
+
+// BreakGlass gives a doctor time-limited access to the records of a patient
+// outside their care. The doctor must state why; the access is logged as a
+// security event, the patient is told, and it awaits compliance review.
+func BreakGlass(user *storage.User, patientID uuid.UUID, reason, ipAddress string) (*storage.EmergencyAccess, error) {
+	doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	patient, err := storage.GlobalStorage.GetPatientByID(patientID)
+	if err != nil {
+		return nil, err
+	}
+	reason = strings.TrimSpace(reason)
+	if len(reason) < config.AppConfig.BreakGlass.MinReasonLength {
+		return nil, ErrBreakGlassReasonRequired
+	}
+	now := time.Now()
+	if HasScope(user, PermPatientsRead, ScopeAny) || storage.GlobalStorage.HasActiveCareRelationship(doctor.ID, patientID, now) {
+		return nil, ErrBreakGlassNotNeeded
+	}
+
+	duration := BreakGlassDuration()
+	expiresAt := now.Add(duration)
+	access := &storage.EmergencyAccess{
+		DoctorID:  doctor.ID,
+		PatientID: patientID,
+		Reason:    reason,
+		IPAddress: ipAddress,
+		GrantedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	if err := storage.GlobalStorage.CreateEmergencyAccess(access); err != nil {
+		return nil, err
+	}
+	relationship, err := createCareRelationship(&storage.CareRelationship{
+		DoctorID:  doctor.ID,
+		PatientID: patientID,
+		Source:    storage.CareSourceEmergency,
+		SourceID:  access.ID,
+		Notes:     reason,
+		StartsAt:  now,
+		EndsAt:    &expiresAt,
+		CreatedBy: user.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.GlobalStorage.LinkEmergencyAccess(access.ID, relationship.ID); err != nil {
+		return nil, err
+	}
+
+	recordSecurityEvent(&storage.SecurityEvent{
+		Type:      storage.SecurityEventBreakGlass,
+		UserID:    user.ID,
+		ActorID:   user.ID,
+		Email:     user.Email,
+		IPAddress: ipAddress,
+		Details:   fmt.Sprintf("Emergency access to patient %s until %s: %s", patientID, expiresAt.Format(time.RFC3339), reason),
+	})
+	notifyEmergencyAccess(user, patient, reason, duration)
+
+	return storage.GlobalStorage.GetEmergencyAccess(access.ID)
+}
+
+// ReviewEmergencyAccess records whether an emergency access was justified
+func ReviewEmergencyAccess(access *storage.EmergencyAccess, outcome, notes string, reviewedBy uuid.UUID) error {
+	if outcome != storage.EmergencyAccessJustified && outcome != storage.EmergencyAccessUnjustified {
+		return ErrEmergencyReviewOutcomeInvalid
+	}
+	if access.ReviewedAt != nil {
+		return ErrEmergencyAccessReviewed
+	}
+	if err := storage.GlobalStorage.ReviewEmergencyAccess(access.ID, outcome, strings.TrimSpace(notes), reviewedBy); err != nil {
+		return err
+	}
+
+	event := &storage.SecurityEvent{
+		Type:    storage.SecurityEventBreakGlassReviewed,
+		ActorID: reviewedBy,
+		Details: fmt.Sprintf("Emergency access %s to patient %s reviewed as %s", access.ID, access.PatientID, outcome),
+	}
+	if doctor, err := storage.GlobalStorage.GetDoctorByID(access.DoctorID); err == nil {
+		event.UserID = doctor.UserID
+		if user, err := storage.GlobalStorage.GetUserByID(doctor.UserID); err == nil {
+			event.Email = user.Email
+		}
+	}
+	recordSecurityEvent(event)
+	return nil
+}
+
+// RecordPatientAccess counts a use of the patient's records by a doctor whose
+// access comes from breaking the glass. Handlers call it once they serve or
+// change the records, so that authorization checks alone are not counted.
+func RecordPatientAccess(user *storage.User, permission string, patientID uuid.UUID) {
+	if AuthorizedScope(user, permission, patientID) != ScopeCare {
+		return
+	}
+	doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID)
+	if err != nil {
+		return
+	}
+	relationship := storage.GlobalStorage.FindActiveCareRelationship(doctor.ID, patientID, time.Now())
+	if relationship != nil && relationship.Source == storage.CareSourceEmergency {
+		storage.GlobalStorage.RecordEmergencyAccessUse(relationship.SourceID, permission)
+	}
+}
+
+func notifyEmergencyAccess(doctor *storage.User, patient *storage.Patient, reason string, duration time.Duration) {
+	user, err := storage.GlobalStorage.GetUserByID(patient.UserID)
+	if err != nil {
+		return
+	}
+	sendAccountEmail(&mailer.Message{
+		To:      user.Email,
+		Subject: "Your Dr. Mario records were accessed in an emergency",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Dr. %s %s, who is not part of your care team, was given emergency access to your records for %s.\n\n"+
+			"Reason given:\n\n%s\n\n"+
+			"Every emergency access is reviewed by our compliance team. If you have concerns, please contact us.\n"+
+			"Sign in at %s\n",
+			user.FirstName, doctor.FirstName, doctor.LastName, describeDuration(duration),
+			reason, strings.TrimRight(config.AppConfig.Account.AppURL, "/")+"/login"),
+	})
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/storage"
+)
+
+// Colormaps supported for heatmap overlays
+var Colormaps = map[string]func(float64) color.RGBA{
+	"jet":     jetColormap,
+	"hot":     hotColormap,
+	"viridis": viridisColormap,
+	"gray":    grayColormap,
+}
+
+// heatmapAspectTolerance is the relative difference allowed between the aspect
+// ratios of a heatmap and its image, to allow for rounding when it was scaled
+const heatmapAspectTolerance = 0.02
+
+// SaveHeatmap decodes a saliency map of the image and stores it as PNG for the
+// detection result. The declared dimensions are checked before decoding so
+// that a small file cannot force a huge allocation.
+func SaveHeatmap(result *storage.DetectionResult, retinalImage *storage.RetinalImage, data []byte) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode heatmap: %v", err)
+	}
+	if err := checkHeatmapSize(cfg, retinalImage.Metadata); err != nil {
+		return err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode heatmap: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, toGray(img)); err != nil {
+		return fmt.Errorf("failed to encode heatmap: %v", err)
+	}
+
+	heatmapKey := "heatmaps/" + result.ID.String() + ".png"
+	if err := blobstore.Default().Put(context.Background(), heatmapKey, &buf, int64(buf.Len()), "image/png"); err != nil {
+		return fmt.Errorf("failed to store heatmap: %v", err)
+	}
+
+	result.HeatmapPath = heatmapKey
+	result.HasHeatmap = true
+	return nil
+}
+
+// checkHeatmapSize rejects heatmaps larger than an image may be, or whose
+// shape differs from the image they are overlaid on
+func checkHeatmapSize(cfg image.Config, metadata *storage.ImageMetadata) error {
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return fmt.Errorf("heatmap has invalid dimensions %dx%d", cfg.Width, cfg.Height)
+	}
+	if cfg.Width > MaxImageDimension || cfg.Height > MaxImageDimension {
+		return fmt.Errorf("heatmap dimensions %dx%d exceed the maximum of %dx%d", cfg.Width, cfg.Height, MaxImageDimension, MaxImageDimension)
+	}
+	if metadata == nil || metadata.Width <= 0 || metadata.Height <= 0 {
+		return nil
+	}
+	imageRatio := float64(metadata.Width) / float64(metadata.Height)
+	heatmapRatio := float64(cfg.Width) / float64(cfg.Height)
+	if math.Abs(heatmapRatio-imageRatio) > heatmapAspectTolerance*imageRatio {
+		return fmt.Errorf("heatmap dimensions %dx%d do not match the aspect ratio of the %dx%d image", cfg.Width, cfg.Height, metadata.Width, metadata.Height)
+	}
+	return nil
+}
+
+// SaveHeatmapBase64 stores a base64-encoded heatmap as returned by the CNN
+func SaveHeatmapBase64(result *storage.DetectionResult, retinalImage *storage.RetinalImage, encoded string) error {
+	// Accept data URLs as well as raw base64
+	if idx := strings.Index(encoded, ","); strings.HasPrefix(encoded, "data:") && idx >= 0 {
+		encoded = encoded[idx+1:]
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("failed to decode heatmap data: %v", err)
+	}
+	return SaveHeatmap(result, retinalImage, data)
+}
+
+// RenderHeatmapOverlay blends the colorized heatmap over the original fundus image.
+// Opacity scales with heatmap intensity so low-attention regions stay visible.
+func RenderHeatmapOverlay(imageKey, heatmapKey string, opacity float64, colormap string) (image.Image, error) {
+	colorize, ok := Colormaps[colormap]
+	if !ok {
+		return nil, fmt.Errorf("unsupported colormap: %s", colormap)
+	}
+	if opacity < 0 || opacity > 1 {
+		return nil, fmt.Errorf("opacity must be between 0 and 1")
+	}
+
+	base, err := decodeStoredImage(imageKey)
+	if err != nil {
+		return nil, err
+	}
+	heatmap, err := decodeStoredImage(heatmapKey)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := base.Bounds()
+	hb := heatmap.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	for y := 0; y < bounds.Dy(); y++ {
+		// Nearest-neighbour scaling of the heatmap to the image size
+		hy := hb.Min.Y + y*hb.Dy()/bounds.Dy()
+		for x := 0; x < bounds.Dx(); x++ {
+			hx := hb.Min.X + x*hb.Dx()/bounds.Dx()
+
+			intensity := float64(color.GrayModel.Convert(heatmap.At(hx, hy)).(color.Gray).Y) / 255
+			overlay := colorize(intensity)
+			alpha := opacity * intensity
+
+			r, g, b, _ := base.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			out.SetRGBA(x, y, color.RGBA{
+				R: blend(uint8(r>>8), overlay.R, alpha),
+				G: blend(uint8(g>>8), overlay.G, alpha),
+				B: blend(uint8(b>>8), overlay.B, alpha),
+				A: 255,
+			})
+		}
+	}
+
+	return out, nil
+}
+
+// decodeStoredImage streams an image from the blob store and decodes it
+func decodeStoredImage(key string) (image.Image, error) {
+	reader, _, err := blobstore.Default().Get(context.Background(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %v", err)
+	}
+	defer reader.Close()
+
+	img, _, err := image.Decode(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	return img, nil
+}
+
+func toGray(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			gray.Set(x, y, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return gray
+}
+
+func blend(base, overlay uint8, alpha float64) uint8 {
+	return uint8(math.Round(float64(base)*(1-alpha) + float64(overlay)*alpha))
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+func jetColormap(v float64) color.RGBA {
+	r := clamp01(1.5 - math.Abs(4*v-3))
+	g := clamp01(1.5 - math.Abs(4*v-2))
+	b := clamp01(1.5 - math.Abs(4*v-1))
+	return color.RGBA{R: uint8(r * 255), G: uint8(g * 255), B: uint8(b * 255), A: 255}
+}
+
+func hotColormap(v float64) color.RGBA {
+	r := clamp01(3 * v)
+	g := clamp01(3*v - 1)
+	b := clamp01(3*v - 2)
+	return color.RGBA{R: uint8(r * 255), G: uint8(g * 255), B: uint8(b * 255), A: 255}
+}
+
+// viridisColormap interpolates between a small set of viridis anchor colors
+func viridisColormap(v float64) color.RGBA {
+	anchors := []color.RGBA{
+		{68, 1, 84, 255},
+		{59, 82, 139, 255},
+		{33, 145, 140, 255},
+		{94, 201, 98, 255},
+		{253, 231, 37, 255},
+	}
+	pos := clamp01(v) * float64(len(anchors)-1)
+	i := int(pos)
+	if i >= len(anchors)-1 {
+		return anchors[len(anchors)-1]
+	}
+	t := pos - float64(i)
+	a, b := anchors[i], anchors[i+1]
+	return color.RGBA{
+		R: uint8(float64(a.R) + t*(float64(b.R)-float64(a.R))),
+		G: uint8(float64(a.G) + t*(float64(b.G)-float64(a.G))),
+		B: uint8(float64(a.B) + t*(float64(b.B)-float64(a.B))),
+		A: 255,
+	}
+}
+
+func grayColormap(v float64) color.RGBA {
+	c := uint8(clamp01(v) * 255)
+	return color.RGBA{R: c, G: c, B: c, A: 255}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+)
+
+// testHeatmap encodes a blank greyscale map of the given size
+func testHeatmap(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSaveHeatmap(t *testing.T) {
+	blobstore.Store = blobstore.NewLocalStore(t.TempDir())
+	fundus := &storage.ImageMetadata{Width: 2000, Height: 1500}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		metadata *storage.ImageMetadata
+		err      string
+	}{
+		{
+			name:     "same aspect ratio",
+			data:     testHeatmap(t, 400, 300),
+			metadata: fundus,
+		},
+		{
+			name:     "aspect ratio within rounding",
+			data:     testHeatmap(t, 401, 300),
+			metadata: fundus,
+		},
+		{
+			name: "image without dimensions",
+			data: testHeatmap(t, 64, 64),
+		},
+		{
+			name:     "different aspect ratio",
+			data:     testHeatmap(t, 300, 300),
+			metadata: fundus,
+			err:      "do not match the aspect ratio",
+		},
+		{
+			name: "too wide",
+			data: testHeatmap(t, MaxImageDimension+1, 1),
+			err:  "exceed the maximum",
+		},
+		{
+			name:     "too large for the image",
+			data:     testHeatmap(t, MaxImageDimension+4, MaxImageDimension+3),
+			metadata: fundus,
+			err:      "exceed the maximum",
+		},
+		{
+			name: "not an image",
+			data: []byte("heatmap"),
+			err:  "failed to decode heatmap",
+		},
+		{
+			name: "truncated",
+			data: testHeatmap(t, 400, 300)[:40],
+			err:  "failed to decode heatmap",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &storage.DetectionResult{ID: uuid.New()}
+			err := SaveHeatmap(result, &storage.RetinalImage{Metadata: tt.metadata}, tt.data)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				if result.HasHeatmap {
+					t.Error("rejected heatmap was attached to the result")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.HasHeatmap || result.HeatmapPath == "" {
+				t.Errorf("heatmap not attached: %+v", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/storage"
+
+	// Register additional decoders with the image package
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+)
+
+// SupportedFormats lists the image formats that can be ingested
+var SupportedFormats = []string{"jpeg", "png", "tiff", "bmp", "dicom"}
+
+// formatExtensions maps each supported format to its accepted file extensions
+var formatExtensions = map[string][]string{
+	"jpeg":  {".jpg", ".jpeg"},
+	"png":   {".png"},
+	"tiff":  {".tif", ".tiff"},
+	"bmp":   {".bmp"},
+	"dicom": {".dcm", ".dicom"},
+}
+
+// DetectImageFormat identifies the real image format from its magic bytes.
+// It returns an empty string for unknown content.
+func DetectImageFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return "tiff"
+	case bytes.HasPrefix(data, []byte("BM")) && len(data) >= 26:
+		return "bmp"
+	case len(data) >= dicomPreambleSize+4 && string(data[dicomPreambleSize:dicomPreambleSize+4]) == "DICM":
+		return "dicom"
+	}
+	return ""
+}
+
+// IsAllowedExtension reports whether the file name has a configured image extension
+func IsAllowedExtension(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, allowedExt := range config.AppConfig.Upload.AllowedExtensions {
+		if "."+allowedExt == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// extensionMatchesFormat reports whether a file extension is valid for a format
+func extensionMatchesFormat(ext, format string) bool {
+	for _, allowed := range formatExtensions[format] {
+		if strings.EqualFold(ext, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractMetadata collects non-identifying acquisition metadata before the
+// source file's metadata is stripped
+func extractMetadata(data []byte, img image.Image, format string) (*storage.ImageMetadata, error) {
+	bounds := img.Bounds()
+	metadata := &storage.ImageMetadata{
+		SourceFormat: format,
+		Width:        bounds.Dx(),
+		Height:       bounds.Dy(),
+	}
+
+	switch format {
+	case "dicom":
+		dicom, err := ParseDICOM(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		metadata.Laterality = dicom.Metadata.Laterality
+		metadata.AcquisitionDate = dicom.Metadata.AcquisitionDate
+		metadata.Modality = dicom.Metadata.Modality
+		metadata.Manufacturer = dicom.Metadata.Manufacturer
+		metadata.DeviceModel = dicom.Metadata.ManufacturerModel
+	case "tiff":
+		applyTIFFTags(metadata, readTIFFTags(data))
+	case "jpeg":
+		if exif := jpegEXIF(data); exif != nil {
+			applyTIFFTags(metadata, readTIFFTags(exif))
+		}
+	}
+
+	return metadata, nil
+}
+
+// TIFF/EXIF tags used for metadata extraction
+const (
+	tiffTagMake             = 0x010F
+	tiffTagModel            = 0x0110
+	tiffTagDateTime         = 0x0132
+	tiffTagExifIFD          = 0x8769
+	tiffTagDateTimeOriginal = 0x9003
+)
+
+func applyTIFFTags(metadata *storage.ImageMetadata, tags map[uint16]string) {
+	metadata.Manufacturer = tags[tiffTagMake]
+	metadata.DeviceModel = tags[tiffTagModel]
+
+	for _, tag := range []uint16{tiffTagDateTimeOriginal, tiffTagDateTime} {
+		if value := tags[tag]; len(value) >= 19 {
+			if t, err := time.Parse("2006:01:02 15:04:05", value[:19]); err == nil {
+				metadata.AcquisitionDate = &t
+				return
+			}
+		}
+	}
+}
+
+// readTIFFTags reads the ASCII tags of interest from IFD0 and the EXIF sub-IFD
+// of a TIFF structure (a TIFF file or the payload of a JPEG EXIF segment)
+func readTIFFTags(data []byte) map[uint16]string {
+	tags := make(map[uint16]string)
+	if len(data) < 8 {
+		return tags
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return tags
+	}
+
+	readIFD := func(offset int) int {
+		if offset <= 0 || offset+2 > len(data) {
+			return 0
+		}
+		subIFD := 0
+		entries := int(order.Uint16(data[offset:]))
+		for i := 0; i < entries; i++ {
+			entry := offset + 2 + i*12
+			if entry+12 > len(data) {
+				break
+			}
+			tag := order.Uint16(data[entry:])
+			fieldType := order.Uint16(data[entry+2:])
+			count := int(order.Uint32(data[entry+4:]))
+
+			switch {
+			case tag == tiffTagExifIFD:
+				subIFD = int(order.Uint32(data[entry+8:]))
+			case fieldType == 2 && count > 0: // ASCII
+				valueOffset := entry + 8
+				if count > 4 {
+					valueOffset = int(order.Uint32(data[entry+8:]))
+				}
+				if valueOffset+count <= len(data) {
+					tags[tag] = strings.TrimRight(string(data[valueOffset:valueOffset+count]), "\x00 ")
+				}
+			}
+		}
+		return subIFD
+	}
+
+	if exifIFD := readIFD(int(order.Uint32(data[4:8]))); exifIFD > 0 {
+		readIFD(exifIFD)
+	}
+	return tags
+}
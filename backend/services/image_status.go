@@ -0,0 +1,41 @@
+package services
+
+import (
+	"strings"
+
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+)
+
+// ApplyQualityAssessment records a new quality assessment and moves the image
+// through the lifecycle. Ungradable images are rejected. Gradable images that
+// were not yet checked become quality_checked, as do images rejected for poor
+// quality on an earlier assessment; rejections made for other reasons stand.
+func ApplyQualityAssessment(image *storage.RetinalImage, quality *storage.ImageQuality, changedBy uuid.UUID) error {
+	previous := image.Quality
+	image.Quality = quality
+	if err := storage.GlobalStorage.UpdateImage(image); err != nil {
+		return err
+	}
+
+	if !quality.Gradable {
+		return storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusRejected, changedBy, ungradableReason(quality))
+	}
+	switch image.Status {
+	case storage.ImageStatusUploaded:
+		return storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusQualityChecked, changedBy, "")
+	case storage.ImageStatusRejected:
+		if previous != nil && !previous.Gradable {
+			return storage.GlobalStorage.TransitionImageStatus(image, storage.ImageStatusQualityChecked, changedBy, "quality reassessed")
+		}
+	}
+	return nil
+}
+
+func ungradableReason(quality *storage.ImageQuality) string {
+	if len(quality.Issues) == 0 {
+		return "image quality insufficient for grading"
+	}
+	return "image quality insufficient for grading: " + strings.Join(quality.Issues, ", ")
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+)
+
+// ImageIngest describes an image to be added to a patient's record
+type ImageIngest struct {
+	PatientID       uuid.UUID
+	DoctorID        uuid.UUID
+	UploadedBy      uuid.UUID // user recorded as the author of the initial status
+	Data            []byte
+	FileName        string
+	ImageType       string
+	Notes           string
+	AcquisitionDate *time.Time      // overrides the date read from image metadata
+	Sanitized       *SanitizedImage // result of an earlier SanitizeImage call on Data, if any
+}
+
+// IngestImage sanitizes uploaded bytes, stores them content-addressed and
+// creates the image record with its quality assessment. If the patient already
+// has identical content, the existing image is returned with duplicate set.
+// Invalid images are reported as *ImageRejection.
+func IngestImage(in ImageIngest) (image *storage.RetinalImage, duplicate bool, err error) {
+	// Sniff the real format, fully decode and strip metadata before storing
+	sanitized := in.Sanitized
+	if sanitized == nil {
+		sanitized, err = SanitizeImage(in.Data, in.FileName)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if err := CheckLaterality(sanitized, in.ImageType); err != nil {
+		return nil, false, err
+	}
+	metadata := sanitized.Metadata
+	if in.AcquisitionDate != nil {
+		metadata.AcquisitionDate = in.AcquisitionDate
+	}
+
+	// Re-uploading identical content for the same patient returns the existing record
+	contentHash := ContentHash(sanitized.Data)
+	if existing, err := storage.GlobalStorage.FindPatientImageByHash(in.PatientID, contentHash); err == nil {
+		return existing, true, nil
+	}
+
+	// Store sanitized content addressed by its SHA-256
+	blob, err := StoreContent(sanitized.Data, sanitized.Extension)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to save file: %v", err)
+	}
+
+	// Create image record
+	image = &storage.RetinalImage{
+		PatientID:   in.PatientID,
+		DoctorID:    in.DoctorID,
+		FileName:    in.FileName,
+		FilePath:    blob.Key,
+		FileSize:    blob.Size,
+		ContentHash: blob.Hash,
+		ImageType:   in.ImageType,
+		UploadDate:  time.Now(),
+		Notes:       in.Notes,
+		Metadata:    metadata,
+	}
+	storage.InitImageStatus(image, in.UploadedBy)
+
+	if err := storage.GlobalStorage.CreateImage(image); err != nil {
+		ReleaseContent(blob.Hash)
+		return nil, false, fmt.Errorf("failed to save image record: %v", err)
+	}
+
+	// Assess image quality before it can be graded
+	if quality, err := AssessImageQuality(blob.Key); err == nil {
+		ApplyQualityAssessment(image, quality, uuid.Nil)
+	}
+
+	// Previews for list views are generated off the request path
+	GenerateDerivativesAsync(image.ID)
+
+	return image, false, nil
+}
+
+// CheckLaterality rejects images whose metadata names the other eye
+func CheckLaterality(sanitized *SanitizedImage, imageType string) error {
+	if laterality := sanitized.Metadata.Laterality; laterality != "" && laterality != imageType {
+		return &ImageRejection{
+			Code:           RejectLateralityMismatch,
+			Reason:         fmt.Sprintf("image laterality %s does not match image_type %s", laterality, imageType),
+			DetectedFormat: sanitized.Format,
+		}
+	}
+	return nil
+}
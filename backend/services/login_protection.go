@@ -0,0 +1,235 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+)
+
+// LoginThrottledError is returned while an account or client IP has to wait
+// before trying to sign in again
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool // locked out, rather than slowed down after a few failures
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return "too many failed sign-in attempts; try again later"
+	}
+	return "please wait before trying to sign in again"
+}
+
+// LoginFailureWindow returns how long failed logins are remembered
+func LoginFailureWindow() time.Duration {
+	return lockoutDuration(config.AppConfig.Lockout.FailureWindow, 15*time.Minute)
+}
+
+// LoginLockoutDuration returns how long an account or IP stays locked
+func LoginLockoutDuration() time.Duration {
+	return lockoutDuration(config.AppConfig.Lockout.LockoutDuration, 15*time.Minute)
+}
+
+// AccountEmailWindow returns how long requests for account emails are counted
+func AccountEmailWindow() time.Duration {
+	return lockoutDuration(config.AppConfig.Account.EmailWindow, time.Hour)
+}
+
+// LoginAttempt is a sign-in attempt that has passed the lockout checks. It
+// is counted as a failure from the start; Succeed or Release takes that back.
+type LoginAttempt struct {
+	email    string
+	ip       string
+	finished bool
+}
+
+// BeginLoginAttempt returns a *LoginThrottledError if the client IP or the
+// account is locked, or if either must wait after recent failures. Otherwise
+// the attempt is counted against both straight away, so that concurrent
+// attempts cannot all pass the check before any failure is recorded. Callers
+// should defer Release and call Fail or Succeed once the outcome is known.
+func BeginLoginAttempt(email, ip string) (*LoginAttempt, error) {
+	keys := []string{ipThrottleKey(ip), accountThrottleKey(email)}
+	retryAfter, locked := storage.GlobalStorage.BeginLoginAttempt(keys, time.Now(), LoginFailureWindow(), loginDelay)
+	if retryAfter > 0 {
+		return nil, &LoginThrottledError{RetryAfter: retryAfter, Locked: locked}
+	}
+	return &LoginAttempt{email: email, ip: ip}, nil
+}
+
+// Fail keeps the attempt counted as a failed password or MFA code, and locks
+// the account or client IP if it reached its threshold. Failures are counted
+// for unknown emails too, so responses do not reveal which exist.
+func (a *LoginAttempt) Fail() {
+	if a.finished {
+		return
+	}
+	a.finished = true
+
+	cfg := config.AppConfig.Lockout
+	now := time.Now()
+	lockout := LoginLockoutDuration()
+
+	throttle, locked := storage.GlobalStorage.LockLoginThrottle(accountThrottleKey(a.email), now, cfg.MaxAccountFailures, lockout)
+	if locked {
+		userID := uuid.Nil
+		if user, err := storage.GlobalStorage.GetUserByEmail(a.email); err == nil {
+			userID = user.ID
+		}
+		recordSecurityEvent(&storage.SecurityEvent{
+			Type:      storage.SecurityEventAccountLocked,
+			UserID:    userID,
+			Email:     a.email,
+			IPAddress: a.ip,
+			Details:   fmt.Sprintf("Locked until %s after %d failed sign-in attempts", throttle.LockedUntil.Format(time.RFC3339), throttle.Failures),
+		})
+	}
+
+	throttle, locked = storage.GlobalStorage.LockLoginThrottle(ipThrottleKey(a.ip), now, cfg.MaxIPFailures, lockout)
+	if locked {
+		recordSecurityEvent(&storage.SecurityEvent{
+			Type:      storage.SecurityEventIPLocked,
+			IPAddress: a.ip,
+			Details:   fmt.Sprintf("Locked until %s after %d failed sign-in attempts", throttle.LockedUntil.Format(time.RFC3339), throttle.Failures),
+		})
+	}
+}
+
+// Succeed clears the failure count of the account once a sign-in completes.
+// The IP count is kept apart from this attempt, since one IP may try many
+// accounts.
+func (a *LoginAttempt) Succeed() {
+	if a.finished {
+		return
+	}
+	a.finished = true
+	storage.GlobalStorage.ReleaseLoginAttempt(ipThrottleKey(a.ip))
+	RecordLoginSuccess(a.email)
+}
+
+// Release takes back an attempt that neither failed nor succeeded, such as a
+// correct password for a deactivated account
+func (a *LoginAttempt) Release() {
+	if a.finished {
+		return
+	}
+	a.finished = true
+	storage.GlobalStorage.ReleaseLoginAttempt(ipThrottleKey(a.ip))
+	storage.GlobalStorage.ReleaseLoginAttempt(accountThrottleKey(a.email))
+}
+
+// RecordLoginSuccess clears the failure count and any lock of an account
+func RecordLoginSuccess(email string) {
+	storage.GlobalStorage.ClearLoginThrottle(accountThrottleKey(email))
+}
+
+// AccountEmailThrottledError is returned when an address or client IP has
+// asked for too many verification or password reset emails
+type AccountEmailThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *AccountEmailThrottledError) Error() string {
+	return "too many email requests; try again later"
+}
+
+// AllowAccountEmail counts a request for a verification or password reset
+// email against the client IP and the address, and returns an
+// *AccountEmailThrottledError once either has used up its allowance for the
+// account email window. A refused request is counted against neither, so a
+// throttled address does not use up the IP's allowance. Requests for unknown
+// addresses are counted too, so responses do not reveal which are registered.
+func AllowAccountEmail(email, ip string) error {
+	cfg := config.AppConfig.Account
+	allowances := []storage.RequestAllowance{
+		{Key: "email-ip:" + ip, Max: cfg.MaxEmailsPerIP},
+		{Key: "email:" + strings.ToLower(strings.TrimSpace(email)), Max: cfg.MaxEmailsPerAddress},
+	}
+	if retryAfter := storage.GlobalStorage.TakeRequestAllowance(allowances, time.Now(), AccountEmailWindow()); retryAfter > 0 {
+		return &AccountEmailThrottledError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// GetLoginLockouts returns the accounts and IPs that are currently locked
+func GetLoginLockouts() []storage.LoginThrottle {
+	return storage.GlobalStorage.GetLockedLoginThrottles(time.Now())
+}
+
+// UnlockAccount lets an administrator lift an account lockout early. It
+// returns false if the account had no failed attempts recorded.
+func UnlockAccount(email string, unlockedBy uuid.UUID) bool {
+	if storage.GlobalStorage.ClearLoginThrottle(accountThrottleKey(email)) != nil {
+		return false
+	}
+	userID := uuid.Nil
+	if user, err := storage.GlobalStorage.GetUserByEmail(email); err == nil {
+		userID = user.ID
+	}
+	recordSecurityEvent(&storage.SecurityEvent{
+		Type:    storage.SecurityEventAccountUnlocked,
+		UserID:  userID,
+		ActorID: unlockedBy,
+		Email:   email,
+		Details: "Sign-in lockout cleared by an administrator",
+	})
+	return true
+}
+
+// UnlockIP lets an administrator lift a client IP lockout early
+func UnlockIP(ip string, unlockedBy uuid.UUID) bool {
+	if storage.GlobalStorage.ClearLoginThrottle(ipThrottleKey(ip)) != nil {
+		return false
+	}
+	recordSecurityEvent(&storage.SecurityEvent{
+		Type:      storage.SecurityEventIPUnlocked,
+		ActorID:   unlockedBy,
+		IPAddress: ip,
+		Details:   "Sign-in lockout cleared by an administrator",
+	})
+	return true
+}
+
+// recordSecurityEvent stores an event and echoes it to the server log
+func recordSecurityEvent(event *storage.SecurityEvent) {
+	storage.GlobalStorage.CreateSecurityEvent(event)
+	log.Printf("🛡️  Security event %s: user=%s email=%s ip=%s %s", event.Type, event.UserID, event.Email, event.IPAddress, event.Details)
+}
+
+// loginDelay is the wait required after the given number of failures: none
+// after the first, then LOGIN_DELAY_BASE doubling up to LOGIN_DELAY_MAX
+func loginDelay(failures int) time.Duration {
+	if failures < 2 {
+		return 0
+	}
+	base := lockoutDuration(config.AppConfig.Lockout.DelayBase, time.Second)
+	limit := lockoutDuration(config.AppConfig.Lockout.DelayMax, 30*time.Second)
+
+	delay := base
+	for i := 2; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+func lockoutDuration(value string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return fallback
+	}
+	return duration
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentLoginAttemptsAreThrottled(t *testing.T) {
+	tests := []struct {
+		name    string
+		account func(t *testing.T, i int) (email, ip string)
+	}{
+		{
+			name: "one account from many IPs",
+			account: func(t *testing.T, i int) (string, string) {
+				return t.Name() + "@example.com", fmt.Sprintf("203.0.113.%d", i+1)
+			},
+		},
+		{
+			name: "many accounts from one IP",
+			account: func(t *testing.T, i int) (string, string) {
+				return fmt.Sprintf("%s-%d@example.com", t.Name(), i), "203.0.113.99"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const attempts = 10
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			allowed := 0
+			for i := 0; i < attempts; i++ {
+				email, ip := tt.account(t, i)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					attempt, err := BeginLoginAttempt(email, ip)
+					var throttled *LoginThrottledError
+					if errors.As(err, &throttled) {
+						return
+					}
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+					attempt.Fail()
+				}()
+			}
+			wg.Wait()
+
+			// The delay starts after the second failure
+			if allowed != 2 {
+				t.Errorf("%d of %d concurrent attempts allowed, want 2", allowed, attempts)
+			}
+		})
+	}
+}
+
+func TestReleasedLoginAttemptsAreNotFailures(t *testing.T) {
+	email, ip := t.Name()+"@example.com", "203.0.113.200"
+	for i := 0; i < 10; i++ {
+		attempt, err := BeginLoginAttempt(email, ip)
+		if err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+		if i%2 == 0 {
+			attempt.Release()
+		} else {
+			attempt.Succeed()
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		attempt, err := BeginLoginAttempt(email, ip)
+		if err != nil {
+			t.Fatalf("failure %d: %v", i+1, err)
+		}
+		attempt.Fail()
+		attempt.Release()
+	}
+	var throttled *LoginThrottledError
+	if _, err := BeginLoginAttempt(email, ip); !errors.As(err, &throttled) || throttled.Locked {
+		t.Errorf("err = %v after two failures, want a delay", err)
+	}
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/storage"
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("multi-factor authentication is already enabled")
+	ErrMFANotEnrolled      = errors.New("multi-factor authentication is not enabled")
+	ErrMFACodeInvalid      = errors.New("invalid authentication code")
+	ErrMFARequired         = errors.New("multi-factor authentication is required for your role and cannot be disabled")
+	ErrMFAChallengeInvalid = errors.New("invalid or expired MFA challenge; sign in again")
+)
+
+// maxMFAChallengeAttempts is how many wrong codes a login challenge accepts
+// before the password has to be entered again
+const maxMFAChallengeAttempts = 5
+
+// MFASetup is what a user needs to add the account to an authenticator app
+type MFASetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// MFAChallengeExpiry returns how long a login may wait for its second factor
+func MFAChallengeExpiry() time.Duration {
+	expiry, err := time.ParseDuration(config.AppConfig.MFA.ChallengeExpiry)
+	if err != nil || expiry <= 0 {
+		return 5 * time.Minute
+	}
+	return expiry
+}
+
+// MFARequired reports whether the user's role must use multi-factor
+// authentication
+func MFARequired(user *storage.User) bool {
+	for _, role := range config.AppConfig.MFA.RequiredRoles {
+		if role == user.Role {
+			return true
+		}
+	}
+	return false
+}
+
+// BeginMFAEnrollment creates a new TOTP secret for the user. It only takes
+// effect once confirmed with a code from the authenticator app.
+func BeginMFAEnrollment(user *storage.User) (*MFASetup, error) {
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.GlobalStorage.SaveMFAEnrollment(&storage.MFAEnrollment{
+		UserID: user.ID,
+		Secret: secret,
+	}); err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			return nil, ErrMFAAlreadyEnabled
+		}
+		return nil, err
+	}
+
+	return &MFASetup{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(config.AppConfig.MFA.Issuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmMFAEnrollment enables MFA once the user enters a valid code for the
+// pending secret. It returns the backup codes, which are shown only once.
+func ConfirmMFAEnrollment(user *storage.User, code string) ([]string, error) {
+	enrollment, err := storage.GlobalStorage.GetMFAEnrollment(user.ID)
+	if err != nil {
+		return nil, ErrMFANotEnrolled
+	}
+	if enrollment.EnabledAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if err := verifyTOTP(enrollment, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateBackupCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.GlobalStorage.EnableMFA(user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableMFA turns off MFA after checking a current code. Users whose role
+// requires MFA cannot disable it.
+func DisableMFA(user *storage.User, code string) error {
+	if MFARequired(user) {
+		return ErrMFARequired
+	}
+	enrollment, err := enabledMFA(user)
+	if err != nil {
+		return err
+	}
+	if err := verifyMFACode(enrollment, code); err != nil {
+		return err
+	}
+	return storage.GlobalStorage.DeleteMFAEnrollment(user.ID)
+}
+
+// RegenerateBackupCodes replaces all backup codes after checking a current
+// code, invalidating any that were written down before
+func RegenerateBackupCodes(user *storage.User, code string) ([]string, error) {
+	enrollment, err := enabledMFA(user)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyMFACode(enrollment, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateBackupCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.GlobalStorage.ReplaceBackupCodes(user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RemainingBackupCodes returns how many unused backup codes the user has
+func RemainingBackupCodes(user *storage.User) int {
+	enrollment, err := enabledMFA(user)
+	if err != nil {
+		return 0
+	}
+	return len(enrollment.BackupCodes)
+}
+
+// StartMFAChallenge is called after a successful password check for a user
+// with MFA enabled. The returned token stands in for the password while the
+// user enters a code.
+func StartMFAChallenge(user *storage.User) (string, time.Time, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	expiresAt := time.Now().Add(MFAChallengeExpiry())
+
+	if err := storage.GlobalStorage.CreateMFAChallenge(&storage.MFAChallenge{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// MFAChallengeUser returns the user a pending login challenge belongs to
+func MFAChallengeUser(challengeToken string) (*storage.User, error) {
+	challenge, err := storage.GlobalStorage.GetMFAChallenge(hashToken(challengeToken))
+	if err != nil || time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrMFAChallengeInvalid
+	}
+	user, err := storage.GlobalStorage.GetUserByID(challenge.UserID)
+	if err != nil {
+		return nil, ErrMFAChallengeInvalid
+	}
+	return user, nil
+}
+
+// CompleteMFAChallenge checks a TOTP or backup code for a login challenge
+// and returns the user to issue tokens for. Challenges are single-use and
+// are dropped after too many wrong codes.
+func CompleteMFAChallenge(challengeToken, code string) (*storage.User, error) {
+	user, err := MFAChallengeUser(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+	hash := hashToken(challengeToken)
+	enrollment, err := enabledMFA(user)
+	if err != nil {
+		storage.GlobalStorage.DeleteMFAChallenge(hash)
+		return nil, ErrMFAChallengeInvalid
+	}
+
+	if err := verifyMFACode(enrollment, code); err != nil {
+		if storage.GlobalStorage.RecordMFAChallengeFailure(hash) >= maxMFAChallengeAttempts {
+			storage.GlobalStorage.DeleteMFAChallenge(hash)
+			return nil, ErrMFAChallengeInvalid
+		}
+		return nil, err
+	}
+
+	storage.GlobalStorage.DeleteMFAChallenge(hash)
+	return user, nil
+}
+
+func enabledMFA(user *storage.User) (*storage.MFAEnrollment, error) {
+	enrollment, err := storage.GlobalStorage.GetMFAEnrollment(user.ID)
+	if err != nil || enrollment.EnabledAt == nil {
+		return nil, ErrMFANotEnrolled
+	}
+	return enrollment, nil
+}
+
+// verifyMFACode accepts either a TOTP code or one of the backup codes
+func verifyMFACode(enrollment *storage.MFAEnrollment, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		return verifyTOTP(enrollment, code)
+	}
+	if err := storage.GlobalStorage.ConsumeBackupCode(enrollment.UserID, hashToken(normalizeBackupCode(code))); err != nil {
+		return ErrMFACodeInvalid
+	}
+	return nil
+}
+
+// verifyTOTP checks a TOTP code and rejects replays of an accepted code
+func verifyTOTP(enrollment *storage.MFAEnrollment, code string) error {
+	step, ok := validateTOTP(enrollment.Secret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return ErrMFACodeInvalid
+	}
+	if err := storage.GlobalStorage.UseMFAStep(enrollment.UserID, step); err != nil {
+		return ErrMFACodeInvalid
+	}
+	return nil
+}
+
+// generateBackupCodes returns readable one-time codes and the hashes to store
+func generateBackupCodes() ([]string, []string, error) {
+	count := config.AppConfig.MFA.BackupCodeCount
+	if count <= 0 {
+		count = 10
+	}
+
+	codes := make([]string, 0, count)
+	hashes := make([]string, 0, count)
+	for len(codes) < count {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(raw))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeBackupCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, "-", ""))
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"dr-mario-backend/storage"
+)
+
+// currentTOTP returns the code for the secret at the current time step
+func currentTOTP(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, time.Now().Unix()/int64(totpPeriod.Seconds()))
+}
+
+// enrolledUser stores a patient, enrolls them in MFA and returns the TOTP
+// code that confirmed the enrollment and the backup codes
+func enrolledUser(t *testing.T) (*storage.User, string, []string) {
+	t.Helper()
+	user := &storage.User{Email: t.Name() + "@example.com", Role: "patient"}
+	if err := storage.GlobalStorage.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	setup, err := BeginMFAEnrollment(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := currentTOTP(t, setup.Secret)
+	backupCodes, err := ConfirmMFAEnrollment(user, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, code, backupCodes
+}
+
+func TestMFACodesAreSingleUse(t *testing.T) {
+	user, enrollmentCode, backupCodes := enrolledUser(t)
+	if !user.MFAEnabled {
+		t.Fatal("MFA not enabled after confirmation")
+	}
+	enrollment, err := enabledMFA(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		code string
+		want error
+	}{
+		{"TOTP code used at enrollment", enrollmentCode, ErrMFACodeInvalid},
+		{"backup code", backupCodes[0], nil},
+		{"backup code again", backupCodes[0], ErrMFACodeInvalid},
+		{"backup code without dash in upper case", " " + strings.ToUpper(strings.ReplaceAll(backupCodes[1], "-", "")) + " ", nil},
+		{"unknown backup code", "aaaa-aaaa", ErrMFACodeInvalid},
+		{"empty", "", ErrMFACodeInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyMFACode(enrollment, tt.code); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if got, want := RemainingBackupCodes(user), len(backupCodes)-2; got != want {
+		t.Errorf("%d backup codes remaining, want %d", got, want)
+	}
+}
+
+func TestMFAChallengeIsDroppedAfterTooManyWrongCodes(t *testing.T) {
+	user, _, backupCodes := enrolledUser(t)
+
+	token, _, err := StartMFAChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < maxMFAChallengeAttempts; i++ {
+		if _, err := CompleteMFAChallenge(token, "aaaa-aaaa"); !errors.Is(err, ErrMFACodeInvalid) {
+			t.Fatalf("wrong code %d: err = %v", i, err)
+		}
+	}
+	if _, err := CompleteMFAChallenge(token, "aaaa-aaaa"); !errors.Is(err, ErrMFAChallengeInvalid) {
+		t.Fatalf("last wrong code: err = %v, want the challenge to be dropped", err)
+	}
+	if _, err := CompleteMFAChallenge(token, backupCodes[0]); !errors.Is(err, ErrMFAChallengeInvalid) {
+		t.Errorf("valid code on a dropped challenge: err = %v", err)
+	}
+
+	// A fresh challenge is single-use
+	token, _, err = StartMFAChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed, err := CompleteMFAChallenge(token, backupCodes[0]); err != nil || completed.ID != user.ID {
+		t.Fatalf("valid code: user = %v, err = %v", completed, err)
+	}
+	if _, err := CompleteMFAChallenge(token, backupCodes[1]); !errors.Is(err, ErrMFAChallengeInvalid) {
+		t.Errorf("reused challenge: err = %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+)
+
+// Permissions granted to roles in config.AppConfig.RBAC. Scoped permissions
+// are granted as <permission>:<scope>.
+const (
+	PermPatientProfile     = "patients:profile"
+	PermDoctorProfile      = "doctors:profile"
+	PermPatientsList       = "patients:list"
+	PermPatientsRead       = "patients:read"
+	PermPatientsRefer      = "patients:refer"
+	PermPatientsBreakGlass = "patients:break_glass"
+	PermImagesRead         = "images:read"
+	PermImagesUpload       = "images:upload"
+	PermImagesDelete       = "images:delete"
+	PermImagesAnalyze      = "images:analyze"
+	PermImagesImport       = "images:import"
+	PermImportsRead        = "imports:read"
+	PermAnnotationsWrite   = "annotations:write"
+	PermAnnotationsDelete  = "annotations:delete"
+	PermResultsReview      = "results:review"
+	PermAnalyticsRead      = "analytics:read"
+	PermAppointmentsManage = "appointments:manage"
+	PermCareTeamRead       = "care_team:read"
+	PermCareTeamAssign     = "care_team:assign"
+	PermCareTeamEnd        = "care_team:end"
+	PermDoctorsVerify      = "doctors:verify"
+	PermUsersManage        = "users:manage"
+	PermSecurityManage     = "security:manage"
+	PermRetentionManage    = "retention:manage"
+)
+
+// Permission scopes: the patient's own records, the patients in the doctor's
+// care, or everyone. For appointments, own and care are the appointments the
+// user attends as patient or doctor; for care relationships, own is the
+// user's relationships.
+const (
+	ScopeOwn  = "own"
+	ScopeCare = "care"
+	ScopeAny  = "any"
+)
+
+// permissionScopes lists every permission with the scopes it can be granted
+// at; nil means the permission is not scoped
+var permissionScopes = map[string][]string{
+	PermPatientProfile:     nil,
+	PermDoctorProfile:      nil,
+	PermPatientsList:       nil,
+	PermPatientsRead:       {ScopeOwn, ScopeCare, ScopeAny},
+	PermPatientsRefer:      nil,
+	PermPatientsBreakGlass: nil,
+	PermImagesRead:         {ScopeOwn, ScopeCare, ScopeAny},
+	PermImagesUpload:       {ScopeOwn, ScopeCare, ScopeAny},
+	PermImagesDelete:       {ScopeOwn, ScopeCare, ScopeAny},
+	PermImagesAnalyze:      {ScopeOwn, ScopeCare, ScopeAny},
+	PermImagesImport:       nil,
+	PermImportsRead:        {ScopeAny},
+	PermAnnotationsWrite:   {ScopeOwn, ScopeCare, ScopeAny},
+	PermAnnotationsDelete:  {ScopeAny},
+	PermResultsReview:      {ScopeOwn, ScopeCare, ScopeAny},
+	PermAnalyticsRead:      {ScopeOwn, ScopeCare, ScopeAny},
+	PermAppointmentsManage: {ScopeOwn, ScopeCare, ScopeAny},
+	PermCareTeamRead:       {ScopeOwn, ScopeAny},
+	PermCareTeamAssign:     nil,
+	PermCareTeamEnd:        {ScopeOwn, ScopeAny},
+	PermDoctorsVerify:      nil,
+	PermUsersManage:        nil,
+	PermSecurityManage:     nil,
+	PermRetentionManage:    nil,
+}
+
+// emergencyPermissions are the care-scoped permissions that emergency access
+// grants: reading the patient's records, but not changing them
+var emergencyPermissions = map[string]bool{
+	PermPatientsRead:  true,
+	PermImagesRead:    true,
+	PermAnalyticsRead: true,
+}
+
+// ValidatePermissions rejects role permissions that are not known, so that a
+// typo in RBAC_<ROLE>_PERMISSIONS fails at startup instead of denying access
+func ValidatePermissions() error {
+	for role, granted := range config.AppConfig.RBAC.RolePermissions {
+		for _, permission := range granted {
+			if !knownPermission(permission) {
+				return fmt.Errorf("unknown permission %q for role %s", permission, role)
+			}
+		}
+	}
+	return nil
+}
+
+func knownPermission(permission string) bool {
+	if scopes, exists := permissionScopes[permission]; exists {
+		return scopes == nil
+	}
+	i := strings.LastIndex(permission, ":")
+	if i < 0 {
+		return false
+	}
+	for _, scope := range permissionScopes[permission[:i]] {
+		if scope == permission[i+1:] {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions returns the permissions granted to the user's role, sorted
+func Permissions(user *storage.User) []string {
+	permissions := append([]string{}, config.AppConfig.RBAC.RolePermissions[user.Role]...)
+	sort.Strings(permissions)
+	return permissions
+}
+
+// HasPermission reports whether the user's role grants the permission at any
+// scope
+func HasPermission(user *storage.User, permission string) bool {
+	for _, granted := range config.AppConfig.RBAC.RolePermissions[user.Role] {
+		if granted == permission || strings.HasPrefix(granted, permission+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the user's role grants the permission at the
+// given scope
+func HasScope(user *storage.User, permission, scope string) bool {
+	for _, granted := range config.AppConfig.RBAC.RolePermissions[user.Role] {
+		if granted == permission+":"+scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize reports whether the user holds the permission for the patient's
+// records: at any scope, for a patient in their care, or for their own
+// patient profile
+func Authorize(user *storage.User, permission string, patientID uuid.UUID) bool {
+	return AuthorizedScope(user, permission, patientID) != ""
+}
+
+// AuthorizedScope returns the widest scope at which the user holds the
+// permission for the patient's records, or "" if they do not hold it.
+// Emergency access only grants the care scope of emergencyPermissions.
+func AuthorizedScope(user *storage.User, permission string, patientID uuid.UUID) string {
+	if HasScope(user, permission, ScopeAny) {
+		return ScopeAny
+	}
+	if HasScope(user, permission, ScopeCare) {
+		if doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID); err == nil {
+			relationship := storage.GlobalStorage.FindActiveCareRelationship(doctor.ID, patientID, time.Now())
+			if relationship != nil && (relationship.Source != storage.CareSourceEmergency || emergencyPermissions[permission]) {
+				return ScopeCare
+			}
+		}
+	}
+	if HasScope(user, permission, ScopeOwn) {
+		if patient, err := storage.GlobalStorage.GetPatientByUserID(user.ID); err == nil && patient.ID == patientID {
+			return ScopeOwn
+		}
+	}
+	return ""
+}
+
+// AuthorizedPatientIDs returns the patients whose records the user holds the
+// permission for, or all=true when it is granted for every patient
+func AuthorizedPatientIDs(user *storage.User, permission string) (patientIDs []uuid.UUID, all bool) {
+	if HasScope(user, permission, ScopeAny) {
+		return nil, true
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	add := func(id uuid.UUID) {
+		if !seen[id] {
+			seen[id] = true
+			patientIDs = append(patientIDs, id)
+		}
+	}
+	if HasScope(user, permission, ScopeOwn) {
+		if patient, err := storage.GlobalStorage.GetPatientByUserID(user.ID); err == nil {
+			add(patient.ID)
+		}
+	}
+	if HasScope(user, permission, ScopeCare) {
+		if doctor, err := storage.GlobalStorage.GetDoctorByUserID(user.ID); err == nil {
+			relationships := storage.GlobalStorage.GetCareRelationships(storage.CareRelationshipFilter{
+				DoctorID: doctor.ID,
+				ActiveAt: time.Now(),
+			})
+			for _, relationship := range relationships {
+				if relationship.Source != storage.CareSourceEmergency || emergencyPermissions[permission] {
+					add(relationship.PatientID)
+				}
+			}
+		}
+	}
+	return patientIDs, false
+}
@@ -0,0 +1,428 @@
+package services
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"dr-mario-backend/config"
+)
+
+// planarImage stores an image as float planes in the 0-255 range.
+// Grayscale images have one plane, colour images three (R, G, B).
+type planarImage struct {
+	width  int
+	height int
+	planes [][]float64
+}
+
+func newPlanarImage(width, height, channels int) *planarImage {
+	p := &planarImage{width: width, height: height, planes: make([][]float64, channels)}
+	for i := range p.planes {
+		p.planes[i] = make([]float64, width*height)
+	}
+	return p
+}
+
+func toPlanar(img image.Image) *planarImage {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if gray, ok := img.(*image.Gray); ok {
+		p := newPlanarImage(width, height, 1)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				p.planes[0][y*width+x] = float64(gray.GrayAt(bounds.Min.X+x, bounds.Min.Y+y).Y)
+			}
+		}
+		return p
+	}
+
+	p := newPlanarImage(width, height, 3)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			i := y*width + x
+			p.planes[0][i] = float64(r >> 8)
+			p.planes[1][i] = float64(g >> 8)
+			p.planes[2][i] = float64(b >> 8)
+		}
+	}
+	return p
+}
+
+func (p *planarImage) toImage() image.Image {
+	if len(p.planes) == 1 {
+		gray := image.NewGray(image.Rect(0, 0, p.width, p.height))
+		for i, v := range p.planes[0] {
+			gray.Pix[i] = clampByte(v)
+		}
+		return gray
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, p.width, p.height))
+	for i := 0; i < p.width*p.height; i++ {
+		out.Pix[i*4] = clampByte(p.planes[0][i])
+		out.Pix[i*4+1] = clampByte(p.planes[1][i])
+		out.Pix[i*4+2] = clampByte(p.planes[2][i])
+		out.Pix[i*4+3] = 255
+	}
+	return out
+}
+
+// luminance returns the per-pixel brightness used for masking
+func (p *planarImage) luminance() []float64 {
+	if len(p.planes) == 1 {
+		return p.planes[0]
+	}
+	lum := make([]float64, p.width*p.height)
+	for i := range lum {
+		lum[i] = 0.299*p.planes[0][i] + 0.587*p.planes[1][i] + 0.114*p.planes[2][i]
+	}
+	return lum
+}
+
+func clampByte(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
+
+// preprocessStep is a single composable preprocessing operation
+type preprocessStep interface {
+	// Name identifies the step and its parameters for reproducibility
+	Name() string
+	Apply(img *planarImage) (*planarImage, error)
+}
+
+// PreprocessPipeline applies a sequence of preprocessing steps to fundus images
+type PreprocessPipeline struct {
+	steps []preprocessStep
+}
+
+// NewPreprocessPipeline builds a pipeline from step names and configuration
+func NewPreprocessPipeline(names []string, cfg config.PreprocessConfig) (*PreprocessPipeline, error) {
+	pipeline := &PreprocessPipeline{}
+	for _, name := range names {
+		switch name {
+		case "circular_crop":
+			pipeline.steps = append(pipeline.steps, circularCropStep{})
+		case "resize":
+			if cfg.InputSize <= 0 {
+				return nil, fmt.Errorf("invalid model input size: %d", cfg.InputSize)
+			}
+			pipeline.steps = append(pipeline.steps, resizeStep{size: cfg.InputSize})
+		case "green_channel":
+			pipeline.steps = append(pipeline.steps, greenChannelStep{})
+		case "clahe":
+			if cfg.CLAHETiles <= 0 || cfg.CLAHEClipLimit <= 0 {
+				return nil, fmt.Errorf("invalid CLAHE parameters")
+			}
+			pipeline.steps = append(pipeline.steps, claheStep{clipLimit: cfg.CLAHEClipLimit, tiles: cfg.CLAHETiles})
+		case "ben_graham":
+			if cfg.BenGrahamSigma <= 0 {
+				return nil, fmt.Errorf("invalid Ben Graham sigma: %v", cfg.BenGrahamSigma)
+			}
+			pipeline.steps = append(pipeline.steps, benGrahamStep{sigma: cfg.BenGrahamSigma})
+		default:
+			return nil, fmt.Errorf("unknown preprocessing step: %s", name)
+		}
+	}
+	return pipeline, nil
+}
+
+// NewDefaultPreprocessPipeline builds the pipeline configured in the environment
+func NewDefaultPreprocessPipeline() (*PreprocessPipeline, error) {
+	cfg := config.AppConfig.Preprocess
+	return NewPreprocessPipeline(cfg.Steps, cfg)
+}
+
+// ConfiguredPreprocessingSteps returns the step names of the configured
+// pipeline, or nil if the configuration is invalid
+func ConfiguredPreprocessingSteps() []string {
+	pipeline, err := NewDefaultPreprocessPipeline()
+	if err != nil {
+		return nil
+	}
+	return pipeline.StepNames()
+}
+
+// StepNames returns the applied steps with their parameters
+func (p *PreprocessPipeline) StepNames() []string {
+	names := make([]string, 0, len(p.steps))
+	for _, step := range p.steps {
+		names = append(names, step.Name())
+	}
+	return names
+}
+
+// Run applies all steps in order
+func (p *PreprocessPipeline) Run(img image.Image) (image.Image, error) {
+	planar := toPlanar(img)
+	for _, step := range p.steps {
+		var err error
+		planar, err = step.Apply(planar)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", step.Name(), err)
+		}
+	}
+	return planar.toImage(), nil
+}
+
+// circularCropStep crops to the bounding square of the retina and blacks out
+// everything outside the inscribed circle
+type circularCropStep struct{}
+
+func (circularCropStep) Name() string { return "circular_crop" }
+
+func (circularCropStep) Apply(img *planarImage) (*planarImage, error) {
+	lum := img.luminance()
+	minX, minY, maxX, maxY := img.width, img.height, -1, -1
+	for y := 0; y < img.height; y++ {
+		for x := 0; x < img.width; x++ {
+			if lum[y*img.width+x] > fundusMaskThreshold {
+				minX = int(math.Min(float64(minX), float64(x)))
+				minY = int(math.Min(float64(minY), float64(y)))
+				maxX = int(math.Max(float64(maxX), float64(x)))
+				maxY = int(math.Max(float64(maxY), float64(y)))
+			}
+		}
+	}
+	if maxX < 0 {
+		return nil, fmt.Errorf("no retina region found")
+	}
+
+	// Square canvas centred on the retina bounding box
+	side := int(math.Max(float64(maxX-minX+1), float64(maxY-minY+1)))
+	cx := float64(minX+maxX+1) / 2
+	cy := float64(minY+maxY+1) / 2
+	originX := int(math.Round(cx - float64(side)/2))
+	originY := int(math.Round(cy - float64(side)/2))
+	radius := float64(side) / 2
+
+	out := newPlanarImage(side, side, len(img.planes))
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			dx, dy := float64(x)+0.5-radius, float64(y)+0.5-radius
+			if dx*dx+dy*dy > radius*radius {
+				continue
+			}
+			sx, sy := originX+x, originY+y
+			if sx < 0 || sy < 0 || sx >= img.width || sy >= img.height {
+				continue
+			}
+			for c := range img.planes {
+				out.planes[c][y*side+x] = img.planes[c][sy*img.width+sx]
+			}
+		}
+	}
+	return out, nil
+}
+
+// resizeStep resizes to the square model input size using bilinear interpolation
+type resizeStep struct {
+	size int
+}
+
+func (s resizeStep) Name() string { return fmt.Sprintf("resize:%dx%d", s.size, s.size) }
+
+func (s resizeStep) Apply(img *planarImage) (*planarImage, error) {
+	out := newPlanarImage(s.size, s.size, len(img.planes))
+	scaleX := float64(img.width) / float64(s.size)
+	scaleY := float64(img.height) / float64(s.size)
+
+	for y := 0; y < s.size; y++ {
+		fy := math.Max(0, (float64(y)+0.5)*scaleY-0.5)
+		y0 := int(fy)
+		y1 := int(math.Min(float64(y0+1), float64(img.height-1)))
+		wy := fy - float64(y0)
+		for x := 0; x < s.size; x++ {
+			fx := math.Max(0, (float64(x)+0.5)*scaleX-0.5)
+			x0 := int(fx)
+			x1 := int(math.Min(float64(x0+1), float64(img.width-1)))
+			wx := fx - float64(x0)
+			for c, plane := range img.planes {
+				top := plane[y0*img.width+x0]*(1-wx) + plane[y0*img.width+x1]*wx
+				bottom := plane[y1*img.width+x0]*(1-wx) + plane[y1*img.width+x1]*wx
+				out.planes[c][y*s.size+x] = top*(1-wy) + bottom*wy
+			}
+		}
+	}
+	return out, nil
+}
+
+// greenChannelStep keeps only the green channel, which carries most lesion contrast
+type greenChannelStep struct{}
+
+func (greenChannelStep) Name() string { return "green_channel" }
+
+func (greenChannelStep) Apply(img *planarImage) (*planarImage, error) {
+	if len(img.planes) == 1 {
+		return img, nil
+	}
+	out := newPlanarImage(img.width, img.height, 1)
+	copy(out.planes[0], img.planes[1])
+	return out, nil
+}
+
+// claheStep applies contrast-limited adaptive histogram equalization.
+// Colour images are equalized on luminance so hue is preserved.
+type claheStep struct {
+	clipLimit float64
+	tiles     int
+}
+
+func (s claheStep) Name() string {
+	return fmt.Sprintf("clahe:clip=%.2f,tiles=%dx%d", s.clipLimit, s.tiles, s.tiles)
+}
+
+func (s claheStep) Apply(img *planarImage) (*planarImage, error) {
+	if len(img.planes) == 1 {
+		out := newPlanarImage(img.width, img.height, 1)
+		out.planes[0] = clahe(img.planes[0], img.width, img.height, s.tiles, s.clipLimit)
+		return out, nil
+	}
+
+	lum := img.luminance()
+	equalized := clahe(lum, img.width, img.height, s.tiles, s.clipLimit)
+
+	out := newPlanarImage(img.width, img.height, 3)
+	for i := range lum {
+		// Shift each channel by the luminance change
+		delta := equalized[i] - lum[i]
+		for c := range img.planes {
+			out.planes[c][i] = img.planes[c][i] + delta
+		}
+	}
+	return out, nil
+}
+
+func clahe(plane []float64, width, height, tiles int, clipLimit float64) []float64 {
+	tileW := int(math.Ceil(float64(width) / float64(tiles)))
+	tileH := int(math.Ceil(float64(height) / float64(tiles)))
+
+	// Build a clipped, equalized lookup table per tile
+	luts := make([][256]float64, tiles*tiles)
+	for ty := 0; ty < tiles; ty++ {
+		for tx := 0; tx < tiles; tx++ {
+			var hist [256]float64
+			n := 0
+			for y := ty * tileH; y < (ty+1)*tileH && y < height; y++ {
+				for x := tx * tileW; x < (tx+1)*tileW && x < width; x++ {
+					hist[clampByte(plane[y*width+x])]++
+					n++
+				}
+			}
+			if n == 0 {
+				for i := range luts[ty*tiles+tx] {
+					luts[ty*tiles+tx][i] = float64(i)
+				}
+				continue
+			}
+
+			limit := math.Max(1, clipLimit*float64(n)/256)
+			excess := 0.0
+			for i := range hist {
+				if hist[i] > limit {
+					excess += hist[i] - limit
+					hist[i] = limit
+				}
+			}
+			for i := range hist {
+				hist[i] += excess / 256
+			}
+
+			cdf := 0.0
+			for i := range hist {
+				cdf += hist[i]
+				luts[ty*tiles+tx][i] = cdf / float64(n) * 255
+			}
+		}
+	}
+
+	// Bilinear interpolation between the four nearest tile mappings
+	out := make([]float64, len(plane))
+	for y := 0; y < height; y++ {
+		gy := (float64(y)+0.5)/float64(tileH) - 0.5
+		ty0 := int(math.Max(0, math.Floor(gy)))
+		ty1 := int(math.Min(float64(tiles-1), float64(ty0+1)))
+		wy := math.Max(0, math.Min(1, gy-float64(ty0)))
+		for x := 0; x < width; x++ {
+			gx := (float64(x)+0.5)/float64(tileW) - 0.5
+			tx0 := int(math.Max(0, math.Floor(gx)))
+			tx1 := int(math.Min(float64(tiles-1), float64(tx0+1)))
+			wx := math.Max(0, math.Min(1, gx-float64(tx0)))
+
+			v := clampByte(plane[y*width+x])
+			top := luts[ty0*tiles+tx0][v]*(1-wx) + luts[ty0*tiles+tx1][v]*wx
+			bottom := luts[ty1*tiles+tx0][v]*(1-wx) + luts[ty1*tiles+tx1][v]*wx
+			out[y*width+x] = top*(1-wy) + bottom*wy
+		}
+	}
+	return out
+}
+
+// benGrahamStep subtracts the local average colour (Kaggle DR winner normalization):
+// out = 4*img - 4*GaussianBlur(img, sigma) + 128
+type benGrahamStep struct {
+	sigma float64
+}
+
+func (s benGrahamStep) Name() string { return fmt.Sprintf("ben_graham:sigma=%.1f", s.sigma) }
+
+func (s benGrahamStep) Apply(img *planarImage) (*planarImage, error) {
+	out := newPlanarImage(img.width, img.height, len(img.planes))
+	for c, plane := range img.planes {
+		blurred := gaussianBlur(plane, img.width, img.height, s.sigma)
+		for i := range plane {
+			out.planes[c][i] = 4*plane[i] - 4*blurred[i] + 128
+		}
+	}
+	return out, nil
+}
+
+// gaussianBlur applies a separable Gaussian filter with edge clamping
+func gaussianBlur(plane []float64, width, height int, sigma float64) []float64 {
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	clampIndex := func(v, max int) int {
+		if v < 0 {
+			return 0
+		}
+		if v >= max {
+			return max - 1
+		}
+		return v
+	}
+
+	tmp := make([]float64, len(plane))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			acc := 0.0
+			for k, w := range kernel {
+				acc += w * plane[y*width+clampIndex(x+k-radius, width)]
+			}
+			tmp[y*width+x] = acc
+		}
+	}
+
+	out := make([]float64, len(plane))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			acc := 0.0
+			for k, w := range kernel {
+				acc += w * tmp[clampIndex(y+k-radius, height)*width+x]
+			}
+			out[y*width+x] = acc
+		}
+	}
+	return out
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/storage"
+)
+
+const (
+	// qualityAnalysisSize is the longest side images are downscaled to before assessment
+	qualityAnalysisSize = 512
+	// fundusMaskThreshold separates the retina from the dark camera background (0-255)
+	fundusMaskThreshold = 20
+	// illuminationGrid is the number of blocks per side used for uniformity
+	illuminationGrid = 8
+)
+
+// channelImage holds a single image channel as floats in the 0-255 range
+type channelImage struct {
+	width  int
+	height int
+	pix    []float64
+}
+
+func (c *channelImage) at(x, y int) float64 {
+	return c.pix[y*c.width+x]
+}
+
+// AssessImageQuality computes blur, illumination, contrast and field-of-view
+// metrics for a fundus image and decides whether it is gradable
+func AssessImageQuality(imageKey string) (*storage.ImageQuality, error) {
+	img, err := decodeStoredImage(imageKey)
+	if err != nil {
+		return nil, err
+	}
+	return AssessQuality(img), nil
+}
+
+// AssessQuality computes the quality metrics of a decoded fundus image
+func AssessQuality(img image.Image) *storage.ImageQuality {
+	cfg := config.AppConfig.Quality
+
+	red, green := extractChannels(img, qualityAnalysisSize)
+	mask := fundusMask(red)
+
+	quality := &storage.ImageQuality{
+		BlurVariance:           laplacianVariance(green, mask),
+		IlluminationUniformity: illuminationUniformity(green, mask),
+		Contrast:               rmsContrast(green, mask),
+		FieldOfViewCoverage:    fieldOfViewCoverage(mask, red.width, red.height),
+		Issues:                 []string{},
+		AssessedAt:             time.Now(),
+	}
+
+	if quality.BlurVariance < cfg.MinBlurVariance {
+		quality.Issues = append(quality.Issues, "image is blurred or out of focus")
+	}
+	if quality.IlluminationUniformity < cfg.MinIlluminationUniformity {
+		quality.Issues = append(quality.Issues, "illumination is uneven")
+	}
+	if quality.Contrast < cfg.MinContrast {
+		quality.Issues = append(quality.Issues, "contrast is too low")
+	}
+	if quality.FieldOfViewCoverage < cfg.MinFieldOfView {
+		quality.Issues = append(quality.Issues, "retina does not fill the field of view")
+	}
+
+	blurScore := math.Min(1, quality.BlurVariance/(2*math.Max(cfg.MinBlurVariance, 1e-9)))
+	contrastScore := math.Min(1, quality.Contrast/(2*math.Max(cfg.MinContrast, 1e-9)))
+	quality.Score = 0.35*blurScore +
+		0.2*quality.IlluminationUniformity +
+		0.2*contrastScore +
+		0.25*quality.FieldOfViewCoverage
+
+	if quality.Score < cfg.MinScore {
+		quality.Issues = append(quality.Issues, fmt.Sprintf("overall quality score %.2f below %.2f", quality.Score, cfg.MinScore))
+	}
+	quality.Gradable = len(quality.Issues) == 0
+
+	return quality
+}
+
+// extractChannels downscales the image and returns its red and green channels.
+// The green channel carries most vessel and lesion contrast in fundus images;
+// the red channel is used to separate the retina from the background.
+func extractChannels(img image.Image, maxSize int) (*channelImage, *channelImage) {
+	bounds := img.Bounds()
+	scale := 1.0
+	if longest := math.Max(float64(bounds.Dx()), float64(bounds.Dy())); longest > float64(maxSize) {
+		scale = float64(maxSize) / longest
+	}
+
+	width := int(math.Max(1, math.Round(float64(bounds.Dx())*scale)))
+	height := int(math.Max(1, math.Round(float64(bounds.Dy())*scale)))
+	red := &channelImage{width: width, height: height, pix: make([]float64, width*height)}
+	green := &channelImage{width: width, height: height, pix: make([]float64, width*height)}
+
+	for y := 0; y < height; y++ {
+		sy := bounds.Min.Y + int(float64(y)/scale)
+		for x := 0; x < width; x++ {
+			sx := bounds.Min.X + int(float64(x)/scale)
+			r, g, _, _ := img.At(sx, sy).RGBA()
+			red.pix[y*width+x] = float64(r >> 8)
+			green.pix[y*width+x] = float64(g >> 8)
+		}
+	}
+	return red, green
+}
+
+// fundusMask marks pixels belonging to the circular retina region
+func fundusMask(red *channelImage) []bool {
+	mask := make([]bool, len(red.pix))
+	for i, v := range red.pix {
+		mask[i] = v > fundusMaskThreshold
+	}
+	return mask
+}
+
+// laplacianVariance measures sharpness as the variance of the 4-neighbour Laplacian
+func laplacianVariance(ch *channelImage, mask []bool) float64 {
+	var sum, sumSq float64
+	n := 0
+	for y := 1; y < ch.height-1; y++ {
+		for x := 1; x < ch.width-1; x++ {
+			if !mask[y*ch.width+x] {
+				continue
+			}
+			lap := ch.at(x-1, y) + ch.at(x+1, y) + ch.at(x, y-1) + ch.at(x, y+1) - 4*ch.at(x, y)
+			sum += lap
+			sumSq += lap * lap
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	mean := sum / float64(n)
+	return sumSq/float64(n) - mean*mean
+}
+
+// illuminationUniformity returns 1 minus the coefficient of variation of block
+// mean brightness across the retina, clamped to 0-1
+func illuminationUniformity(ch *channelImage, mask []bool) float64 {
+	blockW := int(math.Max(1, float64(ch.width/illuminationGrid)))
+	blockH := int(math.Max(1, float64(ch.height/illuminationGrid)))
+
+	var means []float64
+	for by := 0; by < ch.height; by += blockH {
+		for bx := 0; bx < ch.width; bx += blockW {
+			var sum float64
+			n, total := 0, 0
+			for y := by; y < by+blockH && y < ch.height; y++ {
+				for x := bx; x < bx+blockW && x < ch.width; x++ {
+					total++
+					if mask[y*ch.width+x] {
+						sum += ch.at(x, y)
+						n++
+					}
+				}
+			}
+			// Only consider blocks that lie mostly inside the retina
+			if n > 0 && n*2 >= total {
+				means = append(means, sum/float64(n))
+			}
+		}
+	}
+
+	if len(means) == 0 {
+		return 0
+	}
+	mean, std := meanStd(means)
+	if mean == 0 {
+		return 0
+	}
+	return clamp01(1 - std/mean)
+}
+
+// rmsContrast returns the standard deviation of intensity within the retina, normalised to 0-1
+func rmsContrast(ch *channelImage, mask []bool) float64 {
+	var values []float64
+	for i, v := range ch.pix {
+		if mask[i] {
+			values = append(values, v/255)
+		}
+	}
+	if len(values) == 0 {
+		return 0
+	}
+	_, std := meanStd(values)
+	return std
+}
+
+// fieldOfViewCoverage returns the fraction of the centred inscribed circle covered by retina
+func fieldOfViewCoverage(mask []bool, width, height int) float64 {
+	cx, cy := float64(width)/2, float64(height)/2
+	radius := math.Min(cx, cy)
+	inside, covered := 0, 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			if dx*dx+dy*dy > radius*radius {
+				continue
+			}
+			inside++
+			if mask[y*width+x] {
+				covered++
+			}
+		}
+	}
+	if inside == 0 {
+		return 0
+	}
+	return float64(covered) / float64(inside)
+}
+
+func meanStd(values []float64) (float64, float64) {
+	var sum, sumSq float64
+	for _, v := range values {
+		sum += v
+		sumSq += v * v
+	}
+	n := float64(len(values))
+	mean := sum / n
+	return mean, math.Sqrt(math.Max(0, sumSq/n-mean*mean))
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/config"
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+)
+
+const (
+	RetentionActionArchive = "archive"
+	RetentionActionDelete  = "delete"
+
+	// RetentionExpiredReason is recorded on images deleted by the retention policy
+	RetentionExpiredReason = "retention period expired"
+)
+
+var (
+	ErrImageArchived      = errors.New("image is archived and read-only")
+	ErrImageAlreadyPurged = errors.New("image content has already been purged")
+	ErrResultRetracted    = errors.New("detection result is already retracted")
+)
+
+// RetentionReport summarizes one run of the retention policy
+type RetentionReport struct {
+	Purged   int       `json:"purged"`
+	Archived int       `json:"archived"`
+	Deleted  int       `json:"deleted"`
+	Failed   int       `json:"failed"`
+	RanAt    time.Time `json:"ran_at"`
+}
+
+// DeletedImageGracePeriod returns how long a soft-deleted image can be
+// restored before its content is purged
+func DeletedImageGracePeriod() time.Duration {
+	period, err := time.ParseDuration(config.AppConfig.Retention.DeletedGracePeriod)
+	if err != nil || period < 0 {
+		return 30 * 24 * time.Hour
+	}
+	return period
+}
+
+// ImageRetentionPeriod returns the legal retention period for images, or zero
+// when no retention policy is configured
+func ImageRetentionPeriod() time.Duration {
+	period, err := time.ParseDuration(config.AppConfig.Retention.ImageRetention)
+	if err != nil || period <= 0 {
+		return 0
+	}
+	return period
+}
+
+// DeleteImage soft-deletes an image and retracts its detection results. The
+// content stays in the blob store until the grace period has passed.
+func DeleteImage(image *storage.RetinalImage, userID uuid.UUID, reason string) error {
+	now := time.Now()
+	image.Deletion = &storage.ImageDeletion{
+		Reason:     reason,
+		DeletedBy:  userID,
+		DeletedAt:  now,
+		PurgeAfter: now.Add(DeletedImageGracePeriod()),
+	}
+	if err := storage.GlobalStorage.UpdateImage(image); err != nil {
+		return err
+	}
+
+	results, err := storage.GlobalStorage.GetDetectionResultsByImageID(image.ID)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if result.Retraction != nil {
+			continue
+		}
+		result.Retraction = &storage.Retraction{
+			Reason:        "image deleted: " + reason,
+			RetractedBy:   userID,
+			RetractedAt:   now,
+			ImageDeletion: true,
+		}
+		storage.GlobalStorage.UpdateDetectionResult(result)
+	}
+	return nil
+}
+
+// RestoreImage undoes a soft delete before the image content is purged.
+// Results retracted by the deletion are reinstated; results retracted on
+// their own stay retracted.
+func RestoreImage(image *storage.RetinalImage) error {
+	if image.Deletion == nil {
+		return nil
+	}
+	if image.Deletion.PurgedAt != nil {
+		return ErrImageAlreadyPurged
+	}
+
+	image.Deletion = nil
+	if err := storage.GlobalStorage.UpdateImage(image); err != nil {
+		return err
+	}
+
+	results, err := storage.GlobalStorage.GetDetectionResultsByImageID(image.ID)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if result.Retraction != nil && result.Retraction.ImageDeletion {
+			result.Retraction = nil
+			storage.GlobalStorage.UpdateDetectionResult(result)
+		}
+	}
+	return nil
+}
+
+// RetractDetectionResult withdraws a single detection result
+func RetractDetectionResult(result *storage.DetectionResult, userID uuid.UUID, reason string) error {
+	if result.Retraction != nil {
+		return ErrResultRetracted
+	}
+	result.Retraction = &storage.Retraction{
+		Reason:      reason,
+		RetractedBy: userID,
+		RetractedAt: time.Now(),
+	}
+	return storage.GlobalStorage.UpdateDetectionResult(result)
+}
+
+// PurgeImage permanently removes the content of a soft-deleted image: its
+// blob reference, previews, tiles and heatmaps. The image record is kept as a
+// tombstone so the deletion stays auditable.
+func PurgeImage(image *storage.RetinalImage) error {
+	if image.Deletion == nil {
+		return errors.New("only deleted images can be purged")
+	}
+	if image.Deletion.PurgedAt != nil {
+		return ErrImageAlreadyPurged
+	}
+
+	if image.ContentHash != "" {
+		if err := ReleaseContent(image.ContentHash); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("failed to release image content: %v", err)
+		}
+	}
+
+	results, err := storage.GlobalStorage.GetDetectionResultsByImageID(image.ID)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if !result.HasHeatmap {
+			continue
+		}
+		// Cached results share the heatmap of the result they were copied from
+		if !storage.GlobalStorage.IsHeatmapShared(result.HeatmapPath, result.ID) {
+			if err := blobstore.Default().Delete(context.Background(), result.HeatmapPath); err != nil && !errors.Is(err, blobstore.ErrNotExist) {
+				return fmt.Errorf("failed to delete heatmap: %v", err)
+			}
+		}
+		result.HeatmapPath = ""
+		result.HasHeatmap = false
+		storage.GlobalStorage.UpdateDetectionResult(result)
+	}
+
+	now := time.Now()
+	image.Deletion.PurgedAt = &now
+	image.FilePath = ""
+	return storage.GlobalStorage.UpdateImage(image)
+}
+
+// ArchiveImage marks an image as archived. Archived images stay viewable but
+// are read-only; generated previews and tiles are dropped and rebuilt on demand.
+func ArchiveImage(image *storage.RetinalImage) error {
+	now := time.Now()
+	image.ArchivedAt = &now
+	if err := storage.GlobalStorage.UpdateImage(image); err != nil {
+		return err
+	}
+	DeleteDerivatives(image.ContentHash)
+	DeleteTiles(image.ContentHash)
+	return nil
+}
+
+// ApplyRetentionPolicy purges deleted images past their grace period and
+// archives or deletes images past the configured retention period
+func ApplyRetentionPolicy() *RetentionReport {
+	report := &RetentionReport{RanAt: time.Now()}
+
+	due, _ := storage.GlobalStorage.GetImagesDueForPurge(report.RanAt)
+	for _, image := range due {
+		if err := PurgeImage(image); err != nil {
+			log.Printf("Failed to purge image %s: %v", image.ID, err)
+			report.Failed++
+			continue
+		}
+		report.Purged++
+	}
+
+	period := ImageRetentionPeriod()
+	if period == 0 {
+		return report
+	}
+	expired, _ := storage.GlobalStorage.GetImagesPastRetention(report.RanAt.Add(-period))
+	for _, image := range expired {
+		var err error
+		if config.AppConfig.Retention.Action == RetentionActionDelete {
+			if err = DeleteImage(image, uuid.Nil, RetentionExpiredReason); err == nil {
+				report.Deleted++
+			}
+		} else {
+			if err = ArchiveImage(image); err == nil {
+				report.Archived++
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to apply retention to image %s: %v", image.ID, err)
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// RetentionJobInterval returns how often the retention policy runs
+func RetentionJobInterval() time.Duration {
+	interval, err := time.ParseDuration(config.AppConfig.Retention.JobInterval)
+	if err != nil || interval <= 0 {
+		return 24 * time.Hour
+	}
+	return interval
+}
+
+// StartRetentionJob periodically applies the retention policy in the background
+func StartRetentionJob(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			report := ApplyRetentionPolicy()
+			if report.Purged+report.Archived+report.Deleted+report.Failed > 0 {
+				log.Printf("🗃️  Retention: purged %d, archived %d, deleted %d, failed %d",
+					report.Purged, report.Archived, report.Deleted, report.Failed)
+			}
+		}
+	}()
+}
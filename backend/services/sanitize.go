@@ -0,0 +1,339 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"path/filepath"
+
+	"dr-mario-backend/storage"
+)
+
+// Image rejection codes returned to clients
+const (
+	RejectUnsupportedFormat  = "unsupported_format"
+	RejectExtensionMismatch  = "extension_mismatch"
+	RejectCorrupted          = "corrupted"
+	RejectTooLarge           = "too_large"
+	RejectPolyglot           = "polyglot"
+	RejectEmbeddedContent    = "embedded_content"
+	RejectLateralityMismatch = "laterality_mismatch"
+)
+
+// ImageRejection explains why an uploaded file was refused
+type ImageRejection struct {
+	Code           string `json:"code"`
+	Reason         string `json:"reason"`
+	DetectedFormat string `json:"detected_format,omitempty"`
+}
+
+func (e *ImageRejection) Error() string {
+	return e.Reason
+}
+
+// SanitizedImage is an upload that passed validation with its metadata removed
+type SanitizedImage struct {
+	Data      []byte
+	Format    string
+	Extension string
+	Metadata  *storage.ImageMetadata
+}
+
+// suspiciousMarkers indicate scripts or documents smuggled inside image metadata
+var suspiciousMarkers = [][]byte{
+	[]byte("<script"),
+	[]byte("<?php"),
+	[]byte("<html"),
+	[]byte("<svg"),
+	[]byte("javascript:"),
+}
+
+// SanitizeImage sniffs the real format of an upload, checks the declared
+// dimensions before decoding so that a small file cannot force a huge
+// allocation, fully decodes it to reject corrupted or polyglot files, extracts
+// selected metadata and strips everything else (EXIF, XMP, comments, text
+// chunks, DICOM headers) from the stored bytes.
+// Rejections are returned as *ImageRejection.
+func SanitizeImage(data []byte, filename string) (*SanitizedImage, error) {
+	format := DetectImageFormat(data)
+	if format == "" {
+		return nil, &ImageRejection{
+			Code:   RejectUnsupportedFormat,
+			Reason: "file content is not a supported image format",
+		}
+	}
+
+	if ext := filepath.Ext(filename); !extensionMatchesFormat(ext, format) {
+		return nil, &ImageRejection{
+			Code:           RejectExtensionMismatch,
+			Reason:         fmt.Sprintf("file extension %s does not match detected format %s", ext, format),
+			DetectedFormat: format,
+		}
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, &ImageRejection{
+			Code:           RejectCorrupted,
+			Reason:         "image header could not be read",
+			DetectedFormat: format,
+		}
+	}
+	if cfg.Width > MaxImageDimension || cfg.Height > MaxImageDimension {
+		return nil, &ImageRejection{
+			Code:           RejectTooLarge,
+			Reason:         fmt.Sprintf("image dimensions %dx%d exceed the maximum of %dx%d", cfg.Width, cfg.Height, MaxImageDimension, MaxImageDimension),
+			DetectedFormat: format,
+		}
+	}
+
+	img, decodedFormat, err := image.Decode(bytes.NewReader(data))
+	if err != nil || decodedFormat != format {
+		return nil, &ImageRejection{
+			Code:           RejectCorrupted,
+			Reason:         "image could not be fully decoded",
+			DetectedFormat: format,
+		}
+	}
+
+	metadata, err := extractMetadata(data, img, format)
+	if err != nil {
+		return nil, &ImageRejection{
+			Code:           RejectCorrupted,
+			Reason:         "image metadata could not be read: " + err.Error(),
+			DetectedFormat: format,
+		}
+	}
+
+	sanitized := &SanitizedImage{Format: format, Metadata: metadata}
+
+	switch format {
+	case "jpeg":
+		sanitized.Data, metadata.StrippedMetadata, err = stripJPEG(data)
+		sanitized.Extension = ".jpg"
+	case "png":
+		sanitized.Data, metadata.StrippedMetadata, err = stripPNG(data)
+		sanitized.Extension = ".png"
+	default:
+		// Other formats are re-encoded from decoded pixels, which drops all metadata
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to encode normalized image: %v", err)
+		}
+		sanitized.Data = buf.Bytes()
+		sanitized.Extension = ".png"
+		metadata.StrippedMetadata = []string{format + "_header"}
+	}
+	if err != nil {
+		if rejection, ok := err.(*ImageRejection); ok {
+			rejection.DetectedFormat = format
+		}
+		return nil, err
+	}
+
+	return sanitized, nil
+}
+
+// jpegEXIF returns the TIFF payload of the first EXIF APP1 segment, if any
+func jpegEXIF(data []byte) []byte {
+	var exif []byte
+	walkJPEGSegments(data, func(marker byte, segment []byte) {
+		payload := segment[4:]
+		if exif == nil && marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			exif = payload[6:]
+		}
+	})
+	return exif
+}
+
+// walkJPEGSegments calls fn for every marker segment up to the EOI marker,
+// including those between the scans of a progressive image; the segment of an
+// SOS marker includes the entropy-coded data that follows it. It returns the
+// offset just past the EOI marker, or -1 if the stream is malformed or truncated.
+func walkJPEGSegments(data []byte, fn func(marker byte, segment []byte)) int {
+	pos := 2
+	for pos+2 <= len(data) {
+		if data[pos] != 0xFF {
+			return -1
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			// Fill byte
+			pos++
+			continue
+		}
+		if marker == 0xD9 {
+			return pos + 2
+		}
+		if pos+4 > len(data) {
+			return -1
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return -1
+		}
+		if marker == 0xDA {
+			if end = scanDataEnd(data, end); end < 0 {
+				return -1
+			}
+		}
+		fn(marker, data[pos:end])
+		pos = end
+	}
+	return -1
+}
+
+// scanDataEnd returns the offset of the first marker after the entropy-coded
+// data starting at start, skipping stuffed zero bytes and restart markers
+func scanDataEnd(data []byte, start int) int {
+	for i := start; i+1 < len(data); i++ {
+		if data[i] != 0xFF {
+			continue
+		}
+		switch next := data[i+1]; {
+		case next == 0x00, next >= 0xD0 && next <= 0xD7:
+			i++
+		case next != 0xFF:
+			return i
+		}
+	}
+	return -1
+}
+
+// stripJPEG removes application and comment segments other than JFIF, Adobe
+// colour transform and ICC profiles, and rejects data trailing the EOI marker
+func stripJPEG(data []byte) ([]byte, []string, error) {
+	var out bytes.Buffer
+	out.Write(data[:2])
+
+	strippedSet := make(map[string]bool)
+	var stripped []string
+	var suspicious bool
+
+	var scans int
+	eoi := walkJPEGSegments(data, func(marker byte, segment []byte) {
+		payload := segment[4:]
+		keep := true
+		name := ""
+		switch {
+		case marker == 0xDA:
+			scans++
+		case marker == 0xE0 && bytes.HasPrefix(payload, []byte("JFIF\x00")):
+		case marker == 0xEE && bytes.HasPrefix(payload, []byte("Adobe")):
+		case marker == 0xE2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")):
+		case marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+			keep, name = false, "exif"
+		case marker == 0xE1:
+			keep, name = false, "xmp"
+		case marker == 0xED:
+			keep, name = false, "iptc"
+		case marker == 0xFE:
+			keep, name = false, "comment"
+		case marker >= 0xE0 && marker <= 0xEF:
+			keep, name = false, fmt.Sprintf("app%d", marker-0xE0)
+		}
+
+		if keep {
+			out.Write(segment)
+			return
+		}
+		if containsSuspiciousMarker(payload) {
+			suspicious = true
+		}
+		if !strippedSet[name] {
+			strippedSet[name] = true
+			stripped = append(stripped, name)
+		}
+	})
+	if eoi < 0 {
+		return nil, nil, &ImageRejection{Code: RejectCorrupted, Reason: "malformed or truncated JPEG segment structure"}
+	}
+	if scans == 0 {
+		return nil, nil, &ImageRejection{Code: RejectCorrupted, Reason: "JPEG contains no image data"}
+	}
+	if suspicious {
+		return nil, nil, &ImageRejection{Code: RejectEmbeddedContent, Reason: "image metadata contains embedded script or markup"}
+	}
+
+	if hasTrailingData(data[eoi:]) {
+		return nil, nil, &ImageRejection{Code: RejectPolyglot, Reason: "unexpected data after JPEG end of image marker"}
+	}
+
+	out.Write(data[eoi-2 : eoi])
+	return out.Bytes(), stripped, nil
+}
+
+// stripPNG drops text, time and EXIF chunks and rejects data after IEND
+func stripPNG(data []byte) ([]byte, []string, error) {
+	keepChunks := map[string]bool{
+		"IHDR": true, "PLTE": true, "IDAT": true, "IEND": true,
+		"tRNS": true, "gAMA": true, "cHRM": true, "sRGB": true,
+		"iCCP": true, "sBIT": true, "pHYs": true,
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:8])
+
+	strippedSet := make(map[string]bool)
+	var stripped []string
+
+	pos := 8
+	for {
+		if pos+12 > len(data) {
+			return nil, nil, &ImageRejection{Code: RejectCorrupted, Reason: "PNG is missing the IEND chunk"}
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		chunkEnd := pos + 12 + length
+		if length < 0 || chunkEnd > len(data) {
+			return nil, nil, &ImageRejection{Code: RejectCorrupted, Reason: "truncated PNG chunk"}
+		}
+
+		if keepChunks[chunkType] {
+			out.Write(data[pos:chunkEnd])
+		} else {
+			if containsSuspiciousMarker(data[pos+8 : pos+8+length]) {
+				return nil, nil, &ImageRejection{Code: RejectEmbeddedContent, Reason: "image metadata contains embedded script or markup"}
+			}
+			name := "png:" + chunkType
+			if !strippedSet[name] {
+				strippedSet[name] = true
+				stripped = append(stripped, name)
+			}
+		}
+
+		pos = chunkEnd
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	if hasTrailingData(data[pos:]) {
+		return nil, nil, &ImageRejection{Code: RejectPolyglot, Reason: "unexpected data after PNG IEND chunk"}
+	}
+
+	return out.Bytes(), stripped, nil
+}
+
+// hasTrailingData reports whether anything other than zero padding follows the image
+func hasTrailingData(trailer []byte) bool {
+	for _, b := range trailer {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func containsSuspiciousMarker(data []byte) bool {
+	lower := bytes.ToLower(data)
+	for _, marker := range suspiciousMarkers {
+		if bytes.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"reflect"
+	"testing"
+)
+
+// testJPEG encodes a small baseline JPEG
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// jpegSegment builds a marker segment with the given payload
+func jpegSegment(marker byte, payload string) []byte {
+	length := len(payload) + 2
+	return append([]byte{0xFF, marker, byte(length >> 8), byte(length)}, payload...)
+}
+
+// insertBefore returns data with segment inserted at offset
+func insertBefore(data []byte, offset int, segment []byte) []byte {
+	out := append([]byte{}, data[:offset]...)
+	out = append(out, segment...)
+	return append(out, data[offset:]...)
+}
+
+func TestSanitizeJPEG(t *testing.T) {
+	base := testJPEG(t)
+	eoi := len(base) - 2
+
+	tests := []struct {
+		name     string
+		data     []byte
+		filename string
+		code     string
+		stripped []string
+	}{
+		{
+			name:     "clean",
+			data:     base,
+			filename: "fundus.jpg",
+		},
+		{
+			name:     "metadata before the scan",
+			data:     insertBefore(base, 2, jpegSegment(0xE1, "Exif\x00\x00II*\x00\x08\x00\x00\x00\x00\x00")),
+			filename: "fundus.jpg",
+			stripped: []string{"exif"},
+		},
+		{
+			name:     "metadata after the scan",
+			data:     insertBefore(insertBefore(base, eoi, jpegSegment(0xFE, "patient name")), eoi, jpegSegment(0xE1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")),
+			filename: "fundus.jpeg",
+			stripped: []string{"xmp", "comment"},
+		},
+		{
+			name:     "script in a comment after the scan",
+			data:     insertBefore(base, eoi, jpegSegment(0xFE, "<script>alert(1)</script>")),
+			filename: "fundus.jpg",
+			code:     RejectEmbeddedContent,
+		},
+		{
+			name:     "data after end of image",
+			data:     append(append([]byte{}, base...), "PK\x03\x04"...),
+			filename: "fundus.jpg",
+			code:     RejectPolyglot,
+		},
+		{
+			name:     "truncated scan",
+			data:     base[:len(base)/2],
+			filename: "fundus.jpg",
+			code:     RejectCorrupted,
+		},
+		{
+			name:     "missing end of image",
+			data:     base[:eoi],
+			filename: "fundus.jpg",
+			code:     RejectCorrupted,
+		},
+		{
+			name:     "truncated header",
+			data:     base[:20],
+			filename: "fundus.jpg",
+			code:     RejectCorrupted,
+		},
+		{
+			name:     "segment length past the end",
+			data:     append([]byte{0xFF, 0xD8, 0xFF, 0xE0, 0xFF, 0xFF}, base[2:10]...),
+			filename: "fundus.jpg",
+			code:     RejectCorrupted,
+		},
+		{
+			name:     "wrong extension",
+			data:     base,
+			filename: "fundus.png",
+			code:     RejectExtensionMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sanitized, err := SanitizeImage(tt.data, tt.filename)
+			if tt.code != "" {
+				var rejection *ImageRejection
+				if !errors.As(err, &rejection) || rejection.Code != tt.code {
+					t.Fatalf("err = %v, want rejection %q", err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sanitized.Format != "jpeg" || sanitized.Extension != ".jpg" {
+				t.Errorf("format = %s %s", sanitized.Format, sanitized.Extension)
+			}
+			if got := sanitized.Metadata.StrippedMetadata; len(got)+len(tt.stripped) > 0 && !reflect.DeepEqual(got, tt.stripped) {
+				t.Errorf("stripped = %v, want %v", got, tt.stripped)
+			}
+			if !bytes.Equal(sanitized.Data, base) {
+				t.Errorf("sanitized data differs from the image without metadata")
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(sanitized.Data)); err != nil {
+				t.Errorf("sanitized image does not decode: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"dr-mario-backend/config"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrSignatureInvalid = errors.New("invalid download signature")
+	ErrSignatureExpired = errors.New("download link has expired")
+)
+
+// SignedImagePath is the public route that serves signed image downloads
+const SignedImagePath = "/api/v1/files/images/"
+
+// DownloadURLExpiry returns how long a signed download URL stays valid
+func DownloadURLExpiry() time.Duration {
+	expiry, err := time.ParseDuration(config.AppConfig.Download.URLExpiry)
+	if err != nil || expiry <= 0 {
+		return 5 * time.Minute
+	}
+	return expiry
+}
+
+// SignImageURL returns a path that serves the image at the given size without
+// a bearer token. The link is bound to the user it was issued to and expires
+// after DownloadURLExpiry.
+func SignImageURL(imageID, userID uuid.UUID, size string) (string, time.Time) {
+	expiresAt := time.Now().Add(DownloadURLExpiry()).Truncate(time.Second)
+
+	query := url.Values{}
+	if size != "" && size != SizeOriginal {
+		query.Set("size", size)
+	}
+	query.Set("user", userID.String())
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("signature", imageSignature(imageID, userID, size, expiresAt.Unix()))
+
+	return SignedImagePath + imageID.String() + "?" + query.Encode(), expiresAt
+}
+
+// VerifyImageSignature checks a signed download link and returns the user it
+// was issued to
+func VerifyImageSignature(imageID uuid.UUID, size, user, expires, signature string) (uuid.UUID, error) {
+	userID, err := uuid.Parse(user)
+	if err != nil {
+		return uuid.Nil, ErrSignatureInvalid
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return uuid.Nil, ErrSignatureInvalid
+	}
+
+	expected := imageSignature(imageID, userID, size, expiresAt)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return uuid.Nil, ErrSignatureInvalid
+	}
+	if time.Now().Unix() > expiresAt {
+		return uuid.Nil, ErrSignatureExpired
+	}
+	return userID, nil
+}
+
+func imageSignature(imageID, userID uuid.UUID, size string, expiresAt int64) string {
+	if size == "" {
+		size = SizeOriginal
+	}
+	mac := hmac.New(sha256.New, downloadSigningKey())
+	fmt.Fprintf(mac, "image\n%s\n%s\n%s\n%d", imageID, size, userID, expiresAt)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func downloadSigningKey() []byte {
+	if secret := config.AppConfig.Download.SigningSecret; secret != "" {
+		return []byte(secret)
+	}
+	return []byte(config.AppConfig.JWT.Secret)
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestVerifyImageSignature(t *testing.T) {
+	imageID, userID := uuid.New(), uuid.New()
+	signed, _ := SignImageURL(imageID, userID, SizeThumbnail)
+	link, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Path != SignedImagePath+imageID.String() {
+		t.Fatalf("path = %s", link.Path)
+	}
+
+	expired := time.Now().Add(-time.Second).Unix()
+	tests := []struct {
+		name    string
+		imageID uuid.UUID
+		modify  func(query url.Values)
+		want    error
+	}{
+		{
+			name:    "valid",
+			imageID: imageID,
+			modify:  func(query url.Values) {},
+		},
+		{
+			name:    "tampered signature",
+			imageID: imageID,
+			modify: func(query url.Values) {
+				signature := []byte(query.Get("signature"))
+				signature[0] ^= 1
+				query.Set("signature", string(signature))
+			},
+			want: ErrSignatureInvalid,
+		},
+		{
+			name:    "truncated signature",
+			imageID: imageID,
+			modify:  func(query url.Values) { query.Set("signature", query.Get("signature")[:10]) },
+			want:    ErrSignatureInvalid,
+		},
+		{
+			name:    "missing signature",
+			imageID: imageID,
+			modify:  func(query url.Values) { query.Del("signature") },
+			want:    ErrSignatureInvalid,
+		},
+		{
+			name:    "other image",
+			imageID: uuid.New(),
+			modify:  func(query url.Values) {},
+			want:    ErrSignatureInvalid,
+		},
+		{
+			name:    "other user",
+			imageID: imageID,
+			modify:  func(query url.Values) { query.Set("user", uuid.New().String()) },
+			want:    ErrSignatureInvalid,
+		},
+		{
+			name:    "original instead of thumbnail",
+			imageID: imageID,
+			modify:  func(query url.Values) { query.Del("size") },
+			want:    ErrSignatureInvalid,
+		},
+		{
+			name:    "extended expiry",
+			imageID: imageID,
+			modify: func(query url.Values) {
+				expires, _ := strconv.ParseInt(query.Get("expires"), 10, 64)
+				query.Set("expires", strconv.FormatInt(expires+3600, 10))
+			},
+			want: ErrSignatureInvalid,
+		},
+		{
+			name:    "malformed expiry",
+			imageID: imageID,
+			modify:  func(query url.Values) { query.Set("expires", query.Get("expires")+"x") },
+			want:    ErrSignatureInvalid,
+		},
+		{
+			name:    "malformed user",
+			imageID: imageID,
+			modify:  func(query url.Values) { query.Set("user", strings.ToUpper(query.Get("user"))+"0") },
+			want:    ErrSignatureInvalid,
+		},
+		{
+			name:    "expired",
+			imageID: imageID,
+			modify: func(query url.Values) {
+				query.Set("expires", strconv.FormatInt(expired, 10))
+				query.Set("signature", imageSignature(imageID, userID, SizeThumbnail, expired))
+			},
+			want: ErrSignatureExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{}
+			for key, values := range link.Query() {
+				query[key] = values
+			}
+			tt.modify(query)
+
+			got, err := VerifyImageSignature(tt.imageID, query.Get("size"), query.Get("user"), query.Get("expires"), query.Get("signature"))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && got != userID {
+				t.Errorf("user = %s, want %s", got, userID)
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"dr-mario-backend/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is one key pair of the token signing key ring
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	CreatedAt time.Time
+	ExpiresAt time.Time // set once the key is retired; zero while signing or pending
+}
+
+// JWK is the public part of a signing key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// keyRing holds the key that signs new tokens, the key that will replace it
+// and retired keys that still verify tokens issued before a rotation. The
+// next key is published ahead of use so verifiers can cache it in time.
+// Configured keys are shared by every replica and rotated by changing the
+// configuration, so they are never rotated in process.
+type keyRing struct {
+	mu         sync.RWMutex
+	algorithm  string
+	configured bool
+	active     *SigningKey
+	next       *SigningKey
+	retired    []*SigningKey
+}
+
+var signingKeys = &keyRing{}
+
+// InitializeSigningKeys sets up token signing for the configured algorithm.
+// HS256 uses JWT_SECRET. RS256 and EdDSA load their key pairs from
+// JWT_ACTIVE_KEY, JWT_NEXT_KEY and JWT_RETIRED_KEYS; without JWT_ACTIVE_KEY
+// a rotating key ring is generated, which only suits a single development
+// process since every restart and replica gets different keys.
+func InitializeSigningKeys() error {
+	algorithm := config.AppConfig.JWT.Algorithm
+	switch algorithm {
+	case jwt.SigningMethodHS256.Alg():
+	case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg():
+	default:
+		return fmt.Errorf("unsupported JWT_ALGORITHM %q (use HS256, RS256 or EdDSA)", algorithm)
+	}
+
+	signingKeys.mu.Lock()
+	defer signingKeys.mu.Unlock()
+
+	signingKeys.algorithm = algorithm
+	signingKeys.configured = false
+	signingKeys.active, signingKeys.next, signingKeys.retired = nil, nil, nil
+	if algorithm == jwt.SigningMethodHS256.Alg() {
+		return nil
+	}
+	if config.AppConfig.JWT.ActiveKey != "" {
+		return loadConfiguredKeys(algorithm)
+	}
+	if config.AppConfig.JWT.NextKey != "" || len(config.AppConfig.JWT.RetiredKeys) > 0 {
+		return errors.New("JWT_NEXT_KEY and JWT_RETIRED_KEYS require JWT_ACTIVE_KEY")
+	}
+	log.Printf("⚠️  No JWT_ACTIVE_KEY configured, generating %s signing keys; tokens will not survive a restart", algorithm)
+
+	active, err := generateSigningKey(algorithm)
+	if err != nil {
+		return err
+	}
+	next, err := generateSigningKey(algorithm)
+	if err != nil {
+		return err
+	}
+	signingKeys.active, signingKeys.next = active, next
+	return nil
+}
+
+// RotateSigningKeys promotes the pending key to sign new tokens and retires
+// the current one. Retired keys keep verifying for the configured overlap, and
+// at least as long as an access token lives.
+func RotateSigningKeys() error {
+	signingKeys.mu.Lock()
+	defer signingKeys.mu.Unlock()
+
+	if signingKeys.active == nil || signingKeys.configured {
+		return nil
+	}
+	next, err := generateSigningKey(signingKeys.algorithm)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	signingKeys.active.ExpiresAt = now.Add(max(keyOverlap(), AccessTokenExpiry()))
+	retired := []*SigningKey{signingKeys.active}
+	for _, key := range signingKeys.retired {
+		if now.Before(key.ExpiresAt) {
+			retired = append(retired, key)
+		}
+	}
+	signingKeys.retired = retired
+	signingKeys.active = signingKeys.next
+	signingKeys.next = next
+	return nil
+}
+
+// KeyRotationInterval returns how often asymmetric signing keys are rotated
+func KeyRotationInterval() time.Duration {
+	interval, err := time.ParseDuration(config.AppConfig.JWT.RotationInterval)
+	if err != nil || interval <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return interval
+}
+
+// StartKeyRotation rotates generated signing keys in the background. It does
+// nothing for HS256, which signs with a single shared secret, or for
+// configured keys, which are rotated by changing the configuration.
+func StartKeyRotation(interval time.Duration) {
+	signingKeys.mu.RLock()
+	generated := signingKeys.active != nil && !signingKeys.configured
+	signingKeys.mu.RUnlock()
+	if !generated {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := RotateSigningKeys(); err != nil {
+				log.Printf("Failed to rotate signing keys: %v", err)
+				continue
+			}
+			log.Printf("🔑 Rotated token signing keys")
+		}
+	}()
+}
+
+// PublicJWKS returns the public keys that verify tokens: the signing key, the
+// key that replaces it at the next rotation and retired keys still in overlap.
+// It is empty for HS256.
+func PublicJWKS() []JWK {
+	signingKeys.mu.RLock()
+	defer signingKeys.mu.RUnlock()
+
+	keys := []JWK{}
+	for _, key := range signingKeys.published(time.Now()) {
+		keys = append(keys, key.jwk())
+	}
+	return keys
+}
+
+// signToken signs claims with the current key and sets its kid header
+func signToken(claims jwt.Claims) (string, error) {
+	signingKeys.mu.RLock()
+	defer signingKeys.mu.RUnlock()
+
+	if signingKeys.active == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = secretKeyID()
+		return token.SignedString([]byte(config.AppConfig.JWT.Secret))
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(signingKeys.active.Algorithm), claims)
+	token.Header["kid"] = signingKeys.active.ID
+	return token.SignedString(signingKeys.active.Private)
+}
+
+// verificationKey resolves the key named by a token's kid header
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	signingKeys.mu.RLock()
+	defer signingKeys.mu.RUnlock()
+
+	if signingKeys.active == nil {
+		if kid != secretKeyID() || token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return []byte(config.AppConfig.JWT.Secret), nil
+	}
+
+	for _, key := range signingKeys.published(time.Now()) {
+		if key.ID == kid {
+			if token.Method.Alg() != key.Algorithm {
+				return nil, fmt.Errorf("signing key %q does not use %s", kid, token.Method.Alg())
+			}
+			return key.Private.Public(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (k *keyRing) published(now time.Time) []*SigningKey {
+	if k.active == nil {
+		return nil
+	}
+	keys := []*SigningKey{k.active}
+	if k.next != nil {
+		keys = append(keys, k.next)
+	}
+	for _, key := range k.retired {
+		if now.Before(key.ExpiresAt) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (key *SigningKey) jwk() JWK {
+	jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+	switch public := key.Private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+func generateSigningKey(algorithm string) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %v", err)
+	}
+	return newSigningKey(algorithm, private)
+}
+
+// loadConfiguredKeys builds the key ring from the configured key pairs.
+// Retired keys verify for one more overlap after startup, so they can be
+// dropped from the configuration once that has passed.
+func loadConfiguredKeys(algorithm string) error {
+	active, err := loadSigningKey(algorithm, "JWT_ACTIVE_KEY", config.AppConfig.JWT.ActiveKey)
+	if err != nil {
+		return err
+	}
+	var next *SigningKey
+	if config.AppConfig.JWT.NextKey != "" {
+		if next, err = loadSigningKey(algorithm, "JWT_NEXT_KEY", config.AppConfig.JWT.NextKey); err != nil {
+			return err
+		}
+	}
+	expiresAt := time.Now().Add(max(keyOverlap(), AccessTokenExpiry()))
+	var retired []*SigningKey
+	for _, value := range config.AppConfig.JWT.RetiredKeys {
+		key, err := loadSigningKey(algorithm, "JWT_RETIRED_KEYS", value)
+		if err != nil {
+			return err
+		}
+		key.ExpiresAt = expiresAt
+		retired = append(retired, key)
+	}
+
+	signingKeys.configured = true
+	signingKeys.active, signingKeys.next, signingKeys.retired = active, next, retired
+	return nil
+}
+
+// loadSigningKey parses a PKCS#8 (or PKCS#1 RSA) private key given as PEM or
+// as the path of a PEM file
+func loadSigningKey(algorithm, setting, value string) (*SigningKey, error) {
+	data := []byte(value)
+	if !strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		var err error
+		if data, err = os.ReadFile(value); err != nil {
+			return nil, fmt.Errorf("%s: %v", setting, err)
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM private key found", setting)
+	}
+
+	var private interface{}
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", setting, err)
+	}
+
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		if algorithm == jwt.SigningMethodRS256.Alg() {
+			return newSigningKey(algorithm, key)
+		}
+	case ed25519.PrivateKey:
+		if algorithm == jwt.SigningMethodEdDSA.Alg() {
+			return newSigningKey(algorithm, key)
+		}
+	}
+	return nil, fmt.Errorf("%s: key type %T cannot sign %s", setting, private, algorithm)
+}
+
+// newSigningKey names a key by its public key, so every process holding the
+// same key pair publishes the same kid
+func newSigningKey(algorithm string, private crypto.Signer) (*SigningKey, error) {
+	public, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(public)
+	return &SigningKey{
+		ID:        hex.EncodeToString(sum[:8]),
+		Algorithm: algorithm,
+		Private:   private,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+func keyOverlap() time.Duration {
+	overlap, err := time.ParseDuration(config.AppConfig.JWT.KeyOverlap)
+	if err != nil || overlap < 0 {
+		return 24 * time.Hour
+	}
+	return overlap
+}
+
+// secretKeyID names the shared HS256 secret without revealing it
+func secretKeyID() string {
+	sum := sha256.Sum256([]byte(config.AppConfig.JWT.Secret))
+	return "hs256-" + hex.EncodeToString(sum[:4])
+}
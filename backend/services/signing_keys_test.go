@@ -0,0 +1,313 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"dr-mario-backend/config"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestMain(m *testing.M) {
+	config.LoadEnv()
+	os.Exit(m.Run())
+}
+
+// pemKey encodes a private key as PKCS#8 PEM
+func pemKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func rsaKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func ed25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// useJWTConfig replaces the JWT configuration for the duration of a test
+func useJWTConfig(t *testing.T, jwtConfig config.JWTConfig) {
+	t.Helper()
+	saved := config.AppConfig.JWT
+	config.AppConfig.JWT = jwtConfig
+	t.Cleanup(func() {
+		config.AppConfig.JWT = saved
+		InitializeSigningKeys()
+	})
+}
+
+func testClaims() jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		ID:        uuid.New().String(),
+		Subject:   uuid.New().String(),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		IssuedAt:  jwt.NewNumericDate(now),
+	}
+}
+
+func TestConfiguredKeysAreSharedAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	activeFile := filepath.Join(dir, "active.pem")
+	if err := os.WriteFile(activeFile, []byte(pemKey(t, rsaKey(t))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	retiredKey := rsaKey(t)
+
+	tests := []struct {
+		name      string
+		jwtConfig config.JWTConfig
+		published int
+	}{
+		{
+			name:      "RS256 from files and PEM",
+			jwtConfig: config.JWTConfig{Algorithm: "RS256", ActiveKey: activeFile, NextKey: pemKey(t, rsaKey(t)), RetiredKeys: []string{pemKey(t, retiredKey)}},
+			published: 3,
+		},
+		{
+			name:      "EdDSA without next key",
+			jwtConfig: config.JWTConfig{Algorithm: "EdDSA", ActiveKey: pemKey(t, ed25519Key(t))},
+			published: 1,
+		},
+		{
+			name: "RS256 PKCS#1",
+			jwtConfig: config.JWTConfig{Algorithm: "RS256", ActiveKey: string(pem.EncodeToMemory(&pem.Block{
+				Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(retiredKey),
+			}))},
+			published: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useJWTConfig(t, tt.jwtConfig)
+
+			// One process issues a token...
+			if err := InitializeSigningKeys(); err != nil {
+				t.Fatal(err)
+			}
+			jwks := PublicJWKS()
+			if len(jwks) != tt.published {
+				t.Fatalf("published %d keys, want %d", len(jwks), tt.published)
+			}
+			token, err := signToken(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// ...and a restarted process or another replica verifies it
+			if err := InitializeSigningKeys(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(PublicJWKS(), jwks) {
+				t.Errorf("JWKS changed after restart:\n%v\n%v", jwks, PublicJWKS())
+			}
+			if _, err := ParseAccessToken(token); err != nil {
+				t.Errorf("token from before restart rejected: %v", err)
+			}
+
+			// Configured keys are rotated by configuration only
+			if err := RotateSigningKeys(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(PublicJWKS(), jwks) {
+				t.Error("configured keys were rotated in process")
+			}
+		})
+	}
+}
+
+func TestConfiguredKeysRejectInvalidConfiguration(t *testing.T) {
+	tests := []struct {
+		name      string
+		jwtConfig config.JWTConfig
+	}{
+		{"RSA key for EdDSA", config.JWTConfig{Algorithm: "EdDSA", ActiveKey: pemKey(t, rsaKey(t))}},
+		{"Ed25519 key for RS256", config.JWTConfig{Algorithm: "RS256", ActiveKey: pemKey(t, ed25519Key(t))}},
+		{"missing file", config.JWTConfig{Algorithm: "RS256", ActiveKey: filepath.Join(t.TempDir(), "missing.pem")}},
+		{"not PEM", config.JWTConfig{Algorithm: "RS256", ActiveKey: "-----BEGIN nothing"}},
+		{"next key without active key", config.JWTConfig{Algorithm: "RS256", NextKey: pemKey(t, rsaKey(t))}},
+		{"bad retired key", config.JWTConfig{Algorithm: "RS256", ActiveKey: pemKey(t, rsaKey(t)), RetiredKeys: []string{pemKey(t, ed25519Key(t))}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useJWTConfig(t, tt.jwtConfig)
+			if err := InitializeSigningKeys(); err == nil {
+				t.Error("InitializeSigningKeys succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestParseAccessTokenRejectsAlgorithmAndKeyConfusion(t *testing.T) {
+	rsaActive, otherRSA := rsaKey(t), rsaKey(t)
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaActive.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	rs256 := config.JWTConfig{Algorithm: "RS256", Secret: "test-secret", ActiveKey: pemKey(t, rsaActive)}
+	hs256 := config.JWTConfig{Algorithm: "HS256", Secret: "test-secret"}
+
+	// sign builds a token with the given method, kid and key
+	sign := func(method jwt.SigningMethod, kid interface{}, key interface{}, claims jwt.RegisteredClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != nil {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	expired := testClaims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	withoutID := testClaims()
+	withoutID.ID = ""
+
+	tests := []struct {
+		name      string
+		jwtConfig config.JWTConfig
+		token     func() string
+		want      error
+	}{
+		{
+			name:      "RS256 with the active key",
+			jwtConfig: rs256,
+			token:     func() string { return sign(jwt.SigningMethodRS256, signingKeys.active.ID, rsaActive, testClaims()) },
+		},
+		{
+			name:      "HS256 keyed with the RS256 public key",
+			jwtConfig: rs256,
+			token:     func() string { return sign(jwt.SigningMethodHS256, signingKeys.active.ID, publicPEM, testClaims()) },
+			want:      ErrInvalidToken,
+		},
+		{
+			name:      "HS256 with the shared secret while RS256 is configured",
+			jwtConfig: rs256,
+			token:     func() string { return sign(jwt.SigningMethodHS256, secretKeyID(), []byte("test-secret"), testClaims()) },
+			want:      ErrInvalidToken,
+		},
+		{
+			name:      "alg none",
+			jwtConfig: rs256,
+			token: func() string {
+				return sign(jwt.SigningMethodNone, signingKeys.active.ID, jwt.UnsafeAllowNoneSignatureType, testClaims())
+			},
+			want: ErrInvalidToken,
+		},
+		{
+			name:      "other RSA key under the active kid",
+			jwtConfig: rs256,
+			token:     func() string { return sign(jwt.SigningMethodRS256, signingKeys.active.ID, otherRSA, testClaims()) },
+			want:      ErrInvalidToken,
+		},
+		{
+			name:      "EdDSA under the active kid",
+			jwtConfig: rs256,
+			token:     func() string { return sign(jwt.SigningMethodEdDSA, signingKeys.active.ID, ed25519Key(t), testClaims()) },
+			want:      ErrInvalidToken,
+		},
+		{
+			name:      "unknown kid",
+			jwtConfig: rs256,
+			token:     func() string { return sign(jwt.SigningMethodRS256, "unknown", rsaActive, testClaims()) },
+			want:      ErrInvalidToken,
+		},
+		{
+			name:      "missing kid",
+			jwtConfig: rs256,
+			token:     func() string { return sign(jwt.SigningMethodRS256, nil, rsaActive, testClaims()) },
+			want:      ErrInvalidToken,
+		},
+		{
+			name:      "kid that is not a string",
+			jwtConfig: rs256,
+			token:     func() string { return sign(jwt.SigningMethodRS256, 1, rsaActive, testClaims()) },
+			want:      ErrInvalidToken,
+		},
+		{
+			name:      "expired",
+			jwtConfig: rs256,
+			token:     func() string { return sign(jwt.SigningMethodRS256, signingKeys.active.ID, rsaActive, expired) },
+			want:      ErrInvalidToken,
+		},
+		{
+			name:      "without a token ID",
+			jwtConfig: rs256,
+			token:     func() string { return sign(jwt.SigningMethodRS256, signingKeys.active.ID, rsaActive, withoutID) },
+			want:      ErrInvalidToken,
+		},
+		{
+			name:      "HS256 with the shared secret",
+			jwtConfig: hs256,
+			token:     func() string { return sign(jwt.SigningMethodHS256, secretKeyID(), []byte("test-secret"), testClaims()) },
+		},
+		{
+			name:      "HS256 with another secret",
+			jwtConfig: hs256,
+			token: func() string {
+				return sign(jwt.SigningMethodHS256, secretKeyID(), []byte("other-secret"), testClaims())
+			},
+			want: ErrInvalidToken,
+		},
+		{
+			name:      "HS256 without the secret's kid",
+			jwtConfig: hs256,
+			token:     func() string { return sign(jwt.SigningMethodHS256, "other", []byte("test-secret"), testClaims()) },
+			want:      ErrInvalidToken,
+		},
+		{
+			name:      "RS256 while HS256 is configured",
+			jwtConfig: hs256,
+			token:     func() string { return sign(jwt.SigningMethodRS256, secretKeyID(), rsaActive, testClaims()) },
+			want:      ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useJWTConfig(t, tt.jwtConfig)
+			if err := InitializeSigningKeys(); err != nil {
+				t.Fatal(err)
+			}
+
+			claims, err := ParseAccessToken(tt.token())
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && claims.ID == "" {
+				t.Error("claims without ID")
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"math"
+	"sync"
+
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/storage"
+
+	"golang.org/x/image/draw"
+)
+
+// Deep Zoom tile geometry. Tiles do not overlap; edge tiles may be smaller.
+const (
+	TileSize    = 256
+	TileOverlap = 0
+	TileFormat  = "jpg"
+)
+
+// ErrTileOutOfRange is returned for levels or tile coordinates outside the pyramid
+var ErrTileOutOfRange = errors.New("tile out of range")
+
+// TileLevel describes one resolution level of a tile pyramid
+type TileLevel struct {
+	Level   int `json:"level"`
+	Width   int `json:"width"`
+	Height  int `json:"height"`
+	Columns int `json:"columns"`
+	Rows    int `json:"rows"`
+}
+
+// TilePyramid describes a Deep Zoom pyramid. Level MaxLevel is full resolution
+// and every level below halves the previous one, down to a single pixel at level 0.
+type TilePyramid struct {
+	Width    int         `json:"width"`
+	Height   int         `json:"height"`
+	TileSize int         `json:"tile_size"`
+	Overlap  int         `json:"overlap"`
+	Format   string      `json:"format"`
+	MaxLevel int         `json:"max_level"`
+	Levels   []TileLevel `json:"levels"`
+}
+
+// NewTilePyramid computes the pyramid geometry for an image size
+func NewTilePyramid(width, height int) *TilePyramid {
+	maxLevel := int(math.Ceil(math.Log2(float64(max(width, height)))))
+	pyramid := &TilePyramid{
+		Width:    width,
+		Height:   height,
+		TileSize: TileSize,
+		Overlap:  TileOverlap,
+		Format:   TileFormat,
+		MaxLevel: maxLevel,
+	}
+	for level := 0; level <= maxLevel; level++ {
+		scale := pyramid.Scale(level)
+		levelWidth := int(math.Ceil(float64(width) / scale))
+		levelHeight := int(math.Ceil(float64(height) / scale))
+		pyramid.Levels = append(pyramid.Levels, TileLevel{
+			Level:   level,
+			Width:   levelWidth,
+			Height:  levelHeight,
+			Columns: (levelWidth + TileSize - 1) / TileSize,
+			Rows:    (levelHeight + TileSize - 1) / TileSize,
+		})
+	}
+	return pyramid
+}
+
+// Scale returns how many full-resolution pixels one pixel of a level covers
+func (p *TilePyramid) Scale(level int) float64 {
+	return math.Exp2(float64(p.MaxLevel - level))
+}
+
+// TileBounds returns the rectangle of a tile in the pixel space of its level
+func (p *TilePyramid) TileBounds(level, x, y int) (image.Rectangle, error) {
+	if level < 0 || level > p.MaxLevel {
+		return image.Rectangle{}, ErrTileOutOfRange
+	}
+	l := p.Levels[level]
+	if x < 0 || y < 0 || x >= l.Columns || y >= l.Rows {
+		return image.Rectangle{}, ErrTileOutOfRange
+	}
+	return image.Rect(x*TileSize, y*TileSize, min((x+1)*TileSize, l.Width), min((y+1)*TileSize, l.Height)), nil
+}
+
+// TileKey returns the blob key of an image tile. Tiles are addressed by content
+// hash so images sharing content share their pyramid.
+func TileKey(contentHash string, level, x, y int) string {
+	return fmt.Sprintf("tiles/%s/%d/%d_%d.%s", contentHash, level, x, y, TileFormat)
+}
+
+func tilePyramidKey(contentHash string) string {
+	return "tiles/" + contentHash + "/pyramid.json"
+}
+
+// ImagePyramid returns the tile pyramid geometry of an image
+func ImagePyramid(img *storage.RetinalImage) (*TilePyramid, error) {
+	if img.Metadata != nil && img.Metadata.Width > 0 && img.Metadata.Height > 0 {
+		return NewTilePyramid(img.Metadata.Width, img.Metadata.Height), nil
+	}
+	width, height, err := imageDimensions(img.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	return NewTilePyramid(width, height), nil
+}
+
+// tileLocks serializes pyramid generation per content hash
+var tileLocks sync.Map
+
+// EnsureImageTile returns the key of an image tile, generating the pyramid if needed
+func EnsureImageTile(img *storage.RetinalImage, level, x, y int) (string, error) {
+	pyramid, err := ImagePyramid(img)
+	if err != nil {
+		return "", err
+	}
+	if _, err := pyramid.TileBounds(level, x, y); err != nil {
+		return "", err
+	}
+
+	key := TileKey(img.ContentHash, level, x, y)
+	if _, err := blobstore.Default().Stat(context.Background(), key); err == nil {
+		return key, nil
+	} else if err != blobstore.ErrNotExist {
+		return "", err
+	}
+
+	if err := GenerateTiles(img); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// GenerateTiles builds and stores the full tile pyramid of an image. The
+// pyramid description is written last and marks the pyramid as complete.
+func GenerateTiles(img *storage.RetinalImage) error {
+	lock, _ := tileLocks.LoadOrStore(img.ContentHash, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	store := blobstore.Default()
+	if _, err := store.Stat(context.Background(), tilePyramidKey(img.ContentHash)); err == nil {
+		return nil
+	}
+
+	src, err := decodeStoredImage(img.FilePath)
+	if err != nil {
+		return err
+	}
+	bounds := src.Bounds()
+	pyramid := NewTilePyramid(bounds.Dx(), bounds.Dy())
+
+	// Start from full resolution and halve each level from the one above
+	levelImage := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(levelImage, levelImage.Bounds(), src, bounds.Min, draw.Src)
+
+	for level := pyramid.MaxLevel; level >= 0; level-- {
+		l := pyramid.Levels[level]
+		if level != pyramid.MaxLevel {
+			next := image.NewRGBA(image.Rect(0, 0, l.Width, l.Height))
+			draw.BiLinear.Scale(next, next.Bounds(), levelImage, levelImage.Bounds(), draw.Src, nil)
+			levelImage = next
+		}
+
+		for y := 0; y < l.Rows; y++ {
+			for x := 0; x < l.Columns; x++ {
+				rect, _ := pyramid.TileBounds(level, x, y)
+				var buf bytes.Buffer
+				if err := jpeg.Encode(&buf, levelImage.SubImage(rect), &jpeg.Options{Quality: 85}); err != nil {
+					return fmt.Errorf("failed to encode tile: %v", err)
+				}
+				if err := store.Put(context.Background(), TileKey(img.ContentHash, level, x, y), &buf, int64(buf.Len()), "image/jpeg"); err != nil {
+					return fmt.Errorf("failed to store tile: %v", err)
+				}
+			}
+		}
+	}
+
+	description, err := json.Marshal(pyramid)
+	if err != nil {
+		return err
+	}
+	return store.Put(context.Background(), tilePyramidKey(img.ContentHash), bytes.NewReader(description), int64(len(description)), "application/json")
+}
+
+// DeleteTiles removes the stored tile pyramid of a content hash
+func DeleteTiles(contentHash string) {
+	store := blobstore.Default()
+	description, err := blobstore.ReadAll(context.Background(), store, tilePyramidKey(contentHash))
+	if err != nil {
+		return
+	}
+	var pyramid TilePyramid
+	if err := json.Unmarshal(description, &pyramid); err != nil {
+		return
+	}
+
+	for _, l := range pyramid.Levels {
+		for y := 0; y < l.Rows; y++ {
+			for x := 0; x < l.Columns; x++ {
+				if err := store.Delete(context.Background(), TileKey(contentHash, l.Level, x, y)); err != nil {
+					log.Printf("Failed to delete tile of %s: %v", contentHash, err)
+				}
+			}
+		}
+	}
+	store.Delete(context.Background(), tilePyramidKey(contentHash))
+}
+
+// lesionColors are the overlay colors per lesion type
+var lesionColors = map[string]color.RGBA{
+	"microaneurysm":      {R: 255, G: 59, B: 48, A: 255},
+	"hemorrhage":         {R: 175, G: 82, B: 222, A: 255},
+	"hard_exudate":       {R: 255, G: 214, B: 10, A: 255},
+	"soft_exudate":       {R: 52, G: 199, B: 89, A: 255},
+	"neovascularization": {R: 10, G: 132, B: 255, A: 255},
+}
+
+// RenderAnnotationTile draws lesion outlines of the given annotations onto a
+// transparent tile aligned with the image tile at the same position
+func RenderAnnotationTile(pyramid *TilePyramid, level, x, y int, annotations []*storage.Annotation) (image.Image, error) {
+	rect, err := pyramid.TileBounds(level, x, y)
+	if err != nil {
+		return nil, err
+	}
+	tile := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	scale := pyramid.Scale(level)
+
+	// Project full-resolution coordinates into tile pixels
+	project := func(p [2]float64) (float64, float64) {
+		return p[0]/scale - float64(rect.Min.X), p[1]/scale - float64(rect.Min.Y)
+	}
+
+	for _, annotation := range annotations {
+		for _, lesion := range annotation.Lesions {
+			c, ok := lesionColors[lesion.Type]
+			if !ok {
+				c = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			}
+
+			var outline [][2]float64
+			switch lesion.Shape {
+			case storage.ShapeBox:
+				if len(lesion.BBox) != 4 {
+					continue
+				}
+				bx, by, bw, bh := lesion.BBox[0], lesion.BBox[1], lesion.BBox[2], lesion.BBox[3]
+				outline = [][2]float64{{bx, by}, {bx + bw, by}, {bx + bw, by + bh}, {bx, by + bh}}
+			case storage.ShapePolygon:
+				outline = lesion.Points
+			case storage.ShapePoint:
+				for _, p := range lesion.Points {
+					px, py := project(p)
+					drawCircle(tile, px, py, 5, c)
+				}
+				continue
+			}
+
+			for i := range outline {
+				x0, y0 := project(outline[i])
+				x1, y1 := project(outline[(i+1)%len(outline)])
+				drawLine(tile, x0, y0, x1, y1, c)
+			}
+		}
+	}
+	return tile, nil
+}
+
+// RenderHeatmapTile colorizes the part of a heatmap covering an image tile.
+// Opacity scales with intensity so the tile can be layered over the image tile.
+func RenderHeatmapTile(pyramid *TilePyramid, level, x, y int, heatmapKey string, opacity float64, colormap string) (image.Image, error) {
+	colorize, ok := Colormaps[colormap]
+	if !ok {
+		return nil, fmt.Errorf("unsupported colormap: %s", colormap)
+	}
+	if opacity < 0 || opacity > 1 {
+		return nil, fmt.Errorf("opacity must be between 0 and 1")
+	}
+	rect, err := pyramid.TileBounds(level, x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	heatmap, err := decodeStoredImage(heatmapKey)
+	if err != nil {
+		return nil, err
+	}
+	gray := toGray(heatmap)
+	hb := gray.Bounds()
+	scaleX := float64(hb.Dx()) / float64(pyramid.Width)
+	scaleY := float64(hb.Dy()) / float64(pyramid.Height)
+	scale := pyramid.Scale(level)
+
+	tile := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	for ty := 0; ty < rect.Dy(); ty++ {
+		hy := (float64(rect.Min.Y+ty) + 0.5) * scale * scaleY
+		for tx := 0; tx < rect.Dx(); tx++ {
+			hx := (float64(rect.Min.X+tx) + 0.5) * scale * scaleX
+			intensity := bilinearGray(gray, hx-0.5, hy-0.5)
+			overlay := colorize(intensity)
+			tile.SetNRGBA(tx, ty, color.NRGBA{
+				R: overlay.R,
+				G: overlay.G,
+				B: overlay.B,
+				A: uint8(math.Round(255 * clamp01(opacity*intensity))),
+			})
+		}
+	}
+	return tile, nil
+}
+
+// bilinearGray samples a grayscale image at fractional coordinates, returning 0..1
+func bilinearGray(img *image.Gray, fx, fy float64) float64 {
+	b := img.Bounds()
+	fx = math.Max(0, math.Min(fx, float64(b.Dx()-1)))
+	fy = math.Max(0, math.Min(fy, float64(b.Dy()-1)))
+	x0, y0 := int(fx), int(fy)
+	x1, y1 := min(x0+1, b.Dx()-1), min(y0+1, b.Dy()-1)
+	wx, wy := fx-float64(x0), fy-float64(y0)
+
+	at := func(x, y int) float64 { return float64(img.GrayAt(x, y).Y) / 255 }
+	top := at(x0, y0)*(1-wx) + at(x1, y0)*wx
+	bottom := at(x0, y1)*(1-wx) + at(x1, y1)*wx
+	return top*(1-wy) + bottom*wy
+}
+
+// drawLine draws a 2px line, clipped to the tile
+func drawLine(img *image.RGBA, x0, y0, x1, y1 float64, c color.RGBA) {
+	steps := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	if steps == 0 {
+		steps = 1
+	}
+	// Skip lines that cannot touch the tile
+	b := img.Bounds()
+	if math.Max(x0, x1) < -2 || math.Min(x0, x1) > float64(b.Dx()+2) ||
+		math.Max(y0, y1) < -2 || math.Min(y0, y1) > float64(b.Dy()+2) {
+		return
+	}
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		x := int(math.Round(x0 + (x1-x0)*t))
+		y := int(math.Round(y0 + (y1-y0)*t))
+		img.SetRGBA(x, y, c)
+		img.SetRGBA(x+1, y, c)
+		img.SetRGBA(x, y+1, c)
+		img.SetRGBA(x+1, y+1, c)
+	}
+}
+
+// drawCircle draws a circle outline of the given radius in tile pixels
+func drawCircle(img *image.RGBA, cx, cy, radius float64, c color.RGBA) {
+	const segments = 24
+	for i := 0; i < segments; i++ {
+		a0 := 2 * math.Pi * float64(i) / segments
+		a1 := 2 * math.Pi * float64(i+1) / segments
+		drawLine(img, cx+radius*math.Cos(a0), cy+radius*math.Sin(a0), cx+radius*math.Cos(a1), cy+radius*math.Sin(a1), c)
+	}
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/storage"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrTokenRevoked        = errors.New("token has been revoked")
+	ErrRefreshTokenInvalid = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used; all sessions from this login have been signed out")
+)
+
+// TokenPair is a short-lived access token with the refresh token that renews it
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// AccessTokenExpiry returns the lifetime of access tokens
+func AccessTokenExpiry() time.Duration {
+	expiry, err := time.ParseDuration(config.AppConfig.JWT.Expiry)
+	if err != nil || expiry <= 0 {
+		return 15 * time.Minute
+	}
+	return expiry
+}
+
+// RefreshTokenExpiry returns the lifetime of refresh tokens
+func RefreshTokenExpiry() time.Duration {
+	expiry, err := time.ParseDuration(config.AppConfig.JWT.RefreshExpiry)
+	if err != nil || expiry <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return expiry
+}
+
+// IssueTokens starts a new session for the user with a fresh refresh token family
+func IssueTokens(user *storage.User) (*TokenPair, error) {
+	return issueTokens(user, uuid.New())
+}
+
+// RefreshTokens exchanges a refresh token for a new token pair. Each refresh
+// token can be used once; presenting a used token revokes its whole family,
+// since either the client or an attacker holds a stolen copy.
+func RefreshTokens(refreshToken string) (*TokenPair, *storage.User, error) {
+	token, err := storage.GlobalStorage.ConsumeRefreshToken(hashToken(refreshToken))
+	if errors.Is(err, storage.ErrConflict) {
+		storage.GlobalStorage.RevokeRefreshTokenFamily(token.FamilyID)
+		log.Printf("⚠️  Refresh token reuse detected for user %s, revoked token family %s", token.UserID, token.FamilyID)
+		return nil, nil, ErrRefreshTokenReused
+	}
+	if err != nil || time.Now().After(token.ExpiresAt) {
+		return nil, nil, ErrRefreshTokenInvalid
+	}
+
+	user, err := storage.GlobalStorage.GetUserByID(token.UserID)
+	if err != nil {
+		return nil, nil, ErrRefreshTokenInvalid
+	}
+
+	pair, err := issueTokens(user, token.FamilyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pair, user, nil
+}
+
+// ParseAccessToken verifies an access token and checks it against the
+// revocation list
+func ParseAccessToken(tokenString string) (*jwt.RegisteredClaims, error) {
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	if claims.ID == "" {
+		return nil, ErrInvalidToken
+	}
+	if storage.GlobalStorage.IsAccessTokenRevoked(claims.ID) {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// Logout revokes the current access token and its session. A refresh token
+// from a different session of the same user may be given to end that one
+// instead. With all set, every session of the user is signed out.
+func Logout(userID uuid.UUID, accessTokenID string, accessExpiresAt time.Time, refreshToken string, all bool) {
+	storage.GlobalStorage.RevokeAccessToken(accessTokenID, accessExpiresAt)
+
+	if all {
+		storage.GlobalStorage.RevokeUserRefreshTokens(userID)
+		return
+	}
+
+	var token *storage.RefreshToken
+	var err error
+	if refreshToken != "" {
+		token, err = storage.GlobalStorage.GetRefreshTokenByHash(hashToken(refreshToken))
+	} else {
+		token, err = storage.GlobalStorage.GetRefreshTokenByAccessTokenID(accessTokenID)
+	}
+	if err == nil && token.UserID == userID {
+		storage.GlobalStorage.RevokeRefreshTokenFamily(token.FamilyID)
+	}
+}
+
+// StartTokenJanitor periodically drops expired refresh tokens, revocation
+// entries and stale login failure counts in the background
+func StartTokenJanitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			now := time.Now()
+			storage.GlobalStorage.PruneTokens(now)
+			storage.GlobalStorage.PruneLoginThrottles(now, max(LoginFailureWindow(), AccountEmailWindow()))
+		}
+	}()
+}
+
+func issueTokens(user *storage.User, familyID uuid.UUID) (*TokenPair, error) {
+	if user.DeactivatedAt != nil {
+		return nil, ErrAccountDeactivated
+	}
+
+	now := time.Now()
+	pair := &TokenPair{
+		AccessExpiresAt:  now.Add(AccessTokenExpiry()),
+		RefreshExpiresAt: now.Add(RefreshTokenExpiry()),
+	}
+
+	accessTokenID := uuid.New().String()
+	claims := jwt.RegisteredClaims{
+		ID:        accessTokenID,
+		Subject:   user.ID.String(),
+		ExpiresAt: jwt.NewNumericDate(pair.AccessExpiresAt),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+	}
+	accessToken, err := signToken(claims)
+	if err != nil {
+		return nil, err
+	}
+	pair.AccessToken = accessToken
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	pair.RefreshToken = base64.RawURLEncoding.EncodeToString(secret)
+
+	if err := storage.GlobalStorage.CreateRefreshToken(&storage.RefreshToken{
+		FamilyID:        familyID,
+		UserID:          user.ID,
+		TokenHash:       hashToken(pair.RefreshToken),
+		AccessTokenID:   accessTokenID,
+		AccessExpiresAt: pair.AccessExpiresAt,
+		ExpiresAt:       pair.RefreshExpiresAt,
+	}); err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/storage"
+)
+
+func TestRefreshTokenReuseRevokesTheFamily(t *testing.T) {
+	useJWTConfig(t, config.JWTConfig{Algorithm: "HS256", Secret: "test-secret"})
+	if err := InitializeSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+	user := &storage.User{Email: t.Name() + "@example.com", Role: "patient"}
+	if err := storage.GlobalStorage.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := IssueTokens(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := IssueTokens(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, refreshed, err := RefreshTokens(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.ID != user.ID {
+		t.Fatalf("refreshed user %s, want %s", refreshed.ID, user.ID)
+	}
+
+	// An attacker replays the first refresh token
+	if _, _, err := RefreshTokens(first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reused token: err = %v, want %v", err, ErrRefreshTokenReused)
+	}
+
+	tests := []struct {
+		name  string
+		check func() error
+		want  error
+	}{
+		{
+			name:  "refresh token issued after the reused one",
+			check: func() error { _, _, err := RefreshTokens(second.RefreshToken); return err },
+			want:  ErrRefreshTokenInvalid,
+		},
+		{
+			name:  "access token issued after the reused one",
+			check: func() error { _, err := ParseAccessToken(second.AccessToken); return err },
+			want:  ErrTokenRevoked,
+		},
+		{
+			name:  "access token of the reused one",
+			check: func() error { _, err := ParseAccessToken(first.AccessToken); return err },
+			want:  ErrTokenRevoked,
+		},
+		{
+			name:  "other session",
+			check: func() error { _, err := ParseAccessToken(other.AccessToken); return err },
+		},
+		{
+			name:  "unknown refresh token",
+			check: func() error { _, _, err := RefreshTokens("unknown"); return err },
+			want:  ErrRefreshTokenInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.check(); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// The other session still refreshes, once
+	if _, _, err := RefreshTokens(other.RefreshToken); err != nil {
+		t.Fatalf("other session: %v", err)
+	}
+	if _, _, err := RefreshTokens(other.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("other session reused: err = %v", err)
+	}
+}
+
+func TestExpiredRefreshTokenIsRejected(t *testing.T) {
+	useJWTConfig(t, config.JWTConfig{Algorithm: "HS256", Secret: "test-secret", RefreshExpiry: "1ns"})
+	if err := InitializeSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+	user := &storage.User{Email: t.Name() + "@example.com", Role: "patient"}
+	if err := storage.GlobalStorage.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	pair, err := IssueTokens(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, _, err := RefreshTokens(pair.RefreshToken); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("err = %v, want %v", err, ErrRefreshTokenInvalid)
+	}
+}
+
+func TestRefreshTokensRotate(t *testing.T) {
+	useJWTConfig(t, config.JWTConfig{Algorithm: "HS256", Secret: "test-secret"})
+	if err := InitializeSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+	user := &storage.User{Email: t.Name() + "@example.com", Role: RolePatient}
+	if err := storage.GlobalStorage.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := IssueTokens(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := RefreshTokens(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh returned the same refresh token")
+	}
+	if second.AccessToken == first.AccessToken {
+		t.Fatal("refresh returned the same access token")
+	}
+	if _, err := ParseAccessToken(second.AccessToken); err != nil {
+		t.Fatalf("rotated access token: %v", err)
+	}
+
+	// The rotated token refreshes once more; the first one is spent
+	third, _, err := RefreshTokens(second.RefreshToken)
+	if err != nil {
+		t.Fatalf("rotated refresh token: %v", err)
+	}
+	if _, _, err := RefreshTokens(first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("spent token: err = %v, want %v", err, ErrRefreshTokenReused)
+	}
+
+	// Reuse ends the whole family, including the latest pair
+	if _, _, err := RefreshTokens(third.RefreshToken); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("latest refresh token: err = %v, want %v", err, ErrRefreshTokenInvalid)
+	}
+	if _, err := ParseAccessToken(third.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("latest access token: err = %v, want %v", err, ErrTokenRevoked)
+	}
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	useJWTConfig(t, config.JWTConfig{Algorithm: "HS256", Secret: "test-secret"})
+	if err := InitializeSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name             string
+		all              bool
+		otherSessionKept bool
+	}{
+		{name: "current session", otherSessionKept: true},
+		{name: "all sessions", all: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &storage.User{Email: t.Name() + "@example.com", Role: RolePatient}
+			if err := storage.GlobalStorage.CreateUser(user); err != nil {
+				t.Fatal(err)
+			}
+			current, err := IssueTokens(user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, err := IssueTokens(user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := ParseAccessToken(current.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			Logout(user.ID, claims.ID, claims.ExpiresAt.Time, "", tt.all)
+
+			if _, err := ParseAccessToken(current.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+				t.Errorf("access token: err = %v, want %v", err, ErrTokenRevoked)
+			}
+			if _, _, err := RefreshTokens(current.RefreshToken); !errors.Is(err, ErrRefreshTokenInvalid) {
+				t.Errorf("refresh token: err = %v, want %v", err, ErrRefreshTokenInvalid)
+			}
+
+			_, _, err = RefreshTokens(other.RefreshToken)
+			if tt.otherSessionKept && err != nil {
+				t.Errorf("other session: %v", err)
+			}
+			if !tt.otherSessionKept && !errors.Is(err, ErrRefreshTokenInvalid) {
+				t.Errorf("other session: err = %v, want %v", err, ErrRefreshTokenInvalid)
+			}
+		})
+	}
+}
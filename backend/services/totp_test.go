@@ -0,0 +1,73 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed from RFC 6238 appendix B, in base32
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// RFC 6238 appendix B lists eight-digit codes; six-digit codes are their
+	// last six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		step := tt.unix / int64(totpPeriod.Seconds())
+		if got := totpCode(key, step); got != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+		if _, ok := validateTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0)); !ok {
+			t.Errorf("code %s rejected at %d", tt.code, tt.unix)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	issued := time.Unix(1111111111, 0)
+	const code = "050471"
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		now    time.Time
+		valid  bool
+	}{
+		{"current step", rfc6238Secret, code, issued, true},
+		{"lower-case secret", strings.ToLower(rfc6238Secret), code, issued, true},
+		{"one step late", rfc6238Secret, code, issued.Add(totpPeriod), true},
+		{"one step early", rfc6238Secret, code, issued.Add(-totpPeriod), true},
+		{"two steps late", rfc6238Secret, code, issued.Add(2 * totpPeriod), false},
+		{"two steps early", rfc6238Secret, code, issued.Add(-2 * totpPeriod), false},
+		{"wrong code", rfc6238Secret, "050472", issued, false},
+		{"eight digits", rfc6238Secret, "14050471", issued, false},
+		{"too short", rfc6238Secret, "05047", issued, false},
+		{"empty", rfc6238Secret, "", issued, false},
+		{"secret not base32", "not base32!", code, issued, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, valid := validateTOTP(tt.secret, tt.code, tt.now); valid != tt.valid {
+				t.Errorf("valid = %v, want %v", valid, tt.valid)
+			}
+		})
+	}
+}
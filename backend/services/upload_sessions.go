@@ -0,0 +1,99 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"dr-mario-backend/blobstore"
+	"dr-mario-backend/config"
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+)
+
+// UploadSessionExpiry returns how long an idle upload session is kept
+func UploadSessionExpiry() time.Duration {
+	expiry, err := time.ParseDuration(config.AppConfig.Upload.SessionExpiry)
+	if err != nil || expiry <= 0 {
+		return 24 * time.Hour
+	}
+	return expiry
+}
+
+// WriteUploadChunk stores a chunk received at offset and advances the session.
+// Each chunk is a separate object so any blob store backend can hold partial uploads.
+func WriteUploadChunk(session *storage.UploadSession, offset int64, data []byte) (*storage.UploadSession, error) {
+	key := fmt.Sprintf("uploads/%s/%020d-%s", session.ID, offset, uuid.New())
+	store := blobstore.Default()
+	if err := store.Put(context.Background(), key, bytes.NewReader(data), int64(len(data)), "application/octet-stream"); err != nil {
+		return nil, err
+	}
+
+	updated, err := storage.GlobalStorage.AppendUploadChunk(session.ID, storage.UploadChunk{
+		Offset: offset,
+		Size:   int64(len(data)),
+		Key:    key,
+	}, time.Now().Add(UploadSessionExpiry()))
+	if err != nil {
+		store.Delete(context.Background(), key)
+		return nil, err
+	}
+	return updated, nil
+}
+
+// AssembleUpload concatenates the chunks of a complete upload
+func AssembleUpload(session *storage.UploadSession) ([]byte, error) {
+	if session.Offset != session.Length {
+		return nil, fmt.Errorf("upload is incomplete: %d of %d bytes received", session.Offset, session.Length)
+	}
+
+	data := make([]byte, 0, session.Length)
+	for _, chunk := range session.Chunks {
+		part, err := blobstore.ReadAll(context.Background(), blobstore.Default(), chunk.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upload chunk: %v", err)
+		}
+		if int64(len(part)) != chunk.Size {
+			return nil, fmt.Errorf("upload chunk at offset %d is corrupted", chunk.Offset)
+		}
+		data = append(data, part...)
+	}
+	return data, nil
+}
+
+// DiscardUploadChunks deletes the stored chunks of a session
+func DiscardUploadChunks(session *storage.UploadSession) {
+	for _, chunk := range session.Chunks {
+		if err := blobstore.Default().Delete(context.Background(), chunk.Key); err != nil {
+			log.Printf("Failed to delete upload chunk %s: %v", chunk.Key, err)
+		}
+	}
+	session.Chunks = nil
+}
+
+// ExpireUploadSessions removes abandoned and completed sessions past their
+// expiry together with their chunks. It returns the number removed.
+func ExpireUploadSessions() int {
+	expired := storage.GlobalStorage.GetExpiredUploadSessions(time.Now())
+	for _, session := range expired {
+		DiscardUploadChunks(session)
+		storage.GlobalStorage.DeleteUploadSession(session.ID)
+	}
+	return len(expired)
+}
+
+// StartUploadSessionJanitor periodically expires upload sessions in the background
+func StartUploadSessionJanitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if removed := ExpireUploadSessions(); removed > 0 {
+				log.Printf("🧹 Expired %d upload sessions", removed)
+			}
+		}
+	}()
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"dr-mario-backend/config"
+	"dr-mario-backend/storage"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrAccountDeactivated    = errors.New("this account has been deactivated")
+	ErrLastAdmin             = errors.New("the last active admin cannot be demoted or deactivated")
+	ErrCannotModifySelf      = errors.New("admins cannot change their own role or deactivate themselves")
+	ErrBootstrapUnavailable  = errors.New("an admin already exists; ask an admin to grant the role instead")
+	ErrBootstrapTokenInvalid = errors.New("invalid bootstrap token")
+)
+
+// Roles that users can hold. What a role may do is decided by the permissions
+// granted to it; the names only select the profile a user needs.
+const (
+	RolePatient = "patient"
+	RoleDoctor  = "doctor"
+	RoleAdmin   = "admin"
+)
+
+var userRoles = []string{RolePatient, RoleDoctor, RoleAdmin}
+
+// adminBootstrap holds the one-time token for creating the first admin
+var adminBootstrap struct {
+	mu    sync.Mutex
+	token string
+}
+
+// InitializeAdminBootstrap prepares first-run admin creation. While no admin
+// exists, the token from ADMIN_BOOTSTRAP_TOKEN, or a random one written to
+// the log, lets `dr-mario-backend create-admin` create one.
+func InitializeAdminBootstrap() error {
+	if storage.GlobalStorage.CountActiveUsersWithRole(RoleAdmin) > 0 {
+		return nil
+	}
+
+	token := config.AppConfig.Account.AdminBootstrapToken
+	if token == "" {
+		secret := make([]byte, 24)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		token = base64.RawURLEncoding.EncodeToString(secret)
+		log.Printf("👑 No admin account exists. Create one with:\n    dr-mario-backend create-admin -bootstrap-token %s -email <email>", token)
+	} else {
+		log.Printf("👑 No admin account exists. Create one with create-admin and ADMIN_BOOTSTRAP_TOKEN")
+	}
+
+	adminBootstrap.mu.Lock()
+	adminBootstrap.token = token
+	adminBootstrap.mu.Unlock()
+	return nil
+}
+
+// BootstrapAdmin creates the first admin account with the bootstrap token.
+// The token stops working once an admin exists.
+func BootstrapAdmin(token string, user *storage.User, password string) error {
+	adminBootstrap.mu.Lock()
+	defer adminBootstrap.mu.Unlock()
+
+	if adminBootstrap.token == "" || storage.GlobalStorage.CountActiveUsersWithRole(RoleAdmin) > 0 {
+		return ErrBootstrapUnavailable
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminBootstrap.token)) != 1 {
+		return ErrBootstrapTokenInvalid
+	}
+	if _, err := storage.GlobalStorage.GetUserByEmail(user.Email); err == nil {
+		return storage.ErrConflict
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	user.Password = string(hashedPassword)
+	user.Role = RoleAdmin
+	user.EmailVerifiedAt = &now // set up by the operator, not self-registered
+	if err := storage.GlobalStorage.CreateUser(user); err != nil {
+		return err
+	}
+
+	adminBootstrap.token = ""
+	recordSecurityEvent(&storage.SecurityEvent{
+		Type:    storage.SecurityEventAdminBootstrap,
+		UserID:  user.ID,
+		ActorID: user.ID,
+		Email:   user.Email,
+		Details: "First admin account created with the bootstrap token",
+	})
+	return nil
+}
+
+// ValidRole reports whether role is one users can hold
+func ValidRole(role string) bool {
+	for _, r := range userRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangeUserRole moves a user to another role and creates the profile the
+// new role needs. Existing profiles are kept so a change can be undone.
+func ChangeUserRole(user *storage.User, role string, changedBy uuid.UUID) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	if user.Role == role {
+		return nil
+	}
+	if user.ID == changedBy {
+		return ErrCannotModifySelf
+	}
+	if isLastActiveAdmin(user) {
+		return ErrLastAdmin
+	}
+
+	previous := user.Role
+	user.Role = role
+	if err := storage.GlobalStorage.UpdateUser(user); err != nil {
+		return err
+	}
+	if err := ensureRoleProfile(user); err != nil {
+		return err
+	}
+
+	recordSecurityEvent(&storage.SecurityEvent{
+		Type:    storage.SecurityEventRoleChanged,
+		UserID:  user.ID,
+		ActorID: changedBy,
+		Email:   user.Email,
+		Details: fmt.Sprintf("Role changed from %s to %s", previous, role),
+	})
+	return nil
+}
+
+// DeactivateUser blocks sign-in and ends every session of the user
+func DeactivateUser(user *storage.User, reason string, deactivatedBy uuid.UUID) error {
+	if user.ID == deactivatedBy {
+		return ErrCannotModifySelf
+	}
+	if user.DeactivatedAt != nil {
+		return nil
+	}
+	if isLastActiveAdmin(user) {
+		return ErrLastAdmin
+	}
+
+	now := time.Now()
+	user.DeactivatedAt = &now
+	if err := storage.GlobalStorage.UpdateUser(user); err != nil {
+		return err
+	}
+	storage.GlobalStorage.RevokeUserRefreshTokens(user.ID)
+
+	recordSecurityEvent(&storage.SecurityEvent{
+		Type:    storage.SecurityEventDeactivated,
+		UserID:  user.ID,
+		ActorID: deactivatedBy,
+		Email:   user.Email,
+		Details: "Account deactivated: " + reason,
+	})
+	return nil
+}
+
+// ReactivateUser lets a deactivated user sign in again
+func ReactivateUser(user *storage.User, reactivatedBy uuid.UUID) error {
+	if user.DeactivatedAt == nil {
+		return nil
+	}
+
+	user.DeactivatedAt = nil
+	if err := storage.GlobalStorage.UpdateUser(user); err != nil {
+		return err
+	}
+
+	recordSecurityEvent(&storage.SecurityEvent{
+		Type:    storage.SecurityEventReactivated,
+		UserID:  user.ID,
+		ActorID: reactivatedBy,
+		Email:   user.Email,
+		Details: "Account reactivated",
+	})
+	return nil
+}
+
+// ResetUserMFA removes a user's authenticator and backup codes, for users who
+// lost both, and ends their sessions. Roles that require MFA must enroll again
+// at their next sign-in.
+func ResetUserMFA(user *storage.User, resetBy uuid.UUID) error {
+	if err := storage.GlobalStorage.DeleteMFAEnrollment(user.ID); err != nil {
+		return ErrMFANotEnrolled
+	}
+	storage.GlobalStorage.RevokeUserRefreshTokens(user.ID)
+
+	recordSecurityEvent(&storage.SecurityEvent{
+		Type:    storage.SecurityEventMFAReset,
+		UserID:  user.ID,
+		ActorID: resetBy,
+		Email:   user.Email,
+		Details: "Multi-factor authentication reset by an administrator",
+	})
+	return nil
+}
+
+func isLastActiveAdmin(user *storage.User) bool {
+	return user.Role == RoleAdmin && user.DeactivatedAt == nil &&
+		storage.GlobalStorage.CountActiveUsersWithRole(RoleAdmin) <= 1
+}
+
+// ensureRoleProfile creates the patient or doctor profile a role needs
+func ensureRoleProfile(user *storage.User) error {
+	switch user.Role {
+	case RolePatient:
+		if _, err := storage.GlobalStorage.GetPatientByUserID(user.ID); err != nil {
+			return storage.GlobalStorage.CreatePatient(&storage.Patient{UserID: user.ID})
+		}
+	case RoleDoctor:
+		if _, err := storage.GlobalStorage.GetDoctorByUserID(user.ID); err != nil {
+			return storage.GlobalStorage.CreateDoctor(&storage.Doctor{UserID: user.ID})
+		}
+	}
+	return nil
+}
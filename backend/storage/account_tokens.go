@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Account token purposes
+const (
+	AccountTokenEmailVerification = "email_verification"
+	AccountTokenPasswordReset     = "password_reset"
+)
+
+// AccountToken is a single-use token sent by email to verify an address or
+// reset a password. Only the SHA-256 of the token is stored.
+type AccountToken struct {
+	TokenHash string     `json:"-"`
+	UserID    uuid.UUID  `json:"user_id"`
+	Purpose   string     `json:"purpose"`
+	Email     string     `json:"email"` // address the token was sent to
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// CreateAccountToken stores a new token. Earlier unused tokens of the same
+// user and purpose stop working, so only the most recent email is valid.
+func (s *Storage) CreateAccountToken(token *AccountToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, existing := range s.accountTokens {
+		if existing.UserID == token.UserID && existing.Purpose == token.Purpose && existing.UsedAt == nil {
+			delete(s.accountTokens, hash)
+		}
+	}
+
+	token.CreatedAt = time.Now()
+	s.accountTokens[token.TokenHash] = token
+	return nil
+}
+
+// ConsumeAccountToken marks a token as used and returns it. It returns
+// ErrNotFound for unknown tokens or tokens of another purpose, and
+// ErrConflict if the token was already used.
+func (s *Storage) ConsumeAccountToken(hash, purpose string) (*AccountToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, exists := s.accountTokens[hash]
+	if !exists || token.Purpose != purpose {
+		return nil, ErrNotFound
+	}
+	if token.UsedAt != nil {
+		return token, ErrConflict
+	}
+
+	now := time.Now()
+	token.UsedAt = &now
+	return token, nil
+}
+
+// MarkEmailVerified records that the user controls their email address
+func (s *Storage) MarkEmailVerified(userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return ErrNotFound
+	}
+	if user.EmailVerifiedAt == nil {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		user.UpdatedAt = now
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Lesion geometry shapes
+const (
+	ShapeBox     = "box"
+	ShapePolygon = "polygon"
+	ShapePoint   = "point"
+)
+
+// Annotation sources
+const (
+	AnnotationSourceCNN       = "cnn"
+	AnnotationSourceClinician = "clinician"
+)
+
+// Lesion represents a single lesion geometry on a retinal image.
+// Coordinates are in pixels of the original image.
+type Lesion struct {
+	Type       string       `json:"type"`
+	Shape      string       `json:"shape"`
+	BBox       []float64    `json:"bbox,omitempty"`   // [x, y, width, height] for boxes
+	Points     [][2]float64 `json:"points,omitempty"` // vertices for polygons, single entry for points
+	Confidence float64      `json:"confidence"`
+	Notes      string       `json:"notes,omitempty"`
+}
+
+// Annotation represents one version of an author's lesion annotations on an image
+type Annotation struct {
+	ID           uuid.UUID `json:"id"`
+	ImageID      uuid.UUID `json:"image_id"`
+	Source       string    `json:"source"`
+	AuthorID     uuid.UUID `json:"author_id"`
+	ModelVersion string    `json:"model_version,omitempty"`
+	Version      int       `json:"version"`
+	IsCurrent    bool      `json:"is_current"`
+	Lesions      []Lesion  `json:"lesions"`
+	Notes        string    `json:"notes"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// sameAuthor reports whether two annotations belong to the same version chain
+func (a *Annotation) sameAuthor(other *Annotation) bool {
+	return a.ImageID == other.ImageID && a.Source == other.Source && a.AuthorID == other.AuthorID
+}
+
+// Annotation operations
+
+// CreateAnnotation stores a new annotation version. Any current annotation from
+// the same author on the same image is superseded and the version is bumped.
+func (s *Storage) CreateAnnotation(annotation *Annotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.images[annotation.ImageID]; !exists {
+		return ErrNotFound
+	}
+
+	version := 0
+	for _, existing := range s.annotations {
+		if !existing.sameAuthor(annotation) {
+			continue
+		}
+		if existing.Version > version {
+			version = existing.Version
+		}
+		if existing.IsCurrent {
+			existing.IsCurrent = false
+			existing.UpdatedAt = time.Now()
+		}
+	}
+
+	annotation.ID = uuid.New()
+	annotation.Version = version + 1
+	annotation.IsCurrent = true
+	annotation.CreatedAt = time.Now()
+	annotation.UpdatedAt = time.Now()
+
+	s.annotations[annotation.ID] = annotation
+	return nil
+}
+
+func (s *Storage) GetAnnotationByID(id uuid.UUID) (*Annotation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	annotation, exists := s.annotations[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return annotation, nil
+}
+
+// GetAnnotationsByImageID returns annotations for an image ordered by creation
+// time. When includeHistory is false only current versions are returned.
+func (s *Storage) GetAnnotationsByImageID(imageID uuid.UUID, includeHistory bool) ([]*Annotation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var annotations []*Annotation
+	for _, annotation := range s.annotations {
+		if annotation.ImageID != imageID {
+			continue
+		}
+		if !includeHistory && !annotation.IsCurrent {
+			continue
+		}
+		annotations = append(annotations, annotation)
+	}
+
+	sort.Slice(annotations, func(i, j int) bool {
+		return annotations[i].CreatedAt.Before(annotations[j].CreatedAt)
+	})
+	return annotations, nil
+}
+
+// GetAnnotationHistory returns every version in the chain the annotation belongs to
+func (s *Storage) GetAnnotationHistory(id uuid.UUID) ([]*Annotation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	annotation, exists := s.annotations[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	var history []*Annotation
+	for _, existing := range s.annotations {
+		if existing.sameAuthor(annotation) {
+			history = append(history, existing)
+		}
+	}
+
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Version < history[j].Version
+	})
+	return history, nil
+}
+
+// DeleteAnnotation removes an annotation version. If it was the current
+// version, the previous version of the same author becomes current again.
+func (s *Storage) DeleteAnnotation(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	annotation, exists := s.annotations[id]
+	if !exists {
+		return ErrNotFound
+	}
+	delete(s.annotations, id)
+
+	if !annotation.IsCurrent {
+		return nil
+	}
+
+	var previous *Annotation
+	for _, existing := range s.annotations {
+		if existing.sameAuthor(annotation) && (previous == nil || existing.Version > previous.Version) {
+			previous = existing
+		}
+	}
+	if previous != nil {
+		previous.IsCurrent = true
+		previous.UpdatedAt = time.Now()
+	}
+	return nil
+}
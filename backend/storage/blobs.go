@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Blob represents a content-addressed file shared by one or more images
+type Blob struct {
+	Hash      string    `json:"hash"`
+	Key       string    `json:"key"`
+	Size      int64     `json:"size"`
+	RefCount  int       `json:"ref_count"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Blob operations
+
+// RetainBlob registers a reference to a blob, creating it on first use
+func (s *Storage) RetainBlob(blob *Blob) (*Blob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, exists := s.blobs[blob.Hash]; exists {
+		existing.RefCount++
+		existing.UpdatedAt = time.Now()
+		return existing, nil
+	}
+
+	blob.RefCount = 1
+	blob.CreatedAt = time.Now()
+	blob.UpdatedAt = time.Now()
+	s.blobs[blob.Hash] = blob
+	return blob, nil
+}
+
+// ReleaseBlob drops a reference to a blob. It returns the blob and whether
+// this was the last reference, in which case the blob record is removed and
+// the caller is responsible for deleting the stored object.
+func (s *Storage) ReleaseBlob(hash string) (*Blob, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, exists := s.blobs[hash]
+	if !exists {
+		return nil, false, ErrNotFound
+	}
+
+	blob.RefCount--
+	blob.UpdatedAt = time.Now()
+	if blob.RefCount > 0 {
+		return blob, false, nil
+	}
+
+	delete(s.blobs, hash)
+	return blob, true, nil
+}
+
+func (s *Storage) GetBlob(hash string) (*Blob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blob, exists := s.blobs[hash]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return blob, nil
+}
+
+// FindPatientImageByHash returns the patient's existing image with identical content
+func (s *Storage) FindPatientImageByHash(patientID uuid.UUID, hash string) (*RetinalImage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, image := range s.images {
+		if image.PatientID == patientID && image.ContentHash == hash && image.Deletion == nil {
+			return image, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// FindCachedDetectionResult returns an earlier CNN result computed on identical
+// image content, requested from the same model version with the same
+// preprocessing steps
+func (s *Storage) FindCachedDetectionResult(hash, modelVersion string, steps []string) (*DetectionResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if hash == "" {
+		return nil, ErrNotFound
+	}
+
+	for _, result := range s.detectionResults {
+		if result.Retraction != nil || result.RequestedModel == "" || result.RequestedModel != modelVersion || !equalSteps(result.PreprocessingSteps, steps) {
+			continue
+		}
+		image, exists := s.images[result.ImageID]
+		if exists && image.ContentHash == hash {
+			return result, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func equalSteps(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestFindCachedDetectionResult(t *testing.T) {
+	steps := []string{"crop", "clahe"}
+	tests := []struct {
+		name   string
+		result DetectionResult
+		lookup []string
+		found  bool
+	}{
+		{
+			name:   "model reports a different version",
+			result: DetectionResult{ModelVersion: "v2.1.3", RequestedModel: "v2.1.0", PreprocessingSteps: steps},
+			lookup: steps,
+			found:  true,
+		},
+		{
+			name:   "different preprocessing",
+			result: DetectionResult{ModelVersion: "v2.1.0", RequestedModel: "v2.1.0", PreprocessingSteps: []string{"crop"}},
+			lookup: steps,
+		},
+		{
+			name:   "not from the CNN",
+			result: DetectionResult{ModelVersion: "v2.1.0", PreprocessingSteps: steps},
+			lookup: steps,
+		},
+		{
+			name:   "retracted",
+			result: DetectionResult{ModelVersion: "v2.1.0", RequestedModel: "v2.1.0", PreprocessingSteps: steps, Retraction: &Retraction{}},
+			lookup: steps,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash := uuid.NewString()
+			image := &RetinalImage{ContentHash: hash}
+			if err := GlobalStorage.CreateImage(image); err != nil {
+				t.Fatal(err)
+			}
+			result := tt.result
+			result.ImageID = image.ID
+			if err := GlobalStorage.CreateDetectionResult(&result); err != nil {
+				t.Fatal(err)
+			}
+
+			cached, err := GlobalStorage.FindCachedDetectionResult(hash, "v2.1.0", tt.lookup)
+			if found := err == nil; found != tt.found {
+				t.Fatalf("found = %v, want %v", found, tt.found)
+			}
+			if tt.found && cached.ID != result.ID {
+				t.Errorf("found result %s, want %s", cached.ID, result.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// How a care relationship came about
+const (
+	CareSourceAppointment = "appointment"
+	CareSourceReferral    = "referral"
+	CareSourceAdmin       = "admin"
+	CareSourceImport      = "import"
+	CareSourceEmergency   = "emergency"
+)
+
+// CareRelationship puts a patient in a doctor's care between StartsAt and
+// EndsAt (open-ended when nil). Doctors can only access patients they have an
+// active relationship with. SourceID is the appointment, referring doctor or
+// emergency access.
+type CareRelationship struct {
+	ID        uuid.UUID  `json:"id"`
+	DoctorID  uuid.UUID  `json:"doctor_id"`
+	Doctor    *Doctor    `json:"doctor,omitempty"`
+	PatientID uuid.UUID  `json:"patient_id"`
+	Patient   *Patient   `json:"patient,omitempty"`
+	Source    string     `json:"source"`
+	SourceID  uuid.UUID  `json:"source_id"`
+	Notes     string     `json:"notes,omitempty"`
+	StartsAt  time.Time  `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	CreatedBy uuid.UUID  `json:"created_by"`
+	EndedBy   uuid.UUID  `json:"ended_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// ActiveAt reports whether the relationship grants access at t
+func (r *CareRelationship) ActiveAt(t time.Time) bool {
+	return !t.Before(r.StartsAt) && (r.EndsAt == nil || t.Before(*r.EndsAt))
+}
+
+// CareRelationshipFilter selects care relationships; zero fields match
+// everything. ActiveAt keeps only relationships active at that time.
+type CareRelationshipFilter struct {
+	DoctorID  uuid.UUID
+	PatientID uuid.UUID
+	ActiveAt  time.Time
+}
+
+// Care relationship operations
+func (s *Storage) CreateCareRelationship(relationship *CareRelationship) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	relationship.ID = uuid.New()
+	relationship.CreatedAt = time.Now()
+	relationship.UpdatedAt = time.Now()
+
+	s.careRelationships[relationship.ID] = relationship
+	return nil
+}
+
+func (s *Storage) GetCareRelationship(id uuid.UUID) (*CareRelationship, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	relationship, exists := s.careRelationships[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	s.loadCareRelationship(relationship)
+	return relationship, nil
+}
+
+// GetCareRelationships returns matching relationships, newest first
+func (s *Storage) GetCareRelationships(filter CareRelationshipFilter) []*CareRelationship {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	relationships := []*CareRelationship{}
+	for _, relationship := range s.careRelationships {
+		if filter.DoctorID != uuid.Nil && relationship.DoctorID != filter.DoctorID {
+			continue
+		}
+		if filter.PatientID != uuid.Nil && relationship.PatientID != filter.PatientID {
+			continue
+		}
+		if !filter.ActiveAt.IsZero() && !relationship.ActiveAt(filter.ActiveAt) {
+			continue
+		}
+		s.loadCareRelationship(relationship)
+		relationships = append(relationships, relationship)
+	}
+
+	sort.Slice(relationships, func(i, j int) bool {
+		return relationships[i].CreatedAt.After(relationships[j].CreatedAt)
+	})
+	return relationships
+}
+
+// HasActiveCareRelationship reports whether the patient is in the doctor's
+// care at t
+func (s *Storage) HasActiveCareRelationship(doctorID, patientID uuid.UUID, t time.Time) bool {
+	return s.FindActiveCareRelationship(doctorID, patientID, t) != nil
+}
+
+// FindActiveCareRelationship returns a relationship putting the patient in the
+// doctor's care at t, preferring regular care over emergency access, or nil
+func (s *Storage) FindActiveCareRelationship(doctorID, patientID uuid.UUID, t time.Time) *CareRelationship {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found *CareRelationship
+	for _, relationship := range s.careRelationships {
+		if relationship.DoctorID != doctorID || relationship.PatientID != patientID || !relationship.ActiveAt(t) {
+			continue
+		}
+		if relationship.Source != CareSourceEmergency {
+			return relationship
+		}
+		found = relationship
+	}
+	return found
+}
+
+// EndCareRelationship ends a relationship at the given time
+func (s *Storage) EndCareRelationship(id uuid.UUID, endsAt time.Time, endedBy uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	relationship, exists := s.careRelationships[id]
+	if !exists {
+		return ErrNotFound
+	}
+	relationship.EndsAt = &endsAt
+	relationship.EndedBy = endedBy
+	relationship.UpdatedAt = time.Now()
+	return nil
+}
+
+// loadCareRelationship attaches the doctor and patient; callers hold s.mu
+func (s *Storage) loadCareRelationship(relationship *CareRelationship) {
+	if doctor, exists := s.doctors[relationship.DoctorID]; exists {
+		if user, exists := s.users[doctor.UserID]; exists {
+			doctor.User = user
+		}
+		relationship.Doctor = doctor
+	}
+	if patient, exists := s.patients[relationship.PatientID]; exists {
+		if user, exists := s.users[patient.UserID]; exists {
+			patient.User = user
+		}
+		relationship.Patient = patient
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Doctor verification statuses
+const (
+	DoctorVerificationPending  = "pending"
+	DoctorVerificationVerified = "verified"
+	DoctorVerificationRejected = "rejected"
+)
+
+// CredentialDocument is a file a doctor uploaded to prove their license,
+// such as a scan of the license or a hospital ID
+type CredentialDocument struct {
+	ID          uuid.UUID `json:"id"`
+	DoctorID    uuid.UUID `json:"doctor_id"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Key         string    `json:"-"` // blob store key
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+// Credential document operations
+func (s *Storage) CreateCredentialDocument(document *CredentialDocument) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if document.ID == uuid.Nil {
+		document.ID = uuid.New()
+	}
+	document.UploadedAt = time.Now()
+
+	s.credentials[document.ID] = document
+	return nil
+}
+
+func (s *Storage) GetCredentialDocument(id uuid.UUID) (*CredentialDocument, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	document, exists := s.credentials[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return document, nil
+}
+
+// GetCredentialDocuments returns a doctor's documents, oldest first
+func (s *Storage) GetCredentialDocuments(doctorID uuid.UUID) []*CredentialDocument {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	documents := []*CredentialDocument{}
+	for _, document := range s.credentials {
+		if document.DoctorID == doctorID {
+			documents = append(documents, document)
+		}
+	}
+
+	sort.Slice(documents, func(i, j int) bool {
+		return documents[i].UploadedAt.Before(documents[j].UploadedAt)
+	})
+	return documents
+}
+
+func (s *Storage) DeleteCredentialDocument(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.credentials[id]; !exists {
+		return ErrNotFound
+	}
+	delete(s.credentials, id)
+	return nil
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Emergency access review outcomes
+const (
+	EmergencyAccessJustified   = "justified"
+	EmergencyAccessUnjustified = "unjustified"
+)
+
+// EmergencyAccess records a doctor breaking the glass to reach the records of
+// a patient outside their care. Access is granted through a care relationship
+// with source emergency that ends at ExpiresAt, and every use of it is counted
+// by permission until it is reviewed for compliance.
+type EmergencyAccess struct {
+	ID             uuid.UUID         `json:"id"`
+	RelationshipID uuid.UUID         `json:"relationship_id"`
+	Relationship   *CareRelationship `json:"relationship,omitempty"`
+	DoctorID       uuid.UUID         `json:"doctor_id"`
+	PatientID      uuid.UUID         `json:"patient_id"`
+	Reason         string            `json:"reason"`
+	IPAddress      string            `json:"ip_address,omitempty"`
+	GrantedAt      time.Time         `json:"granted_at"`
+	ExpiresAt      time.Time         `json:"expires_at"`
+	AccessCounts   map[string]int    `json:"access_counts"`
+	LastAccessedAt *time.Time        `json:"last_accessed_at,omitempty"`
+	ReviewOutcome  string            `json:"review_outcome,omitempty"`
+	ReviewNotes    string            `json:"review_notes,omitempty"`
+	ReviewedBy     uuid.UUID         `json:"reviewed_by"`
+	ReviewedAt     *time.Time        `json:"reviewed_at,omitempty"`
+}
+
+// EmergencyAccessFilter selects emergency accesses; zero fields match
+// everything. Reviewed is "reviewed", "unreviewed" or empty.
+type EmergencyAccessFilter struct {
+	DoctorID  uuid.UUID
+	PatientID uuid.UUID
+	Since     time.Time
+	Reviewed  string
+}
+
+// Emergency access operations
+func (s *Storage) CreateEmergencyAccess(access *EmergencyAccess) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	access.ID = uuid.New()
+	if access.GrantedAt.IsZero() {
+		access.GrantedAt = time.Now()
+	}
+	access.AccessCounts = make(map[string]int)
+
+	s.emergencyAccesses[access.ID] = access
+	return nil
+}
+
+func (s *Storage) GetEmergencyAccess(id uuid.UUID) (*EmergencyAccess, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	access, exists := s.emergencyAccesses[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	s.loadEmergencyAccess(access)
+	return access, nil
+}
+
+// GetEmergencyAccesses returns matching emergency accesses, newest first
+func (s *Storage) GetEmergencyAccesses(filter EmergencyAccessFilter) []*EmergencyAccess {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accesses := []*EmergencyAccess{}
+	for _, access := range s.emergencyAccesses {
+		if filter.DoctorID != uuid.Nil && access.DoctorID != filter.DoctorID {
+			continue
+		}
+		if filter.PatientID != uuid.Nil && access.PatientID != filter.PatientID {
+			continue
+		}
+		if !filter.Since.IsZero() && access.GrantedAt.Before(filter.Since) {
+			continue
+		}
+		if filter.Reviewed != "" && (access.ReviewedAt != nil) != (filter.Reviewed == "reviewed") {
+			continue
+		}
+		s.loadEmergencyAccess(access)
+		accesses = append(accesses, access)
+	}
+
+	sort.Slice(accesses, func(i, j int) bool {
+		return accesses[i].GrantedAt.After(accesses[j].GrantedAt)
+	})
+	return accesses
+}
+
+// LinkEmergencyAccess records the care relationship granting the access
+func (s *Storage) LinkEmergencyAccess(id, relationshipID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	access, exists := s.emergencyAccesses[id]
+	if !exists {
+		return ErrNotFound
+	}
+	access.RelationshipID = relationshipID
+	return nil
+}
+
+// RecordEmergencyAccessUse counts a use of the access for the permission
+func (s *Storage) RecordEmergencyAccessUse(id uuid.UUID, permission string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if access, exists := s.emergencyAccesses[id]; exists {
+		now := time.Now()
+		access.AccessCounts[permission]++
+		access.LastAccessedAt = &now
+	}
+}
+
+// ReviewEmergencyAccess stores the outcome of a compliance review
+func (s *Storage) ReviewEmergencyAccess(id uuid.UUID, outcome, notes string, reviewedBy uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	access, exists := s.emergencyAccesses[id]
+	if !exists {
+		return ErrNotFound
+	}
+	now := time.Now()
+	access.ReviewOutcome = outcome
+	access.ReviewNotes = notes
+	access.ReviewedBy = reviewedBy
+	access.ReviewedAt = &now
+	return nil
+}
+
+// loadEmergencyAccess attaches the care relationship; callers hold s.mu
+func (s *Storage) loadEmergencyAccess(access *EmergencyAccess) {
+	if relationship, exists := s.careRelationships[access.RelationshipID]; exists {
+		s.loadCareRelationship(relationship)
+		access.Relationship = relationship
+	}
+}
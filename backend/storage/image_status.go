@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Image lifecycle states
+const (
+	ImageStatusUploaded       = "uploaded"
+	ImageStatusQualityChecked = "quality_checked"
+	ImageStatusQueued         = "queued"
+	ImageStatusAnalyzing      = "analyzing"
+	ImageStatusAnalyzed       = "analyzed"
+	ImageStatusReviewed       = "reviewed"
+	ImageStatusRejected       = "rejected"
+)
+
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// imageTransitions lists the states each state may move to. Failed analyses
+// fall back to quality_checked so they can be retried, and rejected images
+// re-enter the lifecycle only through a passing quality assessment.
+var imageTransitions = map[string][]string{
+	ImageStatusUploaded:       {ImageStatusQualityChecked, ImageStatusRejected},
+	ImageStatusQualityChecked: {ImageStatusQueued, ImageStatusAnalyzing, ImageStatusRejected},
+	ImageStatusQueued:         {ImageStatusAnalyzing, ImageStatusQualityChecked, ImageStatusRejected},
+	ImageStatusAnalyzing:      {ImageStatusAnalyzed, ImageStatusQualityChecked, ImageStatusRejected},
+	ImageStatusAnalyzed:       {ImageStatusQueued, ImageStatusAnalyzing, ImageStatusReviewed, ImageStatusRejected},
+	ImageStatusReviewed:       {ImageStatusQueued, ImageStatusAnalyzing, ImageStatusRejected},
+	ImageStatusRejected:       {ImageStatusQualityChecked},
+}
+
+// StatusTransition is one entry in an image's status history. ChangedBy is
+// the user who caused the change, or uuid.Nil for background processing.
+type StatusTransition struct {
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to"`
+	Reason    string    `json:"reason,omitempty"`
+	ChangedBy uuid.UUID `json:"changed_by"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// CanTransitionImage reports whether an image in state from may move to state to
+func CanTransitionImage(from, to string) bool {
+	for _, next := range imageTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// InitImageStatus puts a new image in the uploaded state and starts its history
+func InitImageStatus(image *RetinalImage, changedBy uuid.UUID) {
+	image.Status = ImageStatusUploaded
+	image.StatusHistory = []StatusTransition{{
+		To:        ImageStatusUploaded,
+		ChangedBy: changedBy,
+		ChangedAt: time.Now(),
+	}}
+}
+
+// TransitionImageStatus moves an image to a new state and records the change.
+// Moving to the current state is a no-op.
+func (s *Storage) TransitionImageStatus(image *RetinalImage, to string, changedBy uuid.UUID, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if image.Status == to {
+		return nil
+	}
+	if !CanTransitionImage(image.Status, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, image.Status, to)
+	}
+
+	now := time.Now()
+	image.StatusHistory = append(image.StatusHistory, StatusTransition{
+		From:      image.Status,
+		To:        to,
+		Reason:    reason,
+		ChangedBy: changedBy,
+		ChangedAt: now,
+	})
+	image.Status = to
+	image.UpdatedAt = now
+	s.images[image.ID] = image
+	return nil
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Import row outcomes
+const (
+	ImportRowImported  = "imported"
+	ImportRowDuplicate = "duplicate"
+	ImportRowValid     = "valid" // dry run: the row would be imported
+	ImportRowFailed    = "failed"
+)
+
+// ImportRowResult is the outcome of one manifest row of a bulk import
+type ImportRowResult struct {
+	Row               int       `json:"row"`
+	File              string    `json:"file"`
+	PatientIdentifier string    `json:"patient_identifier"`
+	Status            string    `json:"status"`
+	PatientID         uuid.UUID `json:"patient_id"`
+	PatientCreated    bool      `json:"patient_created"`
+	ImageID           uuid.UUID `json:"image_id"`
+	DetectionQueued   bool      `json:"detection_queued"`
+	Error             string    `json:"error,omitempty"`
+}
+
+// ImportReport summarizes a bulk archive import
+type ImportReport struct {
+	ID                uuid.UUID         `json:"id"`
+	CreatedBy         uuid.UUID         `json:"created_by"`
+	ArchiveName       string            `json:"archive_name"`
+	DryRun            bool              `json:"dry_run"`
+	TotalRows         int               `json:"total_rows"`
+	Imported          int               `json:"imported"`
+	Duplicates        int               `json:"duplicates"`
+	Failed            int               `json:"failed"`
+	PatientsCreated   int               `json:"patients_created"`
+	UnreferencedFiles []string          `json:"unreferenced_files"`
+	Rows              []ImportRowResult `json:"rows"`
+	StartedAt         time.Time         `json:"started_at"`
+	CompletedAt       time.Time         `json:"completed_at"`
+	CreatedAt         time.Time         `json:"created_at"`
+}
+
+// Import report operations
+
+func (s *Storage) CreateImportReport(report *ImportReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report.ID = uuid.New()
+	report.CreatedAt = time.Now()
+
+	s.importReports[report.ID] = report
+	return nil
+}
+
+func (s *Storage) GetImportReportByID(id uuid.UUID) (*ImportReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	report, exists := s.importReports[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return report, nil
+}
+
+// GetImportReports returns import reports, newest first. A nil createdBy returns all reports.
+func (s *Storage) GetImportReports(createdBy uuid.UUID) []*ImportReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var reports []*ImportReport
+	for _, report := range s.importReports {
+		if createdBy == uuid.Nil || report.CreatedBy == createdBy {
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].CreatedAt.After(reports[j].CreatedAt)
+	})
+	return reports
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// LoginThrottle counts failed logins for one account or client IP. Keys are
+// "account:<email>" or "ip:<address>".
+type LoginThrottle struct {
+	Key            string     `json:"key"`
+	Failures       int        `json:"failures"`
+	FirstFailureAt time.Time  `json:"first_failure_at"`
+	LastFailureAt  time.Time  `json:"last_failure_at"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+}
+
+// GetLoginThrottle returns a copy of the throttle state for a key
+func (s *Storage) GetLoginThrottle(key string) (LoginThrottle, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	throttle, exists := s.loginThrottles[key]
+	if !exists {
+		return LoginThrottle{}, ErrNotFound
+	}
+	return *throttle, nil
+}
+
+// BeginLoginAttempt checks the throttles of keys and, unless one of them is
+// locked or waiting out the delay after its recent failures, counts the
+// attempt against every key as a failure. Checking and counting under one lock
+// stops concurrent attempts from all passing the check before any of them is
+// counted. Failures older than window are forgotten. It returns how long to
+// wait if the attempt is refused, and whether that is because of a lock.
+func (s *Storage) BeginLoginAttempt(keys []string, now time.Time, window time.Duration, delay func(failures int) time.Duration) (retryAfter time.Duration, locked bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		throttle, exists := s.loginThrottles[key]
+		if exists && throttle.LockedUntil != nil && now.Before(*throttle.LockedUntil) {
+			return throttle.LockedUntil.Sub(now), true
+		}
+	}
+	for _, key := range keys {
+		throttle, exists := s.loginThrottles[key]
+		if !exists || throttle.LockedUntil != nil || now.Sub(throttle.FirstFailureAt) > window {
+			continue
+		}
+		if wait := throttle.LastFailureAt.Add(delay(throttle.Failures)).Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return retryAfter, false
+	}
+
+	for _, key := range keys {
+		current, exists := s.loginThrottles[key]
+		expired := exists && current.LockedUntil == nil && now.Sub(current.FirstFailureAt) > window
+		unlocked := exists && current.LockedUntil != nil && !now.Before(*current.LockedUntil)
+		if !exists || expired || unlocked {
+			current = &LoginThrottle{Key: key, FirstFailureAt: now}
+			s.loginThrottles[key] = current
+		}
+		current.Failures++
+		current.LastFailureAt = now
+	}
+	return 0, false
+}
+
+// RequestAllowance is how many requests a throttle key allows per window
+type RequestAllowance struct {
+	Key string
+	Max int
+}
+
+// TakeRequestAllowance counts a request against every allowance unless one of
+// them is used up, in which case nothing is counted. It returns how long to
+// wait until the used up allowance starts over. A Max of zero or less is
+// unlimited.
+func (s *Storage) TakeRequestAllowance(allowances []RequestAllowance, now time.Time, window time.Duration) (retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, allowance := range allowances {
+		throttle, exists := s.loginThrottles[allowance.Key]
+		if !exists || allowance.Max <= 0 || now.Sub(throttle.FirstFailureAt) > window || throttle.Failures < allowance.Max {
+			continue
+		}
+		if wait := throttle.FirstFailureAt.Add(window).Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return retryAfter
+	}
+
+	for _, allowance := range allowances {
+		current, exists := s.loginThrottles[allowance.Key]
+		if !exists || now.Sub(current.FirstFailureAt) > window {
+			current = &LoginThrottle{Key: allowance.Key, FirstFailureAt: now}
+			s.loginThrottles[allowance.Key] = current
+		}
+		current.Failures++
+		current.LastFailureAt = now
+	}
+	return 0
+}
+
+// LockLoginThrottle locks a key until now+lockout once its failures reach
+// threshold; locked reports whether this call caused the lock
+func (s *Storage) LockLoginThrottle(key string, now time.Time, threshold int, lockout time.Duration) (throttle LoginThrottle, locked bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, exists := s.loginThrottles[key]
+	if !exists {
+		return LoginThrottle{}, false
+	}
+	if current.LockedUntil == nil && threshold > 0 && current.Failures >= threshold {
+		until := now.Add(lockout)
+		current.LockedUntil = &until
+		locked = true
+	}
+	return *current, locked
+}
+
+// ReleaseLoginAttempt takes back an attempt counted by BeginLoginAttempt
+// that turned out not to be a failure
+func (s *Storage) ReleaseLoginAttempt(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if throttle, exists := s.loginThrottles[key]; exists && throttle.Failures > 0 {
+		throttle.Failures--
+	}
+}
+
+// ClearLoginThrottle forgets the failures and any lock of a key. It returns
+// ErrNotFound if the key had none.
+func (s *Storage) ClearLoginThrottle(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.loginThrottles[key]; !exists {
+		return ErrNotFound
+	}
+	delete(s.loginThrottles, key)
+	return nil
+}
+
+// GetLockedLoginThrottles returns the keys that are locked at the given time
+func (s *Storage) GetLockedLoginThrottles(now time.Time) []LoginThrottle {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var locked []LoginThrottle
+	for _, throttle := range s.loginThrottles {
+		if throttle.LockedUntil != nil && now.Before(*throttle.LockedUntil) {
+			locked = append(locked, *throttle)
+		}
+	}
+	sort.Slice(locked, func(i, j int) bool {
+		return locked[i].LockedUntil.Before(*locked[j].LockedUntil)
+	})
+	return locked
+}
+
+// PruneLoginThrottles drops throttles whose lock has ended and whose
+// failures are older than window
+func (s *Storage) PruneLoginThrottles(now time.Time, window time.Duration) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+	for key, throttle := range s.loginThrottles {
+		if throttle.LockedUntil != nil && now.Before(*throttle.LockedUntil) {
+			continue
+		}
+		if now.Sub(throttle.LastFailureAt) > window {
+			delete(s.loginThrottles, key)
+			pruned++
+		}
+	}
+	return pruned
+}
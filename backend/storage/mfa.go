@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MFAEnrollment holds a user's TOTP secret and recovery codes. An enrollment
+// is pending until the user proves possession of the secret with a first code.
+type MFAEnrollment struct {
+	UserID       uuid.UUID  `json:"user_id"`
+	Secret       string     `json:"-"` // base32 TOTP secret
+	BackupCodes  []string   `json:"-"` // SHA-256 hashes of unused backup codes
+	LastUsedStep int64      `json:"-"` // last accepted TOTP time step, to stop code replay
+	EnabledAt    *time.Time `json:"enabled_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// MFAChallenge is the second step of a login that passed the password check.
+// Only the SHA-256 of the challenge token is stored.
+type MFAChallenge struct {
+	TokenHash string    `json:"-"`
+	UserID    uuid.UUID `json:"user_id"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MFA operations
+
+// SaveMFAEnrollment stores a pending enrollment, replacing an earlier pending
+// one. It returns ErrConflict if the user already has MFA enabled.
+func (s *Storage) SaveMFAEnrollment(enrollment *MFAEnrollment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, exists := s.mfaEnrollments[enrollment.UserID]; exists && existing.EnabledAt != nil {
+		return ErrConflict
+	}
+	enrollment.CreatedAt = time.Now()
+	enrollment.EnabledAt = nil
+	s.mfaEnrollments[enrollment.UserID] = enrollment
+	return nil
+}
+
+func (s *Storage) GetMFAEnrollment(userID uuid.UUID) (*MFAEnrollment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	enrollment, exists := s.mfaEnrollments[userID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return enrollment, nil
+}
+
+// EnableMFA activates a pending enrollment with its first set of backup codes
+func (s *Storage) EnableMFA(userID uuid.UUID, backupCodes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollment, exists := s.mfaEnrollments[userID]
+	if !exists {
+		return ErrNotFound
+	}
+	user, exists := s.users[userID]
+	if !exists {
+		return ErrNotFound
+	}
+
+	now := time.Now()
+	enrollment.EnabledAt = &now
+	enrollment.BackupCodes = backupCodes
+	user.MFAEnabled = true
+	user.UpdatedAt = now
+	return nil
+}
+
+// DeleteMFAEnrollment removes a user's enrollment, enabled or pending, and
+// any login challenges waiting for a code
+func (s *Storage) DeleteMFAEnrollment(userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.mfaEnrollments[userID]; !exists {
+		return ErrNotFound
+	}
+	delete(s.mfaEnrollments, userID)
+	for hash, challenge := range s.mfaChallenges {
+		if challenge.UserID == userID {
+			delete(s.mfaChallenges, hash)
+		}
+	}
+	if user, exists := s.users[userID]; exists {
+		user.MFAEnabled = false
+		user.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+// UseMFAStep records a TOTP time step as used. It returns ErrConflict if a
+// code from this or a later step was already accepted.
+func (s *Storage) UseMFAStep(userID uuid.UUID, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollment, exists := s.mfaEnrollments[userID]
+	if !exists {
+		return ErrNotFound
+	}
+	if step <= enrollment.LastUsedStep {
+		return ErrConflict
+	}
+	enrollment.LastUsedStep = step
+	return nil
+}
+
+// ConsumeBackupCode removes a backup code so it cannot be used again. It
+// returns ErrNotFound if the code is not one of the user's unused codes.
+func (s *Storage) ConsumeBackupCode(userID uuid.UUID, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollment, exists := s.mfaEnrollments[userID]
+	if !exists {
+		return ErrNotFound
+	}
+	for i, code := range enrollment.BackupCodes {
+		if code == hash {
+			enrollment.BackupCodes = append(enrollment.BackupCodes[:i:i], enrollment.BackupCodes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// ReplaceBackupCodes swaps all of a user's backup codes for a new set
+func (s *Storage) ReplaceBackupCodes(userID uuid.UUID, backupCodes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollment, exists := s.mfaEnrollments[userID]
+	if !exists || enrollment.EnabledAt == nil {
+		return ErrNotFound
+	}
+	enrollment.BackupCodes = backupCodes
+	return nil
+}
+
+func (s *Storage) CreateMFAChallenge(challenge *MFAChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge.CreatedAt = time.Now()
+	s.mfaChallenges[challenge.TokenHash] = challenge
+	return nil
+}
+
+func (s *Storage) GetMFAChallenge(hash string) (*MFAChallenge, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	challenge, exists := s.mfaChallenges[hash]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return challenge, nil
+}
+
+// RecordMFAChallengeFailure counts a wrong code against a challenge and
+// returns the number of failed attempts so far
+func (s *Storage) RecordMFAChallengeFailure(hash string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, exists := s.mfaChallenges[hash]
+	if !exists {
+		return 0
+	}
+	challenge.Attempts++
+	return challenge.Attempts
+}
+
+func (s *Storage) DeleteMFAChallenge(hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.mfaChallenges, hash)
+}
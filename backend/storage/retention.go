@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImageDeletion records why and when an image was soft-deleted. Deleted images
+// are hidden from all lookups and their content is purged after PurgeAfter.
+type ImageDeletion struct {
+	Reason     string     `json:"reason"`
+	DeletedBy  uuid.UUID  `json:"deleted_by"`
+	DeletedAt  time.Time  `json:"deleted_at"`
+	PurgeAfter time.Time  `json:"purge_after"`
+	PurgedAt   *time.Time `json:"purged_at,omitempty"`
+}
+
+// Retraction marks a detection result as withdrawn
+type Retraction struct {
+	Reason        string    `json:"reason"`
+	RetractedBy   uuid.UUID `json:"retracted_by"`
+	RetractedAt   time.Time `json:"retracted_at"`
+	ImageDeletion bool      `json:"image_deletion"`
+}
+
+// Retention operations
+
+// GetDeletedImageByID returns a soft-deleted image, including purged ones
+func (s *Storage) GetDeletedImageByID(id uuid.UUID) (*RetinalImage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	image, exists := s.images[id]
+	if !exists || image.Deletion == nil {
+		return nil, ErrNotFound
+	}
+	return image, nil
+}
+
+// GetDeletedImages returns all soft-deleted images, including purged ones
+func (s *Storage) GetDeletedImages() ([]*RetinalImage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var images []*RetinalImage
+	for _, image := range s.images {
+		if image.Deletion != nil {
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
+
+// GetImagesDueForPurge returns soft-deleted images whose content has not been
+// purged and whose purge date is before now
+func (s *Storage) GetImagesDueForPurge(now time.Time) ([]*RetinalImage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var images []*RetinalImage
+	for _, image := range s.images {
+		if image.Deletion != nil && image.Deletion.PurgedAt == nil && now.After(image.Deletion.PurgeAfter) {
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
+
+// GetImagesPastRetention returns live, unarchived images taken before cutoff.
+// The acquisition date is used when known, otherwise the upload date.
+func (s *Storage) GetImagesPastRetention(cutoff time.Time) ([]*RetinalImage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var images []*RetinalImage
+	for _, image := range s.images {
+		if image.Deletion != nil || image.ArchivedAt != nil {
+			continue
+		}
+		taken := image.UploadDate
+		if image.Metadata != nil && image.Metadata.AcquisitionDate != nil {
+			taken = *image.Metadata.AcquisitionDate
+		}
+		if taken.Before(cutoff) {
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
+
+// IsHeatmapShared reports whether a detection result other than resultID
+// refers to the same heatmap object
+func (s *Storage) IsHeatmapShared(key string, resultID uuid.UUID) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, result := range s.detectionResults {
+		if result.ID != resultID && result.HasHeatmap && result.HeatmapPath == key {
+			return true
+		}
+	}
+	return false
+}
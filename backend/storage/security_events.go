@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Security event types
+const (
+	SecurityEventAccountLocked      = "account_locked"
+	SecurityEventIPLocked           = "ip_locked"
+	SecurityEventAccountUnlocked    = "account_unlocked"
+	SecurityEventIPUnlocked         = "ip_unlocked"
+	SecurityEventAdminBootstrap     = "admin_bootstrapped"
+	SecurityEventRoleChanged        = "role_changed"
+	SecurityEventDeactivated        = "account_deactivated"
+	SecurityEventReactivated        = "account_reactivated"
+	SecurityEventMFAReset           = "mfa_reset"
+	SecurityEventDoctorVerified     = "doctor_verified"
+	SecurityEventDoctorRejected     = "doctor_rejected"
+	SecurityEventBreakGlass         = "break_glass_access"
+	SecurityEventBreakGlassReviewed = "break_glass_reviewed"
+)
+
+// SecurityEvent is an entry in the security log. UserID is the account the
+// event concerns and ActorID who caused it; either is uuid.Nil when unknown
+// or not applicable, e.g. a lockout triggered by anonymous login attempts.
+type SecurityEvent struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	UserID    uuid.UUID `json:"user_id"`
+	ActorID   uuid.UUID `json:"actor_id"`
+	Email     string    `json:"email,omitempty"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SecurityEventFilter selects security events; zero fields match everything
+type SecurityEventFilter struct {
+	Type   string
+	UserID uuid.UUID
+	Since  time.Time
+}
+
+// Security event operations
+func (s *Storage) CreateSecurityEvent(event *SecurityEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event.ID = uuid.New()
+	event.CreatedAt = time.Now()
+
+	s.securityEvents = append(s.securityEvents, event)
+	return nil
+}
+
+// GetSecurityEvents returns matching events, newest first
+func (s *Storage) GetSecurityEvents(filter SecurityEventFilter) []*SecurityEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []*SecurityEvent
+	for _, event := range s.securityEvents {
+		if filter.Type != "" && event.Type != filter.Type {
+			continue
+		}
+		if filter.UserID != uuid.Nil && event.UserID != filter.UserID {
+			continue
+		}
+		if !filter.Since.IsZero() && event.CreatedAt.Before(filter.Since) {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].CreatedAt.After(events[j].CreatedAt)
+	})
+	return events
+}
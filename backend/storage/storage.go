@@ -9,27 +9,44 @@ import (
 
 // In-memory storage with thread-safe operations
 type Storage struct {
-	users            map[uuid.UUID]*User
-	patients         map[uuid.UUID]*Patient
-	doctors          map[uuid.UUID]*Doctor
-	images           map[uuid.UUID]*RetinalImage
-	detectionResults map[uuid.UUID]*DetectionResult
-	appointments     map[uuid.UUID]*Appointment
-	userByEmail      map[string]*User
-	mu               sync.RWMutex
+	users             map[uuid.UUID]*User
+	patients          map[uuid.UUID]*Patient
+	doctors           map[uuid.UUID]*Doctor
+	images            map[uuid.UUID]*RetinalImage
+	detectionResults  map[uuid.UUID]*DetectionResult
+	appointments      map[uuid.UUID]*Appointment
+	annotations       map[uuid.UUID]*Annotation
+	blobs             map[string]*Blob
+	uploadSessions    map[uuid.UUID]*UploadSession
+	importReports     map[uuid.UUID]*ImportReport
+	refreshTokens     map[string]*RefreshToken
+	revokedTokens     map[string]time.Time
+	mfaEnrollments    map[uuid.UUID]*MFAEnrollment
+	mfaChallenges     map[string]*MFAChallenge
+	accountTokens     map[string]*AccountToken
+	loginThrottles    map[string]*LoginThrottle
+	securityEvents    []*SecurityEvent
+	credentials       map[uuid.UUID]*CredentialDocument
+	careRelationships map[uuid.UUID]*CareRelationship
+	emergencyAccesses map[uuid.UUID]*EmergencyAccess
+	userByEmail       map[string]*User
+	mu                sync.RWMutex
 }
 
 // User represents the base user model
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Role      string    `json:"role"`
-	Phone     string    `json:"phone"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              uuid.UUID  `json:"id"`
+	Email           string     `json:"email"`
+	Password        string     `json:"-"`
+	FirstName       string     `json:"first_name"`
+	LastName        string     `json:"last_name"`
+	Role            string     `json:"role"`
+	Phone           string     `json:"phone"`
+	MFAEnabled      bool       `json:"mfa_enabled"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	DeactivatedAt   *time.Time `json:"deactivated_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
 // Patient represents a patient in the system
@@ -37,6 +54,7 @@ type Patient struct {
 	ID               uuid.UUID `json:"id"`
 	UserID           uuid.UUID `json:"user_id"`
 	User             *User     `json:"user"`
+	MRN              string    `json:"mrn"` // medical record number used by external systems
 	DateOfBirth      time.Time `json:"date_of_birth"`
 	Gender           string    `json:"gender"`
 	Address          string    `json:"address"`
@@ -48,58 +66,102 @@ type Patient struct {
 
 // Doctor represents a doctor in the system
 type Doctor struct {
-	ID             uuid.UUID `json:"id"`
-	UserID         uuid.UUID `json:"user_id"`
-	User           *User     `json:"user"`
-	License        string    `json:"license"`
-	Specialization string    `json:"specialization"`
-	Experience     int       `json:"experience"`
-	Hospital       string    `json:"hospital"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
+	ID                 uuid.UUID  `json:"id"`
+	UserID             uuid.UUID  `json:"user_id"`
+	User               *User      `json:"user"`
+	License            string     `json:"license"`
+	Specialization     string     `json:"specialization"`
+	Experience         int        `json:"experience"`
+	Hospital           string     `json:"hospital"`
+	VerificationStatus string     `json:"verification_status"`
+	VerificationNote   string     `json:"verification_note,omitempty"`
+	ReviewedBy         uuid.UUID  `json:"reviewed_by"`
+	ReviewedAt         *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
 }
 
 // RetinalImage represents uploaded retinal images
 type RetinalImage struct {
-	ID         uuid.UUID `json:"id"`
-	PatientID  uuid.UUID `json:"patient_id"`
-	Patient    *Patient  `json:"patient"`
-	DoctorID   uuid.UUID `json:"doctor_id"`
-	Doctor     *Doctor   `json:"doctor"`
-	FileName   string    `json:"file_name"`
-	FilePath   string    `json:"file_path"`
-	FileSize   int64     `json:"file_size"`
-	ImageType  string    `json:"image_type"`
-	UploadDate time.Time `json:"upload_date"`
-	Notes      string    `json:"notes"`
-	Status     string    `json:"status"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
-}
-
-// DetectionResult represents AI detection results
+	ID            uuid.UUID          `json:"id"`
+	PatientID     uuid.UUID          `json:"patient_id"`
+	Patient       *Patient           `json:"patient"`
+	DoctorID      uuid.UUID          `json:"doctor_id"`
+	Doctor        *Doctor            `json:"doctor"`
+	FileName      string             `json:"file_name"`
+	FilePath      string             `json:"file_path"`
+	FileSize      int64              `json:"file_size"`
+	ContentHash   string             `json:"content_hash"`
+	ImageType     string             `json:"image_type"`
+	UploadDate    time.Time          `json:"upload_date"`
+	Notes         string             `json:"notes"`
+	Status        string             `json:"status"`
+	StatusHistory []StatusTransition `json:"status_history"`
+	Quality       *ImageQuality      `json:"quality,omitempty"`
+	Metadata      *ImageMetadata     `json:"metadata,omitempty"`
+	Deletion      *ImageDeletion     `json:"deletion,omitempty"`
+	ArchivedAt    *time.Time         `json:"archived_at,omitempty"`
+	CreatedAt     time.Time          `json:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+}
+
+// ImageMetadata holds acquisition metadata extracted from the uploaded file
+type ImageMetadata struct {
+	SourceFormat    string     `json:"source_format"`
+	Width           int        `json:"width"`
+	Height          int        `json:"height"`
+	Laterality      string     `json:"laterality,omitempty"`
+	AcquisitionDate *time.Time `json:"acquisition_date,omitempty"`
+	Modality        string     `json:"modality,omitempty"`
+	Manufacturer    string     `json:"manufacturer,omitempty"`
+	DeviceModel     string     `json:"device_model,omitempty"`
+	// StrippedMetadata lists the metadata blocks removed from the stored file
+	StrippedMetadata []string `json:"stripped_metadata,omitempty"`
+}
+
+// ImageQuality represents the automated quality assessment of a fundus image
+type ImageQuality struct {
+	Score                  float64   `json:"score"`
+	BlurVariance           float64   `json:"blur_variance"`
+	IlluminationUniformity float64   `json:"illumination_uniformity"`
+	Contrast               float64   `json:"contrast"`
+	FieldOfViewCoverage    float64   `json:"field_of_view_coverage"`
+	Gradable               bool      `json:"gradable"`
+	Issues                 []string  `json:"issues"`
+	AssessedAt             time.Time `json:"assessed_at"`
+}
+
+// DetectionResult represents AI detection results. RequestedModel and
+// PreprocessingSteps key the result cache; the version is empty for results
+// that did not come from the CNN.
 type DetectionResult struct {
-	ID                uuid.UUID     `json:"id"`
-	ImageID           uuid.UUID     `json:"image_id"`
-	Image             *RetinalImage `json:"image"`
-	DoctorID          uuid.UUID     `json:"doctor_id"`
-	Doctor            *Doctor       `json:"doctor"`
-	HasDR             bool          `json:"has_dr"`
-	DRStage           string        `json:"dr_stage"`
-	Confidence        float64       `json:"confidence"`
-	HasMacularEdema   bool          `json:"has_macular_edema"`
-	HasHemorrhages    bool          `json:"has_hemorrhages"`
-	HasExudates       bool          `json:"has_exudates"`
-	HasMicroaneurysms bool          `json:"has_microaneurysms"`
-	AnalysisDate      time.Time     `json:"analysis_date"`
-	ProcessingTime    float64       `json:"processing_time"`
-	ModelVersion      string        `json:"model_version"`
-	ReviewedBy        uuid.UUID     `json:"reviewed_by"`
-	ReviewDate        time.Time     `json:"review_date"`
-	ReviewNotes       string        `json:"review_notes"`
-	IsConfirmed       bool          `json:"is_confirmed"`
-	CreatedAt         time.Time     `json:"created_at"`
-	UpdatedAt         time.Time     `json:"updated_at"`
+	ID                 uuid.UUID     `json:"id"`
+	ImageID            uuid.UUID     `json:"image_id"`
+	Image              *RetinalImage `json:"image"`
+	DoctorID           uuid.UUID     `json:"doctor_id"`
+	Doctor             *Doctor       `json:"doctor"`
+	HasDR              bool          `json:"has_dr"`
+	DRStage            string        `json:"dr_stage"`
+	Confidence         float64       `json:"confidence"`
+	HasMacularEdema    bool          `json:"has_macular_edema"`
+	HasHemorrhages     bool          `json:"has_hemorrhages"`
+	HasExudates        bool          `json:"has_exudates"`
+	HasMicroaneurysms  bool          `json:"has_microaneurysms"`
+	AnalysisDate       time.Time     `json:"analysis_date"`
+	ProcessingTime     float64       `json:"processing_time"`
+	ModelVersion       string        `json:"model_version"`
+	RequestedModel     string        `json:"requested_model,omitempty"`
+	PreprocessingSteps []string      `json:"preprocessing_steps"`
+	ReviewedBy         uuid.UUID     `json:"reviewed_by"`
+	ReviewDate         time.Time     `json:"review_date"`
+	ReviewNotes        string        `json:"review_notes"`
+	IsConfirmed        bool          `json:"is_confirmed"`
+	HeatmapPath        string        `json:"-"`
+	HasHeatmap         bool          `json:"has_heatmap"`
+	CachedFromID       uuid.UUID     `json:"cached_from_id"`
+	Retraction         *Retraction   `json:"retraction,omitempty"`
+	CreatedAt          time.Time     `json:"created_at"`
+	UpdatedAt          time.Time     `json:"updated_at"`
 }
 
 // Appointment represents patient appointments
@@ -121,13 +183,26 @@ var GlobalStorage *Storage
 
 func init() {
 	GlobalStorage = &Storage{
-		users:            make(map[uuid.UUID]*User),
-		patients:         make(map[uuid.UUID]*Patient),
-		doctors:          make(map[uuid.UUID]*Doctor),
-		images:           make(map[uuid.UUID]*RetinalImage),
-		detectionResults: make(map[uuid.UUID]*DetectionResult),
-		appointments:     make(map[uuid.UUID]*Appointment),
-		userByEmail:      make(map[string]*User),
+		users:             make(map[uuid.UUID]*User),
+		patients:          make(map[uuid.UUID]*Patient),
+		doctors:           make(map[uuid.UUID]*Doctor),
+		images:            make(map[uuid.UUID]*RetinalImage),
+		detectionResults:  make(map[uuid.UUID]*DetectionResult),
+		appointments:      make(map[uuid.UUID]*Appointment),
+		annotations:       make(map[uuid.UUID]*Annotation),
+		blobs:             make(map[string]*Blob),
+		uploadSessions:    make(map[uuid.UUID]*UploadSession),
+		importReports:     make(map[uuid.UUID]*ImportReport),
+		refreshTokens:     make(map[string]*RefreshToken),
+		revokedTokens:     make(map[string]time.Time),
+		mfaEnrollments:    make(map[uuid.UUID]*MFAEnrollment),
+		mfaChallenges:     make(map[string]*MFAChallenge),
+		accountTokens:     make(map[string]*AccountToken),
+		loginThrottles:    make(map[string]*LoginThrottle),
+		credentials:       make(map[uuid.UUID]*CredentialDocument),
+		careRelationships: make(map[uuid.UUID]*CareRelationship),
+		emergencyAccesses: make(map[uuid.UUID]*EmergencyAccess),
+		userByEmail:       make(map[string]*User),
 	}
 }
 
@@ -223,6 +298,22 @@ func (s *Storage) GetPatientByID(id uuid.UUID) (*Patient, error) {
 	return patient, nil
 }
 
+// GetPatientByMRN finds a patient by medical record number
+func (s *Storage) GetPatientByMRN(mrn string) (*Patient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, patient := range s.patients {
+		if patient.MRN != "" && patient.MRN == mrn {
+			if user, exists := s.users[patient.UserID]; exists {
+				patient.User = user
+			}
+			return patient, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
 func (s *Storage) GetAllPatients() ([]*Patient, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -253,6 +344,9 @@ func (s *Storage) CreateDoctor(doctor *Doctor) error {
 	defer s.mu.Unlock()
 
 	doctor.ID = uuid.New()
+	if doctor.VerificationStatus == "" {
+		doctor.VerificationStatus = DoctorVerificationPending
+	}
 	doctor.CreatedAt = time.Now()
 	doctor.UpdatedAt = time.Now()
 
@@ -335,7 +429,7 @@ func (s *Storage) GetImageByID(id uuid.UUID) (*RetinalImage, error) {
 	defer s.mu.RUnlock()
 
 	image, exists := s.images[id]
-	if !exists {
+	if !exists || image.Deletion != nil {
 		return nil, ErrNotFound
 	}
 
@@ -365,7 +459,7 @@ func (s *Storage) GetImagesByPatientID(patientID uuid.UUID) ([]*RetinalImage, er
 
 	var images []*RetinalImage
 	for _, image := range s.images {
-		if image.PatientID == patientID {
+		if image.PatientID == patientID && image.Deletion == nil {
 			// Load related data
 			if patient, exists := s.patients[image.PatientID]; exists {
 				image.Patient = patient
@@ -411,14 +505,47 @@ func (s *Storage) CreateDetectionResult(result *DetectionResult) error {
 	return nil
 }
 
+func (s *Storage) GetDetectionResultByID(id uuid.UUID) (*DetectionResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stored, exists := s.detectionResults[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	// Load related data into a copy, as concurrent readers share the stored
+	// result under the read lock
+	result := *stored
+	if image, exists := s.images[result.ImageID]; exists {
+		result.Image = image
+	}
+
+	return &result, nil
+}
+
+func (s *Storage) UpdateDetectionResult(result *DetectionResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.detectionResults[result.ID]; !exists {
+		return ErrNotFound
+	}
+
+	result.UpdatedAt = time.Now()
+	s.detectionResults[result.ID] = result
+	return nil
+}
+
 func (s *Storage) GetDetectionResultsByImageID(imageID uuid.UUID) ([]*DetectionResult, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	var results []*DetectionResult
-	for _, result := range s.detectionResults {
-		if result.ImageID == imageID {
-			// Load related data
+	for _, stored := range s.detectionResults {
+		if stored.ImageID == imageID {
+			// Load related data into a copy, as for GetDetectionResultByID
+			result := *stored
 			if image, exists := s.images[result.ImageID]; exists {
 				result.Image = image
 			}
@@ -429,7 +556,7 @@ func (s *Storage) GetDetectionResultsByImageID(imageID uuid.UUID) ([]*DetectionR
 				}
 			}
 
-			results = append(results, result)
+			results = append(results, &result)
 		}
 	}
 	return results, nil
@@ -545,11 +672,26 @@ func (s *Storage) GetStats() map[string]interface{} {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	// Deleted images and retracted results are not counted
+	totalImages := 0
+	for _, image := range s.images {
+		if image.Deletion == nil {
+			totalImages++
+		}
+	}
+	totalDetections := 0
+	for _, result := range s.detectionResults {
+		if result.Retraction == nil {
+			totalDetections++
+		}
+	}
+
 	return map[string]interface{}{
 		"total_patients":     len(s.patients),
 		"total_doctors":      len(s.doctors),
-		"total_images":       len(s.images),
+		"total_images":       totalImages,
 		"total_appointments": len(s.appointments),
-		"total_detections":   len(s.detectionResults),
+		"total_detections":   totalDetections,
+		"total_annotations":  len(s.annotations),
 	}
 }
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestDetectionResultReadersGetCopies(t *testing.T) {
+	image := &RetinalImage{FileName: "fundus.png"}
+	if err := GlobalStorage.CreateImage(image); err != nil {
+		t.Fatal(err)
+	}
+	result := &DetectionResult{ImageID: image.ID}
+	if err := GlobalStorage.CreateDetectionResult(result); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent readers must not write to the shared stored result; run
+	// with -race to check
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := GlobalStorage.GetDetectionResultByID(result.ID); err != nil || got.Image == nil {
+				t.Errorf("GetDetectionResultByID = %v, %v", got, err)
+			}
+			if got, err := GlobalStorage.GetDetectionResultsByImageID(image.ID); err != nil || len(got) != 1 || got[0].Image == nil {
+				t.Errorf("GetDetectionResultsByImageID = %v, %v", got, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := GlobalStorage.GetDetectionResultByID(result.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == result {
+		t.Error("reader got the stored result instead of a copy")
+	}
+	got.ReviewNotes = "changed"
+	if stored, _ := GlobalStorage.GetDetectionResultByID(result.ID); stored.ReviewNotes != "" {
+		t.Error("changing a read result changed the stored result without an update")
+	}
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken is a server-side record of an issued refresh token. Only the
+// SHA-256 of the token is stored. Tokens rotated from the same login share a
+// FamilyID; each token can be used once.
+type RefreshToken struct {
+	ID              uuid.UUID  `json:"id"`
+	FamilyID        uuid.UUID  `json:"family_id"`
+	UserID          uuid.UUID  `json:"user_id"`
+	TokenHash       string     `json:"-"`
+	AccessTokenID   string     `json:"-"` // jti of the access token issued alongside
+	AccessExpiresAt time.Time  `json:"-"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	UsedAt          *time.Time `json:"used_at,omitempty"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// Token operations
+func (s *Storage) CreateRefreshToken(token *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token.ID = uuid.New()
+	token.CreatedAt = time.Now()
+
+	s.refreshTokens[token.TokenHash] = token
+	return nil
+}
+
+func (s *Storage) GetRefreshTokenByHash(hash string) (*RefreshToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, exists := s.refreshTokens[hash]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return token, nil
+}
+
+// GetRefreshTokenByAccessTokenID returns the refresh token issued together
+// with the access token with the given jti
+func (s *Storage) GetRefreshTokenByAccessTokenID(jti string) (*RefreshToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, token := range s.refreshTokens {
+		if token.AccessTokenID == jti {
+			return token, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// ConsumeRefreshToken marks a refresh token as used. It returns ErrConflict
+// if the token was already used, which indicates token reuse, and ErrInvalid
+// if it was revoked.
+func (s *Storage) ConsumeRefreshToken(hash string) (*RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, exists := s.refreshTokens[hash]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	if token.UsedAt != nil {
+		return token, ErrConflict
+	}
+	if token.RevokedAt != nil {
+		return token, ErrInvalid
+	}
+
+	now := time.Now()
+	token.UsedAt = &now
+	return token, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token of a family along with
+// the access tokens issued with them
+func (s *Storage) RevokeRefreshTokenFamily(familyID uuid.UUID) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.revokeRefreshTokens(func(token *RefreshToken) bool {
+		return token.FamilyID == familyID
+	})
+}
+
+// RevokeUserRefreshTokens revokes all refresh tokens of a user, signing them
+// out of every session
+func (s *Storage) RevokeUserRefreshTokens(userID uuid.UUID) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.revokeRefreshTokens(func(token *RefreshToken) bool {
+		return token.UserID == userID
+	})
+}
+
+func (s *Storage) revokeRefreshTokens(match func(*RefreshToken) bool) int {
+	now := time.Now()
+	revoked := 0
+	for _, token := range s.refreshTokens {
+		if !match(token) {
+			continue
+		}
+		if token.AccessTokenID != "" && token.AccessExpiresAt.After(now) {
+			s.revokedTokens[token.AccessTokenID] = token.AccessExpiresAt
+		}
+		if token.RevokedAt == nil {
+			token.RevokedAt = &now
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// RevokeAccessToken adds an access token's jti to the revocation list until
+// the token would have expired anyway
+func (s *Storage) RevokeAccessToken(jti string, expiresAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokedTokens[jti] = expiresAt
+}
+
+func (s *Storage) IsAccessTokenRevoked(jti string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, revoked := s.revokedTokens[jti]
+	return revoked
+}
+
+// PruneTokens drops expired refresh tokens, MFA challenges and account
+// tokens, and revocation entries for access tokens that have expired
+func (s *Storage) PruneTokens(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+	for hash, token := range s.refreshTokens {
+		if now.After(token.ExpiresAt) {
+			delete(s.refreshTokens, hash)
+			pruned++
+		}
+	}
+	for jti, expiresAt := range s.revokedTokens {
+		if now.After(expiresAt) {
+			delete(s.revokedTokens, jti)
+			pruned++
+		}
+	}
+	for hash, challenge := range s.mfaChallenges {
+		if now.After(challenge.ExpiresAt) {
+			delete(s.mfaChallenges, hash)
+			pruned++
+		}
+	}
+	for hash, token := range s.accountTokens {
+		if now.After(token.ExpiresAt) {
+			delete(s.accountTokens, hash)
+			pruned++
+		}
+	}
+	return pruned
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upload session statuses
+const (
+	UploadStatusActive    = "active"
+	UploadStatusCompleted = "completed"
+)
+
+// UploadChunk is a received byte range of a resumable upload
+type UploadChunk struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Key    string `json:"-"`
+}
+
+// UploadSession tracks a resumable chunked image upload
+type UploadSession struct {
+	ID        uuid.UUID     `json:"id"`
+	UserID    uuid.UUID     `json:"user_id"`
+	PatientID uuid.UUID     `json:"patient_id"`
+	DoctorID  uuid.UUID     `json:"doctor_id"`
+	FileName  string        `json:"file_name"`
+	ImageType string        `json:"image_type"`
+	Notes     string        `json:"notes"`
+	Length    int64         `json:"length"`
+	Offset    int64         `json:"offset"`
+	Chunks    []UploadChunk `json:"-"`
+	Status    string        `json:"status"`
+	ImageID   uuid.UUID     `json:"image_id"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// Progress returns the fraction of the upload received so far
+func (u *UploadSession) Progress() float64 {
+	if u.Length == 0 {
+		return 1
+	}
+	return float64(u.Offset) / float64(u.Length)
+}
+
+// Upload session operations
+
+func (s *Storage) CreateUploadSession(session *UploadSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session.ID = uuid.New()
+	session.Status = UploadStatusActive
+	session.CreatedAt = time.Now()
+	session.UpdatedAt = time.Now()
+
+	s.uploadSessions[session.ID] = session
+	return nil
+}
+
+func (s *Storage) GetUploadSessionByID(id uuid.UUID) (*UploadSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.uploadSessions[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return session, nil
+}
+
+// AppendUploadChunk records a chunk received at offset. It fails with
+// ErrConflict if the offset no longer matches the session, e.g. because a
+// concurrent request already wrote that range.
+func (s *Storage) AppendUploadChunk(id uuid.UUID, chunk UploadChunk, expiresAt time.Time) (*UploadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.uploadSessions[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	if session.Status != UploadStatusActive || session.Offset != chunk.Offset {
+		return nil, ErrConflict
+	}
+	if chunk.Offset+chunk.Size > session.Length {
+		return nil, ErrInvalid
+	}
+
+	session.Chunks = append(session.Chunks, chunk)
+	session.Offset += chunk.Size
+	session.ExpiresAt = expiresAt
+	session.UpdatedAt = time.Now()
+	return session, nil
+}
+
+func (s *Storage) UpdateUploadSession(session *UploadSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.uploadSessions[session.ID]; !exists {
+		return ErrNotFound
+	}
+	session.UpdatedAt = time.Now()
+	s.uploadSessions[session.ID] = session
+	return nil
+}
+
+func (s *Storage) DeleteUploadSession(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.uploadSessions[id]; !exists {
+		return ErrNotFound
+	}
+	delete(s.uploadSessions, id)
+	return nil
+}
+
+// GetExpiredUploadSessions returns sessions whose expiry has passed
+func (s *Storage) GetExpiredUploadSessions(now time.Time) []*UploadSession {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var expired []*UploadSession
+	for _, session := range s.uploadSessions {
+		if now.After(session.ExpiresAt) {
+			expired = append(expired, session)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+)
+
+// UserFilter selects users; zero fields match everything. Query matches
+// email and name case-insensitively.
+type UserFilter struct {
+	Query       string
+	Role        string
+	Deactivated *bool
+}
+
+// SearchUsers returns matching users, oldest account first
+func (s *Storage) SearchUsers(filter UserFilter) []*User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := strings.ToLower(strings.TrimSpace(filter.Query))
+	var users []*User
+	for _, user := range s.users {
+		if filter.Role != "" && user.Role != filter.Role {
+			continue
+		}
+		if filter.Deactivated != nil && (user.DeactivatedAt != nil) != *filter.Deactivated {
+			continue
+		}
+		if query != "" {
+			name := strings.ToLower(user.FirstName + " " + user.LastName)
+			if !strings.Contains(strings.ToLower(user.Email), query) && !strings.Contains(name, query) {
+				continue
+			}
+		}
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].CreatedAt.Before(users[j].CreatedAt)
+	})
+	return users
+}
+
+// CountActiveUsersWithRole counts users with the role that are not deactivated
+func (s *Storage) CountActiveUsersWithRole(role string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, user := range s.users {
+		if user.Role == role && user.DeactivatedAt == nil {
+			count++
+		}
+	}
+	return count
+}
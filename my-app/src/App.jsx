@@ -9,6 +9,11 @@ import LandingPage from './pages/index';
 import Login from './pages/Login';
 import Register from './pages/Register';
 import Dashboard from './pages/Dashboard';
+import MfaSetup from './pages/MfaSetup';
+import DoctorVerification from './pages/DoctorVerification';
+import VerifyEmail from './pages/VerifyEmail';
+import ForgotPassword from './pages/ForgotPassword';
+import ResetPassword from './pages/ResetPassword';
 
 // Protected Route Component
 const ProtectedRoute = ({ children }) => {
@@ -66,6 +71,16 @@ const AppRoutes = () => {
             </PublicRoute>
           } 
         />
+        <Route 
+          path="/forgot-password" 
+          element={
+            <PublicRoute>
+              <ForgotPassword />
+            </PublicRoute>
+          } 
+        />
+        <Route path="/verify-email" element={<VerifyEmail />} />
+        <Route path="/reset-password" element={<ResetPassword />} />
         
         {/* Protected routes */}
         <Route 
@@ -77,6 +92,24 @@ const AppRoutes = () => {
           } 
         />
         
+        <Route 
+          path="/mfa/setup" 
+          element={
+            <ProtectedRoute>
+              <MfaSetup />
+            </ProtectedRoute>
+          } 
+        />
+        
+        <Route 
+          path="/doctor/verification" 
+          element={
+            <ProtectedRoute>
+              <DoctorVerification />
+            </ProtectedRoute>
+          } 
+        />
+        
         {/* Catch all route */}
         <Route path="*" element={<Navigate to="/" />} />
       </Routes>
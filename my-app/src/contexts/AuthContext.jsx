@@ -44,20 +44,48 @@ export const AuthProvider = ({ children }) => {
     }
   }, []);
 
+  // Store the session from a successful login, MFA verification or registration
+  const startSession = (data) => {
+    const { token, refresh_token: refreshToken, user } = data;
+
+    localStorage.setItem('authToken', token);
+    localStorage.setItem('refreshToken', refreshToken);
+    localStorage.setItem('user', JSON.stringify(user));
+    setUser(user);
+
+    return { success: true, mfaEnrollmentRequired: !!data.mfa_enrollment_required };
+  };
+
   const login = async (email, password) => {
     try {
       setError(null);
       const response = await authAPI.login({ email, password });
-      const { token, user } = response.data;
-      
-      localStorage.setItem('authToken', token);
-      localStorage.setItem('user', JSON.stringify(user));
-      setUser(user);
-      
-      return { success: true };
+
+      // Accounts with MFA enabled need a code before tokens are issued
+      if (response.data.mfa_required) {
+        return { success: false, mfaRequired: true, mfaToken: response.data.mfa_token };
+      }
+
+      return startSession(response.data);
     } catch (error) {
       const errorMessage = error.response?.data?.error || 'Login failed';
       setError(errorMessage);
+      return {
+        success: false,
+        error: errorMessage,
+        verificationRequired: !!error.response?.data?.email_verification_required,
+      };
+    }
+  };
+
+  const verifyMfa = async (mfaToken, code) => {
+    try {
+      setError(null);
+      const response = await authAPI.verifyMfa(mfaToken, code);
+      return startSession(response.data);
+    } catch (error) {
+      const errorMessage = error.response?.data?.error || 'Verification failed';
+      setError(errorMessage);
       return { success: false, error: errorMessage };
     }
   };
@@ -66,13 +94,13 @@ export const AuthProvider = ({ children }) => {
     try {
       setError(null);
       const response = await authAPI.register(userData);
-      const { token, user } = response.data;
-      
-      localStorage.setItem('authToken', token);
-      localStorage.setItem('user', JSON.stringify(user));
-      setUser(user);
-      
-      return { success: true };
+
+      // No session until the email address is verified
+      if (response.data.email_verification_required) {
+        return { success: true, verificationRequired: true, message: response.data.message };
+      }
+
+      return startSession(response.data);
     } catch (error) {
       const errorMessage = error.response?.data?.error || 'Registration failed';
       setError(errorMessage);
@@ -81,7 +109,13 @@ export const AuthProvider = ({ children }) => {
   };
 
   const logout = () => {
+    // Revoke the session on the server; the local session ends either way
+    const token = localStorage.getItem('authToken');
+    if (token) {
+      authAPI.logout(token, localStorage.getItem('refreshToken')).catch(() => {});
+    }
     localStorage.removeItem('authToken');
+    localStorage.removeItem('refreshToken');
     localStorage.removeItem('user');
     setUser(null);
     setError(null);
@@ -113,6 +147,7 @@ export const AuthProvider = ({ children }) => {
     loading,
     error,
     login,
+    verifyMfa,
     register,
     logout,
     updateProfile,
@@ -133,8 +133,8 @@ const Dashboard = () => {
                           {image.fileName}
                         </span>
                         <span className={`inline-flex items-center px-2.5 py-0.5 rounded-full text-xs font-medium ${
-                          image.status === 'processed' ? 'bg-green-100 text-green-800' :
-                          image.status === 'pending' ? 'bg-yellow-100 text-yellow-800' :
+                          ['analyzed', 'reviewed'].includes(image.status) ? 'bg-green-100 text-green-800' :
+                          ['uploaded', 'quality_checked', 'queued', 'analyzing'].includes(image.status) ? 'bg-yellow-100 text-yellow-800' :
                           'bg-red-100 text-red-800'
                         }`}>
                           {image.status}
@@ -230,13 +230,13 @@ const Dashboard = () => {
                 <div className="flex items-center justify-between">
                   <span className="text-sm text-gray-500">Processed</span>
                   <span className="text-sm font-medium text-gray-900">
-                    {images.filter(img => img.status === 'processed').length}
+                    {images.filter(img => ['analyzed', 'reviewed'].includes(img.status)).length}
                   </span>
                 </div>
                 <div className="flex items-center justify-between">
                   <span className="text-sm text-gray-500">Pending</span>
                   <span className="text-sm font-medium text-gray-900">
-                    {images.filter(img => img.status === 'pending').length}
+                    {images.filter(img => ['uploaded', 'quality_checked', 'queued', 'analyzing'].includes(img.status)).length}
                   </span>
                 </div>
               </div>
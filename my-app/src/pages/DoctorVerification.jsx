@@ -0,0 +1,168 @@
+import React, { useEffect, useState } from 'react';
+import { useNavigate } from 'react-router-dom';
+import { doctorAPI } from '../services/api';
+import { useAuth } from '../contexts/AuthContext';
+
+const statusMessages = {
+  pending: 'Your credentials are waiting for review. You will get an email once an administrator has checked them.',
+  rejected: 'Your credentials could not be verified. Upload new documents to have them reviewed again.',
+};
+
+const DoctorVerification = () => {
+  const [doctor, setDoctor] = useState(null);
+  const [documents, setDocuments] = useState([]);
+  const [license, setLicense] = useState('');
+  const [file, setFile] = useState(null);
+  const [error, setError] = useState(null);
+  const [isLoading, setIsLoading] = useState(false);
+  const { logout } = useAuth();
+  const navigate = useNavigate();
+
+  const loadProfile = async () => {
+    const [profileResponse, credentialsResponse] = await Promise.all([
+      doctorAPI.getProfile(),
+      doctorAPI.getCredentials(),
+    ]);
+    const profile = profileResponse.data.doctor;
+    if (profile.verification_status === 'verified') {
+      navigate('/dashboard');
+      return;
+    }
+    setDoctor(profile);
+    setLicense((current) => current || profile.license);
+    setDocuments(credentialsResponse.data.documents);
+  };
+
+  useEffect(() => {
+    loadProfile().catch((err) => setError(err.response?.data?.error || 'Failed to load your profile'));
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, []);
+
+  const run = async (action, fallback) => {
+    setIsLoading(true);
+    setError(null);
+    try {
+      await action();
+      await loadProfile();
+    } catch (err) {
+      setError(err.response?.data?.error || fallback);
+    }
+    setIsLoading(false);
+  };
+
+  const handleLicense = (e) => {
+    e.preventDefault();
+    run(() => doctorAPI.updateProfile({ license }), 'Failed to save license number');
+  };
+
+  const handleUpload = (e) => {
+    e.preventDefault();
+    const formData = new FormData();
+    formData.append('document', file);
+    run(async () => {
+      await doctorAPI.uploadCredential(formData);
+      setFile(null);
+      e.target.reset();
+    }, 'Failed to upload document');
+  };
+
+  const inputClass = 'appearance-none rounded-md relative block w-full px-3 py-2 border border-gray-300 placeholder-gray-500 text-gray-900 focus:outline-none focus:ring-red-500 focus:border-red-500 sm:text-sm';
+  const buttonClass = 'w-full flex justify-center py-2 px-4 border border-transparent text-sm font-medium rounded-md text-white bg-red-600 hover:bg-red-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-red-500 disabled:opacity-50 disabled:cursor-not-allowed';
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-50 py-12 px-4 sm:px-6 lg:px-8">
+      <div className="max-w-md w-full space-y-8">
+        <div>
+          <h2 className="mt-6 text-center text-3xl font-extrabold text-gray-900">
+            Verify your credentials
+          </h2>
+          <p className="mt-2 text-center text-sm text-gray-600">
+            Patient data is available once an administrator has verified your medical license
+          </p>
+        </div>
+
+        {error && (
+          <div className="rounded-md bg-red-50 p-4">
+            <div className="text-sm text-red-700">{error}</div>
+          </div>
+        )}
+
+        {doctor && (
+          <>
+            <div className={`rounded-md p-4 text-sm ${doctor.verification_status === 'rejected' ? 'bg-red-50 text-red-700' : 'bg-yellow-50 text-yellow-800'}`}>
+              <p>{statusMessages[doctor.verification_status]}</p>
+              {doctor.verification_note && <p className="mt-2 font-medium">{doctor.verification_note}</p>}
+            </div>
+
+            <form className="space-y-4" onSubmit={handleLicense}>
+              <label htmlFor="license" className="block text-sm text-gray-700">
+                Medical license number
+              </label>
+              <input
+                id="license"
+                name="license"
+                type="text"
+                required
+                className={inputClass}
+                value={license}
+                onChange={(e) => setLicense(e.target.value)}
+              />
+              <button type="submit" disabled={isLoading || license === doctor.license} className={buttonClass}>
+                Save license number
+              </button>
+            </form>
+
+            <div className="space-y-4">
+              <h3 className="text-sm font-medium text-gray-900">Credential documents</h3>
+              {documents.length === 0 ? (
+                <p className="text-sm text-gray-600">
+                  Upload a scan of your license or other proof of registration (PDF, JPEG or PNG).
+                </p>
+              ) : (
+                <ul className="divide-y divide-gray-200 bg-white rounded-md border border-gray-200 text-sm">
+                  {documents.map((document) => (
+                    <li key={document.id} className="flex items-center justify-between p-3">
+                      <span className="truncate">{document.file_name}</span>
+                      <button
+                        type="button"
+                        disabled={isLoading}
+                        onClick={() => run(() => doctorAPI.deleteCredential(document.id), 'Failed to delete document')}
+                        className="ml-4 font-medium text-red-600 hover:text-red-500"
+                      >
+                        Remove
+                      </button>
+                    </li>
+                  ))}
+                </ul>
+              )}
+              <form className="space-y-4" onSubmit={handleUpload}>
+                <input
+                  type="file"
+                  accept="application/pdf,image/jpeg,image/png"
+                  required
+                  className="block w-full text-sm text-gray-700"
+                  onChange={(e) => setFile(e.target.files[0])}
+                />
+                <button type="submit" disabled={isLoading || !file} className={buttonClass}>
+                  {isLoading ? 'Uploading...' : 'Upload document'}
+                </button>
+              </form>
+            </div>
+          </>
+        )}
+
+        <div className="text-center">
+          <button
+            type="button"
+            onClick={logout}
+            className="font-medium text-red-600 hover:text-red-500"
+          >
+            Sign out
+          </button>
+        </div>
+      </div>
+    </div>
+  );
+};
+
+export default DoctorVerification;
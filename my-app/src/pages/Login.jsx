@@ -1,15 +1,28 @@
 import React, { useState } from 'react';
 import { useAuth } from '../contexts/AuthContext';
-import { Link, useNavigate } from 'react-router-dom';
+import { Link, useLocation, useNavigate } from 'react-router-dom';
+import { authAPI } from '../services/api';
 
 const Login = () => {
   const [formData, setFormData] = useState({
     email: '',
     password: '',
   });
+  const [mfaToken, setMfaToken] = useState(null);
+  const [mfaCode, setMfaCode] = useState('');
   const [isLoading, setIsLoading] = useState(false);
-  const { login, error, clearError } = useAuth();
+  const [needsVerification, setNeedsVerification] = useState(false);
+  const { login, verifyMfa, error, clearError } = useAuth();
   const navigate = useNavigate();
+  const location = useLocation();
+  const [notice, setNotice] = useState(location.state?.message || null);
+
+  const resendVerification = async () => {
+    await authAPI.resendVerification(formData.email).catch(() => {});
+    setNeedsVerification(false);
+    clearError();
+    setNotice('A new verification link has been sent if the account still needs one.');
+  };
 
   const handleChange = (e) => {
     setFormData({
@@ -23,10 +36,20 @@ const Login = () => {
     e.preventDefault();
     setIsLoading(true);
     
-    const result = await login(formData.email, formData.password);
+    const result = mfaToken
+      ? await verifyMfa(mfaToken, mfaCode)
+      : await login(formData.email, formData.password);
     
-    if (result.success) {
-      navigate('/dashboard');
+    setNotice(null);
+    setNeedsVerification(!!result.verificationRequired);
+    if (result.mfaRequired) {
+      setMfaToken(result.mfaToken);
+    } else if (result.success) {
+      navigate(result.mfaEnrollmentRequired ? '/mfa/setup' : '/dashboard');
+    } else if (mfaToken && /sign in again/.test(result.error)) {
+      // The challenge expired or had too many wrong codes
+      setMfaToken(null);
+      setMfaCode('');
     }
     
     setIsLoading(false);
@@ -50,12 +73,50 @@ const Login = () => {
         </div>
         
         <form className="mt-8 space-y-6" onSubmit={handleSubmit}>
+          {notice && (
+            <div className="rounded-md bg-green-50 p-4">
+              <div className="text-sm text-green-700">{notice}</div>
+            </div>
+          )}
+
           {error && (
             <div className="rounded-md bg-red-50 p-4">
               <div className="text-sm text-red-700">{error}</div>
+              {needsVerification && (
+                <button
+                  type="button"
+                  onClick={resendVerification}
+                  className="mt-2 text-sm font-medium text-red-600 hover:text-red-500"
+                >
+                  Resend verification email
+                </button>
+              )}
             </div>
           )}
           
+          {mfaToken ? (
+          <div>
+            <label htmlFor="mfaCode" className="block text-sm text-gray-700 mb-2">
+              Enter the 6-digit code from your authenticator app, or a backup code
+            </label>
+            <input
+              id="mfaCode"
+              name="mfaCode"
+              type="text"
+              inputMode="numeric"
+              autoComplete="one-time-code"
+              required
+              autoFocus
+              className="appearance-none rounded-md relative block w-full px-3 py-2 border border-gray-300 placeholder-gray-500 text-gray-900 focus:outline-none focus:ring-red-500 focus:border-red-500 sm:text-sm"
+              placeholder="Authentication code"
+              value={mfaCode}
+              onChange={(e) => {
+                setMfaCode(e.target.value);
+                if (error) clearError();
+              }}
+            />
+          </div>
+          ) : (
           <div className="rounded-md shadow-sm -space-y-px">
             <div>
               <label htmlFor="email" className="sr-only">
@@ -90,6 +151,7 @@ const Login = () => {
               />
             </div>
           </div>
+          )}
 
           <div>
             <button
@@ -103,11 +165,19 @@ const Login = () => {
                   <path className="opacity-75" fill="currentColor" d="M4 12a8 8 0 018-8V0C5.373 0 0 5.373 0 12h4zm2 5.291A7.962 7.962 0 014 12H0c0 3.042 1.135 5.824 3 7.938l3-2.647z"></path>
                 </svg>
               ) : null}
-              {isLoading ? 'Signing in...' : 'Sign in'}
+              {isLoading ? 'Signing in...' : mfaToken ? 'Verify' : 'Sign in'}
             </button>
           </div>
 
-          <div className="text-center">
+          <div className="text-center space-y-2">
+            {!mfaToken && (
+              <Link
+                to="/forgot-password"
+                className="block text-sm font-medium text-red-600 hover:text-red-500"
+              >
+                Forgot your password?
+              </Link>
+            )}
             <Link
               to="/register"
               className="font-medium text-red-600 hover:text-red-500"
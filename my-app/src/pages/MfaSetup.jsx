@@ -0,0 +1,144 @@
+import React, { useEffect, useState } from 'react';
+import { useNavigate } from 'react-router-dom';
+import { mfaAPI } from '../services/api';
+import { useAuth } from '../contexts/AuthContext';
+
+const MfaSetup = () => {
+  const [setup, setSetup] = useState(null);
+  const [code, setCode] = useState('');
+  const [backupCodes, setBackupCodes] = useState(null);
+  const [error, setError] = useState(null);
+  const [isLoading, setIsLoading] = useState(false);
+  const { user, logout } = useAuth();
+  const navigate = useNavigate();
+
+  // Start enrollment, or skip straight to the dashboard if MFA is already on
+  useEffect(() => {
+    let cancelled = false;
+    mfaAPI.getStatus()
+      .then((response) => {
+        if (cancelled) return null;
+        if (response.data.enabled) {
+          navigate('/dashboard');
+          return null;
+        }
+        return mfaAPI.enroll().then((enrollResponse) => {
+          if (!cancelled) setSetup(enrollResponse.data);
+        });
+      })
+      .catch((err) => setError(err.response?.data?.error || 'Failed to start MFA setup'));
+    return () => {
+      cancelled = true;
+    };
+  }, [navigate]);
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setIsLoading(true);
+    setError(null);
+
+    try {
+      const response = await mfaAPI.confirm(code);
+      setBackupCodes(response.data.backup_codes);
+    } catch (err) {
+      setError(err.response?.data?.error || 'Verification failed');
+    }
+
+    setIsLoading(false);
+  };
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-50 py-12 px-4 sm:px-6 lg:px-8">
+      <div className="max-w-md w-full space-y-8">
+        <div>
+          <h2 className="mt-6 text-center text-3xl font-extrabold text-gray-900">
+            Set up two-factor authentication
+          </h2>
+          <p className="mt-2 text-center text-sm text-gray-600">
+            {user?.role === 'patient'
+              ? 'Protect your account with an authenticator app'
+              : 'Your role requires an authenticator app to access patient data'}
+          </p>
+        </div>
+
+        {error && (
+          <div className="rounded-md bg-red-50 p-4">
+            <div className="text-sm text-red-700">{error}</div>
+          </div>
+        )}
+
+        {backupCodes ? (
+          <div className="space-y-6">
+            <p className="text-sm text-gray-700">
+              Two-factor authentication is on. Save these backup codes somewhere safe. Each one
+              signs you in once if you lose your authenticator, and they will not be shown again.
+            </p>
+            <ul className="grid grid-cols-2 gap-2 font-mono text-sm bg-white rounded-md border border-gray-200 p-4">
+              {backupCodes.map((backupCode) => (
+                <li key={backupCode}>{backupCode}</li>
+              ))}
+            </ul>
+            <button
+              type="button"
+              onClick={() => navigate('/dashboard')}
+              className="w-full flex justify-center py-2 px-4 border border-transparent text-sm font-medium rounded-md text-white bg-red-600 hover:bg-red-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-red-500"
+            >
+              Continue to dashboard
+            </button>
+          </div>
+        ) : setup ? (
+          <form className="space-y-6" onSubmit={handleSubmit}>
+            <div className="text-sm text-gray-700 space-y-2">
+              <p>
+                Add this account to your authenticator app by opening the{' '}
+                <a href={setup.provisioning_uri} className="font-medium text-red-600 hover:text-red-500">
+                  setup link
+                </a>{' '}
+                on your phone, or enter the key manually:
+              </p>
+              <p className="font-mono break-all bg-white rounded-md border border-gray-200 p-3">
+                {setup.secret}
+              </p>
+            </div>
+            <div>
+              <label htmlFor="code" className="block text-sm text-gray-700 mb-2">
+                Enter the 6-digit code the app shows
+              </label>
+              <input
+                id="code"
+                name="code"
+                type="text"
+                inputMode="numeric"
+                autoComplete="one-time-code"
+                required
+                className="appearance-none rounded-md relative block w-full px-3 py-2 border border-gray-300 placeholder-gray-500 text-gray-900 focus:outline-none focus:ring-red-500 focus:border-red-500 sm:text-sm"
+                placeholder="123456"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+              />
+            </div>
+            <button
+              type="submit"
+              disabled={isLoading}
+              className="w-full flex justify-center py-2 px-4 border border-transparent text-sm font-medium rounded-md text-white bg-red-600 hover:bg-red-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-red-500 disabled:opacity-50 disabled:cursor-not-allowed"
+            >
+              {isLoading ? 'Verifying...' : 'Turn on two-factor authentication'}
+            </button>
+          </form>
+        ) : null}
+
+        <div className="text-center">
+          <button
+            type="button"
+            onClick={logout}
+            className="font-medium text-red-600 hover:text-red-500"
+          >
+            Sign out
+          </button>
+        </div>
+      </div>
+    </div>
+  );
+};
+
+export default MfaSetup;
@@ -89,8 +89,10 @@ const Register = () => {
     
     const result = await register(userData);
     
-    if (result.success) {
-      navigate('/dashboard');
+    if (result.verificationRequired) {
+      navigate('/login', { state: { message: result.message } });
+    } else if (result.success) {
+      navigate(result.mfaEnrollmentRequired ? '/mfa/setup' : '/dashboard');
     }
     
     setIsLoading(false);
@@ -0,0 +1,49 @@
+import React, { useEffect, useRef, useState } from 'react';
+import { Link, useSearchParams } from 'react-router-dom';
+import { authAPI } from '../services/api';
+
+const VerifyEmail = () => {
+  const [searchParams] = useSearchParams();
+  const [status, setStatus] = useState('verifying');
+  const [message, setMessage] = useState('');
+  const requested = useRef(false);
+
+  // Tokens are single-use, so only send the request once
+  useEffect(() => {
+    if (requested.current) return;
+    requested.current = true;
+
+    authAPI.verifyEmail(searchParams.get('token') || '')
+      .then(() => setStatus('verified'))
+      .catch((error) => {
+        setStatus('failed');
+        setMessage(error.response?.data?.error || 'Verification failed');
+      });
+  }, [searchParams]);
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-50 py-12 px-4 sm:px-6 lg:px-8">
+      <div className="max-w-md w-full space-y-6 text-center">
+        <h2 className="text-3xl font-extrabold text-gray-900">Email verification</h2>
+        {status === 'verifying' && (
+          <p className="text-sm text-gray-600">Verifying your email address...</p>
+        )}
+        {status === 'verified' && (
+          <div className="rounded-md bg-green-50 p-4 text-sm text-green-700">
+            Your email address is verified. You can now sign in.
+          </div>
+        )}
+        {status === 'failed' && (
+          <div className="rounded-md bg-red-50 p-4 text-sm text-red-700">
+            {message}. Sign in to request a new verification link.
+          </div>
+        )}
+        <Link to="/login" className="font-medium text-red-600 hover:text-red-500">
+          Go to sign in
+        </Link>
+      </div>
+    </div>
+  );
+};
+
+export default VerifyEmail;
@@ -23,15 +23,66 @@ api.interceptors.request.use(
   }
 );
 
-// Response interceptor to handle errors
+// Clear the stored session and send the user to the login page
+const endSession = () => {
+  localStorage.removeItem('authToken');
+  localStorage.removeItem('refreshToken');
+  localStorage.removeItem('user');
+  window.location.href = '/login';
+};
+
+// A single refresh request shared by all requests that failed with 401,
+// since each refresh token can only be used once
+let refreshPromise = null;
+
+const refreshSession = () => {
+  if (!refreshPromise) {
+    const refreshToken = localStorage.getItem('refreshToken');
+    refreshPromise = (refreshToken
+      ? axios.post(`${api.defaults.baseURL}/auth/refresh`, { refresh_token: refreshToken })
+      : Promise.reject(new Error('No refresh token'))
+    )
+      .then((response) => {
+        localStorage.setItem('authToken', response.data.token);
+        localStorage.setItem('refreshToken', response.data.refresh_token);
+        return response.data.token;
+      })
+      .finally(() => {
+        refreshPromise = null;
+      });
+  }
+  return refreshPromise;
+};
+
+// Response interceptor to refresh expired access tokens and handle errors
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
-    if (error.response?.status === 401) {
-      // Token expired or invalid
-      localStorage.removeItem('authToken');
-      localStorage.removeItem('user');
-      window.location.href = '/login';
+  async (error) => {
+    const request = error.config;
+    const isAuthRequest = request?.url?.startsWith('/auth/');
+    if (error.response?.status === 401 && request && !request._retried && !isAuthRequest) {
+      request._retried = true;
+      try {
+        const token = await refreshSession();
+        request.headers.Authorization = `Bearer ${token}`;
+        return api(request);
+      } catch (refreshError) {
+        endSession();
+        return Promise.reject(refreshError);
+      }
+    }
+    if (error.response?.status === 401 && !isAuthRequest) {
+      endSession();
+    }
+    // Roles that require MFA cannot use the API until they have enrolled
+    if (error.response?.status === 403 && error.response.data?.mfa_enrollment_required
+      && window.location.pathname !== '/mfa/setup') {
+      window.location.href = '/mfa/setup';
+    }
+    // Doctors cannot see patient data until an admin has verified their credentials
+    if (error.response?.status === 403 && error.response.data?.doctor_verification_required
+      && window.location.pathname !== '/doctor/verification') {
+      window.location.href = '/doctor/verification';
     }
     return Promise.reject(error);
   }
@@ -41,10 +92,27 @@ api.interceptors.response.use(
 export const authAPI = {
   register: (userData) => api.post('/auth/register', userData),
   login: (credentials) => api.post('/auth/login', credentials),
+  verifyMfa: (mfaToken, code) => api.post('/auth/mfa/verify', { mfa_token: mfaToken, code }),
+  verifyEmail: (token) => api.post('/auth/verify-email', { token }),
+  resendVerification: (email) => api.post('/auth/verify-email/resend', { email }),
+  forgotPassword: (email) => api.post('/auth/forgot-password', { email }),
+  resetPassword: (token, password) => api.post('/auth/reset-password', { token, password }),
+  logout: (token, refreshToken) => api.post('/auth/logout', { refresh_token: refreshToken }, {
+    headers: { Authorization: `Bearer ${token}` },
+  }),
   getProfile: () => api.get('/profile'),
   updateProfile: (profileData) => api.put('/profile', profileData),
 };
 
+// Multi-factor authentication API
+export const mfaAPI = {
+  getStatus: () => api.get('/mfa'),
+  enroll: () => api.post('/mfa/enroll'),
+  confirm: (code) => api.post('/mfa/confirm', { code }),
+  disable: (code) => api.post('/mfa/disable', { code }),
+  regenerateBackupCodes: (code) => api.post('/mfa/backup-codes', { code }),
+};
+
 // Patient API
 export const patientAPI = {
   getProfile: () => api.get('/patients/profile'),
@@ -52,6 +120,15 @@ export const patientAPI = {
   getAll: () => api.get('/patients'),
   getById: (id) => api.get(`/patients/${id}`),
   getImages: (id) => api.get(`/patients/${id}/images`),
+  refer: (id, referral) => api.post(`/patients/${id}/referrals`, referral),
+  breakGlass: (id, reason) => api.post(`/patients/${id}/emergency-access`, { reason }),
+};
+
+// Care team API
+export const careTeamAPI = {
+  getAll: (params) => api.get('/care-team', { params }),
+  assign: (assignment) => api.post('/care-team', assignment),
+  end: (id) => api.post(`/care-team/${id}/end`),
 };
 
 // Doctor API
@@ -60,6 +137,13 @@ export const doctorAPI = {
   getById: (id) => api.get(`/doctors/${id}`),
   getProfile: () => api.get('/doctors/profile'),
   updateProfile: (profileData) => api.put('/doctors/profile', profileData),
+  getCredentials: () => api.get('/doctors/profile/credentials'),
+  uploadCredential: (formData) => api.post('/doctors/profile/credentials', formData, {
+    headers: {
+      'Content-Type': 'multipart/form-data',
+    },
+  }),
+  deleteCredential: (id) => api.delete(`/doctors/profile/credentials/${id}`),
 };
 
 // Image API